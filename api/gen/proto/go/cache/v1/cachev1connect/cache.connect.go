@@ -43,6 +43,15 @@ const (
 	CacheServiceGetEntryProcedure = "/cache.v1.CacheService/GetEntry"
 	// CacheServiceCheckEntryProcedure is the fully-qualified name of the CacheService's CheckEntry RPC.
 	CacheServiceCheckEntryProcedure = "/cache.v1.CacheService/CheckEntry"
+	// CacheServiceGetEntriesProcedure is the fully-qualified name of the CacheService's GetEntries RPC.
+	CacheServiceGetEntriesProcedure = "/cache.v1.CacheService/GetEntries"
+	// CacheServiceCheckEntriesProcedure is the fully-qualified name of the CacheService's CheckEntries
+	// RPC.
+	CacheServiceCheckEntriesProcedure = "/cache.v1.CacheService/CheckEntries"
+	// CacheServiceGetObjectsProcedure is the fully-qualified name of the CacheService's GetObjects RPC.
+	CacheServiceGetObjectsProcedure = "/cache.v1.CacheService/GetObjects"
+	// CacheServicePutObjectsProcedure is the fully-qualified name of the CacheService's PutObjects RPC.
+	CacheServicePutObjectsProcedure = "/cache.v1.CacheService/PutObjects"
 )
 
 // CacheServiceClient is a client for the cache.v1.CacheService service.
@@ -55,6 +64,14 @@ type CacheServiceClient interface {
 	GetEntry(context.Context, *connect.Request[v1.GetEntryRequest]) (*connect.Response[v1.GetEntryResponse], error)
 	// CheckEntry checks if a cache entry exists
 	CheckEntry(context.Context, *connect.Request[v1.CheckEntryRequest]) (*connect.Response[v1.CheckEntryResponse], error)
+	// GetEntries retrieves a batch of cache entries by key
+	GetEntries(context.Context, *connect.Request[v1.GetEntriesRequest]) (*connect.Response[v1.GetEntriesResponse], error)
+	// CheckEntries checks if a batch of cache entries exist
+	CheckEntries(context.Context, *connect.Request[v1.CheckEntriesRequest]) (*connect.Response[v1.CheckEntriesResponse], error)
+	// GetObjects retrieves a batch of small objects, such as the entries of a compiler cache
+	GetObjects(context.Context, *connect.Request[v1.GetObjectsRequest]) (*connect.Response[v1.GetObjectsResponse], error)
+	// PutObjects stores a batch of small objects
+	PutObjects(context.Context, *connect.Request[v1.PutObjectsRequest]) (*connect.Response[v1.PutObjectsResponse], error)
 }
 
 // NewCacheServiceClient constructs a client for the cache.v1.CacheService service. By default, it
@@ -92,15 +109,43 @@ func NewCacheServiceClient(httpClient connect.HTTPClient, baseURL string, opts .
 			connect.WithSchema(cacheServiceMethods.ByName("CheckEntry")),
 			connect.WithClientOptions(opts...),
 		),
+		getEntries: connect.NewClient[v1.GetEntriesRequest, v1.GetEntriesResponse](
+			httpClient,
+			baseURL+CacheServiceGetEntriesProcedure,
+			connect.WithSchema(cacheServiceMethods.ByName("GetEntries")),
+			connect.WithClientOptions(opts...),
+		),
+		checkEntries: connect.NewClient[v1.CheckEntriesRequest, v1.CheckEntriesResponse](
+			httpClient,
+			baseURL+CacheServiceCheckEntriesProcedure,
+			connect.WithSchema(cacheServiceMethods.ByName("CheckEntries")),
+			connect.WithClientOptions(opts...),
+		),
+		getObjects: connect.NewClient[v1.GetObjectsRequest, v1.GetObjectsResponse](
+			httpClient,
+			baseURL+CacheServiceGetObjectsProcedure,
+			connect.WithSchema(cacheServiceMethods.ByName("GetObjects")),
+			connect.WithClientOptions(opts...),
+		),
+		putObjects: connect.NewClient[v1.PutObjectsRequest, v1.PutObjectsResponse](
+			httpClient,
+			baseURL+CacheServicePutObjectsProcedure,
+			connect.WithSchema(cacheServiceMethods.ByName("PutObjects")),
+			connect.WithClientOptions(opts...),
+		),
 	}
 }
 
 // cacheServiceClient implements CacheServiceClient.
 type cacheServiceClient struct {
-	createEntry *connect.Client[v1.CreateEntryRequest, v1.CreateEntryResponse]
-	updateEntry *connect.Client[v1.UpdateEntryRequest, v1.UpdateEntryResponse]
-	getEntry    *connect.Client[v1.GetEntryRequest, v1.GetEntryResponse]
-	checkEntry  *connect.Client[v1.CheckEntryRequest, v1.CheckEntryResponse]
+	createEntry  *connect.Client[v1.CreateEntryRequest, v1.CreateEntryResponse]
+	updateEntry  *connect.Client[v1.UpdateEntryRequest, v1.UpdateEntryResponse]
+	getEntry     *connect.Client[v1.GetEntryRequest, v1.GetEntryResponse]
+	checkEntry   *connect.Client[v1.CheckEntryRequest, v1.CheckEntryResponse]
+	getEntries   *connect.Client[v1.GetEntriesRequest, v1.GetEntriesResponse]
+	checkEntries *connect.Client[v1.CheckEntriesRequest, v1.CheckEntriesResponse]
+	getObjects   *connect.Client[v1.GetObjectsRequest, v1.GetObjectsResponse]
+	putObjects   *connect.Client[v1.PutObjectsRequest, v1.PutObjectsResponse]
 }
 
 // CreateEntry calls cache.v1.CacheService.CreateEntry.
@@ -123,6 +168,26 @@ func (c *cacheServiceClient) CheckEntry(ctx context.Context, req *connect.Reques
 	return c.checkEntry.CallUnary(ctx, req)
 }
 
+// GetEntries calls cache.v1.CacheService.GetEntries.
+func (c *cacheServiceClient) GetEntries(ctx context.Context, req *connect.Request[v1.GetEntriesRequest]) (*connect.Response[v1.GetEntriesResponse], error) {
+	return c.getEntries.CallUnary(ctx, req)
+}
+
+// CheckEntries calls cache.v1.CacheService.CheckEntries.
+func (c *cacheServiceClient) CheckEntries(ctx context.Context, req *connect.Request[v1.CheckEntriesRequest]) (*connect.Response[v1.CheckEntriesResponse], error) {
+	return c.checkEntries.CallUnary(ctx, req)
+}
+
+// GetObjects calls cache.v1.CacheService.GetObjects.
+func (c *cacheServiceClient) GetObjects(ctx context.Context, req *connect.Request[v1.GetObjectsRequest]) (*connect.Response[v1.GetObjectsResponse], error) {
+	return c.getObjects.CallUnary(ctx, req)
+}
+
+// PutObjects calls cache.v1.CacheService.PutObjects.
+func (c *cacheServiceClient) PutObjects(ctx context.Context, req *connect.Request[v1.PutObjectsRequest]) (*connect.Response[v1.PutObjectsResponse], error) {
+	return c.putObjects.CallUnary(ctx, req)
+}
+
 // CacheServiceHandler is an implementation of the cache.v1.CacheService service.
 type CacheServiceHandler interface {
 	// CreateEntry creates a new cache entry
@@ -133,6 +198,14 @@ type CacheServiceHandler interface {
 	GetEntry(context.Context, *connect.Request[v1.GetEntryRequest]) (*connect.Response[v1.GetEntryResponse], error)
 	// CheckEntry checks if a cache entry exists
 	CheckEntry(context.Context, *connect.Request[v1.CheckEntryRequest]) (*connect.Response[v1.CheckEntryResponse], error)
+	// GetEntries retrieves a batch of cache entries by key
+	GetEntries(context.Context, *connect.Request[v1.GetEntriesRequest]) (*connect.Response[v1.GetEntriesResponse], error)
+	// CheckEntries checks if a batch of cache entries exist
+	CheckEntries(context.Context, *connect.Request[v1.CheckEntriesRequest]) (*connect.Response[v1.CheckEntriesResponse], error)
+	// GetObjects retrieves a batch of small objects, such as the entries of a compiler cache
+	GetObjects(context.Context, *connect.Request[v1.GetObjectsRequest]) (*connect.Response[v1.GetObjectsResponse], error)
+	// PutObjects stores a batch of small objects
+	PutObjects(context.Context, *connect.Request[v1.PutObjectsRequest]) (*connect.Response[v1.PutObjectsResponse], error)
 }
 
 // NewCacheServiceHandler builds an HTTP handler from the service implementation. It returns the
@@ -166,6 +239,30 @@ func NewCacheServiceHandler(svc CacheServiceHandler, opts ...connect.HandlerOpti
 		connect.WithSchema(cacheServiceMethods.ByName("CheckEntry")),
 		connect.WithHandlerOptions(opts...),
 	)
+	cacheServiceGetEntriesHandler := connect.NewUnaryHandler(
+		CacheServiceGetEntriesProcedure,
+		svc.GetEntries,
+		connect.WithSchema(cacheServiceMethods.ByName("GetEntries")),
+		connect.WithHandlerOptions(opts...),
+	)
+	cacheServiceCheckEntriesHandler := connect.NewUnaryHandler(
+		CacheServiceCheckEntriesProcedure,
+		svc.CheckEntries,
+		connect.WithSchema(cacheServiceMethods.ByName("CheckEntries")),
+		connect.WithHandlerOptions(opts...),
+	)
+	cacheServiceGetObjectsHandler := connect.NewUnaryHandler(
+		CacheServiceGetObjectsProcedure,
+		svc.GetObjects,
+		connect.WithSchema(cacheServiceMethods.ByName("GetObjects")),
+		connect.WithHandlerOptions(opts...),
+	)
+	cacheServicePutObjectsHandler := connect.NewUnaryHandler(
+		CacheServicePutObjectsProcedure,
+		svc.PutObjects,
+		connect.WithSchema(cacheServiceMethods.ByName("PutObjects")),
+		connect.WithHandlerOptions(opts...),
+	)
 	return "/cache.v1.CacheService/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
 		case CacheServiceCreateEntryProcedure:
@@ -176,6 +273,14 @@ func NewCacheServiceHandler(svc CacheServiceHandler, opts ...connect.HandlerOpti
 			cacheServiceGetEntryHandler.ServeHTTP(w, r)
 		case CacheServiceCheckEntryProcedure:
 			cacheServiceCheckEntryHandler.ServeHTTP(w, r)
+		case CacheServiceGetEntriesProcedure:
+			cacheServiceGetEntriesHandler.ServeHTTP(w, r)
+		case CacheServiceCheckEntriesProcedure:
+			cacheServiceCheckEntriesHandler.ServeHTTP(w, r)
+		case CacheServiceGetObjectsProcedure:
+			cacheServiceGetObjectsHandler.ServeHTTP(w, r)
+		case CacheServicePutObjectsProcedure:
+			cacheServicePutObjectsHandler.ServeHTTP(w, r)
 		default:
 			http.NotFound(w, r)
 		}
@@ -200,3 +305,19 @@ func (UnimplementedCacheServiceHandler) GetEntry(context.Context, *connect.Reque
 func (UnimplementedCacheServiceHandler) CheckEntry(context.Context, *connect.Request[v1.CheckEntryRequest]) (*connect.Response[v1.CheckEntryResponse], error) {
 	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("cache.v1.CacheService.CheckEntry is not implemented"))
 }
+
+func (UnimplementedCacheServiceHandler) GetEntries(context.Context, *connect.Request[v1.GetEntriesRequest]) (*connect.Response[v1.GetEntriesResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("cache.v1.CacheService.GetEntries is not implemented"))
+}
+
+func (UnimplementedCacheServiceHandler) CheckEntries(context.Context, *connect.Request[v1.CheckEntriesRequest]) (*connect.Response[v1.CheckEntriesResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("cache.v1.CacheService.CheckEntries is not implemented"))
+}
+
+func (UnimplementedCacheServiceHandler) GetObjects(context.Context, *connect.Request[v1.GetObjectsRequest]) (*connect.Response[v1.GetObjectsResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("cache.v1.CacheService.GetObjects is not implemented"))
+}
+
+func (UnimplementedCacheServiceHandler) PutObjects(context.Context, *connect.Request[v1.PutObjectsRequest]) (*connect.Response[v1.PutObjectsResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("cache.v1.CacheService.PutObjects is not implemented"))
+}
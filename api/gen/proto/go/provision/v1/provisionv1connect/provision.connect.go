@@ -39,6 +39,9 @@ const (
 	// ProvisionServiceGetTenantProcedure is the fully-qualified name of the ProvisionService's
 	// GetTenant RPC.
 	ProvisionServiceGetTenantProcedure = "/provision.v1.ProvisionService/GetTenant"
+	// ProvisionServiceQueryAuditEventsProcedure is the fully-qualified name of the ProvisionService's
+	// QueryAuditEvents RPC.
+	ProvisionServiceQueryAuditEventsProcedure = "/provision.v1.ProvisionService/QueryAuditEvents"
 )
 
 // ProvisionServiceClient is a client for the provision.v1.ProvisionService service.
@@ -48,6 +51,8 @@ type ProvisionServiceClient interface {
 	CreateTenant(context.Context, *connect.Request[v1.CreateTenantRequest]) (*connect.Response[v1.CreateTenantResponse], error)
 	// GetTenant retrieves the details of a specific tenant by its ID
 	GetTenant(context.Context, *connect.Request[v1.GetTenantRequest]) (*connect.Response[v1.GetTenantResponse], error)
+	// QueryAuditEvents returns the audit events of a tenant, newest first
+	QueryAuditEvents(context.Context, *connect.Request[v1.QueryAuditEventsRequest]) (*connect.Response[v1.QueryAuditEventsResponse], error)
 }
 
 // NewProvisionServiceClient constructs a client for the provision.v1.ProvisionService service. By
@@ -73,13 +78,20 @@ func NewProvisionServiceClient(httpClient connect.HTTPClient, baseURL string, op
 			connect.WithSchema(provisionServiceMethods.ByName("GetTenant")),
 			connect.WithClientOptions(opts...),
 		),
+		queryAuditEvents: connect.NewClient[v1.QueryAuditEventsRequest, v1.QueryAuditEventsResponse](
+			httpClient,
+			baseURL+ProvisionServiceQueryAuditEventsProcedure,
+			connect.WithSchema(provisionServiceMethods.ByName("QueryAuditEvents")),
+			connect.WithClientOptions(opts...),
+		),
 	}
 }
 
 // provisionServiceClient implements ProvisionServiceClient.
 type provisionServiceClient struct {
-	createTenant *connect.Client[v1.CreateTenantRequest, v1.CreateTenantResponse]
-	getTenant    *connect.Client[v1.GetTenantRequest, v1.GetTenantResponse]
+	createTenant     *connect.Client[v1.CreateTenantRequest, v1.CreateTenantResponse]
+	getTenant        *connect.Client[v1.GetTenantRequest, v1.GetTenantResponse]
+	queryAuditEvents *connect.Client[v1.QueryAuditEventsRequest, v1.QueryAuditEventsResponse]
 }
 
 // CreateTenant calls provision.v1.ProvisionService.CreateTenant.
@@ -92,6 +104,11 @@ func (c *provisionServiceClient) GetTenant(ctx context.Context, req *connect.Req
 	return c.getTenant.CallUnary(ctx, req)
 }
 
+// QueryAuditEvents calls provision.v1.ProvisionService.QueryAuditEvents.
+func (c *provisionServiceClient) QueryAuditEvents(ctx context.Context, req *connect.Request[v1.QueryAuditEventsRequest]) (*connect.Response[v1.QueryAuditEventsResponse], error) {
+	return c.queryAuditEvents.CallUnary(ctx, req)
+}
+
 // ProvisionServiceHandler is an implementation of the provision.v1.ProvisionService service.
 type ProvisionServiceHandler interface {
 	// CreateTenant creates a new tenant with the specified configuration
@@ -99,6 +116,8 @@ type ProvisionServiceHandler interface {
 	CreateTenant(context.Context, *connect.Request[v1.CreateTenantRequest]) (*connect.Response[v1.CreateTenantResponse], error)
 	// GetTenant retrieves the details of a specific tenant by its ID
 	GetTenant(context.Context, *connect.Request[v1.GetTenantRequest]) (*connect.Response[v1.GetTenantResponse], error)
+	// QueryAuditEvents returns the audit events of a tenant, newest first
+	QueryAuditEvents(context.Context, *connect.Request[v1.QueryAuditEventsRequest]) (*connect.Response[v1.QueryAuditEventsResponse], error)
 }
 
 // NewProvisionServiceHandler builds an HTTP handler from the service implementation. It returns the
@@ -120,12 +139,20 @@ func NewProvisionServiceHandler(svc ProvisionServiceHandler, opts ...connect.Han
 		connect.WithSchema(provisionServiceMethods.ByName("GetTenant")),
 		connect.WithHandlerOptions(opts...),
 	)
+	provisionServiceQueryAuditEventsHandler := connect.NewUnaryHandler(
+		ProvisionServiceQueryAuditEventsProcedure,
+		svc.QueryAuditEvents,
+		connect.WithSchema(provisionServiceMethods.ByName("QueryAuditEvents")),
+		connect.WithHandlerOptions(opts...),
+	)
 	return "/provision.v1.ProvisionService/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
 		case ProvisionServiceCreateTenantProcedure:
 			provisionServiceCreateTenantHandler.ServeHTTP(w, r)
 		case ProvisionServiceGetTenantProcedure:
 			provisionServiceGetTenantHandler.ServeHTTP(w, r)
+		case ProvisionServiceQueryAuditEventsProcedure:
+			provisionServiceQueryAuditEventsHandler.ServeHTTP(w, r)
 		default:
 			http.NotFound(w, r)
 		}
@@ -142,3 +169,7 @@ func (UnimplementedProvisionServiceHandler) CreateTenant(context.Context, *conne
 func (UnimplementedProvisionServiceHandler) GetTenant(context.Context, *connect.Request[v1.GetTenantRequest]) (*connect.Response[v1.GetTenantResponse], error) {
 	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("provision.v1.ProvisionService.GetTenant is not implemented"))
 }
+
+func (UnimplementedProvisionServiceHandler) QueryAuditEvents(context.Context, *connect.Request[v1.QueryAuditEventsRequest]) (*connect.Response[v1.QueryAuditEventsResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("provision.v1.ProvisionService.QueryAuditEvents is not implemented"))
+}
@@ -26,6 +26,7 @@ var (
 
 	cli struct {
 		CreateTenant admin.CreateTenantCmd `cmd:"" help:"create a tenant."`
+		Audit        admin.AuditCmd        `cmd:"" help:"read the audit log."`
 		Endpoint     string                `help:"admin endpoint to call" default:"http://localhost:8080" env:"INPUT_ENDPOINT"`
 		Service      string                `default:"execute-api"`
 		Debug        bool                  `help:"Enable debug mode."`
@@ -15,6 +15,7 @@ var (
 		RPC         commands.RPCServerCmd         `cmd:"" help:"start a rpc server."`
 		Lambda      commands.LambdaServerCmd      `cmd:"" help:"start a server in aws lambda."`
 		AdminLambda commands.AdminLambdaServerCmd `cmd:"" help:"start an admin server in aws lambda."`
+		Evictions   commands.EvictionsLambdaCmd   `cmd:"" help:"publish the evictions from the cache index stream in aws lambda."`
 		Debug       bool                          `help:"Enable debug mode."`
 		Version     kong.VersionFlag
 	}
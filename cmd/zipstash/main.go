@@ -22,11 +22,15 @@ var (
 	version = "dev"
 
 	cli struct {
-		Endpoint string            `help:"endpoint to call" default:"http://localhost:8080" env:"INPUT_ENDPOINT"`
-		Save     client.SaveCmd    `cmd:"" help:"save a cache entry."`
-		Restore  client.RestoreCmd `cmd:"" help:"restore a cache entry."`
-		Debug    bool              `help:"Enable debug mode."`
-		Version  kong.VersionFlag
+		Endpoint     string                 `help:"endpoint to call" default:"http://localhost:8080" env:"INPUT_ENDPOINT"`
+		Save         client.SaveCmd         `cmd:"" help:"save a cache entry."`
+		Restore      client.RestoreCmd      `cmd:"" help:"restore a cache entry."`
+		Inspect      client.InspectCmd      `cmd:"" help:"list the contents of a cache entry without downloading it."`
+		Diff         client.DiffCmd         `cmd:"" help:"compare the contents of two cache entries."`
+		ObjectServer client.ObjectServerCmd `cmd:"" help:"serve a local http and webdav endpoint storing objects in zipstash for compiler caches such as sccache and ccache."`
+		Proxy        client.ProxyCmd        `cmd:"" help:"run a local proxy for the duration of a job which authenticates the requests of build tools to zipstash."`
+		Debug        bool                   `help:"Enable debug mode."`
+		Version      kong.VersionFlag
 	}
 )
 
@@ -62,7 +66,14 @@ func main() {
 		},
 		kong.BindTo(ctx, (*context.Context)(nil)))
 	enableDebug(cli.Debug) // enable debug logging
-	err = cmd.Run(&client.Globals{Debug: cli.Debug, Version: version, Client: buildClient(cli.Endpoint, otelInterceptor)})
+	clientOptions := []connect.ClientOption{connect.WithInterceptors(otelInterceptor, client.NewRetryAfterInterceptor())}
+	err = cmd.Run(&client.Globals{
+		Debug:         cli.Debug,
+		Version:       version,
+		Client:        buildClient(cli.Endpoint, clientOptions),
+		Endpoint:      cli.Endpoint,
+		ClientOptions: clientOptions,
+	})
 	span.RecordError(err)
 	cmd.FatalIfErrorf(err)
 }
@@ -75,6 +86,6 @@ func enableDebug(debug bool) {
 	}
 }
 
-func buildClient(endpoint string, otelInterceptor *otelconnect.Interceptor) cachev1connect.CacheServiceClient {
-	return cachev1connect.NewCacheServiceClient(http.DefaultClient, endpoint, connect.WithInterceptors(otelInterceptor))
+func buildClient(endpoint string, opts []connect.ClientOption) cachev1connect.CacheServiceClient {
+	return cachev1connect.NewCacheServiceClient(http.DefaultClient, endpoint, opts...)
 }
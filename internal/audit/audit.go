@@ -0,0 +1,143 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// actions recorded in the audit log.
+const (
+	ActionCacheCreate  = "cache.create"
+	ActionCacheCommit  = "cache.commit"
+	ActionCacheRestore = "cache.restore"
+	ActionCacheInspect = "cache.inspect"
+	ActionTenantCreate = "tenant.create"
+	ActionTenantGet    = "tenant.get"
+	ActionAuditQuery   = "audit.query"
+)
+
+// ResultSuccess is the result of an action which succeeded, failed actions have the code of their error as the
+// result such as not_found or permission_denied.
+const ResultSuccess = "success"
+
+// ErrQueryUnsupported is returned when none of the sinks of a recorder can be queried.
+var ErrQueryUnsupported = errors.New("audit log can't be queried, enable a queryable sink")
+
+// Event records an action on the cache or a tenant and who performed it.
+type Event struct {
+	ID       string    `json:"id"`
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"`
+	Result   string    `json:"result"`
+	Tenant   string    `json:"tenant"`
+	Key      string    `json:"key,omitempty"`
+	CacheID  string    `json:"cache_id,omitempty"`
+	Branch   string    `json:"branch,omitempty"`
+	Sha256   string    `json:"sha256,omitempty"`
+	Fallback bool      `json:"fallback,omitempty"`
+	Subject  string    `json:"subject,omitempty"`
+	Issuer   string    `json:"issuer,omitempty"`
+	// Actor is the principal of requests which aren't authenticated with an OIDC token, such as admin requests.
+	Actor string `json:"actor,omitempty"`
+	// Claims are the verified claims of the OIDC token, such as the run id, workflow ref, job id and actor.
+	Claims map[string]any `json:"claims,omitempty"`
+}
+
+// Sink stores audit events, sinks only append events.
+type Sink interface {
+	Write(ctx context.Context, ev Event) error
+}
+
+// Querier is a sink which can be queried for the events of a tenant.
+type Querier interface {
+	Query(ctx context.Context, q Query) ([]Event, string, error)
+}
+
+// Query selects the events of a tenant, newest first.
+type Query struct {
+	Tenant string
+	// TimePrefix selects events with a UTC RFC3339 time starting with the prefix, such as 2025-01.
+	TimePrefix string
+	Action     string
+	Key        string
+	Subject    string
+	Limit      int32
+	PageToken  string
+}
+
+// matches reports whether the event matches the filters of the query.
+func (q Query) matches(ev Event) bool {
+	return (q.Action == "" || ev.Action == q.Action) &&
+		(q.Key == "" || ev.Key == q.Key) &&
+		(q.Subject == "" || ev.Subject == q.Subject)
+}
+
+// Recorder writes audit events to each of its sinks. The methods do nothing on a nil receiver so handlers
+// constructed without an audit log don't need a recorder.
+type Recorder struct {
+	sinks []Sink
+}
+
+// NewRecorder creates a recorder writing to the sinks.
+func NewRecorder(sinks ...Sink) *Recorder {
+	return &Recorder{sinks: sinks}
+}
+
+// Record writes the event to each sink, assigning its id and time. A sink failing is logged rather than failing
+// the request being audited.
+func (r *Recorder) Record(ctx context.Context, ev Event) {
+	if r == nil {
+		return
+	}
+
+	if ev.ID == "" {
+		ev.ID = uuid.New().String()
+	}
+
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+
+	for _, sink := range r.sinks {
+		if err := sink.Write(ctx, ev); err != nil {
+			log.Error().Err(err).Str("action", ev.Action).Str("tenant", ev.Tenant).Str("id", ev.ID).
+				Msg("failed to write audit event")
+		}
+	}
+}
+
+// Query returns the events matching the query from the first sink which can be queried, along with a token to
+// get the next page when there are more events.
+func (r *Recorder) Query(ctx context.Context, q Query) ([]Event, string, error) {
+	if r != nil {
+		for _, sink := range r.sinks {
+			if querier, ok := sink.(Querier); ok {
+				return querier.Query(ctx, q)
+			}
+		}
+	}
+
+	return nil, "", ErrQueryUnsupported
+}
+
+// Close closes the sinks which hold open files.
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+
+	var errs []error
+
+	for _, sink := range r.sinks {
+		if closer, ok := sink.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,134 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type memorySink struct {
+	events []Event
+	err    error
+}
+
+func (m *memorySink) Write(_ context.Context, ev Event) error {
+	m.events = append(m.events, ev)
+	return m.err
+}
+
+func TestRecorderRecord(t *testing.T) {
+	assert := require.New(t)
+
+	failing := &memorySink{err: errors.New("unavailable")}
+	sink := &memorySink{}
+
+	r := NewRecorder(failing, sink)
+	r.Record(context.Background(), Event{Action: ActionCacheCreate, Tenant: "github_actions#wolfeidau"})
+
+	// a failing sink doesn't stop the event reaching the others
+	assert.Len(failing.events, 1)
+	assert.Len(sink.events, 1)
+
+	ev := sink.events[0]
+	assert.NotEmpty(ev.ID)
+	assert.False(ev.Time.IsZero())
+	assert.Equal(ActionCacheCreate, ev.Action)
+	assert.Equal(failing.events[0].ID, ev.ID)
+}
+
+func TestRecorderNil(t *testing.T) {
+	assert := require.New(t)
+
+	var r *Recorder
+	r.Record(context.Background(), Event{Action: ActionCacheCreate})
+	assert.NoError(r.Close())
+
+	_, _, err := r.Query(context.Background(), Query{})
+	assert.ErrorIs(err, ErrQueryUnsupported)
+
+	_, _, err = NewRecorder(&memorySink{}).Query(context.Background(), Query{})
+	assert.ErrorIs(err, ErrQueryUnsupported)
+}
+
+func TestQueryMatches(t *testing.T) {
+	ev := Event{Action: ActionCacheRestore, Key: "go-mod", Subject: "repo:wolfeidau/zipstash:ref:refs/heads/main"}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  bool
+	}{
+		{name: "no filters", query: Query{}, want: true},
+		{name: "action", query: Query{Action: ActionCacheRestore}, want: true},
+		{name: "other action", query: Query{Action: ActionCacheCreate}, want: false},
+		{name: "key and subject", query: Query{Key: "go-mod", Subject: ev.Subject}, want: true},
+		{name: "other subject", query: Query{Key: "go-mod", Subject: "repo:wolfeidau/zipstash:ref:refs/heads/dev"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.New(t).Equal(tt.want, tt.query.matches(ev))
+		})
+	}
+}
+
+func TestSortKey(t *testing.T) {
+	assert := require.New(t)
+
+	base := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	keys := []string{
+		sortKey(Event{ID: "c", Time: base}),
+		sortKey(Event{ID: "b", Time: base.Add(100 * time.Millisecond)}),
+		sortKey(Event{ID: "a", Time: base.Add(time.Second)}),
+	}
+
+	// keys sort by time even when the fractional seconds end in zeros
+	assert.True(sort.StringsAreSorted(keys))
+	assert.Equal("2025-01-02T03:04:05.000000000Z#c", keys[0])
+}
+
+func TestJSONSink(t *testing.T) {
+	assert := require.New(t)
+
+	var buf bytes.Buffer
+	sink := NewJSONSink(&buf)
+
+	assert.NoError(sink.Write(context.Background(), Event{ID: "1", Action: ActionCacheCommit, Claims: map[string]any{"run_id": "42"}}))
+	assert.NoError(sink.Write(context.Background(), Event{ID: "2", Action: ActionCacheRestore}))
+	assert.NoError(sink.Close())
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	assert.Len(lines, 2)
+
+	var ev Event
+	assert.NoError(json.Unmarshal(lines[0], &ev))
+	assert.Equal("1", ev.ID)
+	assert.Equal("42", ev.Claims["run_id"])
+}
+
+func TestOpenFileSink(t *testing.T) {
+	assert := require.New(t)
+
+	name := filepath.Join(t.TempDir(), "audit.log")
+
+	// the file is appended to when the server restarts
+	for _, id := range []string{"1", "2"} {
+		sink, err := OpenFileSink(name)
+		assert.NoError(err)
+		assert.NoError(sink.Write(context.Background(), Event{ID: id}))
+		assert.NoError(sink.Close())
+	}
+
+	data, err := os.ReadFile(name)
+	assert.NoError(err)
+	assert.Len(bytes.Split(bytes.TrimSpace(data), []byte("\n")), 2)
+}
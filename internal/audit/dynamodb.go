@@ -0,0 +1,105 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/wolfeidau/dynastorev2"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+const (
+	// auditPartitionPrefix prefixes the partition of the events of each tenant in the cache index table.
+	auditPartitionPrefix = "audit#"
+
+	// sortKeyTimeFormat is a fixed width RFC3339 time so the events of a partition sort by time, RFC3339Nano
+	// trims trailing zeros which breaks the ordering.
+	sortKeyTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+	// defaultQueryLimit is the number of events read when a query doesn't have a limit.
+	defaultQueryLimit = 100
+)
+
+// DynamoDBSink stores events in a partition of each tenant in the cache index table, events expire after the
+// retention.
+type DynamoDBSink struct {
+	store     *dynastorev2.Store[string, string, Event]
+	retention time.Duration
+}
+
+// NewDynamoDBSink creates a sink storing events in the table.
+func NewDynamoDBSink(client *dynamodb.Client, table string, retention time.Duration) *DynamoDBSink {
+	return &DynamoDBSink{
+		store:     dynastorev2.New[string, string, Event](client, table),
+		retention: retention,
+	}
+}
+
+func (s *DynamoDBSink) Write(ctx context.Context, ev Event) error {
+	ctx, span := trace.Start(ctx, "DynamoDBSink.Write")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("action", ev.Action), attribute.String("tenant", ev.Tenant))
+
+	_, err := s.store.Create(ctx, auditPartition(ev.Tenant), sortKey(ev), ev,
+		s.store.WriteWithTTL(s.retention),
+	)
+	if err != nil {
+		span.RecordError(err)
+
+		return fmt.Errorf("failed to put audit event: %w", err)
+	}
+
+	return nil
+}
+
+// Query reads a page of the events of the tenant newest first, the filters of the query are applied to the page
+// so it may hold fewer events than the limit.
+func (s *DynamoDBSink) Query(ctx context.Context, q Query) ([]Event, string, error) {
+	ctx, span := trace.Start(ctx, "DynamoDBSink.Query")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("tenant", q.Tenant), attribute.String("time_prefix", q.TimePrefix))
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultQueryLimit
+	}
+
+	opts := []dynastorev2.ReadOption[string, string]{
+		s.store.ReadWithLimit(limit),
+		s.store.ReadWithReverseSortResults(true),
+	}
+	if q.PageToken != "" {
+		opts = append(opts, s.store.ReadWithLastEvaluatedKey(q.PageToken))
+	}
+
+	res, events, err := s.store.ListBySortKeyPrefix(ctx, auditPartition(q.Tenant), q.TimePrefix, opts...)
+	if err != nil {
+		span.RecordError(err)
+
+		return nil, "", fmt.Errorf("failed to list audit events: %w", err)
+	}
+
+	matched := make([]Event, 0, len(events))
+	for _, ev := range events {
+		if q.matches(ev) {
+			matched = append(matched, ev)
+		}
+	}
+
+	return matched, res.LastEvaluatedKey, nil
+}
+
+func auditPartition(tenant string) string {
+	return auditPartitionPrefix + tenant
+}
+
+// sortKey orders the events of a partition by time, the id keeps events at the same time unique.
+func sortKey(ev Event) string {
+	return ev.Time.UTC().Format(sortKeyTimeFormat) + "#" + ev.ID
+}
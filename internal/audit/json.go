@@ -0,0 +1,60 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// JSONSink writes each event as a line of JSON.
+type JSONSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewJSONSink creates a sink writing to w, such as stdout.
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{w: w}
+}
+
+// OpenFileSink creates a sink appending to the file, the file is created if it doesn't exist.
+func OpenFileSink(name string) (*JSONSink, error) {
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	return &JSONSink{w: f, closer: f}, nil
+}
+
+func (s *JSONSink) Write(_ context.Context, ev Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit event: %w", err)
+	}
+
+	// each event is a single write so lines from concurrent requests aren't interleaved
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.w.Write(data)
+	if err != nil {
+		return fmt.Errorf("failed to write audit event: %w", err)
+	}
+
+	return nil
+}
+
+func (s *JSONSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+
+	return s.closer.Close()
+}
@@ -26,10 +26,14 @@ func NewOIDCValidator(ctx context.Context, oidcProviders map[string]OIDCProvider
 		return nil, fmt.Errorf("failed to create JWK cache: %v", err)
 	}
 
-	return &OIDCCachingValidator{
+	v := &OIDCCachingValidator{
 		c:             c,
 		oidcProviders: oidcProviders,
-	}, nil
+	}
+
+	registerJWKSMetrics(v)
+
+	return v, nil
 }
 
 func (v *OIDCCachingValidator) registerJWKSEndpoints(ctx context.Context, oidcProvider OIDCProvider) error {
@@ -47,6 +51,22 @@ func (v *OIDCCachingValidator) registerJWKSEndpoints(ctx context.Context, oidcPr
 	return nil
 }
 
+// Ready registers the JWK sets of every provider and checks they have been fetched, this is used by readiness
+// probes so the server only receives traffic once tokens can be validated.
+func (v *OIDCCachingValidator) Ready(ctx context.Context) error {
+	for issuer, oidcProvider := range v.oidcProviders {
+		if err := v.registerJWKSEndpoints(ctx, oidcProvider); err != nil {
+			return fmt.Errorf("%w: %s: %v", errJWKSUnavailable, issuer, err)
+		}
+
+		if _, err := v.c.CachedSet(oidcProvider.JWKSURL); err != nil {
+			return fmt.Errorf("%w: %s: %v", errJWKSUnavailable, issuer, err)
+		}
+	}
+
+	return nil
+}
+
 func (v *OIDCCachingValidator) ValidateToken(ctx context.Context, tokenStr, expectedAudience string) (OIDCIdentity, error) {
 	// parse the JWT with the expected audience
 	token, err := jwt.Parse(
@@ -55,29 +75,29 @@ func (v *OIDCCachingValidator) ValidateToken(ctx context.Context, tokenStr, expe
 		jwt.WithAudience(expectedAudience),
 	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse token: %v", err)
+		return nil, fmt.Errorf("%w: failed to parse token: %v", errMalformedToken, err)
 	}
 
 	// get the issuer from the token
 	tokenIssuer, ok := token.Issuer()
 	if !ok {
-		return nil, fmt.Errorf("token has no issuer")
+		return nil, fmt.Errorf("%w: token has no issuer", errMalformedToken)
 	}
 
 	oidcProvider, ok := v.oidcProviders[tokenIssuer]
 	if !ok {
-		return nil, fmt.Errorf("unknown issuer: %v", tokenIssuer)
+		return nil, fmt.Errorf("%w: %v", errUnknownIssuer, tokenIssuer)
 	}
 
 	// register the JWK endpoints for the provider
 	if err := v.registerJWKSEndpoints(ctx, oidcProvider); err != nil {
-		return nil, fmt.Errorf("failed to register JWK endpoints: %v", err)
+		return nil, fmt.Errorf("%w: failed to register JWK endpoints: %v", errJWKSUnavailable, err)
 	}
 
 	// get the JWK set for the issuer
 	set, err := v.c.CachedSet(oidcProvider.JWKSURL)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get JWK set: %v", err)
+		return nil, fmt.Errorf("%w: failed to get JWK set: %v", errJWKSUnavailable, err)
 	}
 
 	// validate the token with the JWK set
@@ -89,7 +109,7 @@ func (v *OIDCCachingValidator) ValidateToken(ctx context.Context, tokenStr, expe
 		jwt.WithAudience(expectedAudience),
 	)
 	if err != nil {
-		return nil, fmt.Errorf("token validation failed: %v", err)
+		return nil, fmt.Errorf("%w: %v", errTokenInvalid, err)
 	}
 
 	oidcId := &oidcIdentity{
@@ -98,7 +118,7 @@ func (v *OIDCCachingValidator) ValidateToken(ctx context.Context, tokenStr, expe
 	}
 
 	if err := oidcId.parseClaims(); err != nil {
-		return nil, fmt.Errorf("failed to parse claims: %v", err)
+		return nil, fmt.Errorf("%w: %v", errClaimsInvalid, err)
 	}
 
 	return oidcId, nil
@@ -185,5 +205,6 @@ type ZeroLogErrorSink struct {
 }
 
 func (ZeroLogErrorSink) Put(ctx context.Context, err error) {
+	jwksRefreshFailures.Add(ctx, 1)
 	log.Error().Err(err).Msg("failed to get OIDC identity")
 }
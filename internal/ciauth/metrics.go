@@ -0,0 +1,96 @@
+package ciauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/wolfeidau/zipstash/pkg/metrics"
+)
+
+// errors returned when validating a token, these are used as the reason of validation failures in metrics.
+var (
+	errMalformedToken  = errors.New("malformed token")
+	errUnknownIssuer   = errors.New("unknown issuer")
+	errJWKSUnavailable = errors.New("jwks unavailable")
+	errTokenInvalid    = errors.New("token validation failed")
+	errClaimsInvalid   = errors.New("invalid claims")
+)
+
+// the instruments are created from the global meter so they record to the provider configured by the server.
+var (
+	validationFailures, _ = metrics.Meter().Int64Counter("zipstash.oidc.validation.failures",
+		metric.WithDescription("OIDC tokens which failed validation by reason"),
+		metric.WithUnit("{token}"),
+	)
+	jwksRefreshFailures, _ = metrics.Meter().Int64Counter("zipstash.oidc.jwks.refresh.failures",
+		metric.WithDescription("failed refreshes of the JWK sets of the OIDC providers"),
+		metric.WithUnit("{refresh}"),
+	)
+)
+
+func recordValidationFailure(ctx context.Context, reason string) {
+	validationFailures.Add(ctx, 1, metric.WithAttributes(attribute.String("reason", reason)))
+}
+
+// tokenFailureReason returns the reason a bearer token couldn't be read from the headers.
+func tokenFailureReason(header http.Header) string {
+	if header.Get("Authorization") == "" {
+		return "missing_token"
+	}
+
+	return "malformed_token"
+}
+
+// validationFailureReason returns the reason a token failed validation.
+func validationFailureReason(err error) string {
+	switch {
+	case errors.Is(err, errMalformedToken):
+		return "malformed_token"
+	case errors.Is(err, errUnknownIssuer):
+		return "unknown_issuer"
+	case errors.Is(err, errJWKSUnavailable):
+		return "jwks_unavailable"
+	case errors.Is(err, errTokenInvalid):
+		return "invalid_token"
+	case errors.Is(err, errClaimsInvalid):
+		return "invalid_claims"
+	default:
+		return "unknown"
+	}
+}
+
+// registerJWKSMetrics reports the number of keys cached for each provider, a provider reports no keys until a
+// token it issued is validated or while its JWK set can't be fetched.
+func registerJWKSMetrics(v *OIDCCachingValidator) {
+	keys, err := metrics.Meter().Int64ObservableGauge("zipstash.oidc.jwks.keys",
+		metric.WithDescription("keys in the cached JWK set of each OIDC provider"),
+		metric.WithUnit("{key}"),
+	)
+	if err != nil {
+		return
+	}
+
+	_, _ = metrics.Meter().RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		for issuer, provider := range v.oidcProviders {
+			var n int64
+			// lookup doesn't wait for the set to be fetched, the cached set blocks until it is which would hang
+			// the collection of metrics while a provider is unavailable
+			if v.c.IsRegistered(ctx, provider.JWKSURL) {
+				if set, err := v.c.Lookup(ctx, provider.JWKSURL); err == nil {
+					n = int64(set.Len())
+				}
+			}
+
+			o.ObserveInt64(keys, n, metric.WithAttributes(
+				attribute.String("provider", provider.Name),
+				attribute.String("issuer", issuer),
+			))
+		}
+
+		return nil
+	}, keys)
+}
@@ -0,0 +1,40 @@
+package ciauth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidationFailureReason(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "malformed", err: fmt.Errorf("%w: token has no issuer", errMalformedToken), want: "malformed_token"},
+		{name: "unknown issuer", err: fmt.Errorf("%w: https://example.com", errUnknownIssuer), want: "unknown_issuer"},
+		{name: "jwks", err: fmt.Errorf("%w: failed to get JWK set", errJWKSUnavailable), want: "jwks_unavailable"},
+		{name: "invalid", err: fmt.Errorf("%w: exp not satisfied", errTokenInvalid), want: "invalid_token"},
+		{name: "claims", err: fmt.Errorf("%w: unsupported provider", errClaimsInvalid), want: "invalid_claims"},
+		{name: "other", err: errors.New("boom"), want: "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.Equal(tt.want, validationFailureReason(tt.err))
+		})
+	}
+}
+
+func TestTokenFailureReason(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("missing_token", tokenFailureReason(http.Header{}))
+	assert.Equal("malformed_token", tokenFailureReason(http.Header{"Authorization": {"Basic abc"}}))
+}
@@ -19,6 +19,7 @@ func NewOIDCAuthMiddleware(audience string, validator *OIDCCachingValidator) fun
 			if err != nil {
 				log.Error().Err(err).Msg("failed to extract bearer token")
 				span.SetStatus(codes.Error, err.Error())
+				recordValidationFailure(ctx, tokenFailureReason(r.Header))
 				http.Error(w, "invalid token", http.StatusUnauthorized)
 				return
 			}
@@ -27,6 +28,7 @@ func NewOIDCAuthMiddleware(audience string, validator *OIDCCachingValidator) fun
 			if err != nil {
 				log.Error().Err(err).Msg("failed to validate token")
 				span.SetStatus(codes.Error, err.Error())
+				recordValidationFailure(ctx, validationFailureReason(err))
 				http.Error(w, "invalid token", http.StatusUnauthorized)
 				return
 			}
@@ -38,8 +40,13 @@ func NewOIDCAuthMiddleware(audience string, validator *OIDCCachingValidator) fun
 				Str("owner", oidcIdentity.Owner()).
 				Msg("OIDC identity")
 
-			ctx = context.WithValue(ctx, oidcIdentityKey{}, oidcIdentity)
+			ctx = WithOIDCIdentity(ctx, oidcIdentity)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// WithOIDCIdentity returns a copy of the context carrying the identity, see GetOIDCIdentity.
+func WithOIDCIdentity(ctx context.Context, identity OIDCIdentity) context.Context {
+	return context.WithValue(ctx, oidcIdentityKey{}, identity)
+}
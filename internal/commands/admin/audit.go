@@ -0,0 +1,89 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"connectrpc.com/connect"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	provisionv1 "github.com/wolfeidau/zipstash/api/gen/proto/go/provision/v1"
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+type AuditCmd struct {
+	Query AuditQueryCmd `cmd:"" help:"query the audit events of a tenant, newest first."`
+}
+
+type AuditQueryCmd struct {
+	Provider   string `help:"provider type" default:"github" enum:"github,gitlab,buildkite"`
+	Slug       string `help:"slug of the tenant" required:""`
+	TimePrefix string `help:"only return events with a UTC RFC3339 time starting with the prefix, such as 2025-01 or 2025-01-02T03"`
+	Action     string `help:"only return events of the action, such as cache.create, cache.commit, cache.restore or tenant.create"`
+	Key        string `help:"only return events of the cache key"`
+	Subject    string `help:"only return events of the OIDC subject"`
+	Limit      int32  `help:"number of events read per page" default:"100"`
+	PageToken  string `help:"token of the page to read, printed when there are more events"`
+	All        bool   `help:"read all the pages rather than the first"`
+}
+
+// Run prints each event as a line of JSON so the output can be filtered with jq.
+func (c *AuditQueryCmd) Run(ctx context.Context, globals *Globals) error {
+	ctx, span := trace.Start(ctx, "AuditQueryCmd.Run")
+	defer span.End()
+
+	prov, err := providerValue(c.Provider)
+	if err != nil {
+		return err
+	}
+
+	pageToken := c.PageToken
+
+	for {
+		res, err := globals.Client.QueryAuditEvents(ctx, connect.NewRequest(&provisionv1.QueryAuditEventsRequest{
+			ProviderType: prov,
+			Slug:         c.Slug,
+			TimePrefix:   c.TimePrefix,
+			Action:       c.Action,
+			Key:          c.Key,
+			Subject:      c.Subject,
+			PageSize:     c.Limit,
+			PageToken:    pageToken,
+		}))
+		if err != nil {
+			return fmt.Errorf("failed to query audit events: %w", err)
+		}
+
+		if err := writeEvents(os.Stdout, res.Msg.Events); err != nil {
+			return err
+		}
+
+		pageToken = res.Msg.NextPageToken
+		if pageToken == "" {
+			return nil
+		}
+
+		if !c.All {
+			log.Info().Str("page_token", pageToken).Msg("more events, use --page-token to read the next page")
+			return nil
+		}
+	}
+}
+
+func writeEvents(w io.Writer, events []*provisionv1.AuditEvent) error {
+	for _, ev := range events {
+		data, err := protojson.Marshal(ev)
+		if err != nil {
+			return fmt.Errorf("failed to marshal audit event: %w", err)
+		}
+
+		if _, err := fmt.Fprintln(w, string(data)); err != nil {
+			return fmt.Errorf("failed to write audit event: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -3,35 +3,31 @@ package admin
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"connectrpc.com/connect"
 	"github.com/rs/zerolog/log"
 	"github.com/wolfeidau/zipstash/pkg/trace"
+	"google.golang.org/protobuf/types/known/durationpb"
 
 	providerv1 "github.com/wolfeidau/zipstash/api/gen/proto/go/provider/v1"
 	provisionv1 "github.com/wolfeidau/zipstash/api/gen/proto/go/provision/v1"
 )
 
 type CreateTenantCmd struct {
-	Provider string `help:"provider type" default:"github" enum:"github,gitlab,buildkite"`
-	TenantID string `help:"tenant id to create" required:""`
-	Slug     string `help:"slug of the tenant" required:""`
+	Provider string        `help:"provider type" default:"github" enum:"github,gitlab,buildkite"`
+	TenantID string        `help:"tenant id to create" required:""`
+	Slug     string        `help:"slug of the tenant" required:""`
+	TTL      time.Duration `help:"how long cache entries of the tenant are retained when no ttl is requested, uses the server default when not set"`
 }
 
 func (c *CreateTenantCmd) Run(ctx context.Context, globals *Globals) error {
 	ctx, span := trace.Start(ctx, "CreateTenantCmd.Run")
 	defer span.End()
 
-	var prov providerv1.Provider
-	switch c.Provider {
-	case "github":
-		prov = providerv1.Provider_PROVIDER_GITHUB_ACTIONS
-	case "gitlab":
-		prov = providerv1.Provider_PROVIDER_GITLAB
-	case "buildkite":
-		prov = providerv1.Provider_PROVIDER_BUILDKITE
-	default:
-		return fmt.Errorf("invalid provider type: %s", c.Provider)
+	prov, err := providerValue(c.Provider)
+	if err != nil {
+		return err
 	}
 
 	res, err := globals.Client.CreateTenant(ctx, &connect.Request[provisionv1.CreateTenantRequest]{
@@ -39,6 +35,7 @@ func (c *CreateTenantCmd) Run(ctx context.Context, globals *Globals) error {
 			Id:           c.TenantID,
 			ProviderType: prov,
 			Slug:         c.Slug,
+			Ttl:          ttlValue(c.TTL),
 		},
 	})
 	if err != nil {
@@ -49,3 +46,24 @@ func (c *CreateTenantCmd) Run(ctx context.Context, globals *Globals) error {
 
 	return nil
 }
+
+func providerValue(provider string) (providerv1.Provider, error) {
+	switch provider {
+	case "github":
+		return providerv1.Provider_PROVIDER_GITHUB_ACTIONS, nil
+	case "gitlab":
+		return providerv1.Provider_PROVIDER_GITLAB, nil
+	case "buildkite":
+		return providerv1.Provider_PROVIDER_BUILDKITE, nil
+	default:
+		return providerv1.Provider_PROVIDER_UNSPECIFIED, fmt.Errorf("invalid provider type: %s", provider)
+	}
+}
+
+func ttlValue(ttl time.Duration) *durationpb.Duration {
+	if ttl <= 0 {
+		return nil
+	}
+
+	return durationpb.New(ttl)
+}
@@ -25,6 +25,8 @@ import (
 type AdminLambdaServerCmd struct {
 	CacheIndexTable string `help:"table to store cache index" env:"CACHE_INDEX_TABLE"`
 	TrustRemote     bool   `help:"trust remote spans"`
+
+	AuditFlags `embed:""`
 }
 
 func (s *AdminLambdaServerCmd) Run(ctx context.Context, globals *Globals) error {
@@ -52,7 +54,12 @@ func (s *AdminLambdaServerCmd) Run(ctx context.Context, globals *Globals) error
 		GetDynamoDBClient: ddbClientFunc,
 	})
 
-	psh := server.NewProvisionServiceHandler(store)
+	auditor, err := s.newRecorder(ddbClientFunc, s.CacheIndexTable)
+	if err != nil {
+		return fmt.Errorf("failed to create audit log: %w", err)
+	}
+
+	psh := server.NewProvisionServiceHandler(store, auditor)
 
 	mux := http.NewServeMux()
 	path, handler := provisionv1connect.NewProvisionServiceHandler(psh, opts...)
@@ -0,0 +1,43 @@
+package commands
+
+import (
+	"os"
+	"time"
+
+	"github.com/wolfeidau/zipstash/internal/audit"
+	"github.com/wolfeidau/zipstash/internal/index"
+)
+
+// AuditFlags configures the sinks of the audit log of the servers.
+type AuditFlags struct {
+	AuditLog       string        `help:"file the audit log is appended to as JSON lines, - writes to stdout" env:"AUDIT_LOG"`
+	AuditDynamoDB  bool          `help:"store the audit log in the cache index table so it can be queried with zipstash-admin audit query" env:"AUDIT_DYNAMODB"`
+	AuditRetention time.Duration `help:"how long audit events are kept in the cache index table" env:"AUDIT_RETENTION" default:"8760h"`
+}
+
+// newRecorder returns a recorder writing to the configured sinks, this is nil when the audit log is disabled.
+func (a AuditFlags) newRecorder(ddbClientFunc index.DynamoDBClientFunc, table string) (*audit.Recorder, error) {
+	var sinks []audit.Sink
+
+	switch a.AuditLog {
+	case "":
+	case "-":
+		sinks = append(sinks, audit.NewJSONSink(os.Stdout))
+	default:
+		sink, err := audit.OpenFileSink(a.AuditLog)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	if a.AuditDynamoDB {
+		sinks = append(sinks, audit.NewDynamoDBSink(ddbClientFunc(), table, a.AuditRetention))
+	}
+
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+
+	return audit.NewRecorder(sinks...), nil
+}
@@ -2,18 +2,32 @@ package client
 
 import (
 	"bufio"
+	"maps"
+	"os"
+	"slices"
 	"strings"
+	"time"
 
+	"connectrpc.com/connect"
 	"github.com/wolfeidau/zipstash/api/gen/proto/go/cache/v1/cachev1connect"
 	providerv1 "github.com/wolfeidau/zipstash/api/gen/proto/go/provider/v1"
+	"github.com/wolfeidau/zipstash/pkg/progress"
 )
 
-const audience = "zipstash.wolfe.id.au"
+const (
+	audience = "zipstash.wolfe.id.au"
+	megabyte = 1024 * 1024
+
+	progressLogInterval = 10 * time.Second
+)
 
 type Globals struct {
-	Client  cachev1connect.CacheServiceClient
-	Version string
-	Debug   bool
+	Client cachev1connect.CacheServiceClient
+	// Endpoint and ClientOptions are used by commands which build their own client.
+	Endpoint      string
+	ClientOptions []connect.ClientOption
+	Version       string
+	Debug         bool
 }
 
 func SplitLines(s string) []string {
@@ -25,6 +39,17 @@ func SplitLines(s string) []string {
 	return lines
 }
 
+// nonEmptyLines splits s into lines, dropping blank lines and surrounding whitespace.
+func nonEmptyLines(s string) []string {
+	var lines []string
+	for _, line := range SplitLines(s) {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
 func convertProviderTypeV1(tokenSource string) providerv1.Provider {
 	switch tokenSource {
 	case "github_actions":
@@ -37,3 +62,42 @@ func convertProviderTypeV1(tokenSource string) providerv1.Provider {
 		return providerv1.Provider_PROVIDER_UNSPECIFIED
 	}
 }
+
+// newReporter builds the progress reporter for the requested mode, the summary is always included so
+// transfer statistics are captured regardless of how progress is displayed.
+func newReporter(mode string, summary *progress.Summary) progress.Reporter {
+	switch mode {
+	case "bar":
+		return progress.Multi(summary, progress.NewBar(os.Stderr))
+	case "log":
+		return progress.Multi(summary, progress.NewLogger(progressLogInterval))
+	case "auto":
+		if isTerminal(os.Stderr) {
+			return progress.Multi(summary, progress.NewBar(os.Stderr))
+		}
+		return progress.Multi(summary, progress.NewLogger(progressLogInterval))
+	default:
+		return summary
+	}
+}
+
+// newManifestReporter builds the progress reporter for one of several caches transferred concurrently, a
+// progress bar can't display concurrent transfers so log lines labelled with the cache name are used instead.
+func newManifestReporter(mode, name string, summary *progress.Summary) progress.Reporter {
+	if mode == "none" {
+		return summary
+	}
+	return progress.Multi(summary, progress.NewLogger(progressLogInterval).Named(name))
+}
+
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+func sortedKeys(m map[string]string) []string {
+	return slices.Sorted(maps.Keys(m))
+}
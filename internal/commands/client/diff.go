@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/wolfeidau/zipstash/pkg/archive"
+	"github.com/wolfeidau/zipstash/pkg/tokens"
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+type DiffCmd struct {
+	EntryFlags `embed:""`
+	KeyA       string `arg:"" help:"key of the original cache entry"`
+	KeyB       string `arg:"" help:"key of the cache entry compared with the original"`
+}
+
+// DiffResult is the machine readable result of a diff.
+type DiffResult struct {
+	KeyA    string           `json:"key_a"`
+	KeyB    string           `json:"key_b"`
+	Changes []archive.Change `json:"changes"`
+}
+
+func (c *DiffCmd) Run(ctx context.Context, globals *Globals) error {
+	ctx, span := trace.Start(ctx, "DiffCmd.Run")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("key_a", c.KeyA),
+		attribute.String("key_b", c.KeyB),
+	)
+
+	token, err := tokens.GetToken(ctx, c.TokenSource, audience, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get token: %w", err)
+	}
+
+	a, err := c.readManifest(ctx, globals, token, c.KeyA)
+	if err != nil {
+		return err
+	}
+
+	b, err := c.readManifest(ctx, globals, token, c.KeyB)
+	if err != nil {
+		return err
+	}
+
+	result := &DiffResult{
+		KeyA:    a.Key,
+		KeyB:    b.Key,
+		Changes: archive.Diff(a.Manifest, b.Manifest),
+	}
+
+	span.SetAttributes(attribute.Int("changes", len(result.Changes)))
+
+	return result.write(os.Stdout, c.Output)
+}
+
+// write the result to w using the requested output format, the text format is a line per change prefixed
+// with + for added, - for removed and ~ for modified files.
+func (r *DiffResult) write(w io.Writer, format string) error {
+	if format == outputJSON {
+		if r.Changes == nil {
+			r.Changes = []archive.Change{}
+		}
+		return json.NewEncoder(w).Encode(r)
+	}
+
+	for _, change := range r.Changes {
+		var err error
+		switch change.Kind {
+		case archive.ChangeAdded:
+			_, err = fmt.Fprintf(w, "+ %s (%d bytes)\n", change.Name, change.NewSize)
+		case archive.ChangeRemoved:
+			_, err = fmt.Fprintf(w, "- %s (%d bytes)\n", change.Name, change.OldSize)
+		case archive.ChangeModified:
+			_, err = fmt.Fprintf(w, "~ %s (%d -> %d bytes)\n", change.Name, change.OldSize, change.NewSize)
+		case archive.ChangeMode:
+			_, err = fmt.Fprintf(w, "~ %s (%s -> %s)\n", change.Name, change.OldMode, change.NewMode)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/wolfeidau/zipstash/pkg/archive"
+)
+
+func TestDiffResultWrite(t *testing.T) {
+	changes := []archive.Change{
+		{Name: "tree/a.txt", Kind: archive.ChangeModified, OldSize: 1, NewSize: 2, OldMode: "-rw-r--r--", NewMode: "-rw-r--r--"},
+		{Name: "tree/b.txt", Kind: archive.ChangeRemoved, OldSize: 3, OldMode: "-rw-r--r--"},
+		{Name: "tree/c.sh", Kind: archive.ChangeMode, OldSize: 4, NewSize: 4, OldMode: "-rw-r--r--", NewMode: "-rwxr-xr-x"},
+		{Name: "tree/d.txt", Kind: archive.ChangeAdded, NewSize: 5, NewMode: "-rw-r--r--"},
+	}
+
+	tests := []struct {
+		name     string
+		result   *DiffResult
+		format   string
+		expected string
+	}{
+		{
+			name:   "text",
+			result: &DiffResult{KeyA: "a", KeyB: "b", Changes: changes},
+			format: outputText,
+			expected: "~ tree/a.txt (1 -> 2 bytes)\n" +
+				"- tree/b.txt (3 bytes)\n" +
+				"~ tree/c.sh (-rw-r--r-- -> -rwxr-xr-x)\n" +
+				"+ tree/d.txt (5 bytes)\n",
+		},
+		{
+			name:     "json without changes",
+			result:   &DiffResult{KeyA: "a", KeyB: "b"},
+			format:   outputJSON,
+			expected: `{"key_a":"a","key_b":"b","changes":[]}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			buf := new(bytes.Buffer)
+			assert.NoError(tt.result.write(buf, tt.format))
+			assert.Equal(tt.expected, buf.String())
+		})
+	}
+}
+
+func TestEntryManifestWrite(t *testing.T) {
+	assert := require.New(t)
+
+	m := &EntryManifest{
+		Manifest: &archive.Manifest{
+			Mappings: []archive.ManifestMapping{{Path: "~/.npm", RelativePath: ".npm", Home: true}},
+			Files: []archive.ManifestFile{
+				{Name: ".npm/a.txt", Mode: "-rw-r--r--", Size: 10},
+				{Name: ".npm/link", Mode: "Lrwxrwxrwx", Size: 5, Link: "a.txt"},
+			},
+			Complete: true,
+		},
+		Key:  "npm",
+		Size: 100,
+	}
+
+	buf := new(bytes.Buffer)
+	assert.NoError(m.write(buf, outputText))
+	assert.Contains(buf.String(), "path:          ~/.npm -> ~/.npm\n")
+	assert.Contains(buf.String(), "uncompressed:  15\n")
+	assert.Contains(buf.String(), "Lrwxrwxrwx  5   .npm/link -> a.txt\n")
+	assert.NotContains(buf.String(), "central directory")
+}
@@ -0,0 +1,173 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"text/tabwriter"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+
+	cachev1 "github.com/wolfeidau/zipstash/api/gen/proto/go/cache/v1"
+	"github.com/wolfeidau/zipstash/pkg/archive"
+	"github.com/wolfeidau/zipstash/pkg/downloader"
+	"github.com/wolfeidau/zipstash/pkg/tokens"
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+// inspectPartSize is requested when reading the manifest of an entry, large archives are split into parts of
+// this size so reading the end of the archive only fetches the last part or two. The server raises this to
+// its minimum part size.
+const inspectPartSize = 10 * megabyte
+
+// EntryFlags identify the cache entries read by inspect and diff.
+type EntryFlags struct {
+	TokenSource    string `help:"token source" default:"github_actions" env:"INPUT_TOKEN_SOURCE"`
+	Branch         string `help:"branch to use for the cache entry" env:"INPUT_BRANCH" required:""`
+	Name           string `help:"repository, project or pipeline name to use for the cache entry" env:"INPUT_REPOSITORY" required:""`
+	Owner          string `help:"owner of the cache entry" env:"INPUT_OWNER"`
+	FallbackBranch string `help:"fallback branch to use for the cache entry" env:"INPUT_FALLBACK_BRANCH"`
+	Output         string `help:"output format" default:"text" enum:"text,json" env:"INPUT_OUTPUT"`
+}
+
+// EntryManifest is the manifest of a cache entry along with the details of the entry.
+type EntryManifest struct {
+	*archive.Manifest
+	Key         string `json:"key"`
+	EntryID     string `json:"entry_id"`
+	Sha256sum   string `json:"sha256sum"`
+	Compression string `json:"compression"`
+	Size        int64  `json:"size"`
+	// Fetched is the number of bytes of the archive downloaded to read the manifest.
+	Fetched int64 `json:"fetched"`
+}
+
+// readManifest looks up the entry for the key and reads its manifest using ranged reads of the archive.
+func (f *EntryFlags) readManifest(ctx context.Context, globals *Globals, token, key string) (*EntryManifest, error) {
+	ctx, span := trace.Start(ctx, "EntryFlags.readManifest")
+	defer span.End()
+
+	spec, err := CacheSpec{Key: key}.Resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.String("key", spec.Key))
+
+	getEntryResp, err := globals.Client.GetEntry(ctx, newAuthenticatedProviderRequest(&cachev1.GetEntryRequest{
+		Key:            spec.Key,
+		Name:           f.Name,
+		Branch:         f.Branch,
+		ProviderType:   convertProviderTypeV1(f.TokenSource),
+		Owner:          f.Owner,
+		FallbackBranch: f.FallbackBranch,
+		Platform: &cachev1.Platform{
+			OperatingSystem: runtime.GOOS,
+			Architecture:    runtime.GOARCH,
+			CpuCount:        int32(runtime.NumCPU()),
+		},
+		PartSize: inspectPartSize,
+		Inspect:  true,
+	}, token, f.TokenSource, globals.Version))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cache entry %s: %w", spec.Key, err)
+	}
+
+	entry := getEntryResp.Msg
+
+	r, err := downloader.NewReaderAt(ctx, convertToDownloadInstructions(entry.DownloadInstructions), entry.CacheEntry.FileSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache entry %s: %w", spec.Key, err)
+	}
+
+	zr, err := zip.NewReader(r, r.Size())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	manifest, err := archive.ReadManifest(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug().Str("key", entry.CacheEntry.Key).Int64("size", r.Size()).Int64("fetched", r.Fetched()).Msg("read manifest")
+
+	return &EntryManifest{
+		Manifest:    manifest,
+		Key:         entry.CacheEntry.Key,
+		EntryID:     entry.Id,
+		Sha256sum:   entry.CacheEntry.Sha256Sum,
+		Compression: entry.CacheEntry.Compression,
+		Size:        entry.CacheEntry.FileSize,
+		Fetched:     r.Fetched(),
+	}, nil
+}
+
+type InspectCmd struct {
+	EntryFlags `embed:""`
+	Key        string `arg:"" help:"key of the cache entry, this may be a template using os, arch, env and hashFiles"`
+}
+
+func (c *InspectCmd) Run(ctx context.Context, globals *Globals) error {
+	ctx, span := trace.Start(ctx, "InspectCmd.Run")
+	defer span.End()
+
+	token, err := tokens.GetToken(ctx, c.TokenSource, audience, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get token: %w", err)
+	}
+
+	manifest, err := c.readManifest(ctx, globals, token, c.Key)
+	if err != nil {
+		return err
+	}
+
+	return manifest.write(os.Stdout, c.Output)
+}
+
+// write the manifest to w using the requested output format, the text format is a summary of the entry
+// followed by a line per file.
+func (m *EntryManifest) write(w io.Writer, format string) error {
+	if format == outputJSON {
+		return json.NewEncoder(w).Encode(m)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "key:\t%s\n", m.Key)
+	fmt.Fprintf(tw, "entry:\t%s\n", m.EntryID)
+	fmt.Fprintf(tw, "sha256:\t%s\n", m.Sha256sum)
+	fmt.Fprintf(tw, "compression:\t%s\n", m.Compression)
+	fmt.Fprintf(tw, "size:\t%d\n", m.Size)
+	fmt.Fprintf(tw, "uncompressed:\t%d\n", m.TotalSize())
+	fmt.Fprintf(tw, "files:\t%d\n", len(m.Files))
+
+	for _, mapping := range m.Mappings {
+		root := "."
+		if mapping.Home {
+			root = "~"
+		}
+		fmt.Fprintf(tw, "path:\t%s -> %s/%s\n", mapping.Path, root, mapping.RelativePath)
+	}
+
+	if !m.Complete {
+		fmt.Fprintln(tw, "manifest:\tnone, files listed from the central directory")
+	}
+
+	fmt.Fprintln(tw)
+
+	for _, f := range m.Files {
+		name := f.Name
+		if f.Link != "" {
+			name += " -> " + f.Link
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", f.Mode, f.Size, name)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/wolfeidau/zipstash/pkg/archive"
+	"github.com/wolfeidau/zipstash/pkg/localcache"
+)
+
+// LocalCacheFlags configure a cache of archives on the local disk, this is shared by jobs running on a
+// persistent runner so an archive is only downloaded once per runner.
+type LocalCacheFlags struct {
+	LocalCache     string `help:"directory of a cache of archives on the local disk shared by jobs on the runner, disabled when empty" env:"INPUT_LOCAL_CACHE"`
+	LocalCacheSize int64  `help:"maximum size in megabytes of the local cache, the least recently used archives are evicted first" default:"10240" env:"INPUT_LOCAL_CACHE_SIZE"`
+}
+
+// localCache returns the local cache, or nil when it isn't enabled.
+func (f *LocalCacheFlags) localCache() (*localcache.Cache, error) {
+	if f.LocalCache == "" {
+		return nil, nil
+	}
+
+	dir, err := archive.ResolveHomeDir(f.LocalCache)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve local cache directory: %w", err)
+	}
+
+	return localcache.New(dir, f.LocalCacheSize*megabyte)
+}
+
+// populateLocalCache adds an archive to the local cache, failures are logged rather than returned as the
+// archive has already been saved or restored.
+func populateLocalCache(ctx context.Context, cache *localcache.Cache, sha256sum string, r io.Reader) {
+	if cache == nil {
+		return
+	}
+
+	err := cache.Put(ctx, sha256sum, r)
+	if err != nil {
+		if errors.Is(err, localcache.ErrChecksumMismatch) {
+			log.Warn().Str("sha256sum", sha256sum).Msg("archive doesn't match its checksum, not added to the local cache")
+			return
+		}
+		log.Warn().Err(err).Str("sha256sum", sha256sum).Msg("failed to add archive to the local cache")
+		return
+	}
+
+	log.Debug().Str("sha256sum", sha256sum).Msg("added archive to the local cache")
+}
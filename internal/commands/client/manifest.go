@@ -0,0 +1,156 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+
+	"github.com/wolfeidau/zipstash/pkg/cachekey"
+)
+
+// maxBatchEntries is the maximum number of entries the server accepts in a single batch request.
+const maxBatchEntries = 20
+
+var cacheNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Manifest declares a set of named caches which are saved and restored together, this is typically
+// stored in a zipstash.yaml file at the root of the repository. Keys and restore keys may be templates,
+// see cachekey.Resolve for the available functions.
+//
+//	caches:
+//	  - name: go-mod
+//	    key: go-mod-{{ os }}-{{ hashFiles "**/go.sum" }}
+//	    paths:
+//	      - ~/go/pkg/mod
+//	    restore_keys:
+//	      - go-mod-
+//	    ttl: 168h
+type Manifest struct {
+	Caches []CacheSpec `yaml:"caches"`
+}
+
+// CacheSpec is a single cache saved or restored by a command, this is either declared in a manifest
+// or built from the command line flags.
+type CacheSpec struct {
+	// KeyTemplate is the key before it was resolved, this is only set when the key is a template.
+	KeyTemplate string        `yaml:"-"`
+	Name        string        `yaml:"name"`
+	Key         string        `yaml:"key"`
+	Paths       []string      `yaml:"paths"`
+	RestoreKeys []string      `yaml:"restore_keys"`
+	TTL         time.Duration `yaml:"ttl"`
+}
+
+// LoadManifest reads and validates the manifest at the supplied path.
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	return ParseManifest(data)
+}
+
+// ParseManifest parses and validates a manifest, unknown fields are rejected to catch typos.
+func ParseManifest(data []byte) (*Manifest, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+
+	manifest := new(Manifest)
+
+	err := dec.Decode(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	err = manifest.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// Validate checks each cache has a unique name, a key and at least one path.
+func (m *Manifest) Validate() error {
+	if len(m.Caches) == 0 {
+		return errors.New("no caches declared")
+	}
+
+	names := make(map[string]bool, len(m.Caches))
+
+	for i, spec := range m.Caches {
+		if !cacheNameRegexp.MatchString(spec.Name) {
+			return fmt.Errorf("cache %d: name %q must only contain letters, numbers, dashes and underscores", i, spec.Name)
+		}
+
+		if names[spec.Name] {
+			return fmt.Errorf("cache %s: duplicate name", spec.Name)
+		}
+		names[spec.Name] = true
+
+		if spec.Key == "" {
+			return fmt.Errorf("cache %s: key is required", spec.Name)
+		}
+
+		if len(spec.Paths) == 0 {
+			return fmt.Errorf("cache %s: at least one path is required", spec.Name)
+		}
+
+		if spec.TTL < 0 {
+			return fmt.Errorf("cache %s: ttl must not be negative", spec.Name)
+		}
+	}
+
+	return nil
+}
+
+// Resolve returns a copy of the cache with the key and restore key templates expanded, templates are
+// resolved relative to the working directory.
+func (s CacheSpec) Resolve() (CacheSpec, error) {
+	key, err := cachekey.Resolve(s.Key, ".")
+	if err != nil {
+		return CacheSpec{}, fmt.Errorf("failed to resolve key: %w", err)
+	}
+
+	if key == "" {
+		return CacheSpec{}, fmt.Errorf("key template %q resolved to an empty key", s.Key)
+	}
+
+	if key != s.Key {
+		s.KeyTemplate = s.Key
+		s.Key = key
+
+		log.Info().Str("name", s.Name).Str("template", s.KeyTemplate).Str("key", s.Key).Msg("resolved key")
+	}
+
+	restoreKeys := make([]string, 0, len(s.RestoreKeys))
+	for _, restoreKey := range s.RestoreKeys {
+		restoreKey, err = cachekey.Resolve(restoreKey, ".")
+		if err != nil {
+			return CacheSpec{}, fmt.Errorf("failed to resolve restore key: %w", err)
+		}
+
+		if restoreKey != "" {
+			restoreKeys = append(restoreKeys, restoreKey)
+		}
+	}
+	s.RestoreKeys = restoreKeys
+
+	return s, nil
+}
+
+// chunk splits items into batches of at most size items.
+func chunk[T any](items []T, size int) [][]T {
+	var chunks [][]T
+	for size < len(items) {
+		items, chunks = items[size:], append(chunks, items[:size])
+	}
+	return append(chunks, items)
+}
@@ -0,0 +1,135 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected *Manifest
+		wantErr  string
+	}{
+		{
+			name: "multiple caches",
+			data: `
+caches:
+  - name: go-mod
+    key: go-mod-abc123
+    paths:
+      - ~/go/pkg/mod
+    restore_keys:
+      - go-mod-
+    ttl: 168h
+  - name: node_modules
+    key: node-def456
+    paths:
+      - node_modules
+      - .npm
+`,
+			expected: &Manifest{
+				Caches: []CacheSpec{
+					{Name: "go-mod", Key: "go-mod-abc123", Paths: []string{"~/go/pkg/mod"}, RestoreKeys: []string{"go-mod-"}, TTL: 168 * time.Hour},
+					{Name: "node_modules", Key: "node-def456", Paths: []string{"node_modules", ".npm"}},
+				},
+			},
+		},
+		{
+			name:    "no caches",
+			data:    "caches: []\n",
+			wantErr: "no caches declared",
+		},
+		{
+			name:    "unknown field",
+			data:    "caches:\n  - name: go\n    key: k\n    path: [a]\n",
+			wantErr: "field path not found",
+		},
+		{
+			name:    "duplicate name",
+			data:    "caches:\n  - name: go\n    key: a\n    paths: [a]\n  - name: go\n    key: b\n    paths: [b]\n",
+			wantErr: "duplicate name",
+		},
+		{
+			name:    "invalid name",
+			data:    "caches:\n  - name: go mod\n    key: a\n    paths: [a]\n",
+			wantErr: "must only contain",
+		},
+		{
+			name:    "missing key",
+			data:    "caches:\n  - name: go\n    paths: [a]\n",
+			wantErr: "key is required",
+		},
+		{
+			name:    "missing paths",
+			data:    "caches:\n  - name: go\n    key: a\n",
+			wantErr: "at least one path is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			manifest, err := ParseManifest([]byte(tt.data))
+			if tt.wantErr != "" {
+				assert.ErrorContains(err, tt.wantErr)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(tt.expected, manifest)
+		})
+	}
+}
+
+func TestLoadManifest(t *testing.T) {
+	assert := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "zipstash.yaml")
+	assert.NoError(os.WriteFile(path, []byte("caches:\n  - name: go\n    key: a\n    paths: [a]\n"), 0600))
+
+	manifest, err := LoadManifest(path)
+	assert.NoError(err)
+	assert.Len(manifest.Caches, 1)
+
+	_, err = LoadManifest(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.ErrorContains(err, "failed to read manifest")
+}
+
+func TestChunk(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal([][]int{{1, 2}, {3, 4}, {5}}, chunk([]int{1, 2, 3, 4, 5}, 2))
+	assert.Equal([][]int{{1, 2}}, chunk([]int{1, 2}, 2))
+}
+
+func TestCacheSpecResolve(t *testing.T) {
+	assert := require.New(t)
+
+	t.Setenv("GO_VERSION", "1.24")
+
+	spec, err := CacheSpec{
+		Name:        "go-mod",
+		Key:         `go-{{ env "GO_VERSION" }}-{{ os }}`,
+		RestoreKeys: []string{`go-{{ env "GO_VERSION" }}-`, `{{ env "ZIPSTASH_MISSING_VAR" }}`},
+	}.Resolve()
+	assert.NoError(err)
+	assert.Equal("go-1.24-"+runtime.GOOS, spec.Key)
+	assert.Equal(`go-{{ env "GO_VERSION" }}-{{ os }}`, spec.KeyTemplate)
+	assert.Equal([]string{"go-1.24-"}, spec.RestoreKeys)
+
+	spec, err = CacheSpec{Key: "plain"}.Resolve()
+	assert.NoError(err)
+	assert.Equal("plain", spec.Key)
+	assert.Empty(spec.KeyTemplate)
+
+	_, err = CacheSpec{Key: `{{ env "ZIPSTASH_MISSING_VAR" }}`}.Resolve()
+	assert.ErrorContains(err, "resolved to an empty key")
+}
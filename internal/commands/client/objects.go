@@ -0,0 +1,453 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/rs/zerolog/log"
+
+	cachev1 "github.com/wolfeidau/zipstash/api/gen/proto/go/cache/v1"
+	"github.com/wolfeidau/zipstash/api/gen/proto/go/cache/v1/cachev1connect"
+	"github.com/wolfeidau/zipstash/pkg/tokens"
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+const (
+	// inlineObjectSize is the largest object sent inline to the server, this matches the limit of the server.
+	inlineObjectSize = 256 * 1024
+
+	// tokenRefreshInterval is how long a token is reused before another is requested, CI providers issue tokens
+	// which are valid for a few minutes so this is kept short.
+	tokenRefreshInterval = 2 * time.Minute
+)
+
+// ObjectServerCmd serves a local http endpoint, which also implements enough of webdav for sccache, storing
+// objects in zipstash. Compiler caches such as sccache and ccache are pointed at this endpoint.
+type ObjectServerCmd struct {
+	TokenSource string `help:"token source" default:"github_actions" env:"INPUT_TOKEN_SOURCE"`
+	Owner       string `help:"owner of the objects" env:"INPUT_OWNER" required:""`
+	Namespace   string `help:"namespace of the objects, builds using the same namespace share objects" default:"default" env:"INPUT_NAMESPACE"`
+	Listen      string `help:"address to listen on, this should only be reachable from the local host" default:"127.0.0.1:8086" env:"INPUT_LISTEN"`
+}
+
+func (c *ObjectServerCmd) Run(ctx context.Context, globals *Globals) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := &http.Server{
+		Addr:              c.Listen,
+		Handler:           newObjectServer(globals.Client, c.TokenSource, c.Owner, c.Namespace, globals.Version),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Info().Str("listen", c.Listen).Str("namespace", c.Namespace).Msg("serving objects")
+
+	err := srv.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve objects: %w", err)
+	}
+
+	return nil
+}
+
+// tokenCache reuses a token for a short time so each object request doesn't request a new token.
+type tokenCache struct {
+	mu      sync.Mutex
+	source  string
+	token   string
+	fetched time.Time
+}
+
+func (t *tokenCache) get(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.token != "" && time.Since(t.fetched) < tokenRefreshInterval {
+		return t.token, nil
+	}
+
+	return t.fetch(ctx)
+}
+
+// refresh requests a new token, long running commands call this in the background so requests don't wait for
+// a token.
+func (t *tokenCache) refresh(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, err := t.fetch(ctx)
+
+	return err
+}
+
+func (t *tokenCache) fetch(ctx context.Context) (string, error) {
+	token, err := tokens.GetToken(ctx, t.source, audience, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get token: %w", err)
+	}
+
+	t.token, t.fetched = token, time.Now()
+
+	return token, nil
+}
+
+// objectServer translates http requests for objects into GetObjects and PutObjects calls.
+type objectServer struct {
+	client     cachev1connect.CacheServiceClient
+	httpClient *http.Client
+	tokens     *tokenCache
+	owner      string
+	namespace  string
+	provider   string
+	version    string
+}
+
+func newObjectServer(client cachev1connect.CacheServiceClient, tokenSource, owner, namespace, version string) *objectServer {
+	return &objectServer{
+		client:     client,
+		httpClient: http.DefaultClient,
+		tokens:     &tokenCache{source: tokenSource},
+		owner:      owner,
+		namespace:  namespace,
+		provider:   tokenSource,
+		version:    version,
+	}
+}
+
+func (s *objectServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.Start(r.Context(), "objectServer.ServeHTTP")
+	defer span.End()
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		s.get(ctx, w, r, key)
+	case http.MethodPut:
+		s.put(ctx, w, r, key)
+	case "PROPFIND":
+		s.propfind(ctx, w, r, key)
+	case "MKCOL":
+		// collections are implied by the keys of objects
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, PROPFIND, MKCOL")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *objectServer) getObject(ctx context.Context, key string) (*cachev1.GetObjectResult, error) {
+	token, err := s.tokens.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.client.GetObjects(ctx, newAuthenticatedProviderRequest(&cachev1.GetObjectsRequest{
+		ProviderType: convertProviderTypeV1(s.provider),
+		Owner:        s.owner,
+		Namespace:    s.namespace,
+		Keys:         []string{key},
+	}, token, s.provider, s.version))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object %s: %w", key, err)
+	}
+
+	if len(res.Msg.Results) != 1 {
+		return nil, fmt.Errorf("expected 1 result for object %s, got %d", key, len(res.Msg.Results))
+	}
+
+	return res.Msg.Results[0], nil
+}
+
+func (s *objectServer) get(ctx context.Context, w http.ResponseWriter, r *http.Request, key string) {
+	obj, err := s.getObject(ctx, key)
+	if err != nil {
+		writeObjectError(w, err)
+		return
+	}
+
+	if !obj.Found {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Length", strconv.FormatInt(obj.Size, 10))
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if obj.DownloadUrl == "" {
+		_, err = w.Write(obj.Data)
+		if err != nil {
+			log.Warn().Err(err).Str("key", key).Msg("failed to write object")
+		}
+		return
+	}
+
+	err = s.download(ctx, w, obj.DownloadUrl)
+	if err != nil {
+		// the headers may have been written so the response can only be cut short
+		log.Error().Err(err).Str("key", key).Msg("failed to download object")
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (s *objectServer) download(ctx context.Context, w http.ResponseWriter, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download object: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download object: %s", resp.Status)
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	_, err = io.Copy(w, resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to copy object: %w", err)
+	}
+
+	return nil
+}
+
+func (s *objectServer) put(ctx context.Context, w http.ResponseWriter, r *http.Request, key string) {
+	body, err := spoolObject(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer body.Close()
+
+	token, err := s.tokens.get(ctx)
+	if err != nil {
+		writeObjectError(w, err)
+		return
+	}
+
+	obj := &cachev1.PutObject{
+		Key:       key,
+		Size:      body.size,
+		Sha256Sum: body.sha256sum,
+		Data:      body.data,
+	}
+
+	res, err := s.client.PutObjects(ctx, newAuthenticatedProviderRequest(&cachev1.PutObjectsRequest{
+		ProviderType: convertProviderTypeV1(s.provider),
+		Owner:        s.owner,
+		Namespace:    s.namespace,
+		Objects:      []*cachev1.PutObject{obj},
+	}, token, s.provider, s.version))
+	if err != nil {
+		writeObjectError(w, fmt.Errorf("failed to put object %s: %w", key, err))
+		return
+	}
+
+	if len(res.Msg.Results) != 1 {
+		writeObjectError(w, fmt.Errorf("expected 1 result for object %s, got %d", key, len(res.Msg.Results)))
+		return
+	}
+
+	if url := res.Msg.Results[0].UploadUrl; url != "" {
+		err = s.upload(ctx, url, body)
+		if err != nil {
+			writeObjectError(w, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *objectServer) upload(ctx context.Context, url string, body *spooledObject) error {
+	_, err := body.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("failed to seek object: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, body.file)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.ContentLength = body.size
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload object: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to upload object: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// spooledObject is the body of a PUT request, small objects are held in memory to be sent inline while larger
+// objects are written to a temp file to be uploaded.
+type spooledObject struct {
+	data      []byte
+	file      *os.File
+	size      int64
+	sha256sum string
+}
+
+func spoolObject(r io.Reader) (*spooledObject, error) {
+	hash := sha256.New()
+
+	data, err := io.ReadAll(io.TeeReader(io.LimitReader(r, inlineObjectSize+1), hash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object: %w", err)
+	}
+
+	if len(data) <= inlineObjectSize {
+		return &spooledObject{data: data, size: int64(len(data)), sha256sum: hex.EncodeToString(hash.Sum(nil))}, nil
+	}
+
+	f, err := os.CreateTemp("", "zipstash-object-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+
+	obj := &spooledObject{file: f}
+
+	n, err := io.Copy(io.MultiWriter(f, hash), io.MultiReader(bytes.NewReader(data), r))
+	if err != nil {
+		obj.Close()
+		return nil, fmt.Errorf("failed to spool object: %w", err)
+	}
+
+	obj.size, obj.sha256sum = n, hex.EncodeToString(hash.Sum(nil))
+
+	return obj, nil
+}
+
+// Close removes the temp file holding a large object.
+func (o *spooledObject) Close() {
+	if o.file == nil {
+		return
+	}
+
+	_ = o.file.Close()
+	_ = os.Remove(o.file.Name())
+}
+
+// webdav multistatus response, only the properties used by webdav clients to check an object or collection
+// exists are returned.
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	DAV       string        `xml:"xmlns:D,attr"`
+	Responses []davResponse `xml:"D:response"`
+}
+
+type davResponse struct {
+	Href     string      `xml:"D:href"`
+	Propstat davPropstat `xml:"D:propstat"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"D:prop"`
+	Status string  `xml:"D:status"`
+}
+
+type davProp struct {
+	ResourceType  davResourceType `xml:"D:resourcetype"`
+	ContentLength *int64          `xml:"D:getcontentlength,omitempty"`
+}
+
+type davResourceType struct {
+	Collection *struct{} `xml:"D:collection,omitempty"`
+}
+
+// propfind reports keys ending with a slash as collections, which always exist, and other keys as objects.
+func (s *objectServer) propfind(ctx context.Context, w http.ResponseWriter, r *http.Request, key string) {
+	prop := davProp{}
+
+	if key == "" || strings.HasSuffix(key, "/") {
+		prop.ResourceType.Collection = &struct{}{}
+	} else {
+		obj, err := s.getObject(ctx, key)
+		if err != nil {
+			writeObjectError(w, err)
+			return
+		}
+
+		if !obj.Found {
+			http.NotFound(w, r)
+			return
+		}
+
+		prop.ContentLength = &obj.Size
+	}
+
+	data, err := xml.Marshal(davMultistatus{
+		DAV: "DAV:",
+		Responses: []davResponse{{
+			Href:     r.URL.Path,
+			Propstat: davPropstat{Prop: prop, Status: "HTTP/1.1 200 OK"},
+		}},
+	})
+	if err != nil {
+		writeObjectError(w, fmt.Errorf("failed to marshal propfind response: %w", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusMultiStatus)
+
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(data)
+}
+
+// writeObjectError maps errors from the server to http statuses, compiler caches treat failures as misses.
+func writeObjectError(w http.ResponseWriter, err error) {
+	status := http.StatusBadGateway
+
+	switch connect.CodeOf(err) {
+	case connect.CodeInvalidArgument:
+		status = http.StatusBadRequest
+	case connect.CodeUnauthenticated:
+		status = http.StatusUnauthorized
+	case connect.CodePermissionDenied:
+		status = http.StatusForbidden
+	}
+
+	log.Error().Err(err).Int("status", status).Msg("object request failed")
+	http.Error(w, err.Error(), status)
+}
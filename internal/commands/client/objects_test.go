@@ -0,0 +1,163 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+
+	cachev1 "github.com/wolfeidau/zipstash/api/gen/proto/go/cache/v1"
+	"github.com/wolfeidau/zipstash/api/gen/proto/go/cache/v1/cachev1connect"
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+// fakeObjectsClient stores objects in memory, large objects are uploaded to and downloaded from the blob server.
+type fakeObjectsClient struct {
+	cachev1connect.CacheServiceClient
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	blobURL string
+}
+
+func (f *fakeObjectsClient) GetObjects(_ context.Context, req *connect.Request[cachev1.GetObjectsRequest]) (*connect.Response[cachev1.GetObjectsResponse], error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var results []*cachev1.GetObjectResult
+	for _, key := range req.Msg.Keys {
+		data, ok := f.objects[key]
+		switch {
+		case !ok:
+			results = append(results, &cachev1.GetObjectResult{Key: key})
+		case len(data) > inlineObjectSize:
+			results = append(results, &cachev1.GetObjectResult{Key: key, Found: true, Size: int64(len(data)), DownloadUrl: f.blobURL + "/" + key})
+		default:
+			results = append(results, &cachev1.GetObjectResult{Key: key, Found: true, Size: int64(len(data)), Data: data})
+		}
+	}
+
+	return connect.NewResponse(&cachev1.GetObjectsResponse{Results: results}), nil
+}
+
+func (f *fakeObjectsClient) PutObjects(_ context.Context, req *connect.Request[cachev1.PutObjectsRequest]) (*connect.Response[cachev1.PutObjectsResponse], error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var results []*cachev1.PutObjectResult
+	for _, obj := range req.Msg.Objects {
+		if len(obj.Data) == 0 && obj.Size > 0 {
+			results = append(results, &cachev1.PutObjectResult{Key: obj.Key, UploadUrl: f.blobURL + "/" + obj.Key})
+			continue
+		}
+
+		sum := sha256.Sum256(obj.Data)
+		if hex.EncodeToString(sum[:]) != obj.Sha256Sum {
+			return nil, connect.NewError(connect.CodeInvalidArgument, nil)
+		}
+
+		f.objects[obj.Key] = obj.Data
+		results = append(results, &cachev1.PutObjectResult{Key: obj.Key, Stored: true})
+	}
+
+	return connect.NewResponse(&cachev1.PutObjectsResponse{Results: results}), nil
+}
+
+func (f *fakeObjectsClient) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		f.objects[key] = data
+	case http.MethodGet:
+		_, _ = w.Write(f.objects[key])
+	}
+}
+
+func TestObjectServer(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	assert.NoError(err)
+
+	fake := &fakeObjectsClient{objects: map[string][]byte{}}
+
+	blobs := httptest.NewServer(fake)
+	defer blobs.Close()
+
+	fake.blobURL = blobs.URL
+
+	srv := httptest.NewServer(newObjectServer(fake, "local", "wolfeidau", "sccache", "test"))
+	defer srv.Close()
+
+	do := func(method, key string, body []byte) (*http.Response, []byte) {
+		req, err := http.NewRequest(method, srv.URL+"/"+key, bytes.NewReader(body))
+		assert.NoError(err)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(err)
+		defer resp.Body.Close()
+
+		data, err := io.ReadAll(resp.Body)
+		assert.NoError(err)
+
+		return resp, data
+	}
+
+	small := []byte("compiled object")
+	large := bytes.Repeat([]byte("0123456789"), inlineObjectSize/5)
+
+	for _, tt := range []struct {
+		key  string
+		data []byte
+	}{
+		{key: "a/b/small", data: small},
+		{key: "a/b/large", data: large},
+		{key: "a/b/empty", data: []byte{}},
+	} {
+		resp, _ := do(http.MethodPut, tt.key, tt.data)
+		assert.Equal(http.StatusCreated, resp.StatusCode, tt.key)
+		assert.Equal(tt.data, fake.objects[tt.key], tt.key)
+
+		resp, data := do(http.MethodGet, tt.key, nil)
+		assert.Equal(http.StatusOK, resp.StatusCode, tt.key)
+		assert.Equal(tt.data, data, tt.key)
+
+		resp, _ = do(http.MethodHead, tt.key, nil)
+		assert.Equal(http.StatusOK, resp.StatusCode, tt.key)
+		assert.Equal(int64(len(tt.data)), resp.ContentLength, tt.key)
+	}
+
+	resp, _ := do(http.MethodGet, "a/b/missing", nil)
+	assert.Equal(http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = do("MKCOL", "a/b/", nil)
+	assert.Equal(http.StatusCreated, resp.StatusCode)
+
+	resp, data := do("PROPFIND", "a/b/", nil)
+	assert.Equal(http.StatusMultiStatus, resp.StatusCode)
+	assert.Contains(string(data), "<D:collection></D:collection>")
+
+	resp, data = do("PROPFIND", "a/b/small", nil)
+	assert.Equal(http.StatusMultiStatus, resp.StatusCode)
+	assert.Contains(string(data), "<D:getcontentlength>15</D:getcontentlength>")
+
+	resp, _ = do("PROPFIND", "a/b/missing", nil)
+	assert.Equal(http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = do(http.MethodDelete, "a/b/small", nil)
+	assert.Equal(http.StatusMethodNotAllowed, resp.StatusCode)
+}
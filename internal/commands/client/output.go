@@ -0,0 +1,157 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// Result is the machine readable result of a save or restore.
+type Result struct {
+	Durations   map[string]int64 `json:"durations_ms"`
+	Name        string           `json:"name,omitempty"`
+	Operation   string           `json:"operation"`
+	Key         string           `json:"key"`
+	KeyTemplate string           `json:"key_template,omitempty"`
+	MatchedKey  string           `json:"matched_key,omitempty"`
+	EntryID     string           `json:"entry_id,omitempty"`
+	Sha256sum   string           `json:"sha256sum,omitempty"`
+	Size        int64            `json:"size"`
+	CacheHit    bool             `json:"cache_hit"`
+	// LocalHit is true when the archive was restored from the local cache rather than downloaded.
+	LocalHit bool `json:"local_hit"`
+	Fallback bool `json:"fallback"`
+}
+
+func newResult(operation, key string) *Result {
+	return &Result{
+		Operation: operation,
+		Key:       key,
+		Durations: make(map[string]int64),
+	}
+}
+
+// newSpecResult returns an empty result for a resolved cache.
+func newSpecResult(operation string, spec CacheSpec) *Result {
+	r := newResult(operation, spec.Key)
+	r.Name = spec.Name
+	r.KeyTemplate = spec.KeyTemplate
+	return r
+}
+
+// write the result to w using the requested output format, the text format is the result
+// of restore as a bare true or false which is retained for existing scripts.
+func (r *Result) write(w io.Writer, format string) error {
+	switch format {
+	case outputJSON:
+		return json.NewEncoder(w).Encode(r)
+	default:
+		if r.Operation == "restore" {
+			_, err := fmt.Fprintln(w, r.CacheHit)
+			return err
+		}
+		return nil
+	}
+}
+
+// restoreOutputs returns the values published to the CI provider after a restore, the names are prefixed
+// with the cache name when the result is for a cache declared in a manifest.
+func (r *Result) restoreOutputs() map[string]string {
+	prefix := ""
+	if r.Name != "" {
+		prefix = r.Name + "-"
+	}
+
+	return map[string]string{
+		prefix + "cache-hit":   strconv.FormatBool(r.CacheHit),
+		prefix + "matched-key": r.MatchedKey,
+	}
+}
+
+// writeResults writes the results of saving or restoring several caches to w, the JSON format is an array
+// of results and the text format for restore is a line per cache with the name and cache hit.
+func writeResults(w io.Writer, format string, results []*Result) error {
+	switch format {
+	case outputJSON:
+		return json.NewEncoder(w).Encode(results)
+	default:
+		for _, r := range results {
+			if r.Operation != "restore" {
+				continue
+			}
+
+			_, err := fmt.Fprintf(w, "%s %t\n", r.Name, r.CacheHit)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// publishOutputs makes the outputs available to later steps using the native mechanism of the CI
+// provider the command is running under, it does nothing when not running in a supported provider.
+func publishOutputs(ctx context.Context, outputs map[string]string) error {
+	ctx, span := trace.Start(ctx, "publishOutputs")
+	defer span.End()
+
+	if path := os.Getenv("GITHUB_OUTPUT"); path != "" && os.Getenv("GITHUB_ACTIONS") == "true" {
+		return writeGitHubOutputs(path, outputs)
+	}
+
+	if os.Getenv("BUILDKITE") == "true" {
+		return setBuildkiteMetaData(ctx, outputs)
+	}
+
+	return nil
+}
+
+func writeGitHubOutputs(path string, outputs map[string]string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open github output file: %w", err)
+	}
+	defer f.Close()
+
+	for _, name := range sortedKeys(outputs) {
+		_, err = fmt.Fprintf(f, "%s=%s\n", name, outputs[name])
+		if err != nil {
+			return fmt.Errorf("failed to write github output: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func setBuildkiteMetaData(ctx context.Context, outputs map[string]string) error {
+	for _, name := range sortedKeys(outputs) {
+		// buildkite rejects empty meta-data values
+		if outputs[name] == "" {
+			continue
+		}
+
+		cmd := exec.CommandContext(ctx, "buildkite-agent", "meta-data", "set", name, outputs[name])
+		cmd.Stderr = os.Stderr
+
+		log.Debug().Str("name", name).Msg("setting buildkite meta-data")
+
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to set buildkite meta-data %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+func TestResultWrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   *Result
+		format   string
+		expected string
+	}{
+		{
+			name:     "restore text hit",
+			result:   &Result{Operation: "restore", CacheHit: true},
+			format:   outputText,
+			expected: "true\n",
+		},
+		{
+			name:     "restore text miss",
+			result:   &Result{Operation: "restore"},
+			format:   outputText,
+			expected: "false\n",
+		},
+		{
+			name:     "save text",
+			result:   &Result{Operation: "save", CacheHit: true},
+			format:   outputText,
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			buf := new(bytes.Buffer)
+			assert.NoError(tt.result.write(buf, tt.format))
+			assert.Equal(tt.expected, buf.String())
+		})
+	}
+}
+
+func TestResultWriteJSON(t *testing.T) {
+	assert := require.New(t)
+
+	result := newResult("restore", "go-mod-abc")
+	result.CacheHit = true
+	result.MatchedKey = "go-mod-abc"
+	result.Size = 1024
+	result.Durations["total"] = 10
+
+	buf := new(bytes.Buffer)
+	assert.NoError(result.write(buf, outputJSON))
+
+	var decoded map[string]any
+	assert.NoError(json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(true, decoded["cache_hit"])
+	assert.Equal(false, decoded["fallback"])
+	assert.Equal("go-mod-abc", decoded["matched_key"])
+	assert.Equal(float64(1024), decoded["size"])
+	assert.Equal(float64(10), decoded["durations_ms"].(map[string]any)["total"])
+}
+
+func TestPublishOutputsGitHubActions(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	assert.NoError(err)
+
+	path := filepath.Join(t.TempDir(), "github_output")
+	assert.NoError(os.WriteFile(path, []byte("existing=value\n"), 0600))
+
+	t.Setenv("GITHUB_ACTIONS", "true")
+	t.Setenv("GITHUB_OUTPUT", path)
+
+	result := &Result{CacheHit: false, MatchedKey: "go-mod-main"}
+	assert.NoError(publishOutputs(context.Background(), result.restoreOutputs()))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(err)
+	assert.Equal("existing=value\ncache-hit=false\nmatched-key=go-mod-main\n", string(data))
+}
+
+func TestWriteResults(t *testing.T) {
+	assert := require.New(t)
+
+	goMod := newResult("restore", "go-mod-abc")
+	goMod.Name = "go-mod"
+	goMod.CacheHit = true
+
+	node := newResult("restore", "node-def")
+	node.Name = "node"
+
+	buf := new(bytes.Buffer)
+	assert.NoError(writeResults(buf, outputText, []*Result{goMod, node}))
+	assert.Equal("go-mod true\nnode false\n", buf.String())
+
+	buf.Reset()
+	assert.NoError(writeResults(buf, outputJSON, []*Result{goMod, node}))
+
+	var decoded []map[string]any
+	assert.NoError(json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Len(decoded, 2)
+	assert.Equal("go-mod", decoded[0]["name"])
+	assert.Equal("node", decoded[1]["name"])
+
+	assert.Equal(map[string]string{"go-mod-cache-hit": "true", "go-mod-matched-key": ""}, goMod.restoreOutputs())
+}
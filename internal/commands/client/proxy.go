@@ -0,0 +1,198 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/wolfeidau/zipstash/api/gen/proto/go/cache/v1/cachev1connect"
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+// proxyObjectsPath is the prefix of the object api served by the proxy, this is the same api as the object
+// server.
+const proxyObjectsPath = "/objects"
+
+// proxyForwardPaths are the prefixes of the http apis of the server which build tools use directly, these are
+// forwarded to the server with the token of the job so the tools don't need to be configured with credentials.
+var proxyForwardPaths = []string{
+	"/ac/",           // bazel action cache
+	"/cas/",          // bazel content addressable store
+	"/cache/",        // gradle build cache
+	"/v8/artifacts/", // turborepo remote cache
+	"/v2/",           // oci registry used by buildkit
+}
+
+// ProxyCmd runs a local proxy for the duration of a CI job, it holds a refreshed token and a pool of connections
+// to the server so build tools in the job can get and put through it without authenticating each request.
+type ProxyCmd struct {
+	TokenSource    string `help:"token source" default:"github_actions" env:"INPUT_TOKEN_SOURCE"`
+	Owner          string `help:"owner of the objects" env:"INPUT_OWNER" required:""`
+	Namespace      string `help:"namespace of the objects, builds using the same namespace share objects" default:"default" env:"INPUT_NAMESPACE"`
+	Listen         string `help:"address to listen on, this should only be reachable from the local host" default:"127.0.0.1:8087" env:"INPUT_LISTEN"`
+	MaxConnections int    `help:"maximum number of idle connections kept open to each host" default:"64" env:"INPUT_MAX_CONNECTIONS"`
+}
+
+func (c *ProxyCmd) Run(ctx context.Context, globals *Globals) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	httpClient := &http.Client{Transport: newPooledTransport(c.MaxConnections)}
+	client := cachev1connect.NewCacheServiceClient(httpClient, globals.Endpoint, globals.ClientOptions...)
+
+	p, err := newProxy(client, httpClient, globals.Endpoint, c.TokenSource, c.Owner, c.Namespace, globals.Version)
+	if err != nil {
+		return err
+	}
+
+	// fail before serving when the job can't get a token
+	err = p.tokens.refresh(ctx)
+	if err != nil {
+		return err
+	}
+
+	go p.refreshTokens(ctx, tokenRefreshInterval)
+
+	srv := &http.Server{
+		Addr:              c.Listen,
+		Handler:           p,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Info().Str("listen", c.Listen).Str("endpoint", globals.Endpoint).Str("namespace", c.Namespace).Msg("serving proxy")
+
+	err = srv.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve proxy: %w", err)
+	}
+
+	return nil
+}
+
+// newPooledTransport returns a transport which keeps enough idle connections open for the concurrent requests of
+// build tools, the default transport only keeps two per host.
+func newPooledTransport(maxConns int) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = maxConns * 2
+	transport.MaxIdleConnsPerHost = maxConns
+	transport.IdleConnTimeout = 5 * time.Minute
+
+	return transport
+}
+
+// proxy serves the object api and forwards the http apis of the server, authenticating requests with the token
+// of the job.
+type proxy struct {
+	mux     *http.ServeMux
+	tokens  *tokenCache
+	version string
+}
+
+func newProxy(client cachev1connect.CacheServiceClient, httpClient *http.Client, endpoint, tokenSource, owner, namespace, version string) (*proxy, error) {
+	target, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse endpoint: %w", err)
+	}
+
+	p := &proxy{
+		mux:     http.NewServeMux(),
+		tokens:  &tokenCache{source: tokenSource},
+		version: version,
+	}
+
+	objects := newObjectServer(client, tokenSource, owner, namespace, version)
+	objects.httpClient = httpClient
+	objects.tokens = p.tokens
+
+	forward := &httputil.ReverseProxy{
+		Transport: httpClient.Transport,
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+		},
+	}
+
+	p.mux.HandleFunc("GET /healthz", p.healthz)
+	p.mux.Handle(proxyObjectsPath+"/", http.StripPrefix(proxyObjectsPath, objects))
+
+	for _, prefix := range proxyForwardPaths {
+		p.mux.Handle(prefix, p.authenticate(forward))
+	}
+
+	return p, nil
+}
+
+func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mux.ServeHTTP(w, r)
+}
+
+// authenticate replaces the credentials of the request with the token of the job.
+func (p *proxy) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := trace.Start(r.Context(), "proxy.authenticate")
+		defer span.End()
+
+		token, err := p.tokens.get(ctx)
+		if err != nil {
+			writeObjectError(w, err)
+			return
+		}
+
+		r = r.Clone(ctx)
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("X-Provider", p.tokens.source)
+		r.Header.Set("User-Agent", fmt.Sprintf("zipstash/%s", p.version))
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// healthz reports whether the proxy holds a token, jobs wait on this before starting build tools.
+func (p *proxy) healthz(w http.ResponseWriter, _ *http.Request) {
+	p.tokens.mu.Lock()
+	fresh := p.tokens.token != "" && time.Since(p.tokens.fetched) < tokenRefreshInterval
+	p.tokens.mu.Unlock()
+
+	if !fresh {
+		http.Error(w, "no token", http.StatusServiceUnavailable)
+		return
+	}
+
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// refreshTokens requests a new token before the current one is stale so requests don't wait for a token, failures
+// are retried on the next interval and requests fall back to requesting a token.
+func (p *proxy) refreshTokens(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := p.tokens.refresh(ctx)
+			if err != nil {
+				log.Warn().Err(err).Msg("failed to refresh token")
+			}
+		}
+	}
+}
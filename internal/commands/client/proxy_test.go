@@ -0,0 +1,82 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+func TestProxy(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	assert.NoError(err)
+
+	var forwarded *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	fake := &fakeObjectsClient{objects: map[string][]byte{}}
+
+	p, err := newProxy(fake, http.DefaultClient, upstream.URL, "local", "wolfeidau", "sccache", "test")
+	assert.NoError(err)
+
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+
+	do := func(method, path string, body []byte, header http.Header) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+path, bytes.NewReader(body))
+		assert.NoError(err)
+
+		for k, v := range header {
+			req.Header[k] = v
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(err)
+		defer resp.Body.Close()
+
+		_, err = io.Copy(io.Discard, resp.Body)
+		assert.NoError(err)
+
+		return resp
+	}
+
+	// the proxy isn't healthy until it holds a token
+	resp := do(http.MethodGet, "/healthz", nil, nil)
+	assert.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+
+	p.tokens.token, p.tokens.fetched = "job-token", time.Now()
+
+	resp = do(http.MethodGet, "/healthz", nil, nil)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+
+	// credentials sent by build tools are replaced with the token of the job
+	resp = do(http.MethodGet, "/cas/abc", nil, http.Header{"Authorization": {"Basic dXNlcjpwYXNz"}})
+	assert.Equal(http.StatusNoContent, resp.StatusCode)
+	assert.Equal("/cas/abc", forwarded.URL.Path)
+	assert.Equal("Bearer job-token", forwarded.Header.Get("Authorization"))
+	assert.Equal("local", forwarded.Header.Get("X-Provider"))
+
+	resp = do(http.MethodPut, "/v2/cache/manifests/latest", []byte("{}"), nil)
+	assert.Equal(http.StatusNoContent, resp.StatusCode)
+	assert.Equal("/v2/cache/manifests/latest", forwarded.URL.Path)
+
+	resp = do(http.MethodPut, "/objects/a/b/small", []byte("compiled object"), nil)
+	assert.Equal(http.StatusCreated, resp.StatusCode)
+	assert.Equal([]byte("compiled object"), fake.objects["a/b/small"])
+
+	resp = do(http.MethodGet, "/other", nil, nil)
+	assert.Equal(http.StatusNotFound, resp.StatusCode)
+}
@@ -1,36 +1,65 @@
 package client
 
 import (
-	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
 	"runtime"
+	"time"
 
 	"connectrpc.com/connect"
 	"github.com/rs/zerolog/log"
 	"go.opentelemetry.io/otel/attribute"
-	"golang.org/x/exp/slices"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
 
 	cachev1 "github.com/wolfeidau/zipstash/api/gen/proto/go/cache/v1"
 	"github.com/wolfeidau/zipstash/pkg/archive"
 	"github.com/wolfeidau/zipstash/pkg/downloader"
+	"github.com/wolfeidau/zipstash/pkg/localcache"
+	"github.com/wolfeidau/zipstash/pkg/progress"
+	"github.com/wolfeidau/zipstash/pkg/ratelimit"
 	"github.com/wolfeidau/zipstash/pkg/tokens"
 	"github.com/wolfeidau/zipstash/pkg/trace"
 )
 
 type RestoreCmd struct {
-	Key            string `help:"key to use for the cache entry" required:"" env:"INPUT_KEY"`
-	FallbackBranch string `help:"fallback branch to use for the cache entry" env:"INPUT_FALLBACK_BRANCH"`
-	Path           string `help:"Path list for a cache entry." env:"INPUT_PATH"`
-	TokenSource    string `help:"token source" default:"github_actions" env:"INPUT_TOKEN_SOURCE"`
-	Branch         string `help:"branch to use for the cache entry" env:"INPUT_BRANCH" required:""`
-	Name           string `help:"repository, project or pipeline name to use for the cache entry" env:"INPUT_REPOSITORY" required:""`
-	Owner          string `help:"owner of the cache entry" env:"INPUT_OWNER"`
-	Clean          bool   `help:"clean the path before restore" env:"INPUT_CLEAN"`
+	LocalCacheFlags `embed:""`
+	Key             string  `help:"key to use for the cache entry, this may be a template using os, arch, env and hashFiles" env:"INPUT_KEY"`
+	RestoreKeys     string  `help:"newline separated list of key prefixes to try in order when there is no exact match for the key, these may be templates" env:"INPUT_RESTORE_KEYS"`
+	FallbackBranch  string  `help:"fallback branch to use for the cache entry" env:"INPUT_FALLBACK_BRANCH"`
+	Path            string  `help:"newline separated list of paths or glob patterns for a cache entry, lines starting with ! exclude matching files" env:"INPUT_PATH"`
+	Manifest        string  `help:"manifest declaring the caches to restore with --all" default:"zipstash.yaml" env:"INPUT_MANIFEST"`
+	TokenSource     string  `help:"token source" default:"github_actions" env:"INPUT_TOKEN_SOURCE"`
+	Branch          string  `help:"branch to use for the cache entry" env:"INPUT_BRANCH" required:""`
+	Name            string  `help:"repository, project or pipeline name to use for the cache entry" env:"INPUT_REPOSITORY" required:""`
+	Owner           string  `help:"owner of the cache entry" env:"INPUT_OWNER"`
+	Progress        string  `help:"progress reporting, auto uses a progress bar on a terminal and log lines otherwise" default:"auto" enum:"auto,bar,log,none" env:"INPUT_PROGRESS"`
+	SummaryFile     string  `help:"write a JSON summary of the restore to this file" env:"INPUT_SUMMARY_FILE"`
+	Output          string  `help:"output format for the restore result" default:"text" enum:"text,json" env:"INPUT_OUTPUT"`
+	Concurrency     int     `help:"number of parts to download concurrently" default:"20" env:"INPUT_CONCURRENCY"`
+	Parallel        int     `help:"number of caches to restore concurrently with --all" default:"4" env:"INPUT_PARALLEL"`
+	PartSize        int64   `help:"preferred part size in megabytes for ranged downloads, by default the server picks one based on the archive size" env:"INPUT_PART_SIZE"`
+	MaxBandwidth    int64   `help:"maximum download bandwidth in megabytes per second shared by all parts, 0 is unlimited" env:"INPUT_MAX_RESTORE_BANDWIDTH,INPUT_MAX_BANDWIDTH"`
+	MaxSize         int64   `help:"maximum total uncompressed size in megabytes of an archive, 0 is unlimited" default:"51200" env:"INPUT_MAX_EXTRACT_SIZE"`
+	MaxFiles        int64   `help:"maximum number of entries in an archive, 0 is unlimited" default:"2000000" env:"INPUT_MAX_EXTRACT_FILES"`
+	MaxRatio        float64 `help:"maximum ratio of the uncompressed size to the archive size, 0 is unlimited" default:"1000" env:"INPUT_MAX_EXTRACT_RATIO"`
+	All             bool    `help:"restore all the caches declared in the manifest" env:"INPUT_ALL"`
+	Clean           bool    `help:"clean the path before restore" env:"INPUT_CLEAN"`
+}
+
+// Validate is called by kong after parsing, a key is only required when not restoring from a manifest.
+func (c *RestoreCmd) Validate() error {
+	if !c.All && c.Key == "" {
+		return errors.New("--key is required unless --all is used")
+	}
+
+	return nil
 }
 
 func (c *RestoreCmd) Run(ctx context.Context, globals *Globals) error {
@@ -41,24 +70,174 @@ func (c *RestoreCmd) Run(ctx context.Context, globals *Globals) error {
 		attribute.String("key", c.Key),
 		attribute.String("path", c.Path),
 		attribute.Bool("clean", c.Clean),
+		attribute.Bool("all", c.All),
+		attribute.Int("concurrency", c.Concurrency),
+		attribute.Int64("part_size", c.PartSize),
+		attribute.Int64("max_bandwidth", c.MaxBandwidth),
 		attribute.String("token_source", c.TokenSource),
 	)
 
-	cacheHit, err := c.restore(ctx, globals)
+	if c.All {
+		return c.runAll(ctx, globals)
+	}
+
+	start := time.Now()
+
+	spec, err := c.cacheSpec()
+	if err != nil {
+		return err
+	}
+
+	summary := progress.NewSummary("restore", spec.Key)
+	result := newSpecResult("restore", spec)
+
+	err = c.restore(ctx, globals, spec, summary, result)
 	if err != nil {
 		return fmt.Errorf("failed to restore cache: %w", err)
 	}
 
+	if c.SummaryFile != "" {
+		err = summary.WriteFile(c.SummaryFile)
+		if err != nil {
+			return err
+		}
+	}
+
 	span.SetAttributes(
-		attribute.Bool("cache_hit", cacheHit),
+		attribute.String("resolved_key", result.Key),
+		attribute.Bool("cache_hit", result.CacheHit),
+		attribute.Bool("fallback", result.Fallback),
+		attribute.String("matched_key", result.MatchedKey),
 	)
 
-	fmt.Println(cacheHit)
+	err = publishOutputs(ctx, result.restoreOutputs())
+	if err != nil {
+		return err
+	}
 
-	return nil
+	maps.Copy(result.Durations, summary.Durations)
+	result.Durations["total"] = time.Since(start).Milliseconds()
+
+	return result.write(os.Stdout, c.Output)
 }
 
-func (c *RestoreCmd) restore(ctx context.Context, globals *Globals) (bool, error) {
+// runAll restores every cache declared in the manifest, the entries are looked up in batches using a single
+// token and the archives are downloaded concurrently.
+func (c *RestoreCmd) runAll(ctx context.Context, globals *Globals) error {
+	ctx, span := trace.Start(ctx, "RestoreCmd.runAll")
+	defer span.End()
+
+	manifest, err := LoadManifest(c.Manifest)
+	if err != nil {
+		return err
+	}
+
+	span.SetAttributes(attribute.Int("caches", len(manifest.Caches)))
+
+	start := time.Now()
+
+	token, err := tokens.GetToken(ctx, c.TokenSource, audience, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get token: %w", err)
+	}
+
+	reqs := make([]*cachev1.GetEntryRequest, len(manifest.Caches))
+	for i, spec := range manifest.Caches {
+		manifest.Caches[i], err = spec.Resolve()
+		if err != nil {
+			return fmt.Errorf("failed to resolve cache %s: %w", spec.Name, err)
+		}
+
+		reqs[i] = c.getEntryRequest(manifest.Caches[i])
+	}
+
+	var entries []*cachev1.GetEntriesResult
+	for _, batch := range chunk(reqs, maxBatchEntries) {
+		getEntriesResp, err := globals.Client.GetEntries(ctx, newAuthenticatedProviderRequest(&cachev1.GetEntriesRequest{
+			Entries: batch,
+		}, token, c.TokenSource, globals.Version))
+		if err != nil {
+			return fmt.Errorf("failed to get cache entries: %w", err)
+		}
+
+		entries = append(entries, getEntriesResp.Msg.Results...)
+	}
+
+	if len(entries) != len(manifest.Caches) {
+		return fmt.Errorf("expected %d cache entries got %d", len(manifest.Caches), len(entries))
+	}
+
+	limiter := ratelimit.NewLimiter(c.MaxBandwidth)
+	summaries := make([]*progress.Summary, len(manifest.Caches))
+	results := make([]*Result, len(manifest.Caches))
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(c.Parallel, 1))
+
+	for i, spec := range manifest.Caches {
+		summaries[i] = progress.NewSummary("restore", spec.Key)
+		results[i] = newSpecResult("restore", spec)
+
+		if !entries[i].Found {
+			log.Info().Str("name", spec.Name).Str("key", spec.Key).Msg("cache entry not found")
+			continue
+		}
+
+		g.Go(func() error {
+			reporter := newManifestReporter(c.Progress, spec.Name, summaries[i])
+
+			err := c.restoreEntry(gctx, spec, entries[i].Entry, limiter, reporter, summaries[i], results[i])
+			if err != nil {
+				return fmt.Errorf("failed to restore cache %s: %w", spec.Name, err)
+			}
+
+			return nil
+		})
+	}
+
+	err = g.Wait()
+	if err != nil {
+		return err
+	}
+
+	if c.SummaryFile != "" {
+		err = progress.WriteSummaries(c.SummaryFile, summaries)
+		if err != nil {
+			return err
+		}
+	}
+
+	outputs := make(map[string]string)
+	for i, result := range results {
+		maps.Copy(outputs, result.restoreOutputs())
+		maps.Copy(result.Durations, summaries[i].Durations)
+		result.Durations["total"] = time.Since(start).Milliseconds()
+	}
+
+	err = publishOutputs(ctx, outputs)
+	if err != nil {
+		return err
+	}
+
+	return writeResults(os.Stdout, c.Output, results)
+}
+
+// cacheSpec builds the cache to restore from the flags, resolving any key templates.
+func (c *RestoreCmd) cacheSpec() (CacheSpec, error) {
+	paths, err := checkPath(c.Path)
+	if err != nil {
+		return CacheSpec{}, fmt.Errorf("failed to check path: %w", err)
+	}
+
+	return CacheSpec{
+		Key:         c.Key,
+		Paths:       paths,
+		RestoreKeys: nonEmptyLines(c.RestoreKeys),
+	}.Resolve()
+}
+
+// restore downloads and extracts the cache entry, populating the result as it goes.
+func (c *RestoreCmd) restore(ctx context.Context, globals *Globals, spec CacheSpec, summary *progress.Summary, result *Result) error {
 	ctx, span := trace.Start(ctx, "RestoreCmd.restore")
 	defer span.End()
 
@@ -66,11 +245,26 @@ func (c *RestoreCmd) restore(ctx context.Context, globals *Globals) (bool, error
 
 	token, err := tokens.GetToken(ctx, c.TokenSource, audience, nil)
 	if err != nil {
-		return false, fmt.Errorf("failed to get token: %w", err)
+		return fmt.Errorf("failed to get token: %w", err)
 	}
 
-	req := newAuthenticatedProviderRequest(&cachev1.GetEntryRequest{
-		Key:            c.Key,
+	req := newAuthenticatedProviderRequest(c.getEntryRequest(spec), token, c.TokenSource, globals.Version)
+
+	getEntryResp, err := cl.GetEntry(ctx, req)
+	if err != nil {
+		if connect.CodeOf(err) == connect.CodeNotFound {
+			log.Info().Msg("cache entry not found")
+			return nil
+		}
+		return fmt.Errorf("failed to get cache entry: %w", err)
+	}
+
+	return c.restoreEntry(ctx, spec, getEntryResp.Msg, ratelimit.NewLimiter(c.MaxBandwidth), newReporter(c.Progress, summary), summary, result)
+}
+
+func (c *RestoreCmd) getEntryRequest(spec CacheSpec) *cachev1.GetEntryRequest {
+	return &cachev1.GetEntryRequest{
+		Key:            spec.Key,
 		Name:           c.Name,
 		Branch:         c.Branch,
 		ProviderType:   convertProviderTypeV1(c.TokenSource),
@@ -81,137 +275,142 @@ func (c *RestoreCmd) restore(ctx context.Context, globals *Globals) (bool, error
 			Architecture:    runtime.GOARCH,
 			CpuCount:        int32(runtime.NumCPU()),
 		},
-	}, token, c.TokenSource, globals.Version)
-
-	getEntryResp, err := cl.GetEntry(ctx, req)
-	if err != nil {
-		if connect.CodeOf(err) == connect.CodeNotFound {
-			log.Info().Msg("cache entry not found")
-			return false, nil
-		}
-		return false, fmt.Errorf("failed to get cache entry: %w", err)
+		PartSize:    c.PartSize * megabyte,
+		RestoreKeys: spec.RestoreKeys,
 	}
+}
+
+// restoreEntry downloads the archive for a cache entry and extracts it into the paths of the cache.
+func (c *RestoreCmd) restoreEntry(ctx context.Context, spec CacheSpec, entry *cachev1.GetEntryResponse, limiter *rate.Limiter, reporter progress.Reporter, summary *progress.Summary, result *Result) error {
+	ctx, span := trace.Start(ctx, "RestoreCmd.restoreEntry")
+	defer span.End()
 
 	log.Info().
-		Str("key", getEntryResp.Msg.CacheEntry.Key).
-		Str("compression", getEntryResp.Msg.CacheEntry.Compression).
-		Int64("size", getEntryResp.Msg.CacheEntry.FileSize).
-		Bool("fallback", getEntryResp.Msg.Fallback).
-		Bool("multipart", getEntryResp.Msg.Multipart).
+		Str("name", spec.Name).
+		Str("key", entry.CacheEntry.Key).
+		Str("compression", entry.CacheEntry.Compression).
+		Int64("size", entry.CacheEntry.FileSize).
+		Bool("fallback", entry.Fallback).
+		Bool("multipart", entry.Multipart).
 		Msg("cache entry")
 
-	downloads, err := downloader.NewDownloader(
-		convertToDownloadInstructions(getEntryResp.Msg.DownloadInstructions),
-		20,
-	).Download(ctx)
-	if err != nil {
-		return false, fmt.Errorf("failed to download cache entry: %w", err)
-	}
+	result.MatchedKey = entry.CacheEntry.Key
+	result.EntryID = entry.Id
+	result.Sha256sum = entry.CacheEntry.Sha256Sum
+	result.Size = entry.CacheEntry.FileSize
+	result.Fallback = entry.Fallback
 
-	slices.SortFunc(downloads, func(a, b downloader.DownloadedFile) int {
-		return cmp.Compare(a.Part, b.Part)
-	})
+	zipFileLen := entry.CacheEntry.FileSize
 
-	for _, d := range downloads {
-		log.Debug().
-			Int("part", d.Part).
-			Str("etag", d.ETag).
-			Msg("download")
-	}
-
-	zipFile, zipFileLen, err := combineParts(ctx, downloads)
+	zipFile, cleanup, err := c.openArchive(ctx, entry, limiter, reporter, result)
 	if err != nil {
-		return false, fmt.Errorf("failed to create temp file: %w", err)
+		return err
 	}
-	defer zipFile.Close()
+	defer cleanup()
 
 	log.Info().Int64("zipFileLen", zipFileLen).Str("name", zipFile.Name()).Msg("zip file len")
 
-	paths, err := checkPath(c.Path)
-	if err != nil {
-		return false, fmt.Errorf("failed to check path: %w", err)
-	}
-
 	if c.Clean {
-		for _, path := range paths {
+		patterns, err := archive.ParsePatterns(spec.Paths)
+		if err != nil {
+			return fmt.Errorf("failed to parse path patterns: %w", err)
+		}
+
+		// only literal paths are cleaned, removing the root of a glob could delete far more than the cache
+		for _, path := range patterns.Literals() {
 			var extractedPath string
 			extractedPath, err = archive.ResolveHomeDir(path)
 			if err != nil {
-				return false, fmt.Errorf("failed to resolve home dir: %w", err)
+				return fmt.Errorf("failed to resolve home dir: %w", err)
 			}
 
 			log.Info().Str("path", path).Str("extractedPath", extractedPath).Msg("cleaning path")
 			err = cleanPath(ctx, extractedPath)
 			if err != nil {
-				return false, fmt.Errorf("failed to clean path: %w", err)
+				return fmt.Errorf("failed to clean path: %w", err)
 			}
 		}
 	}
 
-	log.Info().Strs("paths", paths).Msg("extracting files")
+	log.Info().Strs("paths", spec.Paths).Msg("extracting files")
+
+	start := time.Now()
 
-	err = archive.ExtractFiles(ctx, zipFile, zipFileLen, paths)
+	extractInfo, err := archive.ExtractFiles(ctx, zipFile, zipFileLen, spec.Paths, archive.WithLimits(archive.Limits{
+		MaxSize:  c.MaxSize * 1024 * 1024,
+		MaxFiles: c.MaxFiles,
+		MaxRatio: c.MaxRatio,
+	}))
 	if err != nil {
-		return false, fmt.Errorf("failed to restore files: %w", err)
+		return fmt.Errorf("failed to restore files: %w", err)
 	}
 
-	// cleanup zip file
-	defer os.Remove(zipFile.Name())
+	summary.Phase("extract", time.Since(start))
+	summary.SetArchive(zipFileLen, extractInfo.BytesExtracted, extractInfo.FilesExtracted)
 
-	// check if the cache entry is a fallback
-	if getEntryResp.Msg.Fallback {
-		return false, nil
-	}
+	// a fallback entry is restored but isn't reported as a cache hit
+	result.CacheHit = !entry.Fallback
 
-	return true, nil
+	return nil
 }
 
-// pass in a list of paths and turn them into a zip file stream to enable extraction
-func combineParts(ctx context.Context, downloads []downloader.DownloadedFile) (*os.File, int64, error) {
-	ctx, span := trace.Start(ctx, "combineParts")
+// openArchive returns the archive for the cache entry from the local cache when enabled, otherwise it is
+// downloaded to a temp file and added to the local cache. The returned function closes and removes the file.
+func (c *RestoreCmd) openArchive(ctx context.Context, entry *cachev1.GetEntryResponse, limiter *rate.Limiter, reporter progress.Reporter, result *Result) (*os.File, func(), error) {
+	ctx, span := trace.Start(ctx, "RestoreCmd.openArchive")
 	defer span.End()
 
-	zipFile, err := os.CreateTemp("", "zipstash-download-*.zip")
+	cache, err := c.localCache()
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to create temp file: %w", err)
+		return nil, nil, err
 	}
 
-	zipFileLen := int64(0)
-
-	for _, d := range downloads {
-		n, err := appendToFileAndRemove(ctx, zipFile, d.FilePath)
-		if err != nil {
-			return nil, 0, fmt.Errorf("failed to write file: %w", err)
+	if cache != nil {
+		zipFile, err := cache.Get(ctx, entry.CacheEntry.Sha256Sum)
+		switch {
+		case err == nil:
+			log.Info().Str("sha256sum", entry.CacheEntry.Sha256Sum).Msg("restoring from local cache")
+			result.LocalHit = true
+			span.SetAttributes(attribute.Bool("local_hit", true))
+			return zipFile, func() { zipFile.Close() }, nil
+		case !errors.Is(err, localcache.ErrNotFound):
+			log.Warn().Err(err).Msg("failed to read local cache")
 		}
-		zipFileLen += n
 	}
 
-	span.SetAttributes(
-		attribute.Int64("zipFileLen", zipFileLen),
-		attribute.Int("parts", len(downloads)),
-	)
+	zipFile, err := os.CreateTemp("", "zipstash-download-*.zip")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
 
-	return zipFile, zipFileLen, nil
-}
+	cleanup := func() {
+		zipFile.Close()
+		os.Remove(zipFile.Name())
+	}
 
-func appendToFileAndRemove(ctx context.Context, f *os.File, path string) (int64, error) {
-	_, span := trace.Start(ctx, "appendToFileAndRemove")
-	defer span.End()
+	zipFileLen := entry.CacheEntry.FileSize
 
-	pf, err := os.Open(filepath.Clean(path))
+	downloads, err := downloader.NewDownloader(
+		convertToDownloadInstructions(entry.DownloadInstructions),
+		c.Concurrency,
+		downloader.WithRateLimiter(limiter),
+		downloader.WithReporter(reporter),
+	).Download(ctx, zipFile, zipFileLen)
 	if err != nil {
-		return 0, fmt.Errorf("failed to open file: %w", err)
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to download cache entry: %w", err)
 	}
-	defer pf.Close()
 
-	n, err := io.Copy(f, pf)
-	if err != nil {
-		return 0, fmt.Errorf("failed to copy file: %w", err)
+	for _, d := range downloads {
+		log.Debug().
+			Int("part", d.Part).
+			Str("etag", d.ETag).
+			Msg("download")
 	}
 
-	defer os.Remove(path)
+	populateLocalCache(ctx, cache, entry.CacheEntry.Sha256Sum, io.NewSectionReader(zipFile, 0, zipFileLen))
 
-	return n, nil
+	return zipFile, cleanup, nil
 }
 
 func convertToDownloadInstructions(instructs []*cachev1.CacheDownloadInstruction) []downloader.CacheDownloadInstruction {
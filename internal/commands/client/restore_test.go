@@ -1 +1,67 @@
 package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	cachev1 "github.com/wolfeidau/zipstash/api/gen/proto/go/cache/v1"
+	"github.com/wolfeidau/zipstash/pkg/progress"
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+func TestRestoreOpenArchiveLocalCache(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	assert.NoError(err)
+
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	sum := sha256.Sum256(content)
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.ServeContent(w, r, "archive.zip", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	entry := &cachev1.GetEntryResponse{
+		CacheEntry: &cachev1.CacheEntry{
+			FileSize:  int64(len(content)),
+			Sha256Sum: hex.EncodeToString(sum[:]),
+		},
+		DownloadInstructions: []*cachev1.CacheDownloadInstruction{{Method: http.MethodGet, Url: srv.URL}},
+	}
+
+	cmd := &RestoreCmd{
+		LocalCacheFlags: LocalCacheFlags{LocalCache: t.TempDir(), LocalCacheSize: 10},
+		Concurrency:     1,
+	}
+
+	// the first restore downloads the archive and the second reads it from the local cache
+	for _, localHit := range []bool{false, true} {
+		result := newResult("restore", "key")
+
+		zipFile, cleanup, err := cmd.openArchive(context.Background(), entry, nil, progress.Discard, result)
+		assert.NoError(err)
+
+		data, err := io.ReadAll(zipFile)
+		assert.NoError(err)
+		assert.Equal(content, data)
+
+		cleanup()
+
+		assert.Equal(localHit, result.LocalHit)
+		assert.Equal(int32(1), requests.Load())
+	}
+}
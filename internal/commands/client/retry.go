@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/rs/zerolog/log"
+
+	"github.com/wolfeidau/zipstash/pkg/ratelimit"
+)
+
+const (
+	// rateLimitedAttempts is the number of times a request rejected by a rate limit of the server is sent.
+	rateLimitedAttempts = 4
+
+	// maxRetryAfter is the longest retry after hint which is waited for, requests with longer hints fail so jobs
+	// don't appear to hang.
+	maxRetryAfter = time.Minute
+)
+
+// NewRetryAfterInterceptor returns an interceptor which retries requests rejected by a rate limit of the server
+// after waiting for the retry after hint of the error.
+func NewRetryAfterInterceptor() connect.Interceptor {
+	return connect.UnaryInterceptorFunc(func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			if !req.Spec().IsClient {
+				return next(ctx, req)
+			}
+
+			for attempt := 1; ; attempt++ {
+				res, err := next(ctx, req)
+
+				delay, ok := retryAfter(err)
+				if !ok || attempt == rateLimitedAttempts {
+					return res, err
+				}
+
+				log.Warn().Err(err).Str("procedure", req.Spec().Procedure).Dur("retry_after", delay).Int("attempt", attempt).
+					Msg("rate limited, retrying")
+
+				timer := time.NewTimer(delay)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return nil, err
+				case <-timer.C:
+				}
+			}
+		}
+	})
+}
+
+// retryAfter returns the hint of an error returned by a rate limit, other resource exhausted errors such as a
+// message being too large don't have a hint so aren't retried.
+func retryAfter(err error) (time.Duration, bool) {
+	var connectErr *connect.Error
+	if !errors.As(err, &connectErr) || connectErr.Code() != connect.CodeResourceExhausted {
+		return 0, false
+	}
+
+	delay, ok := ratelimit.ParseRetryAfter(connectErr.Meta().Get(ratelimit.RetryAfterHeader), time.Now())
+	if !ok || delay > maxRetryAfter {
+		return 0, false
+	}
+
+	return delay, true
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+
+	cachev1 "github.com/wolfeidau/zipstash/api/gen/proto/go/cache/v1"
+	"github.com/wolfeidau/zipstash/api/gen/proto/go/cache/v1/cachev1connect"
+	"github.com/wolfeidau/zipstash/pkg/ratelimit"
+)
+
+// rateLimitedHandler rejects the first requests with the retry after hint.
+type rateLimitedHandler struct {
+	cachev1connect.UnimplementedCacheServiceHandler
+	rejections int
+	hint       string
+	calls      int
+}
+
+func (h *rateLimitedHandler) GetEntry(context.Context, *connect.Request[cachev1.GetEntryRequest]) (*connect.Response[cachev1.GetEntryResponse], error) {
+	h.calls++
+
+	if h.calls <= h.rejections {
+		err := connect.NewError(connect.CodeResourceExhausted, errors.New("subject rate limit exceeded"))
+		if h.hint != "" {
+			err.Meta().Set(ratelimit.RetryAfterHeader, h.hint)
+		}
+
+		return nil, err
+	}
+
+	return connect.NewResponse(&cachev1.GetEntryResponse{}), nil
+}
+
+func TestRetryAfterInterceptor(t *testing.T) {
+	tests := []struct {
+		name       string
+		rejections int
+		hint       string
+		wantCalls  int
+		wantErr    bool
+	}{
+		{name: "not limited", wantCalls: 1},
+		{name: "retried", rejections: 2, hint: "0", wantCalls: 3},
+		{name: "attempts exhausted", rejections: rateLimitedAttempts, hint: "0", wantCalls: rateLimitedAttempts, wantErr: true},
+		{name: "no hint", rejections: 1, wantCalls: 1, wantErr: true},
+		{name: "hint too long", rejections: 1, hint: "3600", wantCalls: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			handler := &rateLimitedHandler{rejections: tt.rejections, hint: tt.hint}
+
+			mux := http.NewServeMux()
+			mux.Handle(cachev1connect.NewCacheServiceHandler(handler))
+
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			client := cachev1connect.NewCacheServiceClient(srv.Client(), srv.URL, connect.WithInterceptors(NewRetryAfterInterceptor()))
+
+			_, err := client.GetEntry(context.Background(), connect.NewRequest(&cachev1.GetEntryRequest{}))
+			if tt.wantErr {
+				assert.Equal(connect.CodeResourceExhausted, connect.CodeOf(err))
+			} else {
+				assert.NoError(err)
+			}
+			assert.Equal(tt.wantCalls, handler.calls)
+		})
+	}
+}
@@ -2,30 +2,79 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"os"
 	"runtime"
-	"strings"
 	"time"
 
 	"connectrpc.com/connect"
 	"github.com/rs/zerolog/log"
 	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
+	"google.golang.org/protobuf/types/known/durationpb"
 
 	cachev1 "github.com/wolfeidau/zipstash/api/gen/proto/go/cache/v1"
 	"github.com/wolfeidau/zipstash/pkg/archive"
+	"github.com/wolfeidau/zipstash/pkg/progress"
+	"github.com/wolfeidau/zipstash/pkg/ratelimit"
 	"github.com/wolfeidau/zipstash/pkg/tokens"
 	"github.com/wolfeidau/zipstash/pkg/trace"
 	"github.com/wolfeidau/zipstash/pkg/uploader"
 )
 
 type SaveCmd struct {
-	Key         string `help:"key to use for the cache entry" required:"" env:"INPUT_KEY"`
-	Path        string `help:"Path list for a cache entry." env:"INPUT_PATH"`
-	TokenSource string `help:"token source" default:"github_actions" env:"INPUT_TOKEN_SOURCE"`
-	Branch      string `help:"branch to use for the cache entry" env:"INPUT_BRANCH" required:""`
-	Name        string `help:"repository, project or pipeline name to use for the cache entry" env:"INPUT_REPOSITORY" required:""`
-	Owner       string `help:"owner of the cache entry" env:"INPUT_OWNER"`
-	Skip        bool   `help:"Skip saving the cache entry." env:"INPUT_SKIP"`
+	LocalCacheFlags   `embed:""`
+	Key               string        `help:"key to use for the cache entry, this may be a template using os, arch, env and hashFiles" env:"INPUT_KEY"`
+	Path              string        `help:"newline separated list of paths or glob patterns for a cache entry, lines starting with ! exclude matching files" env:"INPUT_PATH"`
+	Manifest          string        `help:"manifest declaring the caches to save with --all" default:"zipstash.yaml" env:"INPUT_MANIFEST"`
+	TokenSource       string        `help:"token source" default:"github_actions" env:"INPUT_TOKEN_SOURCE"`
+	Branch            string        `help:"branch to use for the cache entry" env:"INPUT_BRANCH" required:""`
+	Name              string        `help:"repository, project or pipeline name to use for the cache entry" env:"INPUT_REPOSITORY" required:""`
+	Owner             string        `help:"owner of the cache entry" env:"INPUT_OWNER"`
+	Progress          string        `help:"progress reporting, auto uses a progress bar on a terminal and log lines otherwise" default:"auto" enum:"auto,bar,log,none" env:"INPUT_PROGRESS"`
+	SummaryFile       string        `help:"write a JSON summary of the save to this file" env:"INPUT_SUMMARY_FILE"`
+	Output            string        `help:"output format for the save result" default:"text" enum:"text,json" env:"INPUT_OUTPUT"`
+	Concurrency       int           `help:"number of parts to upload concurrently" default:"20" env:"INPUT_CONCURRENCY"`
+	Parallel          int           `help:"number of caches to save concurrently with --all" default:"4" env:"INPUT_PARALLEL"`
+	PartSize          int64         `help:"preferred part size in megabytes for multipart uploads, by default the server picks one based on the archive size" env:"INPUT_PART_SIZE"`
+	MaxBandwidth      int64         `help:"maximum upload bandwidth in megabytes per second shared by all parts, 0 is unlimited" env:"INPUT_MAX_SAVE_BANDWIDTH,INPUT_MAX_BANDWIDTH"`
+	TTL               time.Duration `help:"how long to retain the cache entry, by default the server default is used" env:"INPUT_TTL"`
+	All               bool          `help:"save all the caches declared in the manifest" env:"INPUT_ALL"`
+	Codec             string        `help:"codec used to compress files, auto uses zstd but stores files which are already compressed" default:"zstd" enum:"store,deflate,zstd,auto" env:"INPUT_CODEC"`
+	ZstdLevel         int           `help:"zstd level from 1 to 22, lower levels are faster and higher levels compress better" default:"3" env:"INPUT_ZSTD_LEVEL"`
+	Workers           int           `help:"number of files to compress concurrently, by default one per CPU" env:"INPUT_WORKERS"`
+	PreserveOwnership bool          `help:"record the uid and gid of each file, these are restored when extracting as a user permitted to change ownership" env:"INPUT_PRESERVE_OWNERSHIP"`
+	PreserveModTimes  bool          `help:"record the modification time of each file, by default a fixed time is used so the archive checksum is reproducible" env:"INPUT_PRESERVE_MTIMES"`
+	Skip              bool          `help:"Skip saving the cache entry." env:"INPUT_SKIP"`
+}
+
+// Validate is called by kong after parsing, a key is only required when not saving from a manifest.
+func (c *SaveCmd) Validate() error {
+	if !c.All && c.Key == "" {
+		return errors.New("--key is required unless --all is used")
+	}
+
+	if c.ZstdLevel < 1 || c.ZstdLevel > archive.MaxZstdLevel {
+		return fmt.Errorf("--zstd-level must be between 1 and %d", archive.MaxZstdLevel)
+	}
+
+	if c.Workers < 0 {
+		return errors.New("--workers must not be negative")
+	}
+
+	return nil
+}
+
+// workers returns the number of files compressed concurrently, defaulting to one per CPU.
+func (c *SaveCmd) workers() int {
+	if c.Workers == 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+
+	return c.Workers
 }
 
 func (c *SaveCmd) Run(ctx context.Context, globals *Globals) error {
@@ -36,7 +85,14 @@ func (c *SaveCmd) Run(ctx context.Context, globals *Globals) error {
 		attribute.String("key", c.Key),
 		attribute.String("path", c.Path),
 		attribute.Bool("skip", c.Skip),
+		attribute.Bool("all", c.All),
+		attribute.Int("concurrency", c.Concurrency),
+		attribute.Int64("part_size", c.PartSize),
+		attribute.Int64("max_bandwidth", c.MaxBandwidth),
 		attribute.String("token_source", c.TokenSource),
+		attribute.String("codec", c.Codec),
+		attribute.Int("zstd_level", c.ZstdLevel),
+		attribute.Int("workers", c.workers()),
 	)
 
 	if c.Skip {
@@ -44,10 +100,138 @@ func (c *SaveCmd) Run(ctx context.Context, globals *Globals) error {
 		return nil
 	}
 
-	return c.save(ctx, globals)
+	if c.All {
+		return c.runAll(ctx, globals)
+	}
+
+	start := time.Now()
+
+	spec, err := c.cacheSpec()
+	if err != nil {
+		return err
+	}
+
+	summary := progress.NewSummary("save", spec.Key)
+	result := newSpecResult("save", spec)
+
+	err = c.save(ctx, globals, spec, summary, result)
+	if err != nil {
+		return err
+	}
+
+	if c.SummaryFile != "" {
+		err = summary.WriteFile(c.SummaryFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	maps.Copy(result.Durations, summary.Durations)
+	result.Durations["total"] = time.Since(start).Milliseconds()
+
+	return result.write(os.Stdout, c.Output)
 }
 
-func (c *SaveCmd) save(ctx context.Context, globals *Globals) error {
+// runAll saves every cache declared in the manifest, the entries are checked in batches using a single
+// token and the archives of caches which don't already exist are built and uploaded concurrently.
+func (c *SaveCmd) runAll(ctx context.Context, globals *Globals) error {
+	ctx, span := trace.Start(ctx, "SaveCmd.runAll")
+	defer span.End()
+
+	manifest, err := LoadManifest(c.Manifest)
+	if err != nil {
+		return err
+	}
+
+	span.SetAttributes(attribute.Int("caches", len(manifest.Caches)))
+
+	start := time.Now()
+
+	token, err := tokens.GetToken(ctx, c.TokenSource, audience, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get token: %w", err)
+	}
+
+	reqs := make([]*cachev1.CheckEntryRequest, len(manifest.Caches))
+	for i, spec := range manifest.Caches {
+		manifest.Caches[i], err = spec.Resolve()
+		if err != nil {
+			return fmt.Errorf("failed to resolve cache %s: %w", spec.Name, err)
+		}
+
+		reqs[i] = c.checkEntryRequest(manifest.Caches[i])
+	}
+
+	var checks []*cachev1.CheckEntryResponse
+	for _, batch := range chunk(reqs, maxBatchEntries) {
+		checkEntriesResp, err := globals.Client.CheckEntries(ctx, newAuthenticatedProviderRequest(&cachev1.CheckEntriesRequest{
+			Entries: batch,
+		}, token, c.TokenSource, globals.Version))
+		if err != nil {
+			return fmt.Errorf("failed to check entries: %w", err)
+		}
+
+		checks = append(checks, checkEntriesResp.Msg.Results...)
+	}
+
+	if len(checks) != len(manifest.Caches) {
+		return fmt.Errorf("expected %d cache entries got %d", len(manifest.Caches), len(checks))
+	}
+
+	limiter := ratelimit.NewLimiter(c.MaxBandwidth)
+	summaries := make([]*progress.Summary, len(manifest.Caches))
+	results := make([]*Result, len(manifest.Caches))
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(c.Parallel, 1))
+
+	for i, spec := range manifest.Caches {
+		summaries[i] = progress.NewSummary("save", spec.Key)
+		results[i] = newSpecResult("save", spec)
+
+		if checks[i].Exists {
+			log.Info().Str("name", spec.Name).Str("key", spec.Key).Msg("cache entry already exists")
+			results[i].CacheHit = true
+			results[i].MatchedKey = spec.Key
+			results[i].Sha256sum = checks[i].Sha256Sum
+			continue
+		}
+
+		g.Go(func() error {
+			reporter := newManifestReporter(c.Progress, spec.Name, summaries[i])
+
+			err := c.saveEntry(gctx, globals, token, spec, limiter, reporter, summaries[i], results[i])
+			if err != nil {
+				return fmt.Errorf("failed to save cache %s: %w", spec.Name, err)
+			}
+
+			return nil
+		})
+	}
+
+	err = g.Wait()
+	if err != nil {
+		return err
+	}
+
+	if c.SummaryFile != "" {
+		err = progress.WriteSummaries(c.SummaryFile, summaries)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, result := range results {
+		maps.Copy(result.Durations, summaries[i].Durations)
+		result.Durations["total"] = time.Since(start).Milliseconds()
+	}
+
+	return writeResults(os.Stdout, c.Output, results)
+}
+
+// save builds and uploads the cache entry, populating the result as it goes. The result is marked as
+// a cache hit when an entry already exists for the key and nothing was saved.
+func (c *SaveCmd) save(ctx context.Context, globals *Globals, spec CacheSpec, summary *progress.Summary, result *Result) error {
 	ctx, span := trace.Start(ctx, "SaveCmd.save")
 	defer span.End()
 
@@ -58,18 +242,7 @@ func (c *SaveCmd) save(ctx context.Context, globals *Globals) error {
 		return fmt.Errorf("failed to get token: %w", err)
 	}
 
-	checkReq := newAuthenticatedProviderRequest(&cachev1.CheckEntryRequest{
-		Key:          c.Key,
-		Branch:       c.Branch,
-		Name:         c.Name,
-		Owner:        c.Owner,
-		ProviderType: convertProviderTypeV1(c.TokenSource),
-		Platform: &cachev1.Platform{
-			OperatingSystem: runtime.GOOS,
-			Architecture:    runtime.GOARCH,
-			CpuCount:        int32(runtime.NumCPU()),
-		},
-	}, token, c.TokenSource, globals.Version)
+	checkReq := newAuthenticatedProviderRequest(c.checkEntryRequest(spec), token, c.TokenSource, globals.Version)
 
 	checkRes, err := cl.CheckEntry(ctx, checkReq)
 	if err != nil {
@@ -78,36 +251,87 @@ func (c *SaveCmd) save(ctx context.Context, globals *Globals) error {
 
 	if checkRes.Msg.Exists {
 		log.Info().Msg("cache entry already exists")
+		result.CacheHit = true
+		result.MatchedKey = spec.Key
+		result.Sha256sum = checkRes.Msg.Sha256Sum
 		return nil
 	}
 
+	return c.saveEntry(ctx, globals, token, spec, ratelimit.NewLimiter(c.MaxBandwidth), newReporter(c.Progress, summary), summary, result)
+}
+
+// cacheSpec builds the cache to save from the flags, resolving any key template.
+func (c *SaveCmd) cacheSpec() (CacheSpec, error) {
 	paths, err := checkPath(c.Path)
 	if err != nil {
-		return fmt.Errorf("failed to check path: %w", err)
+		return CacheSpec{}, fmt.Errorf("failed to check path: %w", err)
+	}
+
+	return CacheSpec{
+		Key:   c.Key,
+		Paths: paths,
+		TTL:   c.TTL,
+	}.Resolve()
+}
+
+func (c *SaveCmd) checkEntryRequest(spec CacheSpec) *cachev1.CheckEntryRequest {
+	return &cachev1.CheckEntryRequest{
+		Key:          spec.Key,
+		Branch:       c.Branch,
+		Name:         c.Name,
+		Owner:        c.Owner,
+		ProviderType: convertProviderTypeV1(c.TokenSource),
+		Platform: &cachev1.Platform{
+			OperatingSystem: runtime.GOOS,
+			Architecture:    runtime.GOARCH,
+			CpuCount:        int32(runtime.NumCPU()),
+		},
 	}
+}
+
+// saveEntry builds the archive for a cache then creates, uploads and completes the cache entry.
+func (c *SaveCmd) saveEntry(ctx context.Context, globals *Globals, token string, spec CacheSpec, limiter *rate.Limiter, reporter progress.Reporter, summary *progress.Summary, result *Result) error {
+	ctx, span := trace.Start(ctx, "SaveCmd.saveEntry")
+	defer span.End()
+
+	cl := globals.Client
 
 	start := time.Now()
 
-	fileInfo, err := archive.BuildArchive(ctx, paths, c.Key)
+	fileInfo, err := archive.BuildArchive(ctx, spec.Paths, spec.Key,
+		archive.WithPreserveOwnership(c.PreserveOwnership),
+		archive.WithPreserveModTimes(c.PreserveModTimes),
+		archive.WithCodec(archive.Codec(c.Codec)),
+		archive.WithZstdLevel(c.ZstdLevel),
+		archive.WithWorkers(c.workers()),
+	)
 	if err != nil {
 		return fmt.Errorf("failed to build archive: %w", err)
 	}
 
 	log.Info().
+		Str("name", spec.Name).
 		Str("path", fileInfo.ArchivePath).
 		Int64("size", fileInfo.Size).
 		Str("sha256sum", fileInfo.Sha256sum).
 		Dur("duration_ms", time.Since(start)).
 		Msg("archive built")
 
-	req := newAuthenticatedProviderRequest(&cachev1.CreateEntryRequest{
+	summary.Phase("archive", time.Since(start))
+
+	result.Sha256sum = fileInfo.Sha256sum
+	result.Size = fileInfo.Size
+	summary.SetArchive(fileInfo.Size, fileInfo.Stats[archive.StatUncompressedBytes], fileInfo.Stats[archive.StatFiles])
+	summary.SetSkipped(fileInfo.Stats[archive.StatSkippedFiles], fileInfo.Stats[archive.StatSkippedDirs])
+
+	createEntryReq := &cachev1.CreateEntryRequest{
 		ProviderType: convertProviderTypeV1(c.TokenSource),
 		CacheEntry: &cachev1.CacheEntry{
-			Key:         c.Key,
-			Compression: "zip",
+			Key:         spec.Key,
+			Compression: fileInfo.Compression,
 			FileSize:    fileInfo.Size,
 			Sha256Sum:   fileInfo.Sha256sum,
-			Paths:       paths,
+			Paths:       spec.Paths,
 			Name:        c.Name,
 			Branch:      c.Branch,
 			Owner:       c.Owner,
@@ -117,20 +341,32 @@ func (c *SaveCmd) save(ctx context.Context, globals *Globals) error {
 			Architecture:    runtime.GOARCH,
 			CpuCount:        int32(runtime.NumCPU()),
 		},
-	}, token, c.TokenSource, globals.Version)
+		PartSize: c.PartSize * megabyte,
+	}
+
+	if spec.TTL > 0 {
+		createEntryReq.Ttl = durationpb.New(spec.TTL)
+	}
 
-	createResp, err := cl.CreateEntry(ctx, req)
+	createResp, err := cl.CreateEntry(ctx, newAuthenticatedProviderRequest(createEntryReq, token, c.TokenSource, globals.Version))
 	if err != nil {
 		if connect.CodeOf(err) == connect.CodeAlreadyExists {
 			log.Info().Msg("cache entry found with matching sha256sum")
-			return nil
+			result.CacheHit = true
+			result.MatchedKey = spec.Key
+			return c.populateLocalCache(ctx, fileInfo)
 		}
 		return fmt.Errorf("failed to create cache entry: %w", err)
 	}
 
 	log.Info().Str("id", createResp.Msg.Id).Msg("creating cache entry")
 
-	upl := uploader.NewUploader(ctx, fileInfo.ArchivePath, toUploadInstructions(createResp.Msg.UploadInstructions), 20)
+	result.EntryID = createResp.Msg.Id
+
+	upl := uploader.NewUploader(ctx, fileInfo.ArchivePath, toUploadInstructions(createResp.Msg.UploadInstructions), c.Concurrency,
+		uploader.WithRateLimiter(limiter),
+		uploader.WithReporter(reporter),
+	)
 
 	etags, err := upl.Upload(ctx)
 	if err != nil {
@@ -149,10 +385,32 @@ func (c *SaveCmd) save(ctx context.Context, globals *Globals) error {
 
 	log.Info().Str("id", updateResp.Msg.Id).Int("parts", len(etags)).Msg("updated cache entry")
 
+	return c.populateLocalCache(ctx, fileInfo)
+}
+
+// populateLocalCache adds the saved archive to the local cache when enabled, so a later restore on the same
+// runner doesn't need to download it.
+func (c *SaveCmd) populateLocalCache(ctx context.Context, fileInfo *archive.ArchiveInfo) error {
+	cache, err := c.localCache()
+	if err != nil || cache == nil {
+		return err
+	}
+
+	f, err := os.Open(fileInfo.ArchivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer f.Close()
+
+	populateLocalCache(ctx, cache, fileInfo.Sha256sum, f)
+
 	return nil
 }
+
+// checkPath splits the newline separated path list, each line is a path or glob pattern and lines starting
+// with ! exclude matching files.
 func checkPath(path string) ([]string, error) {
-	paths := strings.Fields(path)
+	paths := nonEmptyLines(path)
 	if len(paths) == 0 {
 		return nil, fmt.Errorf("no paths provided")
 	}
@@ -31,6 +31,12 @@ func TestCheckPath(t *testing.T) {
 			expected: nil,
 			wantErr:  true,
 		},
+		{
+			name:     "paths with spaces and patterns",
+			path:     "/tmp/my dir\n\n  **/target  \n!**/*.log",
+			expected: []string{"/tmp/my dir", "**/target", "!**/*.log"},
+			wantErr:  false,
+		},
 		{
 			name:     "multiple paths with tilde",
 			path:     "~/documents\n~/.npm",
@@ -51,3 +57,42 @@ func TestCheckPath(t *testing.T) {
 		})
 	}
 }
+
+func TestSaveCmdValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmd     SaveCmd
+		wantErr string
+	}{
+		{
+			name: "defaults",
+			cmd:  SaveCmd{Key: "key", ZstdLevel: 3},
+		},
+		{
+			name:    "missing key",
+			cmd:     SaveCmd{ZstdLevel: 3},
+			wantErr: "--key is required",
+		},
+		{
+			name:    "zstd level too high",
+			cmd:     SaveCmd{Key: "key", ZstdLevel: 23},
+			wantErr: "--zstd-level must be between 1 and 22",
+		},
+		{
+			name:    "negative workers",
+			cmd:     SaveCmd{Key: "key", ZstdLevel: 3, Workers: -1},
+			wantErr: "--workers must not be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cmd.Validate()
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package commands
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/wolfeidau/zipstash/internal/events"
+)
+
+// lambdaFlushTimeout limits how long a response of the lambda server waits for the events of the request to be
+// delivered, events still queued are delivered once the function is next invoked.
+const lambdaFlushTimeout = 10 * time.Second
+
+// EventsFlags configures the subscribers notified of cache events.
+type EventsFlags struct {
+	EventsConfig     string `help:"JSON file of the webhooks and files notified of the cache events of each tenant" env:"EVENTS_CONFIG" type:"existingfile"`
+	EventsConfigJSON string `help:"JSON of the webhooks and files notified of the cache events of each tenant, used in place of a file such as in lambda" env:"EVENTS_CONFIG_JSON"`
+	EventsWorkers    int    `help:"number of events delivered to subscribers at once, zero delivers events before responding with a single attempt" env:"EVENTS_WORKERS" default:"4"`
+}
+
+// newDispatcher returns a dispatcher of the events to the configured subscribers, this is nil when there are no
+// subscribers.
+func (e EventsFlags) newDispatcher() (*events.Dispatcher, error) {
+	var (
+		cfg events.Config
+		err error
+	)
+
+	switch {
+	case e.EventsConfigJSON != "":
+		cfg, err = events.ParseConfig(strings.NewReader(e.EventsConfigJSON))
+	case e.EventsConfig != "":
+		cfg, err = events.LoadConfig(e.EventsConfig)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return events.NewDispatcher(cfg, e.EventsWorkers)
+}
+
+// publisherOf returns the dispatcher as a publisher, this is nil rather than a nil dispatcher when there are no
+// subscribers so the servers skip building events.
+func publisherOf(dispatcher *events.Dispatcher) events.Publisher {
+	if dispatcher == nil {
+		return nil
+	}
+
+	return dispatcher
+}
+
+// flushEvents waits for the events queued by each request to be delivered before responding, as a lambda function
+// is frozen between invocations.
+func flushEvents(dispatcher *events.Dispatcher, next http.Handler) http.Handler {
+	if dispatcher == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+
+		ctx, cancel := context.WithTimeout(r.Context(), lambdaFlushTimeout)
+		defer cancel()
+
+		if err := dispatcher.Flush(ctx); err != nil {
+			log.Warn().Err(err).Msg("failed to deliver events before responding")
+		}
+	})
+}
@@ -0,0 +1,48 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	awsevents "github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/rs/zerolog/log"
+
+	"github.com/wolfeidau/zipstash/internal/server"
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+type EvictionsLambdaCmd struct {
+	EventsFlags `embed:""`
+}
+
+func (s *EvictionsLambdaCmd) Run(ctx context.Context, globals *Globals) error {
+	tp, err := trace.NewLambdaProvider(ctx, "github.com/wolfeidau/zipstash", globals.Version)
+	if err != nil {
+		log.Fatal().Msgf("failed to create trace provider: %v", err)
+	}
+	defer func() {
+		_ = tp.Shutdown(ctx)
+	}()
+
+	dispatcher, err := s.newDispatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create events publisher: %w", err)
+	}
+
+	handler := server.NewEvictionHandler(publisherOf(dispatcher))
+
+	// events are delivered before the batch completes as the function is frozen between invocations, webhooks
+	// are retried within the timeout of the function
+	lambda.Start(func(ctx context.Context, batch awsevents.DynamoDBEvent) error {
+		err := handler.Handle(ctx, batch)
+
+		if ferr := dispatcher.Flush(ctx); ferr != nil {
+			log.Warn().Err(ferr).Msg("failed to deliver events before the batch completed")
+		}
+
+		return err
+	})
+
+	return nil
+}
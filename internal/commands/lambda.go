@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"time"
 
 	"connectrpc.com/connect"
 	"connectrpc.com/otelconnect"
@@ -23,13 +24,21 @@ import (
 	"github.com/wolfeidau/zipstash/internal/ciauth"
 	"github.com/wolfeidau/zipstash/internal/index"
 	"github.com/wolfeidau/zipstash/internal/server"
+	"github.com/wolfeidau/zipstash/pkg/metrics"
 	"github.com/wolfeidau/zipstash/pkg/trace"
 )
 
 type LambdaServerCmd struct {
-	CacheBucket     string `help:"bucket to store cache" env:"CACHE_BUCKET"`
-	CacheIndexTable string `help:"table to store cache index" env:"CACHE_INDEX_TABLE"`
-	TrustRemote     bool   `help:"trust remote spans"`
+	CacheBucket          string `help:"bucket to store cache" env:"CACHE_BUCKET"`
+	CacheIndexTable      string `help:"table to store cache index" env:"CACHE_INDEX_TABLE"`
+	TrustRemote          bool   `help:"trust remote spans"`
+	ActionsDefaultBranch string `help:"branch whose actions cache entries can be restored by every branch" env:"ACTIONS_DEFAULT_BRANCH" default:"main"`
+	MaxUploadSize        int64  `help:"largest request body accepted by the bazel, gradle, turborepo, actions cache and registry apis in bytes, bodies are base64 encoded within the 6MB lambda payload" env:"MAX_UPLOAD_SIZE" default:"4194304"`
+	BucketRetentionDays  int    `help:"days the lifecycle rule of the bucket keeps objects, registry tags expire within this so they don't refer to expired blobs, zero when objects aren't expired" env:"BUCKET_RETENTION_DAYS"`
+
+	AuditFlags     `embed:""`
+	EventsFlags    `embed:""`
+	RateLimitFlags `embed:""`
 }
 
 func (s *LambdaServerCmd) Run(ctx context.Context, globals *Globals) error {
@@ -41,6 +50,14 @@ func (s *LambdaServerCmd) Run(ctx context.Context, globals *Globals) error {
 		_ = tp.Shutdown(ctx)
 	}()
 
+	mp, err := metrics.NewLambdaProvider(ctx, "github.com/wolfeidau/zipstash", globals.Version)
+	if err != nil {
+		return fmt.Errorf("failed to create meter provider: %w", err)
+	}
+	defer func() {
+		_ = mp.Shutdown(ctx)
+	}()
+
 	opts := []connect.HandlerOption{}
 
 	awscfg, err := config.LoadDefaultConfig(ctx)
@@ -56,7 +73,7 @@ func (s *LambdaServerCmd) Run(ctx context.Context, globals *Globals) error {
 	}
 
 	var oteloptions []otelconnect.Option
-	oteloptions = append(oteloptions, otelconnect.WithTracerProvider(tp))
+	oteloptions = append(oteloptions, otelconnect.WithTracerProvider(tp), otelconnect.WithMeterProvider(mp))
 	if s.TrustRemote {
 		oteloptions = append(oteloptions, otelconnect.WithTrustRemote())
 	}
@@ -65,8 +82,6 @@ func (s *LambdaServerCmd) Run(ctx context.Context, globals *Globals) error {
 	if err != nil {
 		return fmt.Errorf("failed to create otel interceptor: %w", err)
 	}
-	opts = append(opts, connect.WithInterceptors(otelInterceptor))
-
 	oidcValidator, err := ciauth.NewOIDCValidator(ctx, ciauth.DefaultOIDCProviders)
 	if err != nil {
 		return fmt.Errorf("failed to create OIDC validator: %w", err)
@@ -79,23 +94,64 @@ func (s *LambdaServerCmd) Run(ctx context.Context, globals *Globals) error {
 		GetDynamoDBClient: ddbClientFunc,
 	})
 
+	auditor, err := s.newRecorder(ddbClientFunc, s.CacheIndexTable)
+	if err != nil {
+		return fmt.Errorf("failed to create audit log: %w", err)
+	}
+
+	dispatcher, err := s.newDispatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create events publisher: %w", err)
+	}
+
+	publisher := publisherOf(dispatcher)
+
 	csh := server.NewCacheServiceHandler(ctx, server.CacheConfig{
-		CacheBucket: s.CacheBucket,
-		GetS3Client: s3ClientFunc,
+		CacheBucket:     s.CacheBucket,
+		GetS3Client:     s3ClientFunc,
+		Audit:           auditor,
+		Events:          publisher,
+		DefaultBranch:   s.ActionsDefaultBranch,
+		BucketRetention: time.Duration(s.BucketRetentionDays) * 24 * time.Hour,
 	}, store)
+	opts = append(opts, connect.WithInterceptors(otelInterceptor, s.newRateLimitInterceptor(publisher)))
+
 	mux := http.NewServeMux()
 	path, handler := cachev1connect.NewCacheServiceHandler(csh, opts...)
 
 	mux.Handle(path, authMiddleware(handler))
 	log.Info().Str("path", path).Msg("serving")
 
+	// uploads are spooled to the ephemeral storage of the function so they are limited to what fits in a payload
+	uploadLimit := func(next http.Handler) http.Handler {
+		return http.MaxBytesHandler(next, s.MaxUploadSize)
+	}
+	uploadAuth := func(next http.Handler) http.Handler {
+		return authMiddleware(uploadLimit(next))
+	}
+
+	server.NewActionsCacheHandler(csh).Mount(mux, uploadAuth, uploadLimit)
+	log.Info().Str("path", server.ActionsCachePath).Str("twirp", server.ActionsCacheTwirpPath).Msg("serving")
+
+	server.NewBazelCacheHandler(csh).Mount(mux, uploadAuth)
+	log.Info().Str("path", server.BazelActionCachePath).Str("cas", server.BazelCASPath).Msg("serving")
+
+	server.NewGradleCacheHandler(csh).Mount(mux, uploadAuth)
+	log.Info().Str("path", server.GradleCachePath).Msg("serving")
+
+	server.NewTurboCacheHandler(csh).Mount(mux, uploadAuth)
+	log.Info().Str("path", server.TurboArtifactsPath).Msg("serving")
+
+	server.NewOCIRegistryHandler(csh).Mount(mux, uploadAuth)
+	log.Info().Str("path", server.OCIRegistryPath).Msg("serving")
+
 	flds := lmw.FieldMap{"version": "dev"}
 
 	ch := lmw.New(
 		raw.New(raw.Fields(flds)),
 		zlog.New(zlog.Fields(flds)),
 	).Then(lambdaextras.GenericHandler(httpadapter.NewV2(
-		otelhttp.NewHandler(mux, "server", otelhttp.WithTracerProvider(tp), otelhttp.WithPublicEndpoint()),
+		otelhttp.NewHandler(metrics.FlushHandler(mp, flushEvents(dispatcher, mux)), "server", otelhttp.WithTracerProvider(tp), otelhttp.WithPublicEndpoint()),
 	).ProxyWithContext))
 
 	lambda.Start(ch)
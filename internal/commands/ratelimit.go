@@ -0,0 +1,28 @@
+package commands
+
+import (
+	"github.com/wolfeidau/zipstash/internal/events"
+	"github.com/wolfeidau/zipstash/internal/server"
+)
+
+// RateLimitFlags configures the limits of the requests of each tenant and OIDC subject to the connect services.
+type RateLimitFlags struct {
+	TenantRateLimit            server.RateLimit            `help:"requests per second and burst of each tenant to the connect services as rate/burst, zero disables the limit" env:"TENANT_RATE_LIMIT" default:"0"`
+	SubjectRateLimit           server.RateLimit            `help:"requests per second and burst of each OIDC subject to the connect services as rate/burst, zero disables the limit" env:"SUBJECT_RATE_LIMIT" default:"0"`
+	TenantProcedureRateLimits  map[string]server.RateLimit `help:"limits of each tenant for procedures such as CreateEntry=5/10, these are counted separately to the other procedures" env:"TENANT_PROCEDURE_RATE_LIMITS"`
+	SubjectProcedureRateLimits map[string]server.RateLimit `help:"limits of each OIDC subject for procedures such as CreateEntry=1/5, these are counted separately to the other procedures" env:"SUBJECT_PROCEDURE_RATE_LIMITS"`
+	TenantConcurrencyLimit     int                         `help:"most requests of each tenant to the connect services in flight at once, zero disables the limit" env:"TENANT_CONCURRENCY_LIMIT" default:"0"`
+	SubjectConcurrencyLimit    int                         `help:"most requests of each OIDC subject to the connect services in flight at once, zero disables the limit" env:"SUBJECT_CONCURRENCY_LIMIT" default:"0"`
+}
+
+// newRateLimitInterceptor returns an interceptor enforcing the configured limits, rejected tenants are published
+// to the publisher.
+func (r RateLimitFlags) newRateLimitInterceptor(publisher events.Publisher) *server.RateLimitInterceptor {
+	return server.NewRateLimitInterceptor(server.RateLimitConfig{
+		Tenant:             server.RateLimits{Default: r.TenantRateLimit, Procedures: r.TenantProcedureRateLimits},
+		Subject:            server.RateLimits{Default: r.SubjectRateLimit, Procedures: r.SubjectProcedureRateLimits},
+		TenantConcurrency:  r.TenantConcurrencyLimit,
+		SubjectConcurrency: r.SubjectConcurrencyLimit,
+		Events:             publisher,
+	})
+}
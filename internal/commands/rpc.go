@@ -2,9 +2,14 @@ package commands
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"connectrpc.com/connect"
 	"connectrpc.com/otelconnect"
@@ -15,33 +20,70 @@ import (
 	"github.com/rs/zerolog/log"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/wolfeidau/zipstash/api/gen/proto/go/cache/v1/cachev1connect"
 	"github.com/wolfeidau/zipstash/api/gen/proto/go/provision/v1/provisionv1connect"
 	"github.com/wolfeidau/zipstash/internal/ciauth"
 	"github.com/wolfeidau/zipstash/internal/index"
 	"github.com/wolfeidau/zipstash/internal/server"
+	"github.com/wolfeidau/zipstash/pkg/metrics"
 	"github.com/wolfeidau/zipstash/pkg/trace"
 )
 
 type RPCServerCmd struct {
-	Listen                string `help:"listen address" default:"localhost:8080"`
-	CacheBucket           string `help:"bucket to store cache" env:"CACHE_BUCKET"`
-	CacheIndexTable       string `help:"table to store cache index" env:"CACHE_INDEX_TABLE"`
-	S3Endpoint            string `help:"s3 endpoint, used in local mode" env:"S3_ENDPOINT" default:"http://minio.zipstash.orb.local:9000"`
-	DynamoEndpoint        string `help:"s3 endpoint, used in local mode" env:"DYNAMO_ENDPOINT" default:"http://dynamodb-local.zipstash.orb.local:8000"`
-	CreateCacheIndexTable bool   `help:"create cache index table if it does not exist" env:"CREATE_CACHE_INDEX_TABLE" default:"false"`
-	Local                 bool   `help:"run in local mode"`
-	TrustRemote           bool   `help:"trust remote spans"`
+	Listen                string        `help:"listen address of the cache apis used by CI jobs" env:"LISTEN" default:"localhost:8080"`
+	AdminListen           string        `help:"listen address of the provision service and metrics, these are served on the listen address when empty" env:"ADMIN_LISTEN"`
+	CacheBucket           string        `help:"bucket to store cache" env:"CACHE_BUCKET"`
+	CacheIndexTable       string        `help:"table to store cache index" env:"CACHE_INDEX_TABLE"`
+	S3Endpoint            string        `help:"s3 endpoint, used in local mode" env:"S3_ENDPOINT" default:"http://minio.zipstash.orb.local:9000"`
+	DynamoEndpoint        string        `help:"s3 endpoint, used in local mode" env:"DYNAMO_ENDPOINT" default:"http://dynamodb-local.zipstash.orb.local:8000"`
+	CreateCacheIndexTable bool          `help:"create cache index table if it does not exist" env:"CREATE_CACHE_INDEX_TABLE" default:"false"`
+	Local                 bool          `help:"run in local mode"`
+	TrustRemote           bool          `help:"trust remote spans"`
+	ShutdownTimeout       time.Duration `help:"how long to wait for requests in flight to complete on shutdown" env:"SHUTDOWN_TIMEOUT" default:"30s"`
+	DrainDelay            time.Duration `help:"how long to keep serving while reporting not ready on shutdown, so load balancers stop sending requests before the listeners close" env:"DRAIN_DELAY" default:"5s"`
+	TLSCert               string        `help:"certificate file, the server uses TLS when this and the key are set" env:"TLS_CERT" type:"existingfile"`
+	TLSKey                string        `help:"private key file of the certificate" env:"TLS_KEY" type:"existingfile"`
+	TLSClientCA           string        `help:"CA file used to verify client certificates, clients must present a certificate when this is set" env:"TLS_CLIENT_CA" type:"existingfile"`
+	TLSReloadInterval     time.Duration `help:"how often the certificate files are checked for changes" env:"TLS_RELOAD_INTERVAL" default:"1m"`
+	ReadHeaderTimeout     time.Duration `help:"how long to wait for the headers of a request" env:"READ_HEADER_TIMEOUT" default:"10s"`
+	ReadTimeout           time.Duration `help:"how long to wait for a request including the body, this must allow for the largest upload" env:"READ_TIMEOUT" default:"30m"`
+	WriteTimeout          time.Duration `help:"how long to wait for a response to be written" env:"WRITE_TIMEOUT" default:"30m"`
+	IdleTimeout           time.Duration `help:"how long to keep idle connections open" env:"IDLE_TIMEOUT" default:"2m"`
+	MaxMessageSize        int64         `help:"largest request accepted by the connect services in bytes" env:"MAX_MESSAGE_SIZE" default:"33554432"`
+	MaxUploadSize         int64         `help:"largest request body accepted by the bazel, gradle, turborepo, actions cache and registry apis in bytes" env:"MAX_UPLOAD_SIZE" default:"5368709120"`
+	ActionsDefaultBranch  string        `help:"branch whose actions cache entries can be restored by every branch" env:"ACTIONS_DEFAULT_BRANCH" default:"main"`
+	BucketRetentionDays   int           `help:"days the lifecycle rule of the bucket keeps objects, registry tags expire within this so they don't refer to expired blobs, zero when objects aren't expired" env:"BUCKET_RETENTION_DAYS"`
+
+	AuditFlags     `embed:""`
+	EventsFlags    `embed:""`
+	RateLimitFlags `embed:""`
 }
 
 func (s *RPCServerCmd) Run(ctx context.Context, globals *Globals) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	tp, err := trace.NewProvider(ctx, "github.com/wolfeidau/zipstash", globals.Version)
 	if err != nil {
 		log.Fatal().Msgf("failed to create trace provider: %v", err)
 	}
 	defer func() {
-		_ = tp.Shutdown(ctx)
+		// the signal context is already cancelled on shutdown so remaining spans and metrics are flushed with their own deadline
+		flushCtx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+		defer cancel()
+		_ = tp.Shutdown(flushCtx)
+	}()
+
+	mp, metricsHandler, err := metrics.NewProvider(ctx, "github.com/wolfeidau/zipstash", globals.Version)
+	if err != nil {
+		return fmt.Errorf("failed to create meter provider: %w", err)
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+		defer cancel()
+		_ = mp.Shutdown(flushCtx)
 	}()
 
 	var s3ClientFunc server.S3ClientFunc
@@ -79,8 +121,26 @@ func (s *RPCServerCmd) Run(ctx context.Context, globals *Globals) error {
 		GetDynamoDBClient: ddbClientFunc,
 	})
 
+	auditor, err := s.newRecorder(ddbClientFunc, s.CacheIndexTable)
+	if err != nil {
+		return fmt.Errorf("failed to create audit log: %w", err)
+	}
+	defer func() {
+		_ = auditor.Close()
+	}()
+
+	dispatcher, err := s.newDispatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create events publisher: %w", err)
+	}
+	defer func() {
+		_ = dispatcher.Close()
+	}()
+
+	publisher := publisherOf(dispatcher)
+
 	var oteloptions []otelconnect.Option
-	oteloptions = append(oteloptions, otelconnect.WithTracerProvider(tp))
+	oteloptions = append(oteloptions, otelconnect.WithTracerProvider(tp), otelconnect.WithMeterProvider(mp))
 	if s.TrustRemote {
 		oteloptions = append(oteloptions, otelconnect.WithTrustRemote())
 	}
@@ -89,29 +149,259 @@ func (s *RPCServerCmd) Run(ctx context.Context, globals *Globals) error {
 	if err != nil {
 		return fmt.Errorf("failed to create otel interceptor: %w", err)
 	}
-	interceptors = append(interceptors, otelInterceptor)
+	interceptors = append(interceptors, otelInterceptor, s.newRateLimitInterceptor(publisher))
 
 	csh := server.NewCacheServiceHandler(ctx, server.CacheConfig{
-		CacheBucket: s.CacheBucket,
-		GetS3Client: s3ClientFunc,
+		CacheBucket:     s.CacheBucket,
+		GetS3Client:     s3ClientFunc,
+		Audit:           auditor,
+		Events:          publisher,
+		DefaultBranch:   s.ActionsDefaultBranch,
+		BucketRetention: time.Duration(s.BucketRetentionDays) * 24 * time.Hour,
 	}, store)
 
-	psh := server.NewProvisionServiceHandler(store)
+	psh := server.NewProvisionServiceHandler(store, auditor)
 
-	mux := http.NewServeMux()
-	path, handler := cachev1connect.NewCacheServiceHandler(csh, connect.WithInterceptors(interceptors...))
+	connectOptions := []connect.HandlerOption{
+		connect.WithInterceptors(interceptors...),
+		connect.WithReadMaxBytes(int(s.MaxMessageSize)),
+	}
 
-	log.Info().Str("path", path).Str("add", s.Listen).Msg("serving")
-	mux.Handle(path, handler)
+	adminListen := s.Listen
+	if s.AdminListen != "" {
+		adminListen = s.AdminListen
+	}
+
+	// the provision service and metrics are served on the admin mux, this is the mux of the cache apis unless the
+	// admin listener is separate
+	root := http.NewServeMux()
+	admin := root
 
-	path, handler = provisionv1connect.NewProvisionServiceHandler(psh, connect.WithInterceptors(interceptors...))
+	cacheMux := http.NewServeMux()
+	provisionMux := cacheMux
+
+	if s.AdminListen != "" {
+		admin = http.NewServeMux()
+		provisionMux = http.NewServeMux()
+	}
+
+	path, handler := cachev1connect.NewCacheServiceHandler(csh, connectOptions...)
 
 	log.Info().Str("path", path).Str("add", s.Listen).Msg("serving")
-	mux.Handle(path, handler)
+	cacheMux.Handle(path, handler)
 
-	return http.ListenAndServe(
-		s.Listen,
-		// Use h2c so we can serve HTTP/2 without TLS.
-		h2c.NewHandler(authMiddleware(mux), &http2.Server{}),
+	path, handler = provisionv1connect.NewProvisionServiceHandler(psh, connectOptions...)
+
+	log.Info().Str("path", path).Str("add", adminListen).Msg("serving")
+	provisionMux.Handle(path, handler)
+
+	root.Handle("/", authMiddleware(http.MaxBytesHandler(cacheMux, s.MaxMessageSize)))
+
+	if s.AdminListen != "" {
+		admin.Handle("/", authMiddleware(http.MaxBytesHandler(provisionMux, s.MaxMessageSize)))
+	}
+
+	// uploads are limited within the auth middleware so requests are authenticated before reading the body
+	uploadLimit := func(next http.Handler) http.Handler {
+		return http.MaxBytesHandler(next, s.MaxUploadSize)
+	}
+	uploadAuth := func(next http.Handler) http.Handler {
+		return authMiddleware(uploadLimit(next))
+	}
+
+	server.NewActionsCacheHandler(csh).Mount(root, uploadAuth, uploadLimit)
+
+	log.Info().Str("path", server.ActionsCachePath).Str("add", s.Listen).Msg("serving")
+	log.Info().Str("path", server.ActionsCacheTwirpPath).Str("add", s.Listen).Msg("serving")
+
+	server.NewBazelCacheHandler(csh).Mount(root, uploadAuth)
+
+	log.Info().Str("path", server.BazelActionCachePath).Str("add", s.Listen).Msg("serving")
+	log.Info().Str("path", server.BazelCASPath).Str("add", s.Listen).Msg("serving")
+
+	server.NewGradleCacheHandler(csh).Mount(root, uploadAuth)
+	server.NewTurboCacheHandler(csh).Mount(root, uploadAuth)
+
+	log.Info().Str("path", server.GradleCachePath).Str("add", s.Listen).Msg("serving")
+	log.Info().Str("path", server.TurboArtifactsPath).Str("add", s.Listen).Msg("serving")
+
+	server.NewOCIRegistryHandler(csh).Mount(root, uploadAuth)
+
+	log.Info().Str("path", server.OCIRegistryPath).Str("add", s.Listen).Msg("serving")
+
+	admin.Handle("/metrics", metricsHandler)
+
+	log.Info().Str("path", "/metrics").Str("add", adminListen).Msg("serving")
+
+	health := server.NewHealthHandler(globals.Version, rpcServerFeatures,
+		server.ReadinessCheck{Name: "index", Check: store.Ping},
+		server.ReadinessCheck{Name: "bucket", Check: csh.Ping},
+		server.ReadinessCheck{Name: "jwks", Check: oidcValidator.Ready},
 	)
+	health.Mount(root)
+
+	log.Info().Str("path", server.HealthPath).Str("add", s.Listen).Msg("serving")
+	log.Info().Str("path", server.ReadinessPath).Str("add", s.Listen).Msg("serving")
+	log.Info().Str("path", server.VersionPath).Str("add", s.Listen).Msg("serving")
+
+	if s.AdminListen != "" {
+		health.Mount(admin)
+	}
+
+	// prime the JWK sets so the server is ready sooner
+	go func() {
+		if err := oidcValidator.Ready(ctx); err != nil {
+			log.Warn().Err(err).Msg("failed to prime JWK sets")
+		}
+	}()
+
+	tlsConfig, err := s.newTLSConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	srv, err := s.newHTTPServer(s.Listen, health.Track(root), tlsConfig)
+	if err != nil {
+		return err
+	}
+
+	servers := []*http.Server{srv}
+
+	if s.AdminListen != "" {
+		adminSrv, err := s.newHTTPServer(s.AdminListen, health.Track(admin), tlsConfig)
+		if err != nil {
+			return err
+		}
+
+		servers = append(servers, adminSrv)
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+
+	for _, srv := range servers {
+		g.Go(func() error {
+			return serveHTTP(srv)
+		})
+	}
+
+	// shutdown when signalled or when a listener fails
+	g.Go(func() error {
+		<-gctx.Done()
+
+		log.Info().Dur("delay", s.DrainDelay).Dur("timeout", s.ShutdownTimeout).Msg("shutting down")
+
+		health.Drain()
+
+		// requests keep being accepted until the probes of load balancers see the server isn't ready
+		time.Sleep(s.DrainDelay)
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+		defer cancel()
+
+		for _, srv := range servers {
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				log.Warn().Err(err).Str("add", srv.Addr).Msg("failed to shutdown server")
+			}
+		}
+
+		// http/2 connections are hijacked by h2c so aren't waited for by shutdown
+		if err := health.Wait(shutdownCtx); err != nil {
+			log.Warn().Err(err).Msg("failed to drain requests in flight")
+		}
+
+		return nil
+	})
+
+	return g.Wait()
+}
+
+// newTLSConfig returns the tls config of the listeners, this is nil when TLS isn't configured. The certificate is
+// reloaded when its files change until the context is done.
+func (s *RPCServerCmd) newTLSConfig(ctx context.Context) (*tls.Config, error) {
+	if s.TLSCert == "" && s.TLSKey == "" {
+		if s.TLSClientCA != "" {
+			return nil, errors.New("tls client CA requires a tls certificate and key")
+		}
+
+		return nil, nil
+	}
+
+	if s.TLSCert == "" || s.TLSKey == "" {
+		return nil, errors.New("tls requires both a certificate and key")
+	}
+
+	reloader, err := server.NewCertReloader(s.TLSCert, s.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tls certificate: %w", err)
+	}
+
+	go reloader.Watch(ctx, s.TLSReloadInterval)
+
+	tlsConfig, err := server.NewTLSConfig(reloader, s.TLSClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tls config: %w", err)
+	}
+
+	return tlsConfig, nil
+}
+
+// newHTTPServer returns a server for the handler which serves HTTP/2 over TLS when the tls config is set, and h2c
+// otherwise.
+func (s *RPCServerCmd) newHTTPServer(addr string, handler http.Handler, tlsConfig *tls.Config) (*http.Server, error) {
+	h2s := &http2.Server{
+		IdleTimeout: s.IdleTimeout,
+	}
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: s.ReadHeaderTimeout,
+		ReadTimeout:       s.ReadTimeout,
+		WriteTimeout:      s.WriteTimeout,
+		IdleTimeout:       s.IdleTimeout,
+	}
+
+	if tlsConfig != nil {
+		// configuring http2 adds h2 to the protocols of the config so each server needs its own
+		srv.TLSConfig = tlsConfig.Clone()
+	} else {
+		// Use h2c so we can serve HTTP/2 without TLS.
+		srv.Handler = h2c.NewHandler(handler, h2s)
+	}
+
+	// configuring the server sends GOAWAY to http/2 connections on shutdown
+	err := http2.ConfigureServer(srv, h2s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure http2: %w", err)
+	}
+
+	return srv, nil
+}
+
+func serveHTTP(srv *http.Server) error {
+	var err error
+	if srv.TLSConfig != nil {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve %s: %w", srv.Addr, err)
+	}
+
+	return nil
+}
+
+// rpcServerFeatures are the apis served by the rpc server, these are reported by the version endpoint.
+var rpcServerFeatures = []string{
+	"cache",
+	"provision",
+	"objects",
+	"actions-cache",
+	"bazel",
+	"gradle",
+	"turborepo",
+	"oci-registry",
+	"metrics",
 }
@@ -0,0 +1,142 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+)
+
+// queueSize is the number of events waiting to be delivered before further events are dropped.
+const queueSize = 1000
+
+// Config holds the subscribers of each tenant, tenants are keyed by provider and owner such as
+// github_actions#wolfeidau.
+type Config struct {
+	Tenants map[string][]SubscriberConfig `json:"tenants"`
+}
+
+// SubscriberConfig is a webhook or file which receives the events of a tenant.
+type SubscriberConfig struct {
+	// Events selects the event types delivered, all types are delivered when empty.
+	Events  []string       `json:"events,omitempty"`
+	Webhook *WebhookConfig `json:"webhook,omitempty"`
+	// File is a file the events are appended to as JSON lines.
+	File string `json:"file,omitempty"`
+}
+
+// LoadConfig reads the subscribers from a JSON file.
+func LoadConfig(name string) (Config, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to open events config: %w", err)
+	}
+	defer f.Close()
+
+	return ParseConfig(f)
+}
+
+// ParseConfig reads the subscribers from JSON, unknown fields are rejected so typos aren't silently ignored.
+func ParseConfig(r io.Reader) (Config, error) {
+	var cfg Config
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&cfg); err != nil {
+		return Config{}, fmt.Errorf("failed to parse events config: %w", err)
+	}
+
+	return cfg, cfg.Validate()
+}
+
+func (c Config) Validate() error {
+	for tenant, subs := range c.Tenants {
+		for i, sub := range subs {
+			if err := sub.validate(); err != nil {
+				return fmt.Errorf("invalid subscriber %d of tenant %s: %w", i, tenant, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s SubscriberConfig) validate() error {
+	for _, typ := range s.Events {
+		if !slices.Contains(Types, typ) {
+			return fmt.Errorf("unknown event type: `%s`", typ)
+		}
+	}
+
+	switch {
+	case s.Webhook != nil && s.File != "":
+		return fmt.Errorf("only one of webhook or file can be set")
+	case s.Webhook != nil:
+		u, err := url.Parse(s.Webhook.URL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("invalid webhook url: `%s`", s.Webhook.URL)
+		}
+
+		if s.Webhook.SecretEnv == "" {
+			return fmt.Errorf("webhook secret_env is required")
+		}
+	case s.File == "":
+		return fmt.Errorf("one of webhook or file is required")
+	}
+
+	return nil
+}
+
+// NewDispatcher creates a dispatcher for the subscribers, events are delivered by the workers in the background
+// or before Publish returns when there are no workers. Without workers webhooks are only tried once with a short
+// timeout as the delivery delays the request which published the event.
+func NewDispatcher(cfg Config, workers int) (*Dispatcher, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	d := &Dispatcher{tenants: make(map[string][]subscription, len(cfg.Tenants))}
+
+	// subscribers writing to the same file share it so concurrent lines aren't interleaved
+	files := make(map[string]*FileSink)
+
+	for tenant, subs := range cfg.Tenants {
+		for _, sub := range subs {
+			var publisher Publisher
+
+			if sub.Webhook != nil {
+				webhook, err := NewWebhookSink(*sub.Webhook)
+				if err != nil {
+					_ = d.Close()
+					return nil, err
+				}
+				if workers <= 0 {
+					webhook.deliverInline()
+				}
+				publisher = webhook
+			} else {
+				file, ok := files[sub.File]
+				if !ok {
+					var err error
+					file, err = OpenFileSink(sub.File)
+					if err != nil {
+						_ = d.Close()
+						return nil, err
+					}
+					files[sub.File] = file
+					d.closers = append(d.closers, file.Close)
+				}
+				publisher = file
+			}
+
+			d.tenants[tenant] = append(d.tenants[tenant], subscription{types: sub.Events, publisher: publisher})
+		}
+	}
+
+	d.start(workers)
+
+	return d, nil
+}
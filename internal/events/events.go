@@ -0,0 +1,226 @@
+// Package events notifies subscribers of changes to the cache, such as entries being created or restored, so
+// they can trigger other work like warming a runner image when the cache of the main branch is refreshed.
+package events
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// types of the events published.
+const (
+	EntryCreated  = "entry.created"
+	EntryRestored = "entry.restored"
+	// EntryEvicted is published from the stream of the cache index table when the ttl of an entry expires.
+	EntryEvicted = "entry.evicted"
+	// TenantQuotaExceeded is published when requests of a tenant are rejected by the rate or concurrency limits.
+	TenantQuotaExceeded = "tenant.quota_exceeded"
+)
+
+// Types are the event types subscribers can select.
+var Types = []string{EntryCreated, EntryRestored, EntryEvicted, TenantQuotaExceeded}
+
+// Event is a change to the cache of a tenant.
+type Event struct {
+	ID       string    `json:"id"`
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	Tenant   string    `json:"tenant"`
+	Key      string    `json:"key,omitempty"`
+	CacheID  string    `json:"cache_id,omitempty"`
+	Name     string    `json:"name,omitempty"`
+	Branch   string    `json:"branch,omitempty"`
+	Sha256   string    `json:"sha256,omitempty"`
+	FileSize int64     `json:"file_size,omitempty"`
+	Fallback bool      `json:"fallback,omitempty"`
+	Subject  string    `json:"subject,omitempty"`
+	Issuer   string    `json:"issuer,omitempty"`
+	// Procedure, Scope and Limit describe the request rejected by the limits of the tenant or subject.
+	Procedure string `json:"procedure,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	Limit     string `json:"limit,omitempty"`
+	// Claims are the verified claims of the OIDC token of the request, such as whether the ref is protected.
+	Claims map[string]any `json:"claims,omitempty"`
+}
+
+// Publisher delivers events, implementations decide which subscribers receive them.
+type Publisher interface {
+	Publish(ctx context.Context, ev Event) error
+}
+
+// subscription is a publisher which receives the events of a tenant of the selected types.
+type subscription struct {
+	types     []string
+	publisher Publisher
+}
+
+func (s subscription) wants(ev Event) bool {
+	return len(s.types) == 0 || slices.Contains(s.types, ev.Type)
+}
+
+// delivery is an event queued for a subscriber.
+type delivery struct {
+	ev        Event
+	publisher Publisher
+}
+
+// Dispatcher publishes events to the subscribers of their tenant. With workers events are delivered in the
+// background so slow webhooks don't delay requests, without workers they are delivered before Publish returns.
+type Dispatcher struct {
+	tenants map[string][]subscription
+	queue   chan delivery
+	wg      sync.WaitGroup
+	mu      sync.RWMutex
+	closed  bool
+	closers []func() error
+
+	// pending counts the queued deliveries, idle is closed when there are none so Flush can wait for them
+	pendingMu sync.Mutex
+	pending   int
+	idle      chan struct{}
+}
+
+// Publish assigns the id and time of the event and delivers it to the subscribers of its tenant. Delivery
+// failures are logged rather than failing the request which caused the event.
+func (d *Dispatcher) Publish(ctx context.Context, ev Event) error {
+	if d == nil {
+		return nil
+	}
+
+	subs := d.tenants[ev.Tenant]
+	if len(subs) == 0 {
+		return nil
+	}
+
+	if ev.ID == "" {
+		ev.ID = uuid.New().String()
+	}
+
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return nil
+	}
+
+	for _, sub := range subs {
+		if !sub.wants(ev) {
+			continue
+		}
+
+		if d.queue == nil {
+			deliver(ctx, delivery{ev: ev, publisher: sub.publisher})
+			continue
+		}
+
+		d.addPending(1)
+
+		select {
+		case d.queue <- delivery{ev: ev, publisher: sub.publisher}:
+		default:
+			d.addPending(-1)
+			log.Warn().Str("type", ev.Type).Str("tenant", ev.Tenant).Str("id", ev.ID).Msg("event queue is full, dropping event")
+		}
+	}
+
+	return nil
+}
+
+// Flush waits for the queued events to be delivered without closing the dispatcher, this is used in lambda as
+// the function is frozen once an invocation returns.
+func (d *Dispatcher) Flush(ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+
+	d.pendingMu.Lock()
+	if d.pending == 0 {
+		d.pendingMu.Unlock()
+		return nil
+	}
+	idle := d.idle
+	d.pendingMu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Dispatcher) addPending(delta int) {
+	d.pendingMu.Lock()
+	defer d.pendingMu.Unlock()
+
+	if d.pending == 0 {
+		d.idle = make(chan struct{})
+	}
+
+	d.pending += delta
+
+	if d.pending == 0 {
+		close(d.idle)
+	}
+}
+
+// Close waits for queued events to be delivered and closes the subscribers holding open files.
+func (d *Dispatcher) Close() error {
+	if d == nil {
+		return nil
+	}
+
+	d.mu.Lock()
+	if !d.closed && d.queue != nil {
+		close(d.queue)
+	}
+	d.closed = true
+	d.mu.Unlock()
+
+	d.wg.Wait()
+
+	var errs []error
+	for _, closer := range d.closers {
+		errs = append(errs, closer())
+	}
+
+	return errors.Join(errs...)
+}
+
+func (d *Dispatcher) start(workers int) {
+	if workers <= 0 {
+		return
+	}
+
+	d.queue = make(chan delivery, queueSize)
+
+	for range workers {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+
+			for del := range d.queue {
+				// queued events outlive the request which published them
+				deliver(context.Background(), del)
+				d.addPending(-1)
+			}
+		}()
+	}
+}
+
+func deliver(ctx context.Context, del delivery) {
+	if err := del.publisher.Publish(ctx, del.ev); err != nil {
+		log.Error().Err(err).Str("type", del.ev.Type).Str("tenant", del.ev.Tenant).Str("id", del.ev.ID).
+			Msg("failed to publish event")
+	}
+}
@@ -0,0 +1,195 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type memoryPublisher struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (m *memoryPublisher) Publish(_ context.Context, ev Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.events = append(m.events, ev)
+	return nil
+}
+
+type publisherFunc func(ctx context.Context, ev Event) error
+
+func (f publisherFunc) Publish(ctx context.Context, ev Event) error { return f(ctx, ev) }
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{name: "webhook", config: `{"tenants":{"github_actions#wolfeidau":[{"events":["entry.created"],"webhook":{"url":"https://example.com/hook","secret_env":"SECRET"}}]}}`},
+		{name: "file", config: `{"tenants":{"github_actions#wolfeidau":[{"file":"events.log"}]}}`},
+		{name: "quota event", config: `{"tenants":{"github_actions#wolfeidau":[{"events":["tenant.quota_exceeded"],"file":"events.log"}]}}`},
+		{name: "unknown event", config: `{"tenants":{"github_actions#wolfeidau":[{"events":["entry.deleted"],"file":"events.log"}]}}`, wantErr: true},
+		{name: "no sink", config: `{"tenants":{"github_actions#wolfeidau":[{"events":["entry.created"]}]}}`, wantErr: true},
+		{name: "both sinks", config: `{"tenants":{"github_actions#wolfeidau":[{"file":"events.log","webhook":{"url":"https://example.com/hook","secret_env":"SECRET"}}]}}`, wantErr: true},
+		{name: "invalid url", config: `{"tenants":{"github_actions#wolfeidau":[{"webhook":{"url":"example.com/hook","secret_env":"SECRET"}}]}}`, wantErr: true},
+		{name: "no secret", config: `{"tenants":{"github_actions#wolfeidau":[{"webhook":{"url":"https://example.com/hook"}}]}}`, wantErr: true},
+		{name: "unknown field", config: `{"tenants":{"github_actions#wolfeidau":[{"flie":"events.log"}]}}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			_, err := ParseConfig(strings.NewReader(tt.config))
+			if tt.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+		})
+	}
+}
+
+func TestDispatcherPublish(t *testing.T) {
+	assert := require.New(t)
+
+	all := &memoryPublisher{}
+	created := &memoryPublisher{}
+
+	d := &Dispatcher{tenants: map[string][]subscription{
+		"github_actions#wolfeidau": {
+			{publisher: all},
+			{types: []string{EntryCreated}, publisher: created},
+		},
+	}}
+
+	ctx := context.Background()
+
+	assert.NoError(d.Publish(ctx, Event{Type: EntryCreated, Tenant: "github_actions#wolfeidau"}))
+	assert.NoError(d.Publish(ctx, Event{Type: EntryRestored, Tenant: "github_actions#wolfeidau"}))
+	assert.NoError(d.Publish(ctx, Event{Type: EntryCreated, Tenant: "gitlab#wolfeidau"}))
+
+	// without workers events are delivered before publish returns
+	assert.Len(all.events, 2)
+	assert.Len(created.events, 1)
+	assert.NotEmpty(created.events[0].ID)
+	assert.False(created.events[0].Time.IsZero())
+}
+
+func TestDispatcherWorkers(t *testing.T) {
+	assert := require.New(t)
+
+	name := filepath.Join(t.TempDir(), "events.log")
+
+	d, err := NewDispatcher(Config{Tenants: map[string][]SubscriberConfig{
+		"github_actions#wolfeidau": {{File: name}},
+		"buildkite#wolfeidau":      {{File: name, Events: []string{EntryRestored}}},
+	}}, 2)
+	assert.NoError(err)
+
+	ctx := context.Background()
+
+	for range 10 {
+		assert.NoError(d.Publish(ctx, Event{Type: EntryCreated, Tenant: "github_actions#wolfeidau", Key: "go-mod"}))
+		assert.NoError(d.Publish(ctx, Event{Type: EntryCreated, Tenant: "buildkite#wolfeidau", Key: "go-mod"}))
+	}
+
+	// close waits for the queued events
+	assert.NoError(d.Close())
+	assert.NoError(d.Publish(ctx, Event{Type: EntryCreated, Tenant: "github_actions#wolfeidau"}))
+
+	f, err := os.Open(name)
+	assert.NoError(err)
+	defer f.Close()
+
+	var lines int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var ev Event
+		assert.NoError(json.Unmarshal(scanner.Bytes(), &ev))
+		assert.Equal("github_actions#wolfeidau", ev.Tenant)
+		lines++
+	}
+	assert.Equal(10, lines)
+}
+
+func TestDispatcherInlineWebhook(t *testing.T) {
+	assert := require.New(t)
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	// the webhook is created by the dispatcher so the test secret and tracer are set up first
+	newTestWebhook(t, srv.URL)
+
+	d, err := NewDispatcher(Config{Tenants: map[string][]SubscriberConfig{
+		"github_actions#wolfeidau": {{Webhook: &WebhookConfig{URL: srv.URL, SecretEnv: "TEST_WEBHOOK_SECRET", MaxTries: 5}}},
+	}}, 0)
+	assert.NoError(err)
+
+	// without workers a failed delivery isn't retried as it delays the request publishing the event
+	assert.NoError(d.Publish(context.Background(), Event{Type: EntryCreated, Tenant: "github_actions#wolfeidau"}))
+	assert.Equal(int32(1), calls.Load())
+}
+
+func TestDispatcherFlush(t *testing.T) {
+	assert := require.New(t)
+
+	release := make(chan struct{})
+	publisher := &memoryPublisher{}
+
+	d := &Dispatcher{tenants: map[string][]subscription{
+		"github_actions#wolfeidau": {{publisher: publisherFunc(func(ctx context.Context, ev Event) error {
+			<-release
+			return publisher.Publish(ctx, ev)
+		})}},
+	}}
+	d.start(1)
+	defer d.Close()
+
+	ctx := context.Background()
+
+	// nothing is queued
+	assert.NoError(d.Flush(ctx))
+
+	for range 3 {
+		assert.NoError(d.Publish(ctx, Event{Type: EntryCreated, Tenant: "github_actions#wolfeidau"}))
+	}
+
+	// flush gives up when the context is done before the events are delivered
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.ErrorIs(d.Flush(cancelled), context.Canceled)
+
+	close(release)
+
+	assert.NoError(d.Flush(ctx))
+	assert.Len(publisher.events, 3)
+}
+
+func TestDispatcherNil(t *testing.T) {
+	assert := require.New(t)
+
+	var d *Dispatcher
+	assert.NoError(d.Publish(context.Background(), Event{Type: EntryCreated}))
+	assert.NoError(d.Flush(context.Background()))
+	assert.NoError(d.Close())
+}
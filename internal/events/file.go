@@ -0,0 +1,48 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// FileSink appends each event to a file as a line of JSON.
+type FileSink struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// OpenFileSink creates a sink appending to the file, the file is created if it doesn't exist.
+func OpenFileSink(name string) (*FileSink, error) {
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open events file: %w", err)
+	}
+
+	return &FileSink{f: f}, nil
+}
+
+func (s *FileSink) Publish(_ context.Context, ev Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.f.Write(data)
+	if err != nil {
+		return fmt.Errorf("failed to write event: %w", err)
+	}
+
+	return nil
+}
+
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
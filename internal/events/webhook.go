@@ -0,0 +1,169 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cenkalti/backoff/v5"
+
+	"github.com/wolfeidau/zipstash/pkg/ratelimit"
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+const (
+	// headers of webhook requests, the signature is the hex encoded HMAC-SHA256 of the timestamp, a period and the
+	// body so receivers can reject old requests being replayed.
+	EventHeader     = "X-Zipstash-Event"
+	DeliveryHeader  = "X-Zipstash-Delivery"
+	TimestampHeader = "X-Zipstash-Timestamp"
+	SignatureHeader = "X-Zipstash-Signature"
+
+	signaturePrefix = "sha256="
+
+	defaultWebhookMaxTries = 5
+	webhookTimeout         = 10 * time.Second
+	webhookMaxElapsedTime  = 2 * time.Minute
+	// inlineWebhookTimeout limits webhooks delivered before responding to the request which published the event.
+	inlineWebhookTimeout = 2 * time.Second
+
+	// maxWebhookRetryAfter caps how long a receiver can ask a delivery to wait.
+	maxWebhookRetryAfter = time.Minute
+)
+
+// WebhookConfig is an http endpoint which receives events.
+type WebhookConfig struct {
+	URL string `json:"url"`
+	// SecretEnv is the environment variable holding the secret the requests are signed with.
+	SecretEnv string `json:"secret_env"`
+	// MaxTries is the number of attempts to deliver each event, defaults to 5.
+	MaxTries uint `json:"max_tries,omitempty"`
+}
+
+// WebhookSink posts each event as JSON to a url, requests are signed with a secret and retried with backoff when
+// they fail or the receiver returns a server error.
+type WebhookSink struct {
+	client     *http.Client
+	url        string
+	secret     []byte
+	maxTries   uint
+	newBackOff func() backoff.BackOff
+}
+
+// NewWebhookSink creates a sink for the webhook, the secret is read from the environment.
+func NewWebhookSink(cfg WebhookConfig) (*WebhookSink, error) {
+	secret := os.Getenv(cfg.SecretEnv)
+	if secret == "" {
+		return nil, fmt.Errorf("webhook secret %s is not set", cfg.SecretEnv)
+	}
+
+	maxTries := cfg.MaxTries
+	if maxTries == 0 {
+		maxTries = defaultWebhookMaxTries
+	}
+
+	return &WebhookSink{
+		client:   &http.Client{Timeout: webhookTimeout},
+		url:      cfg.URL,
+		secret:   []byte(secret),
+		maxTries: maxTries,
+		newBackOff: func() backoff.BackOff {
+			return backoff.NewExponentialBackOff()
+		},
+	}, nil
+}
+
+// deliverInline limits the sink to a single short attempt for each event.
+func (s *WebhookSink) deliverInline() {
+	s.maxTries = 1
+	s.client.Timeout = inlineWebhookTimeout
+}
+
+func (s *WebhookSink) Publish(ctx context.Context, ev Event) error {
+	ctx, span := trace.Start(ctx, "WebhookSink.Publish")
+	defer span.End()
+
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	operation := func() (struct{}, error) {
+		return struct{}{}, s.post(ctx, ev, body)
+	}
+
+	_, err = backoff.Retry(ctx, operation,
+		backoff.WithBackOff(s.newBackOff()),
+		backoff.WithMaxTries(s.maxTries),
+		backoff.WithMaxElapsedTime(webhookMaxElapsedTime),
+	)
+	if err != nil {
+		span.RecordError(err)
+
+		return fmt.Errorf("failed to deliver webhook: %w", err)
+	}
+
+	return nil
+}
+
+func (s *WebhookSink) post(ctx context.Context, ev Event, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return backoff.Permanent(fmt.Errorf("failed to create request: %w", err))
+	}
+
+	// each attempt is signed with the time it is sent so receivers can reject stale requests
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, ev.Type)
+	req.Header.Set(DeliveryHeader, ev.ID)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(s.secret, timestamp, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if delay, ok := ratelimit.ResponseRetryAfter(resp); ok {
+		return &backoff.RetryAfterError{Duration: min(delay, maxWebhookRetryAfter)}
+	}
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("webhook returned: %s", resp.Status)
+	default:
+		return backoff.Permanent(fmt.Errorf("webhook returned: %s", resp.Status))
+	}
+}
+
+// Sign returns the signature header of a webhook request sent at the timestamp.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether the signature header of a webhook request is valid, receivers written in Go can use this
+// along with a check that the timestamp is recent.
+func Verify(secret []byte, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
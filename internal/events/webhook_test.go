@@ -0,0 +1,107 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cenkalti/backoff/v5"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+const testSecret = "s3cr3t"
+
+func newTestWebhook(t *testing.T, url string) *WebhookSink {
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	require.NoError(t, err)
+
+	t.Setenv("TEST_WEBHOOK_SECRET", testSecret)
+
+	sink, err := NewWebhookSink(WebhookConfig{URL: url, SecretEnv: "TEST_WEBHOOK_SECRET", MaxTries: 3})
+	require.NoError(t, err)
+
+	sink.newBackOff = func() backoff.BackOff { return &backoff.ZeroBackOff{} }
+
+	return sink
+}
+
+func TestWebhookSinkPublish(t *testing.T) {
+	assert := require.New(t)
+
+	var received Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(err)
+
+		assert.Equal(EntryCreated, r.Header.Get(EventHeader))
+		assert.Equal("1", r.Header.Get(DeliveryHeader))
+		assert.True(Verify([]byte(testSecret), r.Header.Get(TimestampHeader), body, r.Header.Get(SignatureHeader)))
+		assert.False(Verify([]byte("other"), r.Header.Get(TimestampHeader), body, r.Header.Get(SignatureHeader)))
+
+		assert.NoError(json.Unmarshal(body, &received))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	sink := newTestWebhook(t, srv.URL)
+
+	err := sink.Publish(context.Background(), Event{ID: "1", Type: EntryCreated, Tenant: "github_actions#wolfeidau", Branch: "main"})
+	assert.NoError(err)
+	assert.Equal("main", received.Branch)
+}
+
+func TestWebhookSinkRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		failures  int32
+		wantCalls int32
+		wantErr   bool
+	}{
+		{name: "server error", status: http.StatusBadGateway, failures: 2, wantCalls: 3},
+		{name: "too many requests", status: http.StatusTooManyRequests, failures: 1, wantCalls: 2},
+		{name: "gives up", status: http.StatusInternalServerError, failures: 5, wantCalls: 3, wantErr: true},
+		{name: "client error", status: http.StatusBadRequest, failures: 5, wantCalls: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if calls.Add(1) <= tt.failures {
+					w.WriteHeader(tt.status)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer srv.Close()
+
+			sink := newTestWebhook(t, srv.URL)
+
+			err := sink.Publish(context.Background(), Event{ID: "1", Type: EntryRestored})
+			if tt.wantErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+			assert.Equal(tt.wantCalls, calls.Load())
+		})
+	}
+}
+
+func TestNewWebhookSinkSecret(t *testing.T) {
+	assert := require.New(t)
+
+	t.Setenv("TEST_WEBHOOK_SECRET", "")
+
+	_, err := NewWebhookSink(WebhookConfig{URL: "https://example.com/hook", SecretEnv: "TEST_WEBHOOK_SECRET"})
+	assert.Error(err)
+}
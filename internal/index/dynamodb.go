@@ -54,6 +54,19 @@ func MustNewStore(ctx context.Context, config StoreConfig) *Store {
 	return s
 }
 
+// Ping checks the table can be read, this is used by readiness probes.
+func (s *Store) Ping(ctx context.Context) error {
+	ctx, span := trace.Start(ctx, "Store.Ping")
+	defer span.End()
+
+	_, _, err := s.cacheStore.Get(ctx, "cache", "_ping")
+	if err != nil && !errors.Is(err, dynastorev2.ErrKeyNotExists) {
+		return fmt.Errorf("failed to read table: %w", err)
+	}
+
+	return nil
+}
+
 func (s *Store) GetCache(ctx context.Context, id string) (CacheRecord, error) {
 	ctx, span := trace.Start(ctx, "Store.GetCache")
 	defer span.End()
@@ -109,6 +122,34 @@ func (s *Store) ExistsCacheByFallbackBranch(ctx context.Context, createdPrefix s
 	return true, res[0], nil
 }
 
+// FindCacheByKeyPrefix returns the most recently updated cache record with a cache id starting with the prefix.
+func (s *Store) FindCacheByKeyPrefix(ctx context.Context, prefix string) (bool, CacheRecord, error) {
+	ctx, span := trace.Start(ctx, "Store.FindCacheByKeyPrefix")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("prefix", prefix))
+
+	_, res, err := s.cacheStore.ListBySortKeyPrefix(ctx, "cache", prefix)
+	if err != nil {
+		span.RecordError(err)
+
+		return false, CacheRecord{}, fmt.Errorf("failed to list cache records: %w", err)
+	}
+
+	if len(res) == 0 {
+		return false, CacheRecord{}, nil
+	}
+
+	latest := res[0]
+	for _, rec := range res[1:] {
+		if rec.UpdatedAt.After(latest.UpdatedAt) {
+			latest = rec
+		}
+	}
+
+	return true, latest, nil
+}
+
 func (s *Store) PutCache(ctx context.Context, id, created string, value CacheRecord, lifetime time.Duration) error {
 	ctx, span := trace.Start(ctx, "Store.PutCache")
 	defer span.End()
@@ -20,16 +20,22 @@ type CacheRecord struct {
 	Branch            string    `json:"branch"`
 	Sha256            string    `json:"sha256"`
 	Compression       string    `json:"compression"`
-	Architecture      string    `json:"architecture"`
-	OperatingSystem   string    `json:"operating_system"`
-	FileSize          int64     `json:"file_size"`
-	CpuCount          int32     `json:"cpu_count"`
+	// UploadID identifies the pieces of an archive uploaded through the server while the record is in flight.
+	UploadID        string        `json:"upload_id,omitempty"`
+	Architecture    string        `json:"architecture"`
+	OperatingSystem string        `json:"operating_system"`
+	FileSize        int64         `json:"file_size"`
+	TTL             time.Duration `json:"ttl,omitempty"`
+	CpuCount        int32         `json:"cpu_count"`
 }
 
 type TenantRecord struct {
 	ID           string `json:"id"`
 	ProviderType string `json:"provider_type"`
 	Owner        string `json:"owner"`
+	// TTL is how long cache entries of the tenant are retained when no ttl is requested, the server default is
+	// used when zero.
+	TTL time.Duration `json:"ttl,omitempty"`
 }
 
 func (r *TenantRecord) Validate() error {
@@ -41,6 +47,10 @@ func (r *TenantRecord) Validate() error {
 		return fmt.Errorf("provider is required")
 	}
 
+	if r.TTL < 0 {
+		return fmt.Errorf("invalid ttl: `%s`", r.TTL)
+	}
+
 	return nil
 }
 
@@ -0,0 +1,85 @@
+package index
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// CacheRecordFromImage decodes a cache record from an item image of the table stream along with its id, false is
+// returned when the item isn't a cache record such as tenants and audit events.
+func CacheRecordFromImage(image map[string]events.DynamoDBAttributeValue) (string, CacheRecord, bool, error) {
+	if image["id"].DataType() != events.DataTypeString || image["id"].String() != "cache" {
+		return "", CacheRecord{}, false, nil
+	}
+
+	name, ok := image["name"]
+	if !ok || name.DataType() != events.DataTypeString {
+		return "", CacheRecord{}, false, nil
+	}
+
+	payload, ok := image["payload"]
+	if !ok {
+		return "", CacheRecord{}, false, nil
+	}
+
+	av, err := fromStreamAttributeValue(payload)
+	if err != nil {
+		return "", CacheRecord{}, false, err
+	}
+
+	var rec CacheRecord
+
+	err = attributevalue.Unmarshal(av, &rec)
+	if err != nil {
+		return "", CacheRecord{}, false, fmt.Errorf("failed to unmarshal cache record: %w", err)
+	}
+
+	return name.String(), rec, true, nil
+}
+
+// fromStreamAttributeValue converts an attribute of a lambda stream event to the attribute of the dynamodb sdk.
+func fromStreamAttributeValue(av events.DynamoDBAttributeValue) (types.AttributeValue, error) {
+	switch av.DataType() {
+	case events.DataTypeBinary:
+		return &types.AttributeValueMemberB{Value: av.Binary()}, nil
+	case events.DataTypeBoolean:
+		return &types.AttributeValueMemberBOOL{Value: av.Boolean()}, nil
+	case events.DataTypeBinarySet:
+		return &types.AttributeValueMemberBS{Value: av.BinarySet()}, nil
+	case events.DataTypeList:
+		list := make([]types.AttributeValue, len(av.List()))
+		for i, item := range av.List() {
+			v, err := fromStreamAttributeValue(item)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = v
+		}
+		return &types.AttributeValueMemberL{Value: list}, nil
+	case events.DataTypeMap:
+		m := make(map[string]types.AttributeValue, len(av.Map()))
+		for k, item := range av.Map() {
+			v, err := fromStreamAttributeValue(item)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return &types.AttributeValueMemberM{Value: m}, nil
+	case events.DataTypeNumber:
+		return &types.AttributeValueMemberN{Value: av.Number()}, nil
+	case events.DataTypeNumberSet:
+		return &types.AttributeValueMemberNS{Value: av.NumberSet()}, nil
+	case events.DataTypeNull:
+		return &types.AttributeValueMemberNULL{Value: true}, nil
+	case events.DataTypeString:
+		return &types.AttributeValueMemberS{Value: av.String()}, nil
+	case events.DataTypeStringSet:
+		return &types.AttributeValueMemberSS{Value: av.StringSet()}, nil
+	default:
+		return nil, fmt.Errorf("unsupported attribute type %d", av.DataType())
+	}
+}
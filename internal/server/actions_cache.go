@@ -0,0 +1,1062 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+
+	v1 "github.com/wolfeidau/zipstash/api/gen/proto/go/cache/v1"
+	"github.com/wolfeidau/zipstash/internal/audit"
+	"github.com/wolfeidau/zipstash/internal/ciauth"
+	"github.com/wolfeidau/zipstash/internal/events"
+	"github.com/wolfeidau/zipstash/internal/index"
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+const (
+	// ActionsCachePath is the prefix of the legacy REST actions cache api, runners use this when ACTIONS_CACHE_URL
+	// is set to the server.
+	ActionsCachePath = "/_apis/artifactcache/"
+	// ActionsCacheTwirpPath is the prefix of the twirp based v2 actions cache api, runners use this when
+	// ACTIONS_RESULTS_URL is set to the server and ACTIONS_CACHE_SERVICE_V2 is enabled.
+	ActionsCacheTwirpPath = "/twirp/github.actions.results.api.v1.CacheService/"
+	// ActionsBlobPath is the prefix of the urls returned by the v2 api to upload archives, these implement the
+	// subset of the azure blob api used by the actions toolkit.
+	ActionsBlobPath = "/_apis/zipstash/blob/"
+
+	// actionsOperatingSystem is recorded as the operating system of entries saved through the actions cache api,
+	// the platform of the runner isn't sent but it is included in the version of entries which aren't cross os.
+	actionsOperatingSystem = "actions"
+	// actionsCompression is recorded as the compression of entries, the actions toolkit uploads tar archives
+	// compressed with zstd or gzip and the compression is included in the version.
+	actionsCompression = "tar"
+	// actionsInflightPrefix is the prefix of the ids of in flight records for uploads through the actions api.
+	actionsInflightPrefix = "actions#"
+
+	maxActionsKeyLength = 512
+	// maxBlockListSize is the largest block list accepted when committing blocks.
+	maxBlockListSize = 4 << 20
+	// maxActionsCacheID is the largest id returned by the legacy api, the toolkit handles ids as javascript numbers.
+	maxActionsCacheID = 1 << 53
+)
+
+var (
+	errEntryExists      = errors.New("cache entry already exists")
+	errUploadNotFound   = errors.New("upload not found")
+	errUploadIncomplete = errors.New("upload incomplete")
+)
+
+// ActionsCacheHandler implements the GitHub Actions cache service protocols on top of the cache index and bucket, so
+// actions/cache and other tools using the actions toolkit can save and restore entries without changing workflows.
+//
+// Entries are scoped by the owner, repository and branch of the OIDC token along with the version sent by the
+// client, which is a hash of the cached paths and the compression. Keys are looked up in order, first as an exact
+// match and then as a prefix, on the branch of the token, then the base branch of a pull request and lastly the
+// default branch, mirroring the behaviour of the hosted service so other branches can't poison the default branch.
+type ActionsCacheHandler struct {
+	cache *CacheServiceHandler
+	blobs *blobStore
+}
+
+// NewActionsCacheHandler creates an actions cache handler using the index and bucket of the cache service.
+func NewActionsCacheHandler(cache *CacheServiceHandler) *ActionsCacheHandler {
+	return &ActionsCacheHandler{
+		cache: cache,
+		blobs: newBlobStore(cache.s3Client, cache.cfg.CacheBucket),
+	}
+}
+
+// Mount registers the actions cache apis on the mux, these require an OIDC token which is validated by auth. The
+// blob upload urls are authorized by the unguessable upload id they contain in the same way as presigned urls, so
+// they are only wrapped with limit which applies the same body limit as auth.
+func (h *ActionsCacheHandler) Mount(mux *http.ServeMux, auth, limit func(http.Handler) http.Handler) {
+	api := http.NewServeMux()
+	api.HandleFunc("GET "+ActionsCachePath+"cache", h.getCacheEntry)
+	api.HandleFunc("POST "+ActionsCachePath+"caches", h.reserveCache)
+	api.HandleFunc("PATCH "+ActionsCachePath+"caches/{id}", h.uploadChunk)
+	api.HandleFunc("POST "+ActionsCachePath+"caches/{id}", h.commitCache)
+	api.HandleFunc("POST "+ActionsCacheTwirpPath+"{method}", h.serveTwirp)
+
+	mux.Handle(ActionsCachePath, auth(api))
+	mux.Handle(ActionsCacheTwirpPath, auth(api))
+	mux.Handle("PUT "+ActionsBlobPath+"{upload}", limit(http.HandlerFunc(h.putBlob)))
+}
+
+// architecture is recorded in place of the architecture of entries to scope them by repository, version and
+// branch, restore keys are prefixes of the cache id so they only match entries of the same branch.
+func (s requestScope) architecture(branch, version string) string {
+	return escapeValue(s.name) + "@" + escapeValue(version) + "@" + escapeValue(branch)
+}
+
+func (s requestScope) cacheID(key, version string) string {
+	return buildCacheKey(s.owner, s.provider, actionsOperatingSystem, s.architecture(s.branch, version), key)
+}
+
+// restoreBranches returns the branches entries are restored from in order, the branch of the token, the base
+// branch of a pull request and the default branch.
+func (s requestScope) restoreBranches(defaultBranch string) []string {
+	branches := []string{s.branch}
+
+	for _, branch := range []string{s.baseBranch, defaultBranch} {
+		if branch != "" && !slices.Contains(branches, branch) {
+			branches = append(branches, branch)
+		}
+	}
+
+	return branches
+}
+
+// validateActionsKey checks a key can be used as part of a cache id, keys are limited in the same way as the
+// hosted service and may not contain path traversal.
+func validateActionsKey(key string) error {
+	if key == "" || len(key) > maxActionsKeyLength || strings.Contains(key, ",") {
+		return fmt.Errorf("%w: invalid key %q", errInvalidRequest, key)
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == ".." || segment == "." {
+			return fmt.Errorf("%w: invalid key %q", errInvalidRequest, key)
+		}
+	}
+
+	return nil
+}
+
+func validateActionsRequest(keys []string, version string) error {
+	if version == "" {
+		return fmt.Errorf("%w: version is required", errInvalidRequest)
+	}
+
+	if len(keys) == 0 {
+		return fmt.Errorf("%w: key is required", errInvalidRequest)
+	}
+
+	for _, key := range keys {
+		if err := validateActionsKey(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// actionsEntry is an entry found by a lookup.
+type actionsEntry struct {
+	record index.CacheRecord
+	url    string
+}
+
+// lookup finds the entry matching the keys in order on each of the restore branches, the first key is tried as an
+// exact match and then each key as a prefix of the most recent entry. Nil is returned when there is no match.
+func (h *ActionsCacheHandler) lookup(ctx context.Context, scope requestScope, keys []string, version string) (entry *actionsEntry, err error) {
+	ctx, span := trace.Start(ctx, "ActionsCache.lookup")
+	defer span.End()
+
+	ev := newAuditEvent(ctx, audit.ActionCacheRestore)
+	ev.Key = keys[0]
+	ev.Branch = scope.branch
+
+	defer func() {
+		ev.Result = auditResult(err)
+		if err == nil && entry == nil {
+			ev.Result = auditResult(errEntryNotFound)
+		}
+		h.cache.audit.Record(ctx, ev)
+	}()
+
+	_, err = h.cache.validateOwner(ctx, scope.owner, scope.provider)
+	if err != nil {
+		return nil, err
+	}
+
+	var res existsWithFallbackResult
+
+	for i, branch := range scope.restoreBranches(h.cache.cfg.DefaultBranch) {
+		res, err = h.cache.existsWithFallback(ctx, &v1.GetEntryRequest{
+			Key:          keys[0],
+			RestoreKeys:  keys,
+			Owner:        scope.owner,
+			Name:         scope.name,
+			ProviderType: toProviderV1(scope.provider),
+			Platform: &v1.Platform{
+				OperatingSystem: actionsOperatingSystem,
+				Architecture:    scope.architecture(branch, version),
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to find cache entry: %w", err)
+		}
+
+		if res.exists {
+			// entries of other branches are a fallback
+			res.fallback = res.fallback || i > 0
+			break
+		}
+	}
+
+	span.SetAttributes(attribute.Bool("exists", res.exists))
+
+	if !res.exists {
+		h.cache.metrics.recordLookup(ctx, scope.owner, scope.provider, lookupMiss)
+		return nil, nil
+	}
+
+	// the index may briefly reference an archive which has expired from the bucket
+	exists, head, err := h.cache.existsInS3(ctx, res.cacheID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		h.cache.metrics.recordLookup(ctx, scope.owner, scope.provider, lookupMiss)
+		return nil, nil
+	}
+
+	h.cache.metrics.recordLookup(ctx, scope.owner, scope.provider, lookupResult(res.fallback))
+
+	url, err := h.cache.presigner.PresignDownload(ctx, res.cacheID, aws.ToInt64(head.ContentLength))
+	if err != nil {
+		return nil, err
+	}
+
+	ev.CacheID = res.cacheID
+	ev.Branch = res.record.Branch
+	ev.Sha256 = res.record.Sha256
+	ev.Fallback = res.fallback
+
+	h.cache.publishEntry(ctx, events.EntryRestored, res.cacheID, res.record, res.fallback)
+
+	return &actionsEntry{record: res.record, url: url}, nil
+}
+
+// reserve records an in flight upload of an entry under the id, errEntryExists is returned if the entry exists
+// or another upload of it is in flight.
+func (h *ActionsCacheHandler) reserve(ctx context.Context, scope requestScope, inflightID, key, version string, size int64) (index.CacheRecord, error) {
+	ctx, span := trace.Start(ctx, "ActionsCache.reserve")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("key", key), attribute.String("inflight_id", inflightID))
+
+	tenant, err := h.cache.validateOwner(ctx, scope.owner, scope.provider)
+	if err != nil {
+		return index.CacheRecord{}, err
+	}
+
+	exists, _, err := h.cache.store.ExistsCache(ctx, scope.cacheID(key, version))
+	if err != nil {
+		return index.CacheRecord{}, fmt.Errorf("failed to check if cache entry exists: %w", err)
+	}
+
+	if exists {
+		return index.CacheRecord{}, errEntryExists
+	}
+
+	exists, rec, err := h.cache.store.ExistsCache(ctx, inflightID)
+	if err != nil {
+		return index.CacheRecord{}, fmt.Errorf("failed to check if upload is in flight: %w", err)
+	}
+
+	if exists && inflightLive(rec) {
+		return index.CacheRecord{}, errEntryExists
+	}
+
+	rec = index.CacheRecord{
+		Key:             key,
+		Name:            scope.name,
+		Branch:          scope.branch,
+		Owner:           scope.owner,
+		Provider:        scope.provider,
+		OperatingSystem: actionsOperatingSystem,
+		Architecture:    scope.architecture(scope.branch, version),
+		Compression:     actionsCompression,
+		FileSize:        size,
+		TTL:             tenantTTL(tenant),
+		UploadID:        uuid.New().String(),
+		UpdatedAt:       time.Now(),
+	}
+
+	if identity := ciauth.GetOIDCIdentity(ctx); identity != nil {
+		rec.Identity = &index.Identity{
+			Subject: identity.Subject(),
+			Issuer:  identity.Issuer(),
+		}
+	}
+
+	err = h.cache.store.PutCache(ctx, inflightID, createdValue(rec), rec, cacheRecordInflightTTL)
+	if err != nil {
+		return index.CacheRecord{}, fmt.Errorf("failed to create in flight cache entry: %w", err)
+	}
+
+	return rec, nil
+}
+
+// inflight returns the in flight record with the id if it belongs to the scope.
+func (h *ActionsCacheHandler) inflight(ctx context.Context, scope requestScope, inflightID string) (index.CacheRecord, error) {
+	exists, rec, err := h.cache.store.ExistsCache(ctx, inflightID)
+	if err != nil {
+		return index.CacheRecord{}, fmt.Errorf("failed to get in flight cache entry: %w", err)
+	}
+
+	if !exists || !inflightLive(rec) || rec.Owner != scope.owner || rec.Provider != scope.provider {
+		return index.CacheRecord{}, errUploadNotFound
+	}
+
+	return rec, nil
+}
+
+// inflightLive reports whether an in flight record is still valid, records are removed lazily once their ttl
+// passes so this is checked when they are read.
+func inflightLive(rec index.CacheRecord) bool {
+	return rec.UploadID != "" && time.Since(rec.UpdatedAt) < cacheRecordInflightTTL
+}
+
+// finalize stores the archive assembled from the uploaded pieces and adds the entry to the cache index, the
+// pieces are then removed. The in flight record is removed first so the upload urls can't replace the archive
+// once it is committed, the client can retry the commit if this fails.
+func (h *ActionsCacheHandler) finalize(ctx context.Context, inflightID string, rec index.CacheRecord, size int64, srcs []string) (_ string, err error) {
+	ctx, span := trace.Start(ctx, "ActionsCache.finalize")
+	defer span.End()
+
+	cacheID := buildCacheKey(rec.Owner, rec.Provider, rec.OperatingSystem, rec.Architecture, rec.Key)
+
+	ev := newAuditEvent(ctx, audit.ActionCacheCreate)
+	ev.Tenant = index.TenantKey(rec.Provider, rec.Owner)
+	ev.Key = rec.Key
+	ev.CacheID = cacheID
+	ev.Branch = rec.Branch
+
+	defer func() {
+		ev.Result = auditResult(err)
+		h.cache.audit.Record(ctx, ev)
+	}()
+
+	span.SetAttributes(attribute.String("cache_id", cacheID), attribute.Int64("size", size))
+
+	err = h.checkUploaded(ctx, rec.UploadID, srcs, size)
+	if err != nil {
+		return "", err
+	}
+
+	err = h.cache.store.DeleteCache(ctx, inflightID)
+	if err != nil {
+		return "", fmt.Errorf("failed to delete in flight cache entry: %w", err)
+	}
+
+	err = h.blobs.assemble(ctx, cacheID, srcs)
+	if err != nil {
+		return "", err
+	}
+
+	rec.FileSize = size
+	rec.UpdatedAt = time.Now()
+
+	err = h.cache.store.PutCache(ctx, cacheID, createdValue(rec), rec, rec.TTL)
+	if err != nil {
+		return "", fmt.Errorf("failed to update cache entry: %w", err)
+	}
+
+	h.blobs.removePrefix(ctx, uploadPrefix(rec.UploadID))
+
+	log.Info().Str("cacheID", cacheID).Int64("size", size).Msg("actions cache entry saved")
+
+	h.cache.publishEntry(ctx, events.EntryCreated, cacheID, rec, false)
+
+	return cacheID, nil
+}
+
+// checkUploaded checks the pieces of an upload exist and add up to the size of the archive.
+func (h *ActionsCacheHandler) checkUploaded(ctx context.Context, uploadID string, srcs []string, size int64) error {
+	if len(srcs) == 0 {
+		return errUploadIncomplete
+	}
+
+	objects, err := h.blobs.list(ctx, uploadPrefix(uploadID))
+	if err != nil {
+		return err
+	}
+
+	sizes := make(map[string]int64, len(objects))
+	for _, obj := range objects {
+		sizes[aws.ToString(obj.Key)] = aws.ToInt64(obj.Size)
+	}
+
+	var total int64
+	for _, src := range srcs {
+		n, ok := sizes[src]
+		if !ok {
+			return fmt.Errorf("%w: missing %s", errUploadIncomplete, path.Base(src))
+		}
+		total += n
+	}
+
+	if total != size {
+		return errSizeMismatch
+	}
+
+	return nil
+}
+
+// uploadedBlob returns the pieces of an archive uploaded with the blob api, these are either the committed block
+// list or the blob uploaded in a single request.
+func (h *ActionsCacheHandler) uploadedBlob(ctx context.Context, uploadID string) ([]string, error) {
+	res, err := h.blobs.open(ctx, blockListKey(uploadID))
+	if errors.Is(err, errObjectNotFound) {
+		return []string{blobKey(uploadID)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var keys []string
+
+	err = json.NewDecoder(res.Body).Decode(&keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read block list: %w", err)
+	}
+
+	return keys, nil
+}
+
+func uploadPrefix(uploadID string) string {
+	return uploadsPrefix + uploadID + "/"
+}
+
+// chunkKey returns the key of a chunk uploaded using the legacy api, the offset is padded so chunks are listed
+// in order.
+func chunkKey(uploadID string, start int64) string {
+	return fmt.Sprintf("%schunk/%020d", uploadPrefix(uploadID), start)
+}
+
+// blockKey returns the key of a block uploaded using the blob api.
+func blockKey(uploadID, blockID string) string {
+	return uploadPrefix(uploadID) + "block/" + hex.EncodeToString([]byte(blockID))
+}
+
+// blockListKey returns the key of the keys of the blocks committed with a block list, these are assembled into
+// the archive when the entry is finalized.
+func blockListKey(uploadID string) string {
+	return uploadPrefix(uploadID) + "blocklist"
+}
+
+// blobKey returns the key of an archive uploaded in a single request using the blob api.
+func blobKey(uploadID string) string {
+	return uploadPrefix(uploadID) + "blob"
+}
+
+// orderChunks returns the keys of the chunks of an upload in order, checking they are contiguous and add up
+// to the size of the archive.
+func orderChunks(objects []types.Object, prefix string, size int64) ([]string, error) {
+	sort.Slice(objects, func(i, j int) bool {
+		return aws.ToString(objects[i].Key) < aws.ToString(objects[j].Key)
+	})
+
+	keys := make([]string, len(objects))
+
+	var offset int64
+	for i, obj := range objects {
+		key := aws.ToString(obj.Key)
+
+		start, err := strconv.ParseInt(strings.TrimPrefix(key, prefix), 10, 64)
+		if err != nil || start != offset {
+			return nil, fmt.Errorf("%w: missing chunk at offset %d", errUploadIncomplete, offset)
+		}
+
+		offset += aws.ToInt64(obj.Size)
+		keys[i] = key
+	}
+
+	if offset != size {
+		return nil, fmt.Errorf("%w: uploaded %d bytes of %d", errUploadIncomplete, offset, size)
+	}
+
+	return keys, nil
+}
+
+// entryID returns a stable numeric id for an entry, the v2 api returns this after an upload.
+func entryID(cacheID string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(cacheID))
+	return int64(h.Sum64() & (maxActionsCacheID - 1))
+}
+
+func newActionsCacheID() (int64, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(maxActionsCacheID-1))
+	if err != nil {
+		return 0, fmt.Errorf("failed to generate cache id: %w", err)
+	}
+
+	return n.Int64() + 1, nil
+}
+
+func readJSON(r *http.Request, v any) error {
+	err := json.NewDecoder(io.LimitReader(r.Body, maxBlockListSize)).Decode(v)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidRequest, err)
+	}
+
+	return nil
+}
+
+// legacy REST api
+
+type artifactCacheEntry struct {
+	CacheKey        string    `json:"cacheKey"`
+	Scope           string    `json:"scope"`
+	CacheVersion    string    `json:"cacheVersion"`
+	ArchiveLocation string    `json:"archiveLocation"`
+	CreationTime    time.Time `json:"creationTime"`
+}
+
+type reserveCacheRequest struct {
+	CacheSize *int64 `json:"cacheSize,omitempty"`
+	Key       string `json:"key"`
+	Version   string `json:"version"`
+}
+
+type reserveCacheResponse struct {
+	CacheID int64 `json:"cacheId"`
+}
+
+type commitCacheRequest struct {
+	Size int64 `json:"size"`
+}
+
+func (h *ActionsCacheHandler) getCacheEntry(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.Start(r.Context(), "ActionsCache.getCacheEntry")
+	defer span.End()
+
+	scope, err := newRequestScope(ciauth.GetOIDCIdentity(ctx))
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	var keys []string
+	for _, key := range strings.Split(r.URL.Query().Get("keys"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	version := r.URL.Query().Get("version")
+
+	err = validateActionsRequest(keys, version)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	entry, err := h.lookup(ctx, scope, keys, version)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	if entry == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, artifactCacheEntry{
+		CacheKey:        entry.record.Key,
+		Scope:           entry.record.Branch,
+		CacheVersion:    version,
+		CreationTime:    entry.record.UpdatedAt,
+		ArchiveLocation: entry.url,
+	})
+}
+
+func (h *ActionsCacheHandler) reserveCache(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.Start(r.Context(), "ActionsCache.reserveCache")
+	defer span.End()
+
+	scope, err := newRequestScope(ciauth.GetOIDCIdentity(ctx))
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	var req reserveCacheRequest
+
+	err = readJSON(r, &req)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	err = validateActionsRequest([]string{req.Key}, req.Version)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	id, err := newActionsCacheID()
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	_, err = h.reserve(ctx, scope, actionsInflightPrefix+strconv.FormatInt(id, 10), req.Key, req.Version, aws.ToInt64(req.CacheSize))
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, reserveCacheResponse{CacheID: id})
+}
+
+func (h *ActionsCacheHandler) uploadChunk(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.Start(r.Context(), "ActionsCache.uploadChunk")
+	defer span.End()
+
+	scope, err := newRequestScope(ciauth.GetOIDCIdentity(ctx))
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	rec, err := h.inflight(ctx, scope, actionsInflightPrefix+r.PathValue("id"))
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	start, end, err := parseContentRange(r.Header.Get("Content-Range"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.blobs.put(ctx, chunkKey(rec.UploadID, start), r.Body, end-start+1, "")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *ActionsCacheHandler) commitCache(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.Start(r.Context(), "ActionsCache.commitCache")
+	defer span.End()
+
+	scope, err := newRequestScope(ciauth.GetOIDCIdentity(ctx))
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	inflightID := actionsInflightPrefix + r.PathValue("id")
+
+	rec, err := h.inflight(ctx, scope, inflightID)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	var req commitCacheRequest
+
+	err = readJSON(r, &req)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	prefix := uploadPrefix(rec.UploadID) + "chunk/"
+
+	objects, err := h.blobs.list(ctx, prefix)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	keys, err := orderChunks(objects, prefix, req.Size)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	_, err = h.finalize(ctx, inflightID, rec, req.Size, keys)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseContentRange parses the range of a chunk, the legacy api sends ranges of the form bytes 0-1023/*.
+func parseContentRange(value string) (int64, int64, error) {
+	spec, ok := strings.CutPrefix(value, "bytes ")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid content range: %q", value)
+	}
+
+	spec, _, _ = strings.Cut(spec, "/")
+
+	startValue, endValue, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid content range: %q", value)
+	}
+
+	start, err := strconv.ParseInt(startValue, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid content range: %q", value)
+	}
+
+	end, err := strconv.ParseInt(endValue, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid content range: %q", value)
+	}
+
+	if start < 0 || end < start || end-start >= maxHTTPUploadSize {
+		return 0, 0, fmt.Errorf("invalid content range: %q", value)
+	}
+
+	return start, end, nil
+}
+
+// twirp v2 api
+
+// protoInt64 is an int64 encoded as a string as in the json mapping of protobuf, numbers are also accepted.
+type protoInt64 int64
+
+func (n protoInt64) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strconv.FormatInt(int64(n), 10))
+}
+
+func (n *protoInt64) UnmarshalJSON(data []byte) error {
+	value, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid int64: %s", data)
+	}
+
+	*n = protoInt64(value)
+
+	return nil
+}
+
+type createCacheEntryRequest struct {
+	Key     string `json:"key"`
+	Version string `json:"version"`
+}
+
+type createCacheEntryResponse struct {
+	SignedUploadURL string `json:"signed_upload_url,omitempty"`
+	Message         string `json:"message,omitempty"`
+	Ok              bool   `json:"ok"`
+}
+
+type finalizeCacheEntryUploadRequest struct {
+	Key       string     `json:"key"`
+	Version   string     `json:"version"`
+	SizeBytes protoInt64 `json:"size_bytes"`
+}
+
+type finalizeCacheEntryUploadResponse struct {
+	Message string     `json:"message,omitempty"`
+	EntryID protoInt64 `json:"entry_id,omitempty"`
+	Ok      bool       `json:"ok"`
+}
+
+type getCacheEntryDownloadURLRequest struct {
+	Key         string   `json:"key"`
+	Version     string   `json:"version"`
+	RestoreKeys []string `json:"restore_keys"`
+}
+
+type getCacheEntryDownloadURLResponse struct {
+	SignedDownloadURL string `json:"signed_download_url,omitempty"`
+	MatchedKey        string `json:"matched_key,omitempty"`
+	Ok                bool   `json:"ok"`
+}
+
+type twirpError struct {
+	Code string `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+func writeTwirpError(w http.ResponseWriter, err error) {
+	status := httpStatus(err)
+
+	code := "internal"
+	msg := "internal error"
+
+	switch status {
+	case http.StatusUnauthorized:
+		code = "unauthenticated"
+	case http.StatusForbidden:
+		code = "permission_denied"
+	case http.StatusNotFound:
+		code = "not_found"
+	case http.StatusConflict:
+		code = "already_exists"
+	case http.StatusBadRequest:
+		code = "invalid_argument"
+	default:
+		log.Error().Err(err).Msg("actions cache request failed")
+	}
+
+	if status != http.StatusInternalServerError {
+		msg = err.Error()
+	}
+
+	writeJSON(w, status, twirpError{Code: code, Msg: msg})
+}
+
+func (h *ActionsCacheHandler) serveTwirp(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.Start(r.Context(), "ActionsCache.serveTwirp")
+	defer span.End()
+
+	method := r.PathValue("method")
+
+	span.SetAttributes(attribute.String("method", method))
+
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		writeJSON(w, http.StatusBadRequest, twirpError{Code: "malformed", Msg: "only json requests are supported"})
+		return
+	}
+
+	scope, err := newRequestScope(ciauth.GetOIDCIdentity(ctx))
+	if err != nil {
+		writeTwirpError(w, err)
+		return
+	}
+
+	var res any
+
+	switch method {
+	case "CreateCacheEntry":
+		res, err = h.createCacheEntry(ctx, r, scope)
+	case "FinalizeCacheEntryUpload":
+		res, err = h.finalizeCacheEntryUpload(ctx, r, scope)
+	case "GetCacheEntryDownloadURL":
+		res, err = h.getCacheEntryDownloadURL(ctx, r, scope)
+	default:
+		writeJSON(w, http.StatusNotFound, twirpError{Code: "bad_route", Msg: "unknown method " + method})
+		return
+	}
+
+	if err != nil {
+		writeTwirpError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *ActionsCacheHandler) createCacheEntry(ctx context.Context, r *http.Request, scope requestScope) (*createCacheEntryResponse, error) {
+	var req createCacheEntryRequest
+
+	err := readJSON(r, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateActionsRequest([]string{req.Key}, req.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	inflightID := actionsInflightPrefix + scope.cacheID(req.Key, req.Version)
+
+	rec, err := h.reserve(ctx, scope, inflightID, req.Key, req.Version, 0)
+	if err != nil {
+		if errors.Is(err, errEntryExists) {
+			return &createCacheEntryResponse{Message: err.Error()}, nil
+		}
+		return nil, err
+	}
+
+	return &createCacheEntryResponse{Ok: true, SignedUploadURL: blobURL(r, inflightID, rec.UploadID)}, nil
+}
+
+func (h *ActionsCacheHandler) finalizeCacheEntryUpload(ctx context.Context, r *http.Request, scope requestScope) (*finalizeCacheEntryUploadResponse, error) {
+	var req finalizeCacheEntryUploadRequest
+
+	err := readJSON(r, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateActionsRequest([]string{req.Key}, req.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	inflightID := actionsInflightPrefix + scope.cacheID(req.Key, req.Version)
+
+	rec, err := h.inflight(ctx, scope, inflightID)
+	if err != nil {
+		return nil, err
+	}
+
+	srcs, err := h.uploadedBlob(ctx, rec.UploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheID, err := h.finalize(ctx, inflightID, rec, int64(req.SizeBytes), srcs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &finalizeCacheEntryUploadResponse{Ok: true, EntryID: protoInt64(entryID(cacheID))}, nil
+}
+
+func (h *ActionsCacheHandler) getCacheEntryDownloadURL(ctx context.Context, r *http.Request, scope requestScope) (*getCacheEntryDownloadURLResponse, error) {
+	var req getCacheEntryDownloadURLRequest
+
+	err := readJSON(r, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := append([]string{req.Key}, req.RestoreKeys...)
+
+	err = validateActionsRequest(keys, req.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := h.lookup(ctx, scope, keys, req.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return &getCacheEntryDownloadURLResponse{}, nil
+	}
+
+	return &getCacheEntryDownloadURLResponse{
+		Ok:                true,
+		SignedDownloadURL: entry.url,
+		MatchedKey:        entry.record.Key,
+	}, nil
+}
+
+// blob upload api
+
+// blobURL returns the url the archive for an in flight entry is uploaded to, this is on the same host as the
+// request.
+func blobURL(r *http.Request, inflightID, uploadID string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+
+	u := url.URL{
+		Scheme:   scheme,
+		Host:     r.Host,
+		Path:     ActionsBlobPath + uploadID,
+		RawQuery: url.Values{"entry": {inflightID}}.Encode(),
+	}
+
+	return u.String()
+}
+
+// blockList is the body of a put block list request, the blocks are committed in the order listed.
+type blockList struct {
+	Blocks []struct {
+		ID string `xml:",chardata"`
+	} `xml:",any"`
+}
+
+// putBlob implements the put blob, put block and put block list operations of the azure blob api which the
+// actions toolkit uses to upload to the signed url.
+func (h *ActionsCacheHandler) putBlob(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.Start(r.Context(), "ActionsCache.putBlob")
+	defer span.End()
+
+	uploadID := r.PathValue("upload")
+	inflightID := r.URL.Query().Get("entry")
+
+	exists, rec, err := h.cache.store.ExistsCache(ctx, inflightID)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	if !strings.HasPrefix(inflightID, actionsInflightPrefix) || !exists || !inflightLive(rec) ||
+		subtle.ConstantTimeCompare([]byte(rec.UploadID), []byte(uploadID)) != 1 {
+		writeHTTPError(w, errUploadNotFound)
+		return
+	}
+
+	comp := r.URL.Query().Get("comp")
+
+	span.SetAttributes(attribute.String("comp", comp), attribute.String("inflight_id", inflightID))
+
+	// uploads are staged under the upload id and only copied to the entry when it is finalized
+	switch comp {
+	case "block":
+		blockID := r.URL.Query().Get("blockid")
+		if blockID == "" || r.ContentLength < 0 || r.ContentLength > maxHTTPUploadSize {
+			http.Error(w, "invalid block", http.StatusBadRequest)
+			return
+		}
+
+		err = h.blobs.put(ctx, blockKey(rec.UploadID, blockID), r.Body, r.ContentLength, "")
+	case "blocklist":
+		var list blockList
+
+		err = xml.NewDecoder(io.LimitReader(r.Body, maxBlockListSize)).Decode(&list)
+		if err != nil || len(list.Blocks) == 0 {
+			http.Error(w, "invalid block list", http.StatusBadRequest)
+			return
+		}
+
+		keys := make([]string, len(list.Blocks))
+		for i, block := range list.Blocks {
+			keys[i] = blockKey(rec.UploadID, block.ID)
+		}
+
+		data, _ := json.Marshal(keys)
+
+		err = h.blobs.putBytes(ctx, blockListKey(rec.UploadID), data, "application/json")
+	case "":
+		if r.ContentLength < 0 || r.ContentLength > maxHTTPUploadSize {
+			http.Error(w, "invalid content length", http.StatusBadRequest)
+			return
+		}
+
+		err = h.blobs.put(ctx, blobKey(rec.UploadID), r.Body, r.ContentLength, "")
+	default:
+		http.Error(w, "unsupported operation", http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
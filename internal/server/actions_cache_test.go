@@ -0,0 +1,549 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wolfeidau/zipstash/internal/ciauth"
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+type fakeIdentity struct {
+	claims any
+}
+
+func (f fakeIdentity) Provider() string { return ciauth.GitHubActions }
+func (f fakeIdentity) Claims() any      { return f.claims }
+func (f fakeIdentity) Owner() string    { return "wolfeidau" }
+func (f fakeIdentity) Subject() string  { return "repo:wolfeidau/zipstash:ref:refs/heads/main" }
+func (f fakeIdentity) Issuer() string   { return "https://token.actions.githubusercontent.com" }
+
+// withFakeIdentity is used in place of the OIDC middleware to authenticate requests as the fake identity.
+func withFakeIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(ciauth.WithOIDCIdentity(r.Context(), fakeIdentity{claims: &ciauth.GitHubActionsClaims{RunId: "42"}})))
+	})
+}
+
+// withoutIdentity is used in place of the OIDC middleware to send requests without an identity to the handlers.
+func withoutIdentity(next http.Handler) http.Handler { return next }
+
+// withWorkflowIdentity is used in place of the OIDC middleware to authenticate requests as a workflow of the
+// zipstash repository running on the branch.
+func withWorkflowIdentity(branch string) func(http.Handler) http.Handler {
+	claims := &ciauth.GitHubActionsClaims{RunId: "42", Repository: "wolfeidau/zipstash", Ref: "refs/heads/" + branch}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(ciauth.WithOIDCIdentity(r.Context(), fakeIdentity{claims: claims})))
+		})
+	}
+}
+
+// serveActions sends a request to the handler and returns the response.
+func serveActions(h http.Handler, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+// uploadKeys returns the keys of the objects staged in the uploads prefix of the bucket.
+func uploadKeys(bucket *fakeS3) []string {
+	var keys []string
+	for _, key := range bucket.keys() {
+		if strings.HasPrefix(key, uploadsPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func TestActionsScope(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := newRequestScope(nil)
+	assert.ErrorIs(err, errUnauthenticated)
+
+	scope, err := newRequestScope(fakeIdentity{claims: &ciauth.GitHubActionsClaims{
+		Repository: "wolfeidau/zipstash",
+		Ref:        "refs/heads/main",
+	}})
+	assert.NoError(err)
+	assert.Equal(requestScope{owner: "wolfeidau", provider: ciauth.GitHubActions, name: "wolfeidau/zipstash", branch: "main"}, scope)
+
+	// the repository, version and branch are a single segment of the cache id
+	assert.Equal("wolfeidau/github_actions/actions/wolfeidau%2Fzipstash@a%2Fb@main/npm-linux-abc", scope.cacheID("npm-linux-abc", "a/b"))
+
+	// entries of other branches have other cache ids so a branch can't replace the entries of the default branch
+	feature := scope
+	feature.branch = "feature/x"
+	assert.Equal("wolfeidau/github_actions/actions/wolfeidau%2Fzipstash@a%2Fb@feature%2Fx/npm-linux-abc", feature.cacheID("npm-linux-abc", "a/b"))
+}
+
+func TestActionsRestoreBranches(t *testing.T) {
+	assert := require.New(t)
+
+	scope, err := newRequestScope(fakeIdentity{claims: &ciauth.GitHubActionsClaims{
+		Repository: "wolfeidau/zipstash",
+		Ref:        "refs/pull/42/merge",
+		BaseRef:    "release",
+	}})
+	assert.NoError(err)
+
+	// pull requests restore from their own ref, the branch they target and the default branch
+	assert.Equal([]string{"refs/pull/42/merge", "release", "main"}, scope.restoreBranches("main"))
+
+	scope = requestScope{branch: "main"}
+	assert.Equal([]string{"main"}, scope.restoreBranches("main"))
+
+	scope = requestScope{branch: "feature"}
+	assert.Equal([]string{"feature"}, scope.restoreBranches(""))
+}
+
+func TestValidateActionsRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		keys    []string
+		wantErr bool
+	}{
+		{name: "valid", keys: []string{"npm-linux-abc", "npm-linux-"}, version: "abc"},
+		{name: "nested", keys: []string{"go/build/linux"}, version: "abc"},
+		{name: "no version", keys: []string{"npm"}, wantErr: true},
+		{name: "no keys", version: "abc", wantErr: true},
+		{name: "empty key", keys: []string{""}, version: "abc", wantErr: true},
+		{name: "comma", keys: []string{"a,b"}, version: "abc", wantErr: true},
+		{name: "traversal", keys: []string{"../../other/github_actions/x"}, version: "abc", wantErr: true},
+		{name: "nested traversal", keys: []string{"a/../b"}, version: "abc", wantErr: true},
+		{name: "too long", keys: []string{strings.Repeat("a", maxActionsKeyLength+1)}, version: "abc", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			err := validateActionsRequest(tt.keys, tt.version)
+			if tt.wantErr {
+				assert.ErrorIs(err, errInvalidRequest)
+				return
+			}
+			assert.NoError(err)
+		})
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		value   string
+		start   int64
+		end     int64
+		wantErr bool
+	}{
+		{value: "bytes 0-1023/*", start: 0, end: 1023},
+		{value: "bytes 33554432-67108863/*", start: 33554432, end: 67108863},
+		{value: "bytes 10-20/100", start: 10, end: 20},
+		{value: "0-1023/*", wantErr: true},
+		{value: "bytes 20-10/*", wantErr: true},
+		{value: "bytes -1-10/*", wantErr: true},
+		{value: "bytes 0-x/*", wantErr: true},
+		{value: "bytes 0-5368709120/*", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			assert := require.New(t)
+
+			start, end, err := parseContentRange(tt.value)
+			if tt.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tt.start, start)
+			assert.Equal(tt.end, end)
+		})
+	}
+}
+
+func TestOrderChunks(t *testing.T) {
+	prefix := uploadPrefix("upload") + "chunk/"
+
+	object := func(start, size int64) types.Object {
+		return types.Object{Key: aws.String(chunkKey("upload", start)), Size: aws.Int64(size)}
+	}
+
+	tests := []struct {
+		name    string
+		objects []types.Object
+		size    int64
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "out of order",
+			objects: []types.Object{object(100, 50), object(0, 100)},
+			size:    150,
+			want:    []string{chunkKey("upload", 0), chunkKey("upload", 100)},
+		},
+		{
+			name:    "gap",
+			objects: []types.Object{object(0, 100), object(150, 50)},
+			size:    200,
+			wantErr: true,
+		},
+		{
+			name:    "short",
+			objects: []types.Object{object(0, 100)},
+			size:    200,
+			wantErr: true,
+		},
+		{
+			name:    "none",
+			size:    1,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			keys, err := orderChunks(tt.objects, prefix, tt.size)
+			if tt.wantErr {
+				assert.ErrorIs(err, errUploadIncomplete)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tt.want, keys)
+		})
+	}
+}
+
+func TestProtoInt64(t *testing.T) {
+	assert := require.New(t)
+
+	var req finalizeCacheEntryUploadRequest
+
+	assert.NoError(json.Unmarshal([]byte(`{"key":"a","version":"v","size_bytes":"1024"}`), &req))
+	assert.Equal(protoInt64(1024), req.SizeBytes)
+
+	assert.NoError(json.Unmarshal([]byte(`{"size_bytes":2048}`), &req))
+	assert.Equal(protoInt64(2048), req.SizeBytes)
+
+	assert.Error(json.Unmarshal([]byte(`{"size_bytes":"x"}`), &req))
+
+	data, err := json.Marshal(finalizeCacheEntryUploadResponse{Ok: true, EntryID: 42})
+	assert.NoError(err)
+	assert.JSONEq(`{"ok":true,"entry_id":"42"}`, string(data))
+}
+
+func TestBlockList(t *testing.T) {
+	assert := require.New(t)
+
+	body := `<?xml version="1.0" encoding="utf-8"?><BlockList><Latest>AAAA</Latest><Uncommitted>AAAB</Uncommitted><Latest>AAAC</Latest></BlockList>`
+
+	var list blockList
+	assert.NoError(xml.Unmarshal([]byte(body), &list))
+	assert.Len(list.Blocks, 3)
+	assert.Equal("AAAA", list.Blocks[0].ID)
+	assert.Equal("AAAB", list.Blocks[1].ID)
+	assert.Equal("AAAC", list.Blocks[2].ID)
+}
+
+func TestBlobURL(t *testing.T) {
+	assert := require.New(t)
+
+	r := httptest.NewRequest(http.MethodPost, "http://cache.example.com"+ActionsCacheTwirpPath+"CreateCacheEntry", nil)
+	assert.Equal("http://cache.example.com/_apis/zipstash/blob/upload?entry=actions%23owner%2Fkey", blobURL(r, "actions#owner/key", "upload"))
+
+	r.TLS = &tls.ConnectionState{}
+	assert.True(strings.HasPrefix(blobURL(r, "actions#owner/key", "upload"), "https://cache.example.com/"))
+
+	r.TLS = nil
+	r.Header.Set("X-Forwarded-Proto", "https")
+	assert.True(strings.HasPrefix(blobURL(r, "actions#owner/key", "upload"), "https://cache.example.com/"))
+}
+
+func TestActionsCacheRequiresIdentity(t *testing.T) {
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	require.NoError(t, err)
+
+	h := NewActionsCacheHandler(&CacheServiceHandler{})
+
+	// requests are passed through without an identity to check the handlers reject them
+	mux := http.NewServeMux()
+	h.Mount(mux, withoutIdentity, withoutIdentity)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{name: "legacy lookup", method: http.MethodGet, path: ActionsCachePath + "cache?keys=a&version=v", status: http.StatusUnauthorized},
+		{name: "legacy reserve", method: http.MethodPost, path: ActionsCachePath + "caches", body: `{"key":"a","version":"v"}`, status: http.StatusUnauthorized},
+		{name: "twirp create", method: http.MethodPost, path: ActionsCacheTwirpPath + "CreateCacheEntry", body: `{"key":"a","version":"v"}`, status: http.StatusUnauthorized},
+		{name: "twirp unknown method", method: http.MethodPost, path: ActionsCacheTwirpPath + "DeleteEverything", body: `{}`, status: http.StatusUnauthorized},
+		{name: "legacy wrong method", method: http.MethodDelete, path: ActionsCachePath + "caches/1", status: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			mux.ServeHTTP(w, r)
+
+			assert.Equal(tt.status, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestActionsTwirpErrors(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	assert.NoError(err)
+
+	h := NewActionsCacheHandler(&CacheServiceHandler{})
+
+	identity := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(ciauth.WithOIDCIdentity(r.Context(), fakeIdentity{claims: &ciauth.GitHubActionsClaims{}})))
+		})
+	}
+
+	mux := http.NewServeMux()
+	h.Mount(mux, identity, func(next http.Handler) http.Handler { return next })
+
+	for _, tt := range []struct {
+		method string
+		body   string
+		code   string
+		status int
+	}{
+		{method: "DeleteEverything", body: `{}`, code: "bad_route", status: http.StatusNotFound},
+		{method: "CreateCacheEntry", body: `{"key":"../x","version":"v"}`, code: "invalid_argument", status: http.StatusBadRequest},
+		{method: "GetCacheEntryDownloadURL", body: `{"key":"a"}`, code: "invalid_argument", status: http.StatusBadRequest},
+		{method: "FinalizeCacheEntryUpload", body: `not json`, code: "invalid_argument", status: http.StatusBadRequest},
+	} {
+		r := httptest.NewRequest(http.MethodPost, ActionsCacheTwirpPath+tt.method, strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		mux.ServeHTTP(w, r)
+
+		assert.Equal(tt.status, w.Code, tt.method)
+
+		var twerr twirpError
+		assert.NoError(json.Unmarshal(w.Body.Bytes(), &twerr))
+		assert.Equal(tt.code, twerr.Code, tt.method)
+	}
+}
+
+func TestActionsCacheLegacyEntries(t *testing.T) {
+	assert := require.New(t)
+
+	cache, bucket, store := newTestCacheService(t, CacheConfig{DefaultBranch: "main"})
+	h := NewActionsCacheHandler(cache)
+
+	feature, main := http.NewServeMux(), http.NewServeMux()
+	h.Mount(feature, withWorkflowIdentity("feature"), withoutIdentity)
+	h.Mount(main, withWorkflowIdentity("main"), withoutIdentity)
+
+	cacheID := buildCacheKey("wolfeidau", ciauth.GitHubActions, actionsOperatingSystem, "wolfeidau%2Fzipstash@v1@feature", "go-mod")
+
+	w := serveActions(feature, http.MethodGet, ActionsCachePath+"cache?keys=go-mod&version=v1", "")
+	assert.Equal(http.StatusNoContent, w.Code)
+
+	w = serveActions(feature, http.MethodPost, ActionsCachePath+"caches", `{"key":"go-mod","version":"v1"}`)
+	assert.Equal(http.StatusCreated, w.Code, w.Body.String())
+
+	var reserved reserveCacheResponse
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &reserved))
+
+	id := strconv.FormatInt(reserved.CacheID, 10)
+
+	w = serveActions(feature, http.MethodPatch, ActionsCachePath+"caches/"+id, "hello", "Content-Range", "bytes 0-4/*")
+	assert.Equal(http.StatusNoContent, w.Code, w.Body.String())
+
+	w = serveActions(feature, http.MethodPatch, ActionsCachePath+"caches/"+id, " world", "Content-Range", "bytes 5-10/*")
+	assert.Equal(http.StatusNoContent, w.Code, w.Body.String())
+
+	// chunks are staged until the entry is committed
+	_, ok := bucket.get(cacheID)
+	assert.False(ok)
+	assert.Len(uploadKeys(bucket), 2)
+
+	// a commit with the wrong size leaves the upload in flight so it can be retried
+	w = serveActions(feature, http.MethodPost, ActionsCachePath+"caches/"+id, `{"size":12}`)
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	w = serveActions(feature, http.MethodPost, ActionsCachePath+"caches/"+id, `{"size":11}`)
+	assert.Equal(http.StatusNoContent, w.Code, w.Body.String())
+
+	data, ok := bucket.get(cacheID)
+	assert.True(ok)
+	assert.Equal("hello world", string(data))
+	assert.Empty(uploadKeys(bucket))
+
+	rec, err := store.GetCache(context.Background(), cacheID)
+	assert.NoError(err)
+	assert.Equal("feature", rec.Branch)
+	assert.Equal(int64(11), rec.FileSize)
+
+	// the upload is removed once committed
+	w = serveActions(feature, http.MethodPatch, ActionsCachePath+"caches/"+id, "evil", "Content-Range", "bytes 0-3/*")
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	w = serveActions(feature, http.MethodPost, ActionsCachePath+"caches", `{"key":"go-mod","version":"v1"}`)
+	assert.Equal(http.StatusConflict, w.Code)
+
+	w = serveActions(feature, http.MethodGet, ActionsCachePath+"cache?keys=go-&version=v1", "")
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+
+	var entry artifactCacheEntry
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &entry))
+	assert.Equal("go-mod", entry.CacheKey)
+	assert.Equal("feature", entry.Scope)
+
+	location, err := url.Parse(entry.ArchiveLocation)
+	assert.NoError(err)
+	assert.Equal("/"+fakeBucket+"/"+cacheID, location.Path)
+
+	// the default branch doesn't restore the entries of other branches
+	w = serveActions(main, http.MethodGet, ActionsCachePath+"cache?keys=go-mod&version=v1", "")
+	assert.Equal(http.StatusNoContent, w.Code)
+}
+
+func TestActionsCacheBlobEntries(t *testing.T) {
+	assert := require.New(t)
+
+	cache, bucket, _ := newTestCacheService(t, CacheConfig{DefaultBranch: "main"})
+	h := NewActionsCacheHandler(cache)
+
+	limit := func(next http.Handler) http.Handler { return http.MaxBytesHandler(next, 128) }
+
+	mux := http.NewServeMux()
+	h.Mount(mux, withWorkflowIdentity("main"), limit)
+
+	architecture := "wolfeidau%2Fzipstash@v1@main"
+
+	// create returns the url the archive is uploaded to with the blob api
+	create := func(key string) string {
+		w := serveActions(mux, http.MethodPost, ActionsCacheTwirpPath+"CreateCacheEntry", `{"key":"`+key+`","version":"v1"}`)
+		assert.Equal(http.StatusOK, w.Code, w.Body.String())
+
+		var res createCacheEntryResponse
+		assert.NoError(json.Unmarshal(w.Body.Bytes(), &res))
+		assert.True(res.Ok)
+
+		u, err := url.Parse(res.SignedUploadURL)
+		assert.NoError(err)
+
+		return u.RequestURI()
+	}
+
+	finalize := func(key string, size int) *httptest.ResponseRecorder {
+		return serveActions(mux, http.MethodPost, ActionsCacheTwirpPath+"FinalizeCacheEntryUpload", fmt.Sprintf(`{"key":"%s","version":"v1","size_bytes":"%d"}`, key, size))
+	}
+
+	t.Run("single upload", func(t *testing.T) {
+		cacheID := buildCacheKey("wolfeidau", ciauth.GitHubActions, actionsOperatingSystem, architecture, "go-mod")
+
+		upload := create("go-mod")
+
+		w := serveActions(mux, http.MethodPut, upload, "archive")
+		assert.Equal(http.StatusCreated, w.Code, w.Body.String())
+
+		// the archive is staged until the entry is finalized
+		_, ok := bucket.get(cacheID)
+		assert.False(ok)
+
+		w = finalize("go-mod", 7)
+		assert.Equal(http.StatusOK, w.Code, w.Body.String())
+
+		data, ok := bucket.get(cacheID)
+		assert.True(ok)
+		assert.Equal("archive", string(data))
+		assert.Empty(uploadKeys(bucket))
+
+		// the upload url can't replace the committed archive
+		w = serveActions(mux, http.MethodPut, upload, "evil")
+		assert.Equal(http.StatusNotFound, w.Code)
+
+		data, _ = bucket.get(cacheID)
+		assert.Equal("archive", string(data))
+
+		w = serveActions(mux, http.MethodPost, ActionsCacheTwirpPath+"GetCacheEntryDownloadURL", `{"key":"go-sum","restore_keys":["go-"],"version":"v1"}`)
+		assert.Equal(http.StatusOK, w.Code, w.Body.String())
+
+		var res getCacheEntryDownloadURLResponse
+		assert.NoError(json.Unmarshal(w.Body.Bytes(), &res))
+		assert.True(res.Ok)
+		assert.Equal("go-mod", res.MatchedKey)
+	})
+
+	t.Run("blocks", func(t *testing.T) {
+		cacheID := buildCacheKey("wolfeidau", ciauth.GitHubActions, actionsOperatingSystem, architecture, "node-modules")
+
+		upload := create("node-modules")
+
+		w := serveActions(mux, http.MethodPut, upload+"&comp=block&blockid=AAAA", "hello ")
+		assert.Equal(http.StatusCreated, w.Code, w.Body.String())
+
+		w = serveActions(mux, http.MethodPut, upload+"&comp=block&blockid=AAAB", "world")
+		assert.Equal(http.StatusCreated, w.Code, w.Body.String())
+
+		w = serveActions(mux, http.MethodPut, upload+"&comp=blocklist", `<?xml version="1.0" encoding="utf-8"?><BlockList><Latest>AAAA</Latest><Latest>AAAB</Latest></BlockList>`)
+		assert.Equal(http.StatusCreated, w.Code, w.Body.String())
+
+		_, ok := bucket.get(cacheID)
+		assert.False(ok)
+
+		// the size must match the committed blocks
+		w = finalize("node-modules", 12)
+		assert.Equal(http.StatusBadRequest, w.Code)
+
+		w = finalize("node-modules", 11)
+		assert.Equal(http.StatusOK, w.Code, w.Body.String())
+
+		data, ok := bucket.get(cacheID)
+		assert.True(ok)
+		assert.Equal("hello world", string(data))
+		assert.Empty(uploadKeys(bucket))
+	})
+
+	t.Run("upload limit", func(t *testing.T) {
+		upload := create("too-large")
+
+		w := serveActions(mux, http.MethodPut, upload, strings.Repeat("a", 129))
+		assert.Equal(http.StatusRequestEntityTooLarge, w.Code)
+		assert.Empty(uploadKeys(bucket))
+	})
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"connectrpc.com/connect"
+	"github.com/awslabs/aws-lambda-go-api-proxy/core"
+	"github.com/rs/zerolog/log"
+
+	"github.com/wolfeidau/zipstash/internal/audit"
+	"github.com/wolfeidau/zipstash/internal/ciauth"
+	"github.com/wolfeidau/zipstash/internal/index"
+)
+
+// newAuditEvent returns an event of the action by the identity of the request, the tenant defaults to the tenant of
+// the identity and is replaced by callers which act on another tenant. Requests without an OIDC identity such as
+// admin requests through api gateway are recorded with the IAM principal as the actor.
+func newAuditEvent(ctx context.Context, action string) audit.Event {
+	ev := audit.Event{
+		Action: action,
+	}
+
+	if identity := ciauth.GetOIDCIdentity(ctx); identity != nil {
+		ev.Tenant = index.TenantKey(identity.Provider(), identity.Owner())
+		ev.Subject = identity.Subject()
+		ev.Issuer = identity.Issuer()
+		ev.Claims = claimsMap(identity.Claims())
+	}
+
+	if apiCtx, ok := core.GetAPIGatewayV2ContextFromContext(ctx); ok && apiCtx.Authorizer != nil && apiCtx.Authorizer.IAM != nil {
+		ev.Actor = apiCtx.Authorizer.IAM.UserARN
+	}
+
+	return ev
+}
+
+// auditResult returns the result of an action which failed with the error, errors of the http apis are recorded
+// with the code matching their status so the results of each api can be queried in the same way.
+func auditResult(err error) string {
+	if err == nil {
+		return audit.ResultSuccess
+	}
+
+	var connectErr *connect.Error
+	if errors.As(err, &connectErr) {
+		return connectErr.Code().String()
+	}
+
+	switch httpStatus(err) {
+	case http.StatusUnauthorized:
+		return connect.CodeUnauthenticated.String()
+	case http.StatusNotFound:
+		return connect.CodeNotFound.String()
+	case http.StatusConflict:
+		return connect.CodeAlreadyExists.String()
+	case http.StatusBadRequest, http.StatusLengthRequired:
+		return connect.CodeInvalidArgument.String()
+	case http.StatusRequestEntityTooLarge:
+		return connect.CodeResourceExhausted.String()
+	default:
+		return connect.CodeInternal.String()
+	}
+}
+
+// claimsMap converts the claims of a provider to a map so they are stored with their json names.
+func claimsMap(claims any) map[string]any {
+	if claims == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(claims)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to marshal claims")
+		return nil
+	}
+
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		log.Warn().Err(err).Msg("failed to unmarshal claims")
+		return nil
+	}
+
+	return m
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/wolfeidau/zipstash/api/gen/proto/go/cache/v1"
+	providerv1 "github.com/wolfeidau/zipstash/api/gen/proto/go/provider/v1"
+	"github.com/wolfeidau/zipstash/internal/audit"
+	"github.com/wolfeidau/zipstash/internal/ciauth"
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+func TestNewAuditEvent(t *testing.T) {
+	assert := require.New(t)
+
+	ctx := ciauth.WithOIDCIdentity(context.Background(), fakeIdentity{claims: &ciauth.GitHubActionsClaims{
+		RunId:       "42",
+		Actor:       "wolfeidau",
+		WorkflowRef: "wolfeidau/zipstash/.github/workflows/ci.yml@refs/heads/main",
+	}})
+
+	ev := newAuditEvent(ctx, audit.ActionCacheCreate)
+	assert.Equal(audit.ActionCacheCreate, ev.Action)
+	assert.Equal("github_actions#wolfeidau", ev.Tenant)
+	assert.Equal("repo:wolfeidau/zipstash:ref:refs/heads/main", ev.Subject)
+	assert.Equal("https://token.actions.githubusercontent.com", ev.Issuer)
+	assert.Equal("42", ev.Claims["run_id"])
+	assert.Equal("wolfeidau", ev.Claims["actor"])
+	assert.Equal("wolfeidau/zipstash/.github/workflows/ci.yml@refs/heads/main", ev.Claims["workflow_ref"])
+
+	// requests without an identity such as admin requests have no tenant until the handler sets it
+	ev = newAuditEvent(context.Background(), audit.ActionTenantGet)
+	assert.Empty(ev.Tenant)
+	assert.Nil(ev.Claims)
+}
+
+func TestAuditResult(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal(audit.ResultSuccess, auditResult(nil))
+	assert.Equal("not_found", auditResult(connect.NewError(connect.CodeNotFound, errors.New("missing"))))
+	assert.Equal("internal", auditResult(errors.New("failed")))
+
+	// errors of the http apis are recorded with the code matching their status
+	assert.Equal("not_found", auditResult(errEntryNotFound))
+	assert.Equal("unauthenticated", auditResult(errUnauthenticated))
+	assert.Equal("invalid_argument", auditResult(fmt.Errorf("object: %w", errChecksumMismatch)))
+	assert.Equal("already_exists", auditResult(errEntryExists))
+	assert.Equal("resource_exhausted", auditResult(errTooLarge))
+}
+
+// memorySink holds the events written to it.
+type memorySink struct {
+	mu     sync.Mutex
+	events []audit.Event
+}
+
+func (m *memorySink) Write(_ context.Context, ev audit.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.events = append(m.events, ev)
+
+	return nil
+}
+
+func TestHTTPAPIsAudit(t *testing.T) {
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	require.NoError(t, err)
+
+	// only requests which are rejected before the index and bucket are used are covered, HEAD requests only check
+	// an entry exists so they aren't recorded as restores
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		action string
+		result string
+	}{
+		{name: "bazel get", method: http.MethodGet, path: BazelCASPath + "abc", action: audit.ActionCacheRestore, result: "invalid_argument"},
+		{name: "bazel head", method: http.MethodHead, path: BazelCASPath + "abc"},
+		{name: "bazel put", method: http.MethodPut, path: BazelCASPath + "abc", action: audit.ActionCacheCreate, result: "invalid_argument"},
+		{name: "gradle get", method: http.MethodGet, path: GradleCachePath + "a%2Fb", action: audit.ActionCacheRestore, result: "invalid_argument"},
+		{name: "gradle head", method: http.MethodHead, path: GradleCachePath + "a%2Fb"},
+		{name: "gradle put", method: http.MethodPut, path: GradleCachePath + "a%2Fb", action: audit.ActionCacheCreate, result: "invalid_argument"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			sink := &memorySink{}
+			cache := &CacheServiceHandler{audit: audit.NewRecorder(sink)}
+
+			mux := http.NewServeMux()
+			NewBazelCacheHandler(cache).Mount(mux, withFakeIdentity)
+			NewGradleCacheHandler(cache).Mount(mux, withFakeIdentity)
+
+			mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+
+			if tt.action == "" {
+				assert.Empty(sink.events)
+				return
+			}
+
+			assert.Len(sink.events, 1)
+			assert.Equal(tt.action, sink.events[0].Action)
+			assert.Equal(tt.result, sink.events[0].Result)
+			assert.Equal("github_actions#wolfeidau", sink.events[0].Tenant)
+			assert.Equal("42", sink.events[0].Claims["run_id"])
+		})
+	}
+}
+
+func TestPutObjectsAudit(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	assert.NoError(err)
+
+	sink := &memorySink{}
+	zs := &CacheServiceHandler{audit: audit.NewRecorder(sink)}
+
+	ctx := ciauth.WithOIDCIdentity(context.Background(), fakeIdentity{claims: &ciauth.GitHubActionsClaims{RunId: "42"}})
+
+	_, err = zs.PutObjects(ctx, connect.NewRequest(&v1.PutObjectsRequest{
+		ProviderType: providerv1.Provider_PROVIDER_GITHUB_ACTIONS,
+		Owner:        "other",
+		Namespace:    "sccache",
+	}))
+	assert.Equal(connect.CodePermissionDenied, connect.CodeOf(err))
+
+	// a request for another owner is recorded against the tenant of the token
+	assert.Len(sink.events, 1)
+	assert.Equal(audit.ActionCacheCreate, sink.events[0].Action)
+	assert.Equal("permission_denied", sink.events[0].Result)
+	assert.Equal("github_actions#wolfeidau", sink.events[0].Tenant)
+	assert.Equal("sccache", sink.events[0].Key)
+	assert.Equal("42", sink.events[0].Claims["run_id"])
+}
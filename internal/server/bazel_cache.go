@@ -0,0 +1,250 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/wolfeidau/zipstash/internal/audit"
+	"github.com/wolfeidau/zipstash/internal/ciauth"
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+const (
+	// BazelActionCachePath is the prefix of the bazel action cache, which maps the hash of an action to its
+	// serialized ActionResult.
+	BazelActionCachePath = "/ac/"
+	// BazelCASPath is the prefix of the bazel content addressable store, which maps the sha256 of a blob to the blob.
+	BazelCASPath = "/cas/"
+
+	// bazelPrefix is the prefix of the objects stored through the bazel apis, these aren't recorded in the cache
+	// index.
+	bazelPrefix = "_bazel/"
+
+	bazelActionCache = "ac"
+	bazelCAS         = "cas"
+)
+
+// BazelCacheHandler implements the bazel HTTP remote cache protocol on top of the cache bucket, bazel is pointed at
+// the server with --remote_cache and the OIDC token is passed with --remote_header.
+//
+// Entries are scoped by the owner and provider of the OIDC token so each tenant has its own cache. Blobs are
+// uploaded through the server so their sha256 can be verified, while downloads are redirected to presigned urls.
+type BazelCacheHandler struct {
+	cache *CacheServiceHandler
+	blobs *blobStore
+}
+
+// NewBazelCacheHandler creates a bazel cache handler using the index and bucket of the cache service.
+func NewBazelCacheHandler(cache *CacheServiceHandler) *BazelCacheHandler {
+	return &BazelCacheHandler{
+		cache: cache,
+		blobs: newBlobStore(cache.s3Client, cache.cfg.CacheBucket),
+	}
+}
+
+// Mount registers the bazel cache apis on the mux, these require an OIDC token which is validated by auth.
+func (h *BazelCacheHandler) Mount(mux *http.ServeMux, auth func(http.Handler) http.Handler) {
+	api := http.NewServeMux()
+
+	// GET patterns also match HEAD requests
+	api.HandleFunc("GET /{kind}/{hash}", h.get)
+	api.HandleFunc("PUT /{kind}/{hash}", h.put)
+
+	mux.Handle(BazelActionCachePath, auth(api))
+	mux.Handle(BazelCASPath, auth(api))
+}
+
+// bazelKey returns the key of the object holding an entry of the tenant.
+func bazelKey(owner, provider, kind, hash string) string {
+	return bazelPrefix + path.Join(owner, provider, kind, hash)
+}
+
+// validateBazelHash checks the hash is a hex encoded sha256 as sent by bazel.
+func validateBazelHash(hash string) error {
+	if len(hash) != 64 {
+		return fmt.Errorf("%w: invalid hash %q", errInvalidRequest, hash)
+	}
+
+	for _, c := range hash {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return fmt.Errorf("%w: invalid hash %q", errInvalidRequest, hash)
+		}
+	}
+
+	return nil
+}
+
+// entryKey validates the request and returns the key of the object it refers to.
+func (h *BazelCacheHandler) entryKey(ctx context.Context, r *http.Request) (string, error) {
+	identity := ciauth.GetOIDCIdentity(ctx)
+	if identity == nil {
+		return "", errUnauthenticated
+	}
+
+	kind, hash := r.PathValue("kind"), r.PathValue("hash")
+
+	if kind != bazelActionCache && kind != bazelCAS {
+		return "", fmt.Errorf("%w: invalid cache %q", errInvalidRequest, kind)
+	}
+
+	err := validateBazelHash(hash)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = h.cache.validateOwner(ctx, identity.Owner(), identity.Provider())
+	if err != nil {
+		return "", err
+	}
+
+	return bazelKey(identity.Owner(), identity.Provider(), kind, hash), nil
+}
+
+// get responds to HEAD requests with the size of the entry and redirects GET requests to a presigned url, bazel
+// treats a 404 as a cache miss. HEAD requests only check the entry exists so only GET requests are recorded as
+// restores.
+func (h *BazelCacheHandler) get(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.Start(r.Context(), "BazelCache.get")
+	defer span.End()
+
+	ev := newAuditEvent(ctx, audit.ActionCacheRestore)
+	ev.Key = r.PathValue("hash")
+
+	key, size, err := h.lookup(ctx, r)
+
+	var url string
+	if err == nil && r.Method == http.MethodGet {
+		url, err = h.cache.presigner.PresignDownload(ctx, key, size)
+	}
+
+	if r.Method == http.MethodGet {
+		ev.CacheID = key
+		ev.Result = auditResult(err)
+		h.cache.audit.Record(ctx, ev)
+	}
+
+	switch {
+	case errors.Is(err, errEntryNotFound):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		writeHTTPError(w, err)
+		return
+	}
+
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+}
+
+// lookup returns the key and size of an entry, errEntryNotFound is returned when it doesn't exist.
+func (h *BazelCacheHandler) lookup(ctx context.Context, r *http.Request) (string, int64, error) {
+	ctx, span := trace.Start(ctx, "BazelCache.lookup")
+	defer span.End()
+
+	key, err := h.entryKey(ctx, r)
+	if err != nil {
+		return "", 0, err
+	}
+
+	span.SetAttributes(attribute.String("key", key))
+
+	exists, head, err := h.cache.existsInS3(ctx, key)
+	if err != nil {
+		return key, 0, err
+	}
+
+	span.SetAttributes(attribute.Bool("exists", exists))
+
+	// the identity was checked when building the key
+	identity := ciauth.GetOIDCIdentity(ctx)
+
+	if !exists {
+		h.cache.metrics.recordLookup(ctx, identity.Owner(), identity.Provider(), lookupMiss)
+		return key, 0, errEntryNotFound
+	}
+
+	h.cache.metrics.recordLookup(ctx, identity.Owner(), identity.Provider(), lookupHit)
+
+	return key, aws.ToInt64(head.ContentLength), nil
+}
+
+// put stores an entry, blobs in the content addressable store must match their hash. Blobs which already exist
+// aren't uploaded again as their content is the same.
+func (h *BazelCacheHandler) put(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.Start(r.Context(), "BazelCache.put")
+	defer span.End()
+
+	err := h.store(ctx, r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// store saves the body of the request as the entry.
+func (h *BazelCacheHandler) store(ctx context.Context, r *http.Request) (err error) {
+	ctx, span := trace.Start(ctx, "BazelCache.store")
+	defer span.End()
+
+	ev := newAuditEvent(ctx, audit.ActionCacheCreate)
+	ev.Key = r.PathValue("hash")
+
+	defer func() {
+		ev.Result = auditResult(err)
+		h.cache.audit.Record(ctx, ev)
+	}()
+
+	key, err := h.entryKey(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	ev.CacheID = key
+
+	span.SetAttributes(attribute.String("key", key), attribute.Int64("size", r.ContentLength))
+
+	err = checkUploadSize(r)
+	if err != nil {
+		return err
+	}
+
+	var sha256sum string
+
+	if r.PathValue("kind") == bazelCAS {
+		sha256sum = r.PathValue("hash")
+		ev.Sha256 = sha256sum
+
+		exists, _, err := h.cache.existsInS3(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			return nil
+		}
+	}
+
+	err = h.blobs.put(ctx, key, r.Body, r.ContentLength, sha256sum)
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Str("key", key).Int64("size", r.ContentLength).Msg("bazel cache entry saved")
+
+	return nil
+}
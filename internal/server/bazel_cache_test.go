@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/wolfeidau/zipstash/internal/ciauth"
+)
+
+func TestValidateBazelHash(t *testing.T) {
+	tests := []struct {
+		name    string
+		hash    string
+		wantErr bool
+	}{
+		{name: "valid", hash: strings.Repeat("ab", 32)},
+		{name: "short", hash: "abc", wantErr: true},
+		{name: "upper case", hash: strings.Repeat("AB", 32), wantErr: true},
+		{name: "not hex", hash: strings.Repeat("zz", 32), wantErr: true},
+		{name: "traversal", hash: "../" + strings.Repeat("a", 61), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			err := validateBazelHash(tt.hash)
+			if tt.wantErr {
+				assert.ErrorIs(err, errInvalidRequest)
+				return
+			}
+			assert.NoError(err)
+		})
+	}
+}
+
+func TestBazelKey(t *testing.T) {
+	assert := require.New(t)
+
+	hash := strings.Repeat("ab", 32)
+	assert.Equal("_bazel/wolfeidau/github_actions/cas/"+hash, bazelKey("wolfeidau", ciauth.GitHubActions, bazelCAS, hash))
+}
+
+func TestBazelCacheRequests(t *testing.T) {
+	blob := []byte("blob")
+	sum := sha256.Sum256(blob)
+	hash := hex.EncodeToString(sum[:])
+
+	// objects of the tenant of the fake identity in the bucket before the request
+	stored := map[string][]byte{
+		bazelKey("wolfeidau", ciauth.GitHubActions, bazelCAS, hash): blob,
+	}
+
+	tests := []struct {
+		name     string
+		auth     func(http.Handler) http.Handler
+		method   string
+		path     string
+		body     []byte
+		stored   map[string][]byte
+		status   int
+		size     string
+		location string
+		saved    string
+	}{
+		{name: "no identity get", auth: withoutIdentity, method: http.MethodGet, path: BazelActionCachePath + hash, status: http.StatusUnauthorized},
+		{name: "no identity put", auth: withoutIdentity, method: http.MethodPut, path: BazelCASPath + hash, body: blob, status: http.StatusUnauthorized},
+		{name: "invalid hash", auth: withFakeIdentity, method: http.MethodGet, path: BazelCASPath + "abc", status: http.StatusBadRequest},
+		{name: "invalid method", auth: withFakeIdentity, method: http.MethodDelete, path: BazelCASPath + hash, status: http.StatusMethodNotAllowed},
+		{name: "unknown path", auth: withFakeIdentity, method: http.MethodGet, path: "/other/" + hash, status: http.StatusNotFound},
+		{name: "get miss", auth: withFakeIdentity, method: http.MethodGet, path: BazelCASPath + hash, status: http.StatusNotFound},
+		{name: "head miss", auth: withFakeIdentity, method: http.MethodHead, path: BazelActionCachePath + hash, stored: stored, status: http.StatusNotFound},
+		{name: "head", auth: withFakeIdentity, method: http.MethodHead, path: BazelCASPath + hash, stored: stored, status: http.StatusOK, size: "4"},
+		{name: "get redirects", auth: withFakeIdentity, method: http.MethodGet, path: BazelCASPath + hash, stored: stored, status: http.StatusTemporaryRedirect, location: "/cache/_bazel/wolfeidau/github_actions/cas/" + hash},
+		{name: "put blob", auth: withFakeIdentity, method: http.MethodPut, path: BazelCASPath + hash, body: blob, status: http.StatusOK, saved: "_bazel/wolfeidau/github_actions/cas/" + hash},
+		{name: "put blob not matching hash", auth: withFakeIdentity, method: http.MethodPut, path: BazelCASPath + hash, body: []byte("other"), status: http.StatusBadRequest},
+		{name: "put existing blob", auth: withFakeIdentity, method: http.MethodPut, path: BazelCASPath + hash, body: blob, stored: stored, status: http.StatusOK},
+		{name: "put action result", auth: withFakeIdentity, method: http.MethodPut, path: BazelActionCachePath + hash, body: []byte("result"), status: http.StatusOK, saved: "_bazel/wolfeidau/github_actions/ac/" + hash},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			cache, bucket, _ := newTestCacheService(t, CacheConfig{})
+			for key, data := range tt.stored {
+				bucket.put(key, data)
+			}
+
+			mux := http.NewServeMux()
+			NewBazelCacheHandler(cache).Mount(mux, tt.auth)
+
+			r := httptest.NewRequest(tt.method, tt.path, bytes.NewReader(tt.body))
+			w := httptest.NewRecorder()
+
+			mux.ServeHTTP(w, r)
+
+			assert.Equal(tt.status, w.Code, w.Body.String())
+			assert.Equal(tt.size, w.Header().Get("Content-Length"))
+
+			if tt.location != "" {
+				location, err := url.Parse(w.Header().Get("Location"))
+				assert.NoError(err)
+				assert.Equal(tt.location, location.Path)
+			}
+
+			// rejected requests don't write to the bucket
+			objects := len(tt.stored)
+			if tt.saved != "" {
+				saved, ok := bucket.get(tt.saved)
+				assert.True(ok)
+				assert.Equal(tt.body, saved)
+				objects++
+			}
+			assert.Len(bucket.keys(), objects)
+		})
+	}
+}
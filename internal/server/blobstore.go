@@ -0,0 +1,474 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+const (
+	// uploadsPrefix is the prefix of the objects holding the pieces of archives uploaded through the server, these
+	// are removed once assembled. Abandoned uploads, along with the objects of the bazel, registry and objects apis
+	// which aren't recorded in the index, are expired by the lifecycle rule of the bucket.
+	uploadsPrefix = "_uploads/"
+
+	// maxDeleteObjects is the most objects which can be deleted in a single request.
+	maxDeleteObjects = 1000
+
+	// minCopyPartSize is the minimum size of every part but the last of a multipart copy.
+	minCopyPartSize = 5 << 20
+	// streamPartSize is the size of the parts uploaded when sources too small to copy are read through the server.
+	streamPartSize = 8 << 20
+)
+
+var (
+	// errSizeMismatch is returned when the body of an upload doesn't match its declared size.
+	errSizeMismatch = errors.New("body size doesn't match the declared size")
+	// errObjectNotFound is returned when an object doesn't exist in the bucket.
+	errObjectNotFound = errors.New("object not found")
+)
+
+// blobStore reads and writes objects in the cache bucket on behalf of clients which upload through the server
+// rather than using presigned urls.
+type blobStore struct {
+	s3Client *s3.Client
+	bucket   string
+}
+
+func newBlobStore(s3Client *s3.Client, bucket string) *blobStore {
+	return &blobStore{s3Client: s3Client, bucket: bucket}
+}
+
+// put stores the body under the key, the body is spooled to a temp file so it can be verified against the
+// size, and the sha256 sum when it isn't empty, and retried by the s3 client. A negative size is used when the
+// size isn't known, the body is then limited to the largest single object.
+func (b *blobStore) put(ctx context.Context, key string, body io.Reader, size int64, sha256sum string) error {
+	ctx, span := trace.Start(ctx, "blobStore.put")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("key", key), attribute.Int64("size", size))
+
+	f, err := os.CreateTemp("", "zipstash-upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	hash := sha256.New()
+
+	limit := size
+	if size < 0 {
+		limit = maxHTTPUploadSize
+	}
+
+	n, err := io.Copy(io.MultiWriter(f, hash), io.LimitReader(body, limit+1))
+	if err != nil {
+		return fmt.Errorf("failed to read body: %w", err)
+	}
+
+	switch {
+	case size < 0 && n > limit:
+		return errTooLarge
+	case size < 0:
+		size = n
+	case n != size:
+		return errSizeMismatch
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(b.bucket),
+		Key:           aws.String(key),
+		Body:          f,
+		ContentLength: aws.Int64(size),
+	}
+
+	if sha256sum != "" {
+		if hex.EncodeToString(hash.Sum(nil)) != sha256sum {
+			return errChecksumMismatch
+		}
+
+		// s3 also verifies the checksum as the body is read again from the temp file
+		input.ChecksumSHA256 = aws.String(convertSha256ToBase64(sha256sum))
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("failed to seek temp file: %w", err)
+	}
+
+	_, err = b.s3Client.PutObject(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to put object: %w", err)
+	}
+
+	return nil
+}
+
+// putBytes stores a small object which is already held in memory.
+func (b *blobStore) putBytes(ctx context.Context, key string, data []byte, contentType string) error {
+	ctx, span := trace.Start(ctx, "blobStore.putBytes")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("key", key), attribute.Int("size", len(data)))
+
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(b.bucket),
+		Key:           aws.String(key),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+	}
+
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+
+	_, err := b.s3Client.PutObject(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to put object: %w", err)
+	}
+
+	return nil
+}
+
+// open returns the object, errObjectNotFound is returned when it doesn't exist. The caller closes the body.
+func (b *blobStore) open(ctx context.Context, key string) (*s3.GetObjectOutput, error) {
+	ctx, span := trace.Start(ctx, "blobStore.open")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("key", key))
+
+	res, err := b.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var nsk *types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return nil, errObjectNotFound
+		}
+		return nil, fmt.Errorf("failed to get object: %w", err)
+	}
+
+	return res, nil
+}
+
+// sha256sum reads the object and returns its hex encoded sha256 sum.
+func (b *blobStore) sha256sum(ctx context.Context, key string) (string, error) {
+	ctx, span := trace.Start(ctx, "blobStore.sha256sum")
+	defer span.End()
+
+	res, err := b.open(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	hash := sha256.New()
+
+	_, err = io.Copy(hash, res.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read object: %w", err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// list returns the objects with the prefix ordered by key.
+func (b *blobStore) list(ctx context.Context, prefix string) ([]types.Object, error) {
+	ctx, span := trace.Start(ctx, "blobStore.list")
+	defer span.End()
+
+	var objects []types.Object
+
+	paginator := s3.NewListObjectsV2Paginator(b.s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+
+		objects = append(objects, page.Contents...)
+	}
+
+	return objects, nil
+}
+
+// assemble concatenates the source objects into the destination. When every source except the last is at least
+// the minimum s3 part size the data is copied within s3 using a multipart upload so it isn't transferred through
+// the server again, otherwise the sources are read through the server and uploaded in parts.
+func (b *blobStore) assemble(ctx context.Context, dst string, srcs []string) error {
+	ctx, span := trace.Start(ctx, "blobStore.assemble")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("key", dst), attribute.Int("parts", len(srcs)))
+
+	if len(srcs) == 0 {
+		return errors.New("no parts to assemble")
+	}
+
+	if len(srcs) == 1 {
+		_, err := b.s3Client.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(b.bucket),
+			Key:        aws.String(dst),
+			CopySource: aws.String(b.bucket + "/" + srcs[0]),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to copy object: %w", err)
+		}
+
+		return nil
+	}
+
+	copyable, err := b.copyable(ctx, srcs)
+	if err != nil {
+		return err
+	}
+
+	span.SetAttributes(attribute.Bool("copyable", copyable))
+
+	if !copyable {
+		return b.assembleStream(ctx, dst, srcs)
+	}
+
+	createResp, err := b.s3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(dst),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+
+	parts := make([]types.CompletedPart, len(srcs))
+
+	for i, src := range srcs {
+		copyResp, err := b.s3Client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:     aws.String(b.bucket),
+			Key:        aws.String(dst),
+			UploadId:   createResp.UploadId,
+			PartNumber: aws.Int32(int32(i + 1)),
+			CopySource: aws.String(b.bucket + "/" + src),
+		})
+		if err != nil {
+			b.abort(ctx, dst, createResp.UploadId)
+			return fmt.Errorf("failed to copy part %d: %w", i+1, err)
+		}
+
+		parts[i] = types.CompletedPart{
+			ETag:       copyResp.CopyPartResult.ETag,
+			PartNumber: aws.Int32(int32(i + 1)),
+		}
+	}
+
+	_, err = b.s3Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(b.bucket),
+		Key:             aws.String(dst),
+		UploadId:        createResp.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		b.abort(ctx, dst, createResp.UploadId)
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+
+	return nil
+}
+
+// copyable reports whether the sources can be assembled with a multipart copy, every source but the last must
+// be at least the minimum part size.
+func (b *blobStore) copyable(ctx context.Context, srcs []string) (bool, error) {
+	for _, src := range srcs[:len(srcs)-1] {
+		head, err := b.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(b.bucket),
+			Key:    aws.String(src),
+		})
+		if err != nil {
+			var nf *types.NotFound
+			if errors.As(err, &nf) {
+				return false, errUploadIncomplete
+			}
+			return false, fmt.Errorf("failed to head object: %w", err)
+		}
+
+		if aws.ToInt64(head.ContentLength) < minCopyPartSize {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// assembleStream reads the sources in order and uploads them in parts of streamPartSize, only a single part is
+// held in memory.
+func (b *blobStore) assembleStream(ctx context.Context, dst string, srcs []string) error {
+	ctx, span := trace.Start(ctx, "blobStore.assembleStream")
+	defer span.End()
+
+	createResp, err := b.s3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(dst),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+
+	r := &objectsReader{ctx: ctx, blobs: b, keys: srcs}
+	defer r.Close()
+
+	buf := make([]byte, streamPartSize)
+
+	var parts []types.CompletedPart
+
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			b.abort(ctx, dst, createResp.UploadId)
+			return err
+		}
+
+		// the last part may be empty only when every source is, s3 needs at least one part
+		if n > 0 || len(parts) == 0 {
+			partNumber := aws.Int32(int32(len(parts) + 1))
+
+			partResp, perr := b.s3Client.UploadPart(ctx, &s3.UploadPartInput{
+				Bucket:        aws.String(b.bucket),
+				Key:           aws.String(dst),
+				UploadId:      createResp.UploadId,
+				PartNumber:    partNumber,
+				Body:          bytes.NewReader(buf[:n]),
+				ContentLength: aws.Int64(int64(n)),
+			})
+			if perr != nil {
+				b.abort(ctx, dst, createResp.UploadId)
+				return fmt.Errorf("failed to upload part %d: %w", len(parts)+1, perr)
+			}
+
+			parts = append(parts, types.CompletedPart{ETag: partResp.ETag, PartNumber: partNumber})
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	_, err = b.s3Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(b.bucket),
+		Key:             aws.String(dst),
+		UploadId:        createResp.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		b.abort(ctx, dst, createResp.UploadId)
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+
+	return nil
+}
+
+// objectsReader reads the objects one after another, each object is only opened once the previous one is read.
+type objectsReader struct {
+	ctx   context.Context
+	blobs *blobStore
+	keys  []string
+	cur   io.ReadCloser
+}
+
+func (r *objectsReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if len(r.keys) == 0 {
+				return 0, io.EOF
+			}
+
+			res, err := r.blobs.open(r.ctx, r.keys[0])
+			if errors.Is(err, errObjectNotFound) {
+				return 0, errUploadIncomplete
+			}
+			if err != nil {
+				return 0, err
+			}
+
+			r.cur, r.keys = res.Body, r.keys[1:]
+		}
+
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			_ = r.cur.Close()
+			r.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+
+		return n, err
+	}
+}
+
+func (r *objectsReader) Close() error {
+	if r.cur == nil {
+		return nil
+	}
+
+	return r.cur.Close()
+}
+
+func (b *blobStore) abort(ctx context.Context, key string, uploadID *string) {
+	_, err := b.s3Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(b.bucket),
+		Key:      aws.String(key),
+		UploadId: uploadID,
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("failed to abort multipart upload")
+	}
+}
+
+// removePrefix deletes the objects with the prefix, failures are logged as abandoned objects are removed by
+// the lifecycle rule of the bucket.
+func (b *blobStore) removePrefix(ctx context.Context, prefix string) {
+	ctx, span := trace.Start(ctx, "blobStore.removePrefix")
+	defer span.End()
+
+	objects, err := b.list(ctx, prefix)
+	if err != nil {
+		log.Warn().Err(err).Str("prefix", prefix).Msg("failed to list objects to remove")
+		return
+	}
+
+	for len(objects) > 0 {
+		batch := objects[:min(len(objects), maxDeleteObjects)]
+		objects = objects[len(batch):]
+
+		ids := make([]types.ObjectIdentifier, len(batch))
+		for i, obj := range batch {
+			ids[i] = types.ObjectIdentifier{Key: obj.Key}
+		}
+
+		_, err := b.s3Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(b.bucket),
+			Delete: &types.Delete{Objects: ids, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			log.Warn().Err(err).Str("prefix", prefix).Msg("failed to remove objects")
+			return
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+func TestBlobStoreAssemble(t *testing.T) {
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		sizes   []int
+		missing bool
+		wantErr error
+	}{
+		{name: "single block", sizes: []int{10}},
+		{name: "blocks large enough to copy", sizes: []int{minCopyPartSize, minCopyPartSize, 10}},
+		{name: "blocks too small to copy", sizes: []int{3 << 20, 3 << 20, 3 << 20, 10}},
+		{name: "small block between large blocks", sizes: []int{minCopyPartSize, 10, minCopyPartSize}},
+		{name: "empty blocks", sizes: []int{0, 0}},
+		{name: "missing block", sizes: []int{10, 10}, missing: true, wantErr: errUploadIncomplete},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			fake, client := newFakeS3(t)
+			blobs := newBlobStore(client, fakeBucket)
+
+			var want []byte
+			srcs := make([]string, len(tt.sizes))
+			for i, size := range tt.sizes {
+				data := bytes.Repeat([]byte{byte('a' + i)}, size)
+				srcs[i] = uploadsPrefix + "test/" + string(rune('a'+i))
+				want = append(want, data...)
+
+				if tt.missing && i == 0 {
+					continue
+				}
+				fake.put(srcs[i], data)
+			}
+
+			err := blobs.assemble(context.Background(), "dst", srcs)
+			if tt.wantErr != nil {
+				assert.ErrorIs(err, tt.wantErr)
+				return
+			}
+			assert.NoError(err)
+
+			got, ok := fake.get("dst")
+			assert.True(ok)
+			assert.True(bytes.Equal(want, got), "assembled object doesn't match the blocks")
+		})
+	}
+}
+
+func TestBlobStorePut(t *testing.T) {
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	require.NoError(t, err)
+
+	data := []byte("blob")
+	hash := sha256.Sum256(data)
+	sum := hex.EncodeToString(hash[:])
+
+	tests := []struct {
+		name      string
+		body      []byte
+		size      int64
+		sha256sum string
+		wantErr   error
+	}{
+		{name: "verified", body: data, size: 4, sha256sum: sum},
+		{name: "not verified", body: data, size: 4},
+		{name: "unknown size", body: data, size: -1, sha256sum: sum},
+		{name: "empty", body: nil, size: 0},
+		{name: "checksum mismatch", body: []byte("bolb"), size: 4, sha256sum: sum, wantErr: errChecksumMismatch},
+		{name: "shorter than size", body: data, size: 5, sha256sum: sum, wantErr: errSizeMismatch},
+		{name: "longer than size", body: data, size: 3, wantErr: errSizeMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			fake, client := newFakeS3(t)
+			blobs := newBlobStore(client, fakeBucket)
+
+			err := blobs.put(context.Background(), "dst", bytes.NewReader(tt.body), tt.size, tt.sha256sum)
+			if tt.wantErr != nil {
+				assert.ErrorIs(err, tt.wantErr)
+				assert.Empty(fake.keys())
+				return
+			}
+			assert.NoError(err)
+
+			got, ok := fake.get("dst")
+			assert.True(ok)
+			assert.True(bytes.Equal(tt.body, got))
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/wolfeidau/zipstash/internal/ciauth"
+	"github.com/wolfeidau/zipstash/internal/events"
+	"github.com/wolfeidau/zipstash/internal/index"
+)
+
+// publishEntry notifies the subscribers of the tenant of the entry that it was created or restored.
+func (zs *CacheServiceHandler) publishEntry(ctx context.Context, typ, cacheID string, rec index.CacheRecord, fallback bool) {
+	if zs.events == nil {
+		return
+	}
+
+	ev := newEntryEvent(typ, cacheID, rec, fallback)
+
+	if identity := ciauth.GetOIDCIdentity(ctx); identity != nil {
+		ev.Subject = identity.Subject()
+		ev.Issuer = identity.Issuer()
+		ev.Claims = claimsMap(identity.Claims())
+	}
+
+	if err := zs.events.Publish(ctx, ev); err != nil {
+		log.Warn().Err(err).Str("type", typ).Str("cacheID", cacheID).Msg("failed to publish event")
+	}
+}
+
+// newEntryEvent returns an event of the entry in the tenant of the record.
+func newEntryEvent(typ, cacheID string, rec index.CacheRecord, fallback bool) events.Event {
+	return events.Event{
+		Type:     typ,
+		Tenant:   index.TenantKey(rec.Provider, rec.Owner),
+		Key:      rec.Key,
+		CacheID:  cacheID,
+		Name:     rec.Name,
+		Branch:   rec.Branch,
+		Sha256:   rec.Sha256,
+		FileSize: rec.FileSize,
+		Fallback: fallback,
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/wolfeidau/zipstash/internal/ciauth"
+	"github.com/wolfeidau/zipstash/internal/events"
+	"github.com/wolfeidau/zipstash/internal/index"
+)
+
+type recordingPublisher struct {
+	events []events.Event
+}
+
+func (r *recordingPublisher) Publish(_ context.Context, ev events.Event) error {
+	r.events = append(r.events, ev)
+	return nil
+}
+
+func TestPublishEntry(t *testing.T) {
+	assert := require.New(t)
+
+	publisher := &recordingPublisher{}
+	zs := &CacheServiceHandler{events: publisher}
+
+	ctx := ciauth.WithOIDCIdentity(context.Background(), fakeIdentity{claims: &ciauth.GitHubActionsClaims{RefProtected: "false"}})
+
+	rec := index.CacheRecord{Key: "go-mod", Owner: "wolfeidau", Provider: ciauth.GitHubActions, Branch: "feature", Sha256: "abc", FileSize: 42}
+	zs.publishEntry(ctx, events.EntryCreated, "cache-id", rec, false)
+
+	assert.Len(publisher.events, 1)
+
+	ev := publisher.events[0]
+	assert.Equal(events.EntryCreated, ev.Type)
+	assert.Equal("github_actions#wolfeidau", ev.Tenant)
+	assert.Equal("cache-id", ev.CacheID)
+	assert.Equal("feature", ev.Branch)
+	assert.Equal(int64(42), ev.FileSize)
+	assert.Equal("repo:wolfeidau/zipstash:ref:refs/heads/main", ev.Subject)
+	assert.Equal("false", ev.Claims["ref_protected"])
+
+	// handlers without a publisher don't publish
+	(&CacheServiceHandler{}).publishEntry(ctx, events.EntryCreated, "cache-id", rec, false)
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+
+	awsevents "github.com/aws/aws-lambda-go/events"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/wolfeidau/zipstash/internal/events"
+	"github.com/wolfeidau/zipstash/internal/index"
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+// ttlPrincipal is the principal of the deletes made by the ttl of a dynamodb table.
+const ttlPrincipal = "dynamodb.amazonaws.com"
+
+// EvictionHandler publishes entry.evicted when entries expire from the cache index, it receives the records of the
+// stream of the index table.
+//
+// Only entries removed by the ttl of the table are published, in flight records and entries replaced or deleted by
+// the server are skipped. The archives of evicted entries are removed by the lifecycle rule of the bucket.
+type EvictionHandler struct {
+	events events.Publisher
+}
+
+// NewEvictionHandler creates an eviction handler publishing to the publisher.
+func NewEvictionHandler(publisher events.Publisher) *EvictionHandler {
+	return &EvictionHandler{events: publisher}
+}
+
+// Handle publishes the evictions in a batch of stream records, records which can't be decoded are logged and
+// skipped so they don't block the stream.
+func (h *EvictionHandler) Handle(ctx context.Context, batch awsevents.DynamoDBEvent) error {
+	ctx, span := trace.Start(ctx, "Evictions.Handle")
+	defer span.End()
+
+	span.SetAttributes(attribute.Int("records", len(batch.Records)))
+
+	if h.events == nil {
+		return nil
+	}
+
+	for _, record := range batch.Records {
+		if !expired(record) {
+			continue
+		}
+
+		cacheID, rec, ok, err := index.CacheRecordFromImage(record.Change.OldImage)
+		if err != nil {
+			log.Warn().Err(err).Str("eventID", record.EventID).Msg("failed to decode evicted record")
+			continue
+		}
+
+		// in flight records are stored under the id of their upload rather than the cache id
+		if !ok || cacheID != buildCacheKey(rec.Owner, rec.Provider, rec.OperatingSystem, rec.Architecture, rec.Key) {
+			continue
+		}
+
+		if err := h.events.Publish(ctx, newEntryEvent(events.EntryEvicted, cacheID, rec, false)); err != nil {
+			log.Warn().Err(err).Str("cacheID", cacheID).Msg("failed to publish event")
+		}
+	}
+
+	return nil
+}
+
+// expired reports whether the stream record is the removal of an item by the ttl of the table.
+func expired(record awsevents.DynamoDBEventRecord) bool {
+	return record.EventName == string(awsevents.DynamoDBOperationTypeRemove) &&
+		record.UserIdentity != nil &&
+		record.UserIdentity.Type == "Service" &&
+		record.UserIdentity.PrincipalID == ttlPrincipal
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	awsevents "github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wolfeidau/zipstash/internal/ciauth"
+	"github.com/wolfeidau/zipstash/internal/events"
+	"github.com/wolfeidau/zipstash/internal/index"
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+// streamImage returns the image of an item of the index table as it is sent in a stream record.
+func streamImage(t *testing.T, partition, name string, rec index.CacheRecord) map[string]awsevents.DynamoDBAttributeValue {
+	t.Helper()
+
+	payload, err := attributevalue.Marshal(rec)
+	require.NoError(t, err)
+
+	return map[string]awsevents.DynamoDBAttributeValue{
+		"id":      awsevents.NewStringAttribute(partition),
+		"name":    awsevents.NewStringAttribute(name),
+		"expires": awsevents.NewNumberAttribute("1700000000"),
+		"payload": toStreamAttributeValue(t, payload),
+	}
+}
+
+func toStreamAttributeValue(t *testing.T, av types.AttributeValue) awsevents.DynamoDBAttributeValue {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return awsevents.NewStringAttribute(v.Value)
+	case *types.AttributeValueMemberN:
+		return awsevents.NewNumberAttribute(v.Value)
+	case *types.AttributeValueMemberBOOL:
+		return awsevents.NewBooleanAttribute(v.Value)
+	case *types.AttributeValueMemberNULL:
+		return awsevents.NewNullAttribute()
+	case *types.AttributeValueMemberL:
+		list := make([]awsevents.DynamoDBAttributeValue, len(v.Value))
+		for i, item := range v.Value {
+			list[i] = toStreamAttributeValue(t, item)
+		}
+		return awsevents.NewListAttribute(list)
+	case *types.AttributeValueMemberM:
+		m := make(map[string]awsevents.DynamoDBAttributeValue, len(v.Value))
+		for k, item := range v.Value {
+			m[k] = toStreamAttributeValue(t, item)
+		}
+		return awsevents.NewMapAttribute(m)
+	default:
+		t.Fatalf("unsupported attribute %T", av)
+		return awsevents.DynamoDBAttributeValue{}
+	}
+}
+
+func TestEvictionHandler(t *testing.T) {
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	require.NoError(t, err)
+
+	rec := index.CacheRecord{
+		Key:             "go-mod",
+		Name:            "wolfeidau/zipstash",
+		Owner:           "wolfeidau",
+		Provider:        ciauth.GitHubActions,
+		Branch:          "main",
+		OperatingSystem: "linux",
+		Architecture:    "amd64",
+		Sha256:          "abc",
+		FileSize:        42,
+		UpdatedAt:       time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	cacheID := buildCacheKey(rec.Owner, rec.Provider, rec.OperatingSystem, rec.Architecture, rec.Key)
+
+	ttl := &awsevents.DynamoDBUserIdentity{Type: "Service", PrincipalID: ttlPrincipal}
+
+	tests := []struct {
+		name    string
+		record  awsevents.DynamoDBEventRecord
+		evicted bool
+	}{
+		{
+			name:    "expired by ttl",
+			record:  awsevents.DynamoDBEventRecord{EventName: "REMOVE", UserIdentity: ttl, Change: awsevents.DynamoDBStreamRecord{OldImage: streamImage(t, "cache", cacheID, rec)}},
+			evicted: true,
+		},
+		{
+			name:   "deleted by the server",
+			record: awsevents.DynamoDBEventRecord{EventName: "REMOVE", Change: awsevents.DynamoDBStreamRecord{OldImage: streamImage(t, "cache", cacheID, rec)}},
+		},
+		{
+			name:   "modified",
+			record: awsevents.DynamoDBEventRecord{EventName: "MODIFY", Change: awsevents.DynamoDBStreamRecord{OldImage: streamImage(t, "cache", cacheID, rec)}},
+		},
+		{
+			name:   "in flight record",
+			record: awsevents.DynamoDBEventRecord{EventName: "REMOVE", UserIdentity: ttl, Change: awsevents.DynamoDBStreamRecord{OldImage: streamImage(t, "cache", "actions#abc", rec)}},
+		},
+		{
+			name:   "not a cache record",
+			record: awsevents.DynamoDBEventRecord{EventName: "REMOVE", UserIdentity: ttl, Change: awsevents.DynamoDBStreamRecord{OldImage: streamImage(t, "audit#github_actions#wolfeidau", cacheID, rec)}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			publisher := &recordingPublisher{}
+
+			err := NewEvictionHandler(publisher).Handle(context.Background(), awsevents.DynamoDBEvent{
+				Records: []awsevents.DynamoDBEventRecord{tt.record},
+			})
+			assert.NoError(err)
+
+			if !tt.evicted {
+				assert.Empty(publisher.events)
+				return
+			}
+
+			assert.Len(publisher.events, 1)
+
+			ev := publisher.events[0]
+			assert.Equal(events.EntryEvicted, ev.Type)
+			assert.Equal("github_actions#wolfeidau", ev.Tenant)
+			assert.Equal(cacheID, ev.CacheID)
+			assert.Equal("go-mod", ev.Key)
+			assert.Equal("main", ev.Branch)
+			assert.Equal("abc", ev.Sha256)
+			assert.Equal(int64(42), ev.FileSize)
+		})
+	}
+}
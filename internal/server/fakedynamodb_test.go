@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wolfeidau/zipstash/internal/index"
+)
+
+const fakeTable = "cache-index"
+
+var (
+	setExpression      = regexp.MustCompile(`(#\d+) = (:\d+)`)
+	addExpression      = regexp.MustCompile(`ADD (#\d+) (:\d+)`)
+	keyEqualExpression = regexp.MustCompile(`\((#\d+) = (:\d+)\)`)
+	beginsWith         = regexp.MustCompile(`begins_with \((#\d+), (:\d+)\)`)
+)
+
+// fakeAttribute is an attribute value in the json encoding of the dynamodb api such as {"S": "main"}.
+type fakeAttribute = map[string]any
+
+// fakeDynamoDB is an in memory table implementing the subset of the dynamodb api used by the index store, it
+// supports the expressions built by dynastore rather than the full expression language.
+type fakeDynamoDB struct {
+	mu    sync.Mutex
+	items []map[string]fakeAttribute
+}
+
+// newFakeIndex returns an index store backed by an in memory table with the tenant of the fake identity.
+func newFakeIndex(t *testing.T) (*fakeDynamoDB, *index.Store) {
+	t.Helper()
+
+	f := &fakeDynamoDB{}
+
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	client := dynamodb.New(dynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(srv.URL),
+		Credentials:  aws.AnonymousCredentials{},
+	})
+
+	store := index.MustNewStore(context.Background(), index.StoreConfig{
+		GetDynamoDBClient: func() *dynamodb.Client { return client },
+		CacheIndexTable:   fakeTable,
+	})
+
+	err := store.PutTenant(context.Background(), "tenant-1", index.TenantRecord{
+		ID:           "tenant-1",
+		Owner:        "wolfeidau",
+		ProviderType: "github_actions",
+	})
+	require.NoError(t, err)
+
+	return f, store
+}
+
+func (f *fakeDynamoDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Key                       map[string]fakeAttribute
+		UpdateExpression          string
+		ConditionExpression       string
+		KeyConditionExpression    string
+		ExpressionAttributeNames  map[string]string
+		ExpressionAttributeValues map[string]fakeAttribute
+		ScanIndexForward          *bool
+		Limit                     int
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeDynamoDBError(w, "SerializationException")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.") {
+	case "GetItem":
+		resp := map[string]any{}
+		if i := f.find(req.Key); i >= 0 {
+			resp["Item"] = f.items[i]
+		}
+		writeDynamoDB(w, resp)
+	case "UpdateItem":
+		i := f.find(req.Key)
+		if !conditionHolds(req.ConditionExpression, i >= 0) {
+			writeDynamoDBError(w, "ConditionalCheckFailedException")
+			return
+		}
+
+		if i < 0 {
+			f.items = append(f.items, map[string]fakeAttribute{})
+			i = len(f.items) - 1
+		}
+
+		item := f.items[i]
+		for name, value := range req.Key {
+			item[name] = value
+		}
+
+		for _, m := range setExpression.FindAllStringSubmatch(req.UpdateExpression, -1) {
+			item[req.ExpressionAttributeNames[m[1]]] = req.ExpressionAttributeValues[m[2]]
+		}
+
+		for _, m := range addExpression.FindAllStringSubmatch(req.UpdateExpression, -1) {
+			name := req.ExpressionAttributeNames[m[1]]
+			current, _ := strconv.ParseInt(stringValue(item[name], "N"), 10, 64)
+			delta, _ := strconv.ParseInt(stringValue(req.ExpressionAttributeValues[m[2]], "N"), 10, 64)
+			item[name] = fakeAttribute{"N": strconv.FormatInt(current+delta, 10)}
+		}
+
+		writeDynamoDB(w, map[string]any{"Attributes": item})
+	case "DeleteItem":
+		i := f.find(req.Key)
+		if !conditionHolds(req.ConditionExpression, i >= 0) {
+			writeDynamoDBError(w, "ConditionalCheckFailedException")
+			return
+		}
+
+		if i >= 0 {
+			f.items = append(f.items[:i], f.items[i+1:]...)
+		}
+
+		writeDynamoDB(w, map[string]any{})
+	case "Query":
+		writeDynamoDB(w, map[string]any{"Items": f.query(req.KeyConditionExpression, req.ExpressionAttributeNames, req.ExpressionAttributeValues, req.ScanIndexForward, req.Limit)})
+	default:
+		writeDynamoDBError(w, "UnknownOperationException")
+	}
+}
+
+// find returns the index of the item with the key or -1 when it doesn't exist.
+func (f *fakeDynamoDB) find(key map[string]fakeAttribute) int {
+	for i, item := range f.items {
+		if stringValue(item["id"], "S") == stringValue(key["id"], "S") && stringValue(item["name"], "S") == stringValue(key["name"], "S") {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// query returns the items matching the partition key and sort key prefix of a key condition ordered by the sort key.
+func (f *fakeDynamoDB) query(condition string, names map[string]string, values map[string]fakeAttribute, forward *bool, limit int) []map[string]fakeAttribute {
+	eq := keyEqualExpression.FindStringSubmatch(condition)
+	prefix := beginsWith.FindStringSubmatch(condition)
+	if eq == nil || prefix == nil {
+		return nil
+	}
+
+	partitionKey, sortKey := names[eq[1]], names[prefix[1]]
+
+	var items []map[string]fakeAttribute
+	for _, item := range f.items {
+		if stringValue(item[partitionKey], "S") == stringValue(values[eq[2]], "S") &&
+			strings.HasPrefix(stringValue(item[sortKey], "S"), stringValue(values[prefix[2]], "S")) {
+			items = append(items, item)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		less := stringValue(items[i][sortKey], "S") < stringValue(items[j][sortKey], "S")
+		if forward != nil && !*forward {
+			return !less
+		}
+		return less
+	})
+
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+
+	return items
+}
+
+// conditionHolds checks the existence conditions used by dynastore for creates, updates and deletes.
+func conditionHolds(condition string, exists bool) bool {
+	switch {
+	case strings.Contains(condition, "attribute_not_exists"):
+		return !exists
+	case strings.Contains(condition, "attribute_exists"):
+		return exists
+	default:
+		return true
+	}
+}
+
+func stringValue(av fakeAttribute, typ string) string {
+	s, _ := av[typ].(string)
+	return s
+}
+
+func writeDynamoDB(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeDynamoDBError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]string{"__type": "com.amazonaws.dynamodb.v20120810#" + code, "message": code})
+}
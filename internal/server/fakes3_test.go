@@ -0,0 +1,342 @@
+package server
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const fakeBucket = "cache"
+
+// fakeS3 is an in memory bucket implementing the subset of the s3 api used by the handlers, it enforces the
+// minimum part size of multipart uploads and verifies sha256 checksums as s3 does.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	// contentTypes holds the content type of objects put with one
+	contentTypes map[string]string
+	uploads      map[string]map[int32][]byte
+	nextID       int
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *s3.Client) {
+	t.Helper()
+
+	f := &fakeS3{objects: map[string][]byte{}, contentTypes: map[string]string{}, uploads: map[string]map[int32][]byte{}}
+
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	client := s3.New(s3.Options{
+		Region:                     "us-east-1",
+		UsePathStyle:               true,
+		BaseEndpoint:               aws.String(srv.URL),
+		Credentials:                credentials.NewStaticCredentialsProvider("key", "secret", ""), // presigning needs credentials
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+		ResponseChecksumValidation: aws.ResponseChecksumValidationWhenRequired,
+	})
+
+	return f, client
+}
+
+func (f *fakeS3) put(key string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.objects[key] = data
+}
+
+func (f *fakeS3) get(key string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, ok := f.objects[key]
+	return data, ok
+}
+
+func (f *fakeS3) delete(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.objects, key)
+}
+
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != fakeBucket {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	q := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		f.list(w, q.Get("prefix"))
+	case r.Method == http.MethodPost && key == "" && q.Has("delete"):
+		f.deleteObjects(w, r)
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		f.nextID++
+		uploadID := strconv.Itoa(f.nextID)
+		f.uploads[uploadID] = map[int32][]byte{}
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: bucket, Key: key, UploadId: uploadID})
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		f.complete(w, r, key, q.Get("uploadId"))
+	case r.Method == http.MethodPut && q.Has("uploadId"):
+		f.uploadPart(w, r, q)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		data, ok := f.source(r)
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		f.objects[key] = data
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CopyObjectResult"`
+			ETag    string
+		}{ETag: etag(data)})
+	case r.Method == http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		if !checksumValid(r, body) {
+			writeS3Error(w, http.StatusBadRequest, "BadDigest")
+			return
+		}
+		f.objects[key] = body
+		f.contentTypes[key] = r.Header.Get("Content-Type")
+		w.Header().Set("ETag", etag(body))
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("ETag", etag(data))
+		if contentType := f.contentTypes[key]; contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	case r.Method == http.MethodDelete && q.Has("uploadId"):
+		delete(f.uploads, q.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (f *fakeS3) source(r *http.Request) ([]byte, bool) {
+	src, err := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		return nil, false
+	}
+
+	_, key, _ := strings.Cut(strings.TrimPrefix(src, "/"), "/")
+	data, ok := f.objects[key]
+
+	return data, ok
+}
+
+func (f *fakeS3) uploadPart(w http.ResponseWriter, r *http.Request, q url.Values) {
+	parts, ok := f.uploads[q.Get("uploadId")]
+	if !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+
+	partNumber, err := strconv.Atoi(q.Get("partNumber"))
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+
+	if r.Header.Get("X-Amz-Copy-Source") != "" {
+		data, ok := f.source(r)
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		parts[int32(partNumber)] = data
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CopyPartResult"`
+			ETag    string
+		}{ETag: etag(data)})
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+
+	parts[int32(partNumber)] = body
+	w.Header().Set("ETag", etag(body))
+}
+
+func (f *fakeS3) complete(w http.ResponseWriter, r *http.Request, key, uploadID string) {
+	parts, ok := f.uploads[uploadID]
+	if !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+
+	var req struct {
+		Parts []struct {
+			PartNumber int32
+		} `xml:"Part"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Parts) == 0 {
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+
+	var buf bytes.Buffer
+	for i, part := range req.Parts {
+		data, ok := parts[part.PartNumber]
+		if !ok {
+			writeS3Error(w, http.StatusBadRequest, "InvalidPart")
+			return
+		}
+		// every part but the last must be at least 5 MiB
+		if i < len(req.Parts)-1 && len(data) < minCopyPartSize {
+			writeS3Error(w, http.StatusBadRequest, "EntityTooSmall")
+			return
+		}
+		buf.Write(data)
+	}
+
+	delete(f.uploads, uploadID)
+	f.objects[key] = buf.Bytes()
+
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Key     string
+		ETag    string
+	}{Key: key, ETag: etag(buf.Bytes())})
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix string) {
+	type content struct {
+		Key  string
+		Size int64
+	}
+
+	res := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		IsTruncated bool
+		Contents    []content
+	}{Name: fakeBucket, Prefix: prefix}
+
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		res.Contents = append(res.Contents, content{Key: key, Size: int64(len(f.objects[key]))})
+	}
+	res.KeyCount = len(res.Contents)
+
+	writeXML(w, res)
+}
+
+func (f *fakeS3) deleteObjects(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Objects []struct {
+			Key string
+		} `xml:"Object"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+
+	for _, obj := range req.Objects {
+		delete(f.objects, obj.Key)
+	}
+
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"DeleteResult"`
+	}{})
+}
+
+// checksumValid verifies the sha256 checksum of a put when the client sends one.
+func checksumValid(r *http.Request, body []byte) bool {
+	want := r.Header.Get("X-Amz-Checksum-Sha256")
+	if want == "" {
+		return true
+	}
+
+	sum := sha256.Sum256(body)
+
+	return base64.StdEncoding.EncodeToString(sum[:]) == want
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func writeXML(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
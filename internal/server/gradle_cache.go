@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+const (
+	// GradleCachePath is the prefix of the gradle http build cache, gradle is pointed at the server by setting the
+	// url of a remote HttpBuildCache to this path.
+	GradleCachePath = "/cache/"
+
+	gradleProtocol = "gradle"
+)
+
+// GradleCacheHandler implements the gradle http build cache protocol. Gradle can only send basic auth, so the OIDC
+// token is passed as the password and the user name is ignored.
+type GradleCacheHandler struct {
+	cache *httpCache
+}
+
+// NewGradleCacheHandler creates a gradle cache handler using the index and bucket of the cache service.
+func NewGradleCacheHandler(cache *CacheServiceHandler) *GradleCacheHandler {
+	return &GradleCacheHandler{cache: newHTTPCache(cache, gradleProtocol)}
+}
+
+// Mount registers the gradle cache api on the mux, this requires an OIDC token which is validated by auth.
+func (h *GradleCacheHandler) Mount(mux *http.ServeMux, auth func(http.Handler) http.Handler) {
+	api := http.NewServeMux()
+
+	// GET patterns also match HEAD requests
+	api.HandleFunc("GET "+GradleCachePath+"{key}", h.get)
+	api.HandleFunc("PUT "+GradleCachePath+"{key}", h.put)
+
+	mux.Handle(GradleCachePath, basicAuthAsBearer(auth(api)))
+}
+
+func (h *GradleCacheHandler) get(w http.ResponseWriter, r *http.Request) {
+	h.cache.serve(w, r, defaultNamespace, r.PathValue("key"))
+}
+
+// put stores an entry, gradle treats a 413 as the entry being too large to cache rather than an error.
+func (h *GradleCacheHandler) put(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.Start(r.Context(), "GradleCache.put")
+	defer span.End()
+
+	_, err := h.cache.store(ctx, r, defaultNamespace, r.PathValue("key"))
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
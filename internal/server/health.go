@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	HealthPath    = "/healthz"
+	ReadinessPath = "/readyz"
+	VersionPath   = "/version"
+
+	// readinessTimeout bounds the checks of a readiness probe, probes are typically given a few seconds.
+	readinessTimeout = 5 * time.Second
+
+	// drainPollInterval is how often the requests in flight are checked while draining.
+	drainPollInterval = 50 * time.Millisecond
+)
+
+// ReadinessCheck is a dependency which must be available before the server receives traffic.
+type ReadinessCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// HealthHandler serves the probes and version of the server, these are unauthenticated so load balancers and
+// orchestrators can use them. It also tracks in flight requests so they can be drained on shutdown.
+type HealthHandler struct {
+	version  string
+	features []string
+	checks   []ReadinessCheck
+	draining atomic.Bool
+	inflight atomic.Int64
+}
+
+// NewHealthHandler creates a health handler reporting the version and features of the server.
+func NewHealthHandler(version string, features []string, checks ...ReadinessCheck) *HealthHandler {
+	return &HealthHandler{
+		version:  version,
+		features: features,
+		checks:   checks,
+	}
+}
+
+// Mount registers the probes on the mux.
+func (h *HealthHandler) Mount(mux *http.ServeMux) {
+	mux.HandleFunc("GET "+HealthPath, h.healthz)
+	mux.HandleFunc("GET "+ReadinessPath, h.readyz)
+	mux.HandleFunc("GET "+VersionPath, h.versionz)
+}
+
+// healthz reports the process is running, this doesn't check dependencies so a failing dependency doesn't cause
+// the server to be restarted.
+func (h *HealthHandler) healthz(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+type readinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// readyz runs the readiness checks concurrently, the server isn't ready while it is draining. The probe is
+// unauthenticated so only the status of each check is returned, the errors are logged.
+func (h *HealthHandler) readyz(w http.ResponseWriter, r *http.Request) {
+	if h.draining.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, readinessResponse{Status: "draining"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	results := make([]string, len(h.checks))
+
+	var g errgroup.Group
+	for i, check := range h.checks {
+		g.Go(func() error {
+			err := check.Check(ctx)
+			if err != nil {
+				log.Warn().Err(err).Str("check", check.Name).Msg("readiness check failed")
+				results[i] = "unavailable"
+				return err
+			}
+
+			results[i] = "ok"
+
+			return nil
+		})
+	}
+
+	res := readinessResponse{Status: "ready", Checks: make(map[string]string, len(h.checks))}
+	status := http.StatusOK
+
+	if err := g.Wait(); err != nil {
+		res.Status, status = "unavailable", http.StatusServiceUnavailable
+	}
+
+	for i, check := range h.checks {
+		res.Checks[check.Name] = results[i]
+	}
+
+	writeJSON(w, status, res)
+}
+
+type versionResponse struct {
+	Version  string   `json:"version"`
+	Features []string `json:"features"`
+}
+
+func (h *HealthHandler) versionz(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, versionResponse{Version: h.version, Features: h.features})
+}
+
+// Track counts the requests in flight so they can be waited for by Wait.
+func (h *HealthHandler) Track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.inflight.Add(1)
+		defer h.inflight.Add(-1)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Drain marks the server as not ready so it is removed from load balancers while shutting down.
+func (h *HealthHandler) Drain() {
+	h.draining.Store(true)
+}
+
+// Wait waits for the requests in flight to complete or the context to be done.
+func (h *HealthHandler) Wait(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for h.inflight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthHandler(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	failing := func(context.Context) error { return errors.New("access denied") }
+
+	tests := []struct {
+		name       string
+		checks     []ReadinessCheck
+		draining   bool
+		path       string
+		status     int
+		wantStatus string
+		wantChecks map[string]string
+	}{
+		{name: "healthz", checks: []ReadinessCheck{{Name: "index", Check: failing}}, path: HealthPath, status: http.StatusOK, wantStatus: "ok"},
+		{name: "ready", checks: []ReadinessCheck{{Name: "index", Check: ok}, {Name: "bucket", Check: ok}}, path: ReadinessPath, status: http.StatusOK, wantStatus: "ready", wantChecks: map[string]string{"index": "ok", "bucket": "ok"}},
+		{name: "not ready", checks: []ReadinessCheck{{Name: "index", Check: ok}, {Name: "bucket", Check: failing}}, path: ReadinessPath, status: http.StatusServiceUnavailable, wantStatus: "unavailable", wantChecks: map[string]string{"index": "ok", "bucket": "unavailable"}},
+		{name: "draining", checks: []ReadinessCheck{{Name: "index", Check: ok}}, draining: true, path: ReadinessPath, status: http.StatusServiceUnavailable, wantStatus: "draining"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			h := NewHealthHandler("1.2.3", []string{"cache"}, tt.checks...)
+			if tt.draining {
+				h.Drain()
+			}
+
+			mux := http.NewServeMux()
+			h.Mount(mux)
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(tt.status, w.Code)
+
+			var res readinessResponse
+			assert.NoError(json.Unmarshal(w.Body.Bytes(), &res))
+			assert.Equal(tt.wantStatus, res.Status)
+			assert.Equal(tt.wantChecks, res.Checks)
+		})
+	}
+}
+
+func TestHealthHandlerVersion(t *testing.T) {
+	assert := require.New(t)
+
+	mux := http.NewServeMux()
+	NewHealthHandler("1.2.3", []string{"cache", "bazel"}).Mount(mux)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, VersionPath, nil))
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.JSONEq(`{"version":"1.2.3","features":["cache","bazel"]}`, w.Body.String())
+}
+
+func TestHealthHandlerWait(t *testing.T) {
+	assert := require.New(t)
+
+	h := NewHealthHandler("1.2.3", nil)
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(h.Track(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})))
+	defer srv.Close()
+
+	go func() {
+		resp, err := http.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	assert.Eventually(func() bool { return h.inflight.Load() == 1 }, time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	assert.ErrorIs(h.Wait(ctx), context.DeadlineExceeded)
+
+	close(release)
+
+	assert.NoError(h.Wait(context.Background()))
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/wolfeidau/zipstash/internal/audit"
+	"github.com/wolfeidau/zipstash/internal/ciauth"
+	"github.com/wolfeidau/zipstash/internal/events"
+	"github.com/wolfeidau/zipstash/internal/index"
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+const (
+	// defaultNamespace is used for entries of protocols which don't send a namespace.
+	defaultNamespace = "default"
+
+	maxHTTPCacheKeyLength = 256
+)
+
+// httpCache stores entries for the simple key value build cache protocols. Entries are recorded in the cache index
+// with the ttl of the tenant and stored in the bucket under their cache id, so they expire and are scoped in the
+// same way as entries saved with the cache service.
+type httpCache struct {
+	cache *CacheServiceHandler
+	blobs *blobStore
+	// protocol is recorded as the operating system of entries to keep the entries of each protocol apart.
+	protocol string
+}
+
+func newHTTPCache(cache *CacheServiceHandler, protocol string) *httpCache {
+	return &httpCache{
+		cache:    cache,
+		blobs:    newBlobStore(cache.s3Client, cache.cfg.CacheBucket),
+		protocol: protocol,
+	}
+}
+
+// validateHTTPCacheKey checks a key or namespace sent by a client can be used as a segment of a cache id, the
+// protocols use hex encoded hashes so keys are limited to a conservative set of characters.
+func validateHTTPCacheKey(key string) error {
+	if key == "" || key == "." || key == ".." || len(key) > maxHTTPCacheKeyLength {
+		return fmt.Errorf("%w: invalid key %q", errInvalidRequest, key)
+	}
+
+	for _, c := range key {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return fmt.Errorf("%w: invalid key %q", errInvalidRequest, key)
+		}
+	}
+
+	return nil
+}
+
+// cacheID returns the id of the entry, the namespace is recorded in place of the architecture.
+func (c *httpCache) cacheID(scope requestScope, namespace, key string) string {
+	return buildCacheKey(scope.owner, scope.provider, c.protocol, escapeValue(namespace), key)
+}
+
+// request validates the identity, namespace and key of a request and returns the scope and cache id.
+func (c *httpCache) request(ctx context.Context, namespace, key string) (requestScope, string, error) {
+	scope, err := newRequestScope(ciauth.GetOIDCIdentity(ctx))
+	if err != nil {
+		return requestScope{}, "", err
+	}
+
+	err = validateHTTPCacheKey(namespace)
+	if err != nil {
+		return requestScope{}, "", err
+	}
+
+	err = validateHTTPCacheKey(key)
+	if err != nil {
+		return requestScope{}, "", err
+	}
+
+	return scope, c.cacheID(scope, namespace, key), nil
+}
+
+// serve responds to HEAD requests with the size of the entry and redirects GET requests to a presigned url, a
+// 404 is returned when the entry doesn't exist. HEAD requests only check the entry exists so only GET requests
+// are recorded as restores.
+func (c *httpCache) serve(w http.ResponseWriter, r *http.Request, namespace, key string) {
+	ctx, span := trace.Start(r.Context(), "HTTPCache.serve")
+	defer span.End()
+
+	ev := newAuditEvent(ctx, audit.ActionCacheRestore)
+	ev.Key = key
+
+	cacheID, rec, size, err := c.lookup(ctx, namespace, key)
+
+	var url string
+	if err == nil && r.Method == http.MethodGet {
+		url, err = c.cache.presigner.PresignDownload(ctx, cacheID, size)
+	}
+
+	if r.Method == http.MethodGet {
+		ev.CacheID = cacheID
+		ev.Branch = rec.Branch
+		ev.Result = auditResult(err)
+		c.cache.audit.Record(ctx, ev)
+	}
+
+	switch {
+	case errors.Is(err, errEntryNotFound):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		writeHTTPError(w, err)
+		return
+	}
+
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	c.cache.publishEntry(ctx, events.EntryRestored, cacheID, rec, false)
+
+	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+}
+
+// lookup returns the cache id, record and size of an entry, errEntryNotFound is returned when it doesn't exist.
+func (c *httpCache) lookup(ctx context.Context, namespace, key string) (string, index.CacheRecord, int64, error) {
+	ctx, span := trace.Start(ctx, "HTTPCache.lookup")
+	defer span.End()
+
+	scope, cacheID, err := c.request(ctx, namespace, key)
+	if err != nil {
+		return "", index.CacheRecord{}, 0, err
+	}
+
+	span.SetAttributes(attribute.String("protocol", c.protocol), attribute.String("cache_id", cacheID))
+
+	_, err = c.cache.validateOwner(ctx, scope.owner, scope.provider)
+	if err != nil {
+		return cacheID, index.CacheRecord{}, 0, err
+	}
+
+	exists, rec, err := c.cache.store.ExistsCache(ctx, cacheID)
+	if err != nil {
+		return cacheID, index.CacheRecord{}, 0, fmt.Errorf("failed to get cache entry: %w", err)
+	}
+
+	// the index may briefly reference an object which has expired from the bucket
+	var size int64
+	if exists {
+		found, head, err := c.cache.existsInS3(ctx, cacheID)
+		if err != nil {
+			return cacheID, index.CacheRecord{}, 0, err
+		}
+
+		exists = found
+		if found {
+			size = aws.ToInt64(head.ContentLength)
+		}
+	}
+
+	span.SetAttributes(attribute.Bool("exists", exists))
+
+	if !exists {
+		c.cache.metrics.recordLookup(ctx, scope.owner, scope.provider, lookupMiss)
+		return cacheID, index.CacheRecord{}, 0, errEntryNotFound
+	}
+
+	c.cache.metrics.recordLookup(ctx, scope.owner, scope.provider, lookupHit)
+
+	return cacheID, rec, size, nil
+}
+
+// store saves the body of the request as the entry and records it in the index, an existing entry is replaced.
+func (c *httpCache) store(ctx context.Context, r *http.Request, namespace, key string) (_ string, err error) {
+	ctx, span := trace.Start(ctx, "HTTPCache.store")
+	defer span.End()
+
+	ev := newAuditEvent(ctx, audit.ActionCacheCreate)
+	ev.Key = key
+
+	defer func() {
+		ev.Result = auditResult(err)
+		c.cache.audit.Record(ctx, ev)
+	}()
+
+	scope, cacheID, err := c.request(ctx, namespace, key)
+	if err != nil {
+		return "", err
+	}
+
+	ev.CacheID = cacheID
+	ev.Branch = scope.branch
+
+	span.SetAttributes(
+		attribute.String("protocol", c.protocol),
+		attribute.String("cache_id", cacheID),
+		attribute.Int64("size", r.ContentLength),
+	)
+
+	err = checkUploadSize(r)
+	if err != nil {
+		return "", err
+	}
+
+	tenant, err := c.cache.validateOwner(ctx, scope.owner, scope.provider)
+	if err != nil {
+		return "", err
+	}
+
+	err = c.blobs.put(ctx, cacheID, r.Body, r.ContentLength, "")
+	if err != nil {
+		return "", err
+	}
+
+	rec := index.CacheRecord{
+		Key:             key,
+		Name:            scope.name,
+		Branch:          scope.branch,
+		Owner:           scope.owner,
+		Provider:        scope.provider,
+		OperatingSystem: c.protocol,
+		Architecture:    escapeValue(namespace),
+		FileSize:        r.ContentLength,
+		TTL:             tenantTTL(tenant),
+		UpdatedAt:       time.Now(),
+	}
+
+	if identity := ciauth.GetOIDCIdentity(ctx); identity != nil {
+		rec.Identity = &index.Identity{
+			Subject: identity.Subject(),
+			Issuer:  identity.Issuer(),
+		}
+	}
+
+	err = c.cache.store.PutCache(ctx, cacheID, createdValue(rec), rec, rec.TTL)
+	if err != nil {
+		return "", fmt.Errorf("failed to put cache entry: %w", err)
+	}
+
+	log.Debug().Str("protocol", c.protocol).Str("cacheID", cacheID).Int64("size", r.ContentLength).Msg("cache entry saved")
+
+	c.cache.publishEntry(ctx, events.EntryCreated, cacheID, rec, false)
+
+	return cacheID, nil
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/wolfeidau/zipstash/internal/ciauth"
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+func TestValidateHTTPCacheKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{name: "hash", key: "d41d8cd98f00b204e9800998ecf8427e"},
+		{name: "team", key: "team_abc-123"},
+		{name: "empty", key: "", wantErr: true},
+		{name: "dot", key: ".", wantErr: true},
+		{name: "dot dot", key: "..", wantErr: true},
+		{name: "slash", key: "a/b", wantErr: true},
+		{name: "escaped", key: "a%2Fb", wantErr: true},
+		{name: "too long", key: strings.Repeat("a", maxHTTPCacheKeyLength+1), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			err := validateHTTPCacheKey(tt.key)
+			if tt.wantErr {
+				assert.ErrorIs(err, errInvalidRequest)
+				return
+			}
+			assert.NoError(err)
+		})
+	}
+}
+
+func TestHTTPCacheID(t *testing.T) {
+	assert := require.New(t)
+
+	c := &httpCache{protocol: turboProtocol}
+	scope := requestScope{owner: "wolfeidau", provider: ciauth.GitHubActions}
+
+	assert.Equal("wolfeidau/github_actions/turbo/team_abc/0a1b2c", c.cacheID(scope, "team_abc", "0a1b2c"))
+}
+
+func TestTurboNamespace(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "", want: defaultNamespace},
+		{query: "?teamId=team_abc", want: "team_abc"},
+		{query: "?slug=frontend", want: "frontend"},
+		{query: "?teamId=team_abc&slug=frontend", want: "team_abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			assert := require.New(t)
+
+			r := httptest.NewRequest(http.MethodGet, TurboArtifactsPath+"abc"+tt.query, nil)
+			assert.Equal(tt.want, turboNamespace(r))
+		})
+	}
+}
+
+func TestBasicAuthAsBearer(t *testing.T) {
+	assert := require.New(t)
+
+	var got string
+	h := basicAuthAsBearer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, GradleCachePath+"abc", nil)
+	r.SetBasicAuth("gradle", "token123")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal("Bearer token123", got)
+
+	r = httptest.NewRequest(http.MethodGet, GradleCachePath+"abc", nil)
+	r.Header.Set("Authorization", "Bearer token456")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal("Bearer token456", got)
+}
+
+func TestHTTPCacheRequests(t *testing.T) {
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		auth   func(http.Handler) http.Handler
+		method string
+		path   string
+		status int
+	}{
+		{name: "gradle no identity", auth: withoutIdentity, method: http.MethodGet, path: GradleCachePath + "abc", status: http.StatusUnauthorized},
+		{name: "gradle invalid key", auth: withFakeIdentity, method: http.MethodGet, path: GradleCachePath + "a%2Fb", status: http.StatusBadRequest},
+		{name: "gradle invalid method", auth: withFakeIdentity, method: http.MethodDelete, path: GradleCachePath + "abc", status: http.StatusMethodNotAllowed},
+		{name: "turbo no identity", auth: withoutIdentity, method: http.MethodPut, path: TurboArtifactsPath + "abc", status: http.StatusUnauthorized},
+		{name: "turbo invalid team", auth: withFakeIdentity, method: http.MethodGet, path: TurboArtifactsPath + "abc?teamId=..", status: http.StatusBadRequest},
+		{name: "turbo status", auth: withFakeIdentity, method: http.MethodGet, path: TurboArtifactsPath + "status", status: http.StatusOK},
+		{name: "turbo events", auth: withFakeIdentity, method: http.MethodPost, path: TurboArtifactsPath + "events", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			mux := http.NewServeMux()
+			NewGradleCacheHandler(&CacheServiceHandler{}).Mount(mux, tt.auth)
+			NewTurboCacheHandler(&CacheServiceHandler{}).Mount(mux, tt.auth)
+
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			w := httptest.NewRecorder()
+
+			mux.ServeHTTP(w, r)
+
+			assert.Equal(tt.status, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestHTTPCacheEntries(t *testing.T) {
+	assert := require.New(t)
+
+	cache, bucket, store := newTestCacheService(t, CacheConfig{})
+
+	mux := http.NewServeMux()
+	NewGradleCacheHandler(cache).Mount(mux, withFakeIdentity)
+	NewTurboCacheHandler(cache).Mount(mux, withFakeIdentity)
+
+	gradleID := "wolfeidau/github_actions/gradle/default/abc"
+	turboID := "wolfeidau/github_actions/turbo/team_a/abc"
+
+	// the requests are sent in order to the same cache
+	steps := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		status   int
+		size     string
+		location string
+	}{
+		{name: "gradle miss", method: http.MethodGet, path: GradleCachePath + "abc", status: http.StatusNotFound},
+		{name: "gradle put", method: http.MethodPut, path: GradleCachePath + "abc", body: "gradle entry", status: http.StatusCreated},
+		{name: "gradle head", method: http.MethodHead, path: GradleCachePath + "abc", status: http.StatusOK, size: "12"},
+		{name: "gradle get", method: http.MethodGet, path: GradleCachePath + "abc", status: http.StatusTemporaryRedirect, location: "/cache/" + gradleID},
+		{name: "turbo put", method: http.MethodPut, path: TurboArtifactsPath + "abc?teamId=team_a", body: "turbo entry", status: http.StatusAccepted},
+		{name: "turbo other team", method: http.MethodGet, path: TurboArtifactsPath + "abc?teamId=team_b", status: http.StatusNotFound},
+		{name: "turbo get", method: http.MethodGet, path: TurboArtifactsPath + "abc?teamId=team_a", status: http.StatusTemporaryRedirect, location: "/cache/" + turboID},
+	}
+
+	for _, step := range steps {
+		r := httptest.NewRequest(step.method, step.path, strings.NewReader(step.body))
+		w := httptest.NewRecorder()
+
+		mux.ServeHTTP(w, r)
+
+		assert.Equal(step.status, w.Code, "%s: %s", step.name, w.Body.String())
+		assert.Equal(step.size, w.Header().Get("Content-Length"), step.name)
+
+		if step.location != "" {
+			location, err := url.Parse(w.Header().Get("Location"))
+			assert.NoError(err)
+			assert.Equal(step.location, location.Path, step.name)
+		}
+	}
+
+	for cacheID, body := range map[string]string{gradleID: "gradle entry", turboID: "turbo entry"} {
+		data, ok := bucket.get(cacheID)
+		assert.True(ok, cacheID)
+		assert.Equal(body, string(data))
+
+		rec, err := store.GetCache(context.Background(), cacheID)
+		assert.NoError(err)
+		assert.Equal("abc", rec.Key)
+		assert.Equal(int64(len(body)), rec.FileSize)
+	}
+
+	// an entry is a miss once its object expires from the bucket even though the index still refers to it
+	bucket.delete(gradleID)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, GradleCachePath+"abc", nil))
+	assert.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestHTTPCacheLengthRequired(t *testing.T) {
+	assert := require.New(t)
+
+	cache, bucket, _ := newTestCacheService(t, CacheConfig{})
+
+	mux := http.NewServeMux()
+	NewGradleCacheHandler(cache).Mount(mux, withFakeIdentity)
+
+	// the body is spooled and verified against its declared length so chunked uploads are rejected
+	r := httptest.NewRequest(http.MethodPut, GradleCachePath+"abc", strings.NewReader("entry"))
+	r.ContentLength = -1
+	w := httptest.NewRecorder()
+
+	mux.ServeHTTP(w, r)
+
+	assert.Equal(http.StatusLengthRequired, w.Code)
+	assert.Empty(bucket.keys())
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"connectrpc.com/connect"
+	"github.com/rs/zerolog/log"
+
+	"github.com/wolfeidau/zipstash/internal/ciauth"
+)
+
+// errors shared by the http apis which are served alongside the connect services.
+var (
+	errUnauthenticated  = errors.New("unauthenticated")
+	errInvalidRequest   = errors.New("invalid request")
+	errChecksumMismatch = errors.New("body doesn't match the checksum")
+	errLengthRequired   = errors.New("content length required")
+	errTooLarge         = errors.New("body too large")
+	errEntryNotFound    = errors.New("cache entry not found")
+)
+
+// maxHTTPUploadSize is the largest body accepted by the http apis which store an upload as a single object, this
+// is the limit of a single s3 put.
+const maxHTTPUploadSize = 5 << 30
+
+// requestScope identifies who is saving or restoring entries through the http apis, this is taken from the OIDC
+// token as these protocols don't send the repository or branch.
+type requestScope struct {
+	owner    string
+	provider string
+	name     string
+	branch   string
+	// baseBranch is the branch a pull request targets, entries of it can be restored by the pull request.
+	baseBranch string
+}
+
+func newRequestScope(identity ciauth.OIDCIdentity) (requestScope, error) {
+	if identity == nil {
+		return requestScope{}, errUnauthenticated
+	}
+
+	scope := requestScope{
+		owner:    identity.Owner(),
+		provider: identity.Provider(),
+	}
+
+	switch claims := identity.Claims().(type) {
+	case *ciauth.GitHubActionsClaims:
+		scope.name = claims.Repository
+		scope.branch = strings.TrimPrefix(claims.Ref, "refs/heads/")
+		scope.baseBranch = claims.BaseRef
+	case *ciauth.BuildkiteClaims:
+		scope.name = claims.PipelineSlug
+		scope.branch = claims.BuildBranch
+	}
+
+	return scope, nil
+}
+
+// httpStatus returns the http status for an error returned by the handlers of the http apis.
+func httpStatus(err error) int {
+	switch {
+	case errors.Is(err, errUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, errEntryExists):
+		return http.StatusConflict
+	case errors.Is(err, errUploadNotFound), errors.Is(err, errEntryNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errUploadIncomplete), errors.Is(err, errSizeMismatch), errors.Is(err, errInvalidRequest),
+		errors.Is(err, errChecksumMismatch), errors.Is(err, errDigestInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, errLengthRequired):
+		return http.StatusLengthRequired
+	case errors.Is(err, errTooLarge), errors.As(err, new(*http.MaxBytesError)):
+		// the body limits of routes are enforced by the server with http.MaxBytesReader
+		return http.StatusRequestEntityTooLarge
+	}
+
+	switch connect.CodeOf(err) {
+	case connect.CodePermissionDenied:
+		return http.StatusForbidden
+	case connect.CodeNotFound:
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeHTTPError(w http.ResponseWriter, err error) {
+	status := httpStatus(err)
+	if status == http.StatusInternalServerError {
+		log.Error().Err(err).Msg("http api request failed")
+		http.Error(w, "internal error", status)
+		return
+	}
+
+	http.Error(w, err.Error(), status)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to write response")
+	}
+}
+
+// checkUploadSize checks the request declares the size of its body and that it can be stored as a single object.
+func checkUploadSize(r *http.Request) error {
+	if r.ContentLength < 0 {
+		return errLengthRequired
+	}
+
+	if r.ContentLength > maxHTTPUploadSize {
+		return errTooLarge
+	}
+
+	return nil
+}
+
+// basicAuthAsBearer passes the password of basic auth credentials on as a bearer token, this supports clients
+// such as gradle which can only send basic auth. The user name is ignored.
+func basicAuthAsBearer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, password, ok := r.BasicAuth(); ok {
+			r = r.Clone(r.Context())
+			r.Header.Set("Authorization", "Bearer "+password)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/wolfeidau/zipstash/pkg/metrics"
+)
+
+// results of looking up a cache entry.
+const (
+	lookupHit      = "hit"
+	lookupMiss     = "miss"
+	lookupFallback = "fallback"
+)
+
+// directions of presigned transfers.
+const (
+	directionUpload   = "upload"
+	directionDownload = "download"
+)
+
+// cacheMetrics records the lookups and transfers of the cache service, the request counts and latencies of each
+// rpc are recorded by the otel interceptor. The methods do nothing on a nil receiver so handlers constructed in
+// tests don't need metrics.
+type cacheMetrics struct {
+	lookups          metric.Int64Counter
+	presignedBytes   metric.Int64Counter
+	multipartUploads metric.Int64UpDownCounter
+}
+
+func newCacheMetrics() *cacheMetrics {
+	meter := metrics.Meter()
+
+	// the instruments returned with an error are noops so metrics failing doesn't stop the server
+	lookups, err := meter.Int64Counter("zipstash.cache.lookups",
+		metric.WithDescription("cache entry lookups by tenant and result"),
+		metric.WithUnit("{lookup}"),
+	)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to create lookups counter")
+	}
+
+	presignedBytes, err := meter.Int64Counter("zipstash.presigned.bytes",
+		metric.WithDescription("bytes presigned for upload and download"),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to create presigned bytes counter")
+	}
+
+	multipartUploads, err := meter.Int64UpDownCounter("zipstash.multipart.uploads",
+		metric.WithDescription("multipart uploads started and not yet completed by the process, abandoned uploads aren't subtracted"),
+		metric.WithUnit("{upload}"),
+	)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to create multipart uploads counter")
+	}
+
+	return &cacheMetrics{
+		lookups:          lookups,
+		presignedBytes:   presignedBytes,
+		multipartUploads: multipartUploads,
+	}
+}
+
+// lookupResult returns the result of a lookup which found an entry.
+func lookupResult(fallback bool) string {
+	if fallback {
+		return lookupFallback
+	}
+
+	return lookupHit
+}
+
+func (m *cacheMetrics) recordLookup(ctx context.Context, owner, provider, result string) {
+	if m == nil {
+		return
+	}
+
+	m.lookups.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("owner", owner),
+		attribute.String("provider", provider),
+		attribute.String("result", result),
+	))
+}
+
+func (m *cacheMetrics) recordPresigned(ctx context.Context, direction string, size int64) {
+	if m == nil {
+		return
+	}
+
+	m.presignedBytes.Add(ctx, size, metric.WithAttributes(attribute.String("direction", direction)))
+}
+
+func (m *cacheMetrics) multipartStarted(ctx context.Context) {
+	if m == nil {
+		return
+	}
+
+	m.multipartUploads.Add(ctx, 1)
+}
+
+func (m *cacheMetrics) multipartFinished(ctx context.Context) {
+	if m == nil {
+		return
+	}
+
+	m.multipartUploads.Add(ctx, -1)
+}
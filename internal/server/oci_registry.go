@@ -0,0 +1,838 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/wolfeidau/zipstash/internal/audit"
+	"github.com/wolfeidau/zipstash/internal/ciauth"
+	"github.com/wolfeidau/zipstash/internal/index"
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+const (
+	// OCIRegistryPath is the prefix of the oci distribution api, buildkit stores its cache in the server with
+	// --cache-to type=registry,ref=<server>/<name>:<tag> after logging in with the OIDC token as the password.
+	OCIRegistryPath = "/v2/"
+
+	// ociPrefix is the prefix of the blobs and manifests stored through the registry, these are shared by the
+	// repositories of a tenant and aren't recorded in the index, unlike the tags which refer to them.
+	ociPrefix = "_oci/"
+
+	// ociOperatingSystem is recorded as the operating system of the tags of repositories.
+	ociOperatingSystem = "oci"
+
+	maxManifestSize = 4 << 20
+	maxTagLength    = 128
+)
+
+// manifestMediaTypes are the media types of the manifests accepted by the registry, these are returned as the
+// content type of the manifest so other types aren't stored.
+var manifestMediaTypes = map[string]bool{
+	"application/vnd.oci.image.manifest.v1+json":                true,
+	"application/vnd.oci.image.index.v1+json":                   true,
+	"application/vnd.docker.distribution.manifest.v2+json":      true,
+	"application/vnd.docker.distribution.manifest.list.v2+json": true,
+}
+
+var (
+	errBlobUnknown     = errors.New("blob unknown to registry")
+	errManifestUnknown = errors.New("manifest unknown")
+	errManifestInvalid = errors.New("manifest invalid")
+	errDigestInvalid   = errors.New("provided digest did not match uploaded content")
+	errRangeInvalid    = errors.New("invalid content range")
+	errNameUnknown     = errors.New("repository name not known to registry")
+	errUnsupported     = errors.New("the operation is unsupported")
+)
+
+// OCIRegistryHandler implements the subset of the oci distribution api used to push and pull images, which is
+// enough for buildkit to use the server as a registry cache.
+//
+// Blobs and manifests are content addressed and shared by the repositories of the tenant of the OIDC token,
+// while tags are recorded in the cache index with the ttl of the tenant, capped at the retention of the bucket.
+type OCIRegistryHandler struct {
+	cache *CacheServiceHandler
+	blobs *blobStore
+}
+
+// NewOCIRegistryHandler creates a registry handler using the index and bucket of the cache service.
+func NewOCIRegistryHandler(cache *CacheServiceHandler) *OCIRegistryHandler {
+	return &OCIRegistryHandler{
+		cache: cache,
+		blobs: newBlobStore(cache.s3Client, cache.cfg.CacheBucket),
+	}
+}
+
+// Mount registers the registry api on the mux, this requires an OIDC token which is validated by auth. Registry
+// clients only send basic auth so the token is passed as the password.
+func (h *OCIRegistryHandler) Mount(mux *http.ServeMux, auth func(http.Handler) http.Handler) {
+	mux.Handle(OCIRegistryPath, ociChallenge(basicAuthAsBearer(auth(http.HandlerFunc(h.serve)))))
+}
+
+// ociChallenge adds the challenge registry clients need to send credentials to unauthorized responses.
+func ociChallenge(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+
+		if r.Header.Get("Authorization") == "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="zipstash"`)
+			writeOCIError(w, errUnauthenticated)
+			return
+		}
+
+		next.ServeHTTP(&challengeWriter{ResponseWriter: w}, r)
+	})
+}
+
+type challengeWriter struct {
+	http.ResponseWriter
+}
+
+func (c *challengeWriter) WriteHeader(status int) {
+	if status == http.StatusUnauthorized {
+		c.Header().Set("WWW-Authenticate", `Basic realm="zipstash"`)
+	}
+
+	c.ResponseWriter.WriteHeader(status)
+}
+
+// ociRoute is a parsed registry request path, repository names may contain slashes so the path is split on the
+// last api segment.
+type ociRoute struct {
+	name      string
+	kind      string
+	reference string
+}
+
+const (
+	ociBlobs     = "blobs"
+	ociUploads   = "uploads"
+	ociManifests = "manifests"
+)
+
+func parseOCIPath(p string) (ociRoute, bool) {
+	rest := strings.TrimPrefix(p, OCIRegistryPath)
+
+	// uploads are also started without the trailing slash
+	if name, ok := strings.CutSuffix(rest, "/blobs/uploads"); ok && name != "" {
+		return ociRoute{name: name, kind: ociUploads}, true
+	}
+
+	for _, route := range []struct {
+		segment string
+		kind    string
+	}{
+		{segment: "/blobs/uploads/", kind: ociUploads},
+		{segment: "/blobs/", kind: ociBlobs},
+		{segment: "/manifests/", kind: ociManifests},
+	} {
+		if i := strings.LastIndex(rest, route.segment); i > 0 {
+			return ociRoute{name: rest[:i], kind: route.kind, reference: rest[i+len(route.segment):]}, true
+		}
+	}
+
+	return ociRoute{}, false
+}
+
+// parseDigest returns the hex encoded sum of a sha256 digest, the only algorithm supported.
+func parseDigest(digest string) (string, error) {
+	sum, ok := strings.CutPrefix(digest, "sha256:")
+	if !ok || len(sum) != 64 {
+		return "", fmt.Errorf("%w: unsupported digest %q", errDigestInvalid, digest)
+	}
+
+	for _, c := range sum {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return "", fmt.Errorf("%w: invalid digest %q", errDigestInvalid, digest)
+		}
+	}
+
+	return sum, nil
+}
+
+func validateTag(tag string) error {
+	if len(tag) > maxTagLength {
+		return fmt.Errorf("%w: invalid tag %q", errInvalidRequest, tag)
+	}
+
+	return validateHTTPCacheKey(tag)
+}
+
+// ociTenant is the scope of a registry request.
+type ociTenant struct {
+	scope  requestScope
+	tenant index.TenantRecord
+}
+
+func (t ociTenant) prefix() string {
+	return ociPrefix + path.Join(t.scope.owner, t.scope.provider) + "/"
+}
+
+func (t ociTenant) blobKey(sum string) string {
+	return t.prefix() + "blobs/sha256/" + sum
+}
+
+func (t ociTenant) manifestKey(sum string) string {
+	return t.prefix() + "manifests/sha256/" + sum
+}
+
+func (t ociTenant) tagID(name, tag string) string {
+	return buildCacheKey(t.scope.owner, t.scope.provider, ociOperatingSystem, escapeValue(name), tag)
+}
+
+// uploadMarkerKey is the key of the object recording the tenant which started an upload.
+func uploadMarkerKey(uploadID string) string {
+	return uploadPrefix(uploadID) + "tenant"
+}
+
+func (h *OCIRegistryHandler) tenant(ctx context.Context, name string) (ociTenant, error) {
+	scope, err := newRequestScope(ciauth.GetOIDCIdentity(ctx))
+	if err != nil {
+		return ociTenant{}, err
+	}
+
+	err = validateObjectKey(name)
+	if err != nil {
+		return ociTenant{}, err
+	}
+
+	tenant, err := h.cache.validateOwner(ctx, scope.owner, scope.provider)
+	if err != nil {
+		return ociTenant{}, err
+	}
+
+	return ociTenant{scope: scope, tenant: tenant}, nil
+}
+
+func (h *OCIRegistryHandler) serve(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.Start(r.Context(), "OCIRegistry.serve")
+	defer span.End()
+
+	// clients check the api is supported and their credentials are valid with the base path
+	if r.URL.Path == OCIRegistryPath {
+		if ciauth.GetOIDCIdentity(ctx) == nil {
+			writeOCIError(w, errUnauthenticated)
+			return
+		}
+		writeJSON(w, http.StatusOK, struct{}{})
+		return
+	}
+
+	route, ok := parseOCIPath(r.URL.Path)
+	if !ok {
+		writeOCIError(w, fmt.Errorf("%w: unknown path %q", errNameUnknown, r.URL.Path))
+		return
+	}
+
+	span.SetAttributes(
+		attribute.String("name", route.name),
+		attribute.String("kind", route.kind),
+		attribute.String("reference", route.reference),
+	)
+
+	t, err := h.tenant(ctx, route.name)
+	if err != nil {
+		writeOCIError(w, err)
+		return
+	}
+
+	switch {
+	case route.kind == ociBlobs && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		err = h.getBlob(ctx, w, r, t, route)
+	case route.kind == ociUploads && r.Method == http.MethodPost:
+		err = h.startUpload(ctx, w, r, t, route)
+	case route.kind == ociUploads && r.Method == http.MethodPatch:
+		err = h.uploadChunk(ctx, w, r, t, route)
+	case route.kind == ociUploads && r.Method == http.MethodPut:
+		err = h.finishUpload(ctx, w, r, t, route)
+	case route.kind == ociUploads && r.Method == http.MethodGet:
+		err = h.uploadStatus(ctx, w, r, t, route)
+	case route.kind == ociUploads && r.Method == http.MethodDelete:
+		err = h.cancelUpload(ctx, w, t, route)
+	case route.kind == ociManifests && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		err = h.getManifest(ctx, w, r, t, route)
+	case route.kind == ociManifests && r.Method == http.MethodPut:
+		err = h.putManifest(ctx, w, r, t, route)
+	default:
+		err = errUnsupported
+	}
+
+	if err != nil {
+		writeOCIError(w, err)
+	}
+}
+
+func (h *OCIRegistryHandler) getBlob(ctx context.Context, w http.ResponseWriter, r *http.Request, t ociTenant, route ociRoute) error {
+	sum, err := parseDigest(route.reference)
+	if err != nil {
+		return err
+	}
+
+	key := t.blobKey(sum)
+
+	exists, head, err := h.cache.existsInS3(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return errBlobUnknown
+	}
+
+	w.Header().Set("Docker-Content-Digest", route.reference)
+
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.FormatInt(aws.ToInt64(head.ContentLength), 10))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	url, err := h.cache.presigner.PresignDownload(ctx, key, aws.ToInt64(head.ContentLength))
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+
+	return nil
+}
+
+// startUpload starts an upload, or stores the blob when it is sent in a single request with its digest. A mount
+// of a blob the tenant already has succeeds immediately as blobs are shared by the repositories of a tenant.
+func (h *OCIRegistryHandler) startUpload(ctx context.Context, w http.ResponseWriter, r *http.Request, t ociTenant, route ociRoute) error {
+	query := r.URL.Query()
+
+	if mount := query.Get("mount"); mount != "" {
+		sum, err := parseDigest(mount)
+		if err != nil {
+			return err
+		}
+
+		exists, _, err := h.cache.existsInS3(ctx, t.blobKey(sum))
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			writeBlobCreated(w, route.name, mount)
+			return nil
+		}
+	}
+
+	if digest := query.Get("digest"); digest != "" {
+		sum, err := parseDigest(digest)
+		if err != nil {
+			return err
+		}
+
+		err = h.blobs.put(ctx, t.blobKey(sum), r.Body, r.ContentLength, sum)
+		if err != nil {
+			return err
+		}
+
+		writeBlobCreated(w, route.name, digest)
+		return nil
+	}
+
+	uploadID := uuid.New().String()
+
+	err := h.blobs.putBytes(ctx, uploadMarkerKey(uploadID), []byte(index.TenantKey(t.scope.provider, t.scope.owner)), "")
+	if err != nil {
+		return err
+	}
+
+	writeUploadStatus(w, http.StatusAccepted, route.name, uploadID, 0)
+
+	return nil
+}
+
+// upload checks the upload was started by the tenant and returns the chunks uploaded so far.
+func (h *OCIRegistryHandler) upload(ctx context.Context, t ociTenant, uploadID string) ([]string, int64, error) {
+	_, err := uuid.Parse(uploadID)
+	if err != nil {
+		return nil, 0, errUploadNotFound
+	}
+
+	res, err := h.blobs.open(ctx, uploadMarkerKey(uploadID))
+	if err != nil {
+		if errors.Is(err, errObjectNotFound) {
+			return nil, 0, errUploadNotFound
+		}
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+
+	owner, err := io.ReadAll(io.LimitReader(res.Body, 1024))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read upload: %w", err)
+	}
+
+	if subtle.ConstantTimeCompare(owner, []byte(index.TenantKey(t.scope.provider, t.scope.owner))) != 1 {
+		return nil, 0, errUploadNotFound
+	}
+
+	return h.chunks(ctx, uploadID)
+}
+
+// appendChunk stores the body as the next chunk of the upload, a content range may be sent and must start at the
+// end of the upload.
+func (h *OCIRegistryHandler) appendChunk(ctx context.Context, r *http.Request, uploadID string, offset int64) (int64, error) {
+	if value := r.Header.Get("Content-Range"); value != "" {
+		if !strings.HasPrefix(value, "bytes ") {
+			value = "bytes " + value
+		}
+
+		start, _, err := parseContentRange(value)
+		if err != nil || start != offset {
+			return 0, fmt.Errorf("%w: %q doesn't start at %d", errRangeInvalid, r.Header.Get("Content-Range"), offset)
+		}
+	}
+
+	if r.ContentLength > maxHTTPUploadSize {
+		return 0, errTooLarge
+	}
+
+	if r.ContentLength == 0 {
+		return offset, nil
+	}
+
+	err := h.blobs.put(ctx, chunkKey(uploadID, offset), r.Body, r.ContentLength, "")
+	if err != nil {
+		return 0, err
+	}
+
+	_, size, err := h.chunks(ctx, uploadID)
+	if err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// chunks returns the keys of the chunks of an upload in order and their total size.
+func (h *OCIRegistryHandler) chunks(ctx context.Context, uploadID string) ([]string, int64, error) {
+	prefix := uploadPrefix(uploadID) + "chunk/"
+
+	objects, err := h.blobs.list(ctx, prefix)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var size int64
+	for _, obj := range objects {
+		size += aws.ToInt64(obj.Size)
+	}
+
+	keys, err := orderChunks(objects, prefix, size)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return keys, size, nil
+}
+
+func (h *OCIRegistryHandler) uploadChunk(ctx context.Context, w http.ResponseWriter, r *http.Request, t ociTenant, route ociRoute) error {
+	_, offset, err := h.upload(ctx, t, route.reference)
+	if err != nil {
+		return err
+	}
+
+	size, err := h.appendChunk(ctx, r, route.reference, offset)
+	if err != nil {
+		return err
+	}
+
+	writeUploadStatus(w, http.StatusAccepted, route.name, route.reference, size)
+
+	return nil
+}
+
+func (h *OCIRegistryHandler) uploadStatus(ctx context.Context, w http.ResponseWriter, _ *http.Request, t ociTenant, route ociRoute) error {
+	_, size, err := h.upload(ctx, t, route.reference)
+	if err != nil {
+		return err
+	}
+
+	writeUploadStatus(w, http.StatusNoContent, route.name, route.reference, size)
+
+	return nil
+}
+
+func (h *OCIRegistryHandler) cancelUpload(ctx context.Context, w http.ResponseWriter, t ociTenant, route ociRoute) error {
+	_, _, err := h.upload(ctx, t, route.reference)
+	if err != nil {
+		return err
+	}
+
+	h.blobs.removePrefix(ctx, uploadPrefix(route.reference))
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+// finishUpload stores the final chunk, if any, and moves the upload to the blob once its digest is verified.
+func (h *OCIRegistryHandler) finishUpload(ctx context.Context, w http.ResponseWriter, r *http.Request, t ociTenant, route ociRoute) error {
+	digest := r.URL.Query().Get("digest")
+
+	sum, err := parseDigest(digest)
+	if err != nil {
+		return err
+	}
+
+	_, offset, err := h.upload(ctx, t, route.reference)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.appendChunk(ctx, r, route.reference, offset)
+	if err != nil {
+		return err
+	}
+
+	keys, _, err := h.chunks(ctx, route.reference)
+	if err != nil {
+		return err
+	}
+
+	err = h.assembleBlob(ctx, route.reference, keys, t.blobKey(sum), sum)
+	if err != nil {
+		return err
+	}
+
+	h.blobs.removePrefix(ctx, uploadPrefix(route.reference))
+
+	log.Debug().Str("name", route.name).Str("digest", digest).Msg("registry blob saved")
+
+	writeBlobCreated(w, route.name, digest)
+
+	return nil
+}
+
+// assembleBlob concatenates the chunks of an upload into the blob, verifying the digest before the blob is
+// written. Chunks large enough to be copied within s3 are assembled in the upload first, while smaller chunks
+// are read through the server.
+func (h *OCIRegistryHandler) assembleBlob(ctx context.Context, uploadID string, keys []string, dst, sum string) error {
+	ctx, span := trace.Start(ctx, "OCIRegistry.assembleBlob")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("key", dst), attribute.Int("chunks", len(keys)))
+
+	if len(keys) == 0 {
+		// an empty blob, such as an empty layer or config
+		if sum != hex.EncodeToString(sha256.New().Sum(nil)) {
+			return errDigestInvalid
+		}
+		return h.blobs.putBytes(ctx, dst, nil, "")
+	}
+
+	copyable, err := h.blobs.copyable(ctx, keys)
+	if err != nil {
+		return err
+	}
+
+	if !copyable {
+		var size int64
+		readers := make([]io.Reader, len(keys))
+		for i, key := range keys {
+			res, err := h.blobs.open(ctx, key)
+			if err != nil {
+				return err
+			}
+			defer res.Body.Close()
+
+			readers[i] = res.Body
+			size += aws.ToInt64(res.ContentLength)
+		}
+
+		err = h.blobs.put(ctx, dst, io.MultiReader(readers...), size, sum)
+		if errors.Is(err, errChecksumMismatch) {
+			return errDigestInvalid
+		}
+		return err
+	}
+
+	staging := uploadPrefix(uploadID) + "blob"
+
+	err = h.blobs.assemble(ctx, staging, keys)
+	if err != nil {
+		return err
+	}
+
+	got, err := h.blobs.sha256sum(ctx, staging)
+	if err != nil {
+		return err
+	}
+
+	if got != sum {
+		return errDigestInvalid
+	}
+
+	return h.blobs.assemble(ctx, dst, []string{staging})
+}
+
+// manifestDigest resolves a manifest reference, which is a digest or a tag, to the hex encoded sum.
+func (h *OCIRegistryHandler) manifestDigest(ctx context.Context, t ociTenant, route ociRoute) (string, error) {
+	if strings.HasPrefix(route.reference, "sha256:") {
+		return parseDigest(route.reference)
+	}
+
+	err := validateTag(route.reference)
+	if err != nil {
+		return "", err
+	}
+
+	exists, rec, err := h.cache.store.ExistsCache(ctx, t.tagID(route.name, route.reference))
+	if err != nil {
+		return "", fmt.Errorf("failed to get tag: %w", err)
+	}
+
+	if !exists {
+		return "", errManifestUnknown
+	}
+
+	return rec.Sha256, nil
+}
+
+func (h *OCIRegistryHandler) getManifest(ctx context.Context, w http.ResponseWriter, r *http.Request, t ociTenant, route ociRoute) error {
+	sum, err := h.manifestDigest(ctx, t, route)
+	if err != nil {
+		return err
+	}
+
+	res, err := h.blobs.open(ctx, t.manifestKey(sum))
+	if err != nil {
+		if errors.Is(err, errObjectNotFound) {
+			return errManifestUnknown
+		}
+		return err
+	}
+	defer res.Body.Close()
+
+	w.Header().Set("Content-Type", aws.ToString(res.ContentType))
+	w.Header().Set("Content-Length", strconv.FormatInt(aws.ToInt64(res.ContentLength), 10))
+	w.Header().Set("Docker-Content-Digest", "sha256:"+sum)
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodHead {
+		return nil
+	}
+
+	_, err = io.Copy(w, res.Body)
+	if err != nil {
+		log.Warn().Err(err).Str("digest", sum).Msg("failed to write manifest")
+	}
+
+	return nil
+}
+
+// putManifest stores a manifest by its digest and records the tag, if it was pushed by tag, in the index.
+func (h *OCIRegistryHandler) putManifest(ctx context.Context, w http.ResponseWriter, r *http.Request, t ociTenant, route ociRoute) (err error) {
+	ev := newAuditEvent(ctx, audit.ActionCacheCreate)
+	ev.Key = route.name + ":" + route.reference
+	ev.Branch = t.scope.branch
+
+	defer func() {
+		ev.Result = auditResult(err)
+		h.cache.audit.Record(ctx, ev)
+	}()
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxManifestSize+1))
+	if err != nil {
+		return fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	if len(data) > maxManifestSize {
+		return errTooLarge
+	}
+
+	hash := sha256.Sum256(data)
+	sum := hex.EncodeToString(hash[:])
+	digest := "sha256:" + sum
+
+	ev.CacheID = t.manifestKey(sum)
+	ev.Sha256 = sum
+
+	tagged := !strings.HasPrefix(route.reference, "sha256:")
+
+	if tagged {
+		err = validateTag(route.reference)
+	} else if route.reference != digest {
+		err = errDigestInvalid
+	}
+	if err != nil {
+		return err
+	}
+
+	mediaType, err := manifestMediaType(r.Header.Get("Content-Type"), data)
+	if err != nil {
+		return err
+	}
+
+	err = h.blobs.putBytes(ctx, t.manifestKey(sum), data, mediaType)
+	if err != nil {
+		return err
+	}
+
+	if tagged {
+		rec := index.CacheRecord{
+			Key:             route.reference,
+			Name:            route.name,
+			Branch:          t.scope.branch,
+			Owner:           t.scope.owner,
+			Provider:        t.scope.provider,
+			OperatingSystem: ociOperatingSystem,
+			Architecture:    escapeValue(route.name),
+			Compression:     mediaType,
+			Sha256:          sum,
+			FileSize:        int64(len(data)),
+			TTL:             h.tagTTL(t.tenant),
+			UpdatedAt:       time.Now(),
+		}
+
+		if identity := ciauth.GetOIDCIdentity(ctx); identity != nil {
+			rec.Identity = &index.Identity{
+				Subject: identity.Subject(),
+				Issuer:  identity.Issuer(),
+			}
+		}
+
+		err = h.cache.store.PutCache(ctx, t.tagID(route.name, route.reference), createdValue(rec), rec, rec.TTL)
+		if err != nil {
+			return fmt.Errorf("failed to put tag: %w", err)
+		}
+	}
+
+	log.Debug().Str("name", route.name).Str("reference", route.reference).Str("digest", digest).Msg("registry manifest saved")
+
+	w.Header().Set("Location", OCIRegistryPath+route.name+"/manifests/"+digest)
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusCreated)
+
+	return nil
+}
+
+// manifestMediaType returns the media type of a manifest from the content type it was pushed with, which must be
+// a supported manifest type and match the media type in the manifest when it has one.
+func manifestMediaType(contentType string, data []byte) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !manifestMediaTypes[mediaType] {
+		return "", fmt.Errorf("%w: unsupported media type %q", errManifestInvalid, contentType)
+	}
+
+	var manifest struct {
+		MediaType string `json:"mediaType"`
+	}
+
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errManifestInvalid, err)
+	}
+
+	if manifest.MediaType != "" && manifest.MediaType != mediaType {
+		return "", fmt.Errorf("%w: media type %q doesn't match %q", errManifestInvalid, manifest.MediaType, mediaType)
+	}
+
+	return mediaType, nil
+}
+
+// tagTTL returns the ttl of the tags of a tenant, tags don't outlive the blobs and manifests they refer to which
+// are expired by the lifecycle of the bucket.
+func (h *OCIRegistryHandler) tagTTL(tenant index.TenantRecord) time.Duration {
+	ttl := tenantTTL(tenant)
+
+	if retention := h.cache.cfg.BucketRetention; retention > 0 {
+		ttl = min(ttl, retention)
+	}
+
+	return ttl
+}
+
+func writeBlobCreated(w http.ResponseWriter, name, digest string) {
+	w.Header().Set("Location", OCIRegistryPath+name+"/blobs/"+digest)
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// writeUploadStatus writes the location and progress of an upload, the range is inclusive so an empty upload
+// reports 0-0 as registries do.
+func writeUploadStatus(w http.ResponseWriter, status int, name, uploadID string, size int64) {
+	w.Header().Set("Location", OCIRegistryPath+name+"/blobs/uploads/"+uploadID)
+	w.Header().Set("Docker-Upload-UUID", uploadID)
+	w.Header().Set("Range", fmt.Sprintf("0-%d", max(size-1, 0)))
+	w.WriteHeader(status)
+}
+
+type ociErrors struct {
+	Errors []ociErrorDetail `json:"errors"`
+}
+
+type ociErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeOCIError writes an error in the format of the distribution api.
+func writeOCIError(w http.ResponseWriter, err error) {
+	status, code := http.StatusInternalServerError, "UNKNOWN"
+
+	switch {
+	case errors.Is(err, errBlobUnknown):
+		status, code = http.StatusNotFound, "BLOB_UNKNOWN"
+	case errors.Is(err, errManifestUnknown):
+		status, code = http.StatusNotFound, "MANIFEST_UNKNOWN"
+	case errors.Is(err, errManifestInvalid):
+		status, code = http.StatusBadRequest, "MANIFEST_INVALID"
+	case errors.Is(err, errUploadNotFound), errors.Is(err, errUploadIncomplete):
+		status, code = http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN"
+	case errors.Is(err, errDigestInvalid), errors.Is(err, errChecksumMismatch):
+		status, code = http.StatusBadRequest, "DIGEST_INVALID"
+	case errors.Is(err, errRangeInvalid):
+		status, code = http.StatusRequestedRangeNotSatisfiable, "BLOB_UPLOAD_INVALID"
+	case errors.Is(err, errInvalidRequest):
+		status, code = http.StatusBadRequest, "NAME_INVALID"
+	case errors.Is(err, errTooLarge):
+		status, code = http.StatusRequestEntityTooLarge, "SIZE_INVALID"
+	case errors.Is(err, errUnsupported):
+		status, code = http.StatusMethodNotAllowed, "UNSUPPORTED"
+	case errors.Is(err, errNameUnknown):
+		status, code = http.StatusNotFound, "NAME_UNKNOWN"
+	default:
+		status = httpStatus(err)
+		switch status {
+		case http.StatusUnauthorized:
+			code = "UNAUTHORIZED"
+		case http.StatusForbidden:
+			code = "DENIED"
+		case http.StatusBadRequest:
+			code = "BLOB_UPLOAD_INVALID"
+		case http.StatusRequestEntityTooLarge:
+			code = "SIZE_INVALID"
+		}
+	}
+
+	if status == http.StatusInternalServerError {
+		log.Error().Err(err).Msg("registry request failed")
+		err = errors.New("internal error")
+	}
+
+	writeJSON(w, status, ociErrors{Errors: []ociErrorDetail{{Code: code, Message: err.Error()}}})
+}
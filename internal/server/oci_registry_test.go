@@ -0,0 +1,344 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/wolfeidau/zipstash/internal/ciauth"
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+func TestParseOCIPath(t *testing.T) {
+	tests := []struct {
+		name  string
+		path  string
+		want  ociRoute
+		found bool
+	}{
+		{name: "blob", path: "/v2/cache/blobs/sha256:abc", want: ociRoute{name: "cache", kind: ociBlobs, reference: "sha256:abc"}, found: true},
+		{name: "nested name", path: "/v2/org/app/cache/manifests/latest", want: ociRoute{name: "org/app/cache", kind: ociManifests, reference: "latest"}, found: true},
+		{name: "start upload", path: "/v2/cache/blobs/uploads/", want: ociRoute{name: "cache", kind: ociUploads}, found: true},
+		{name: "start upload without slash", path: "/v2/cache/blobs/uploads", want: ociRoute{name: "cache", kind: ociUploads}, found: true},
+		{name: "upload", path: "/v2/cache/blobs/uploads/1234", want: ociRoute{name: "cache", kind: ociUploads, reference: "1234"}, found: true},
+		{name: "name containing api segment", path: "/v2/blobs/manifests/latest", want: ociRoute{name: "blobs", kind: ociManifests, reference: "latest"}, found: true},
+		{name: "no name", path: "/v2/blobs/sha256:abc"},
+		{name: "unknown", path: "/v2/cache/tags/list"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			got, found := parseOCIPath(tt.path)
+			assert.Equal(tt.found, found)
+			assert.Equal(tt.want, got)
+		})
+	}
+}
+
+func TestParseDigest(t *testing.T) {
+	sum := strings.Repeat("ab", 32)
+
+	tests := []struct {
+		name    string
+		digest  string
+		want    string
+		wantErr bool
+	}{
+		{name: "valid", digest: "sha256:" + sum, want: sum},
+		{name: "unsupported algorithm", digest: "sha512:" + sum, wantErr: true},
+		{name: "short", digest: "sha256:abc", wantErr: true},
+		{name: "upper case", digest: "sha256:" + strings.ToUpper(sum), wantErr: true},
+		{name: "traversal", digest: "sha256:../" + sum[3:], wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			got, err := parseDigest(tt.digest)
+			if tt.wantErr {
+				assert.ErrorIs(err, errDigestInvalid)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tt.want, got)
+		})
+	}
+}
+
+func TestOCITenantKeys(t *testing.T) {
+	assert := require.New(t)
+
+	sum := strings.Repeat("ab", 32)
+	tenant := ociTenant{scope: requestScope{owner: "wolfeidau", provider: ciauth.GitHubActions}}
+
+	assert.Equal("_oci/wolfeidau/github_actions/blobs/sha256/"+sum, tenant.blobKey(sum))
+	assert.Equal("_oci/wolfeidau/github_actions/manifests/sha256/"+sum, tenant.manifestKey(sum))
+}
+
+func TestOCIRegistryRequests(t *testing.T) {
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	require.NoError(t, err)
+
+	h := NewOCIRegistryHandler(&CacheServiceHandler{})
+
+	rejectToken := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+		})
+	}
+
+	tests := []struct {
+		name      string
+		auth      func(http.Handler) http.Handler
+		anonymous bool
+		method    string
+		path      string
+		status    int
+		challenge bool
+	}{
+		{name: "no credentials", auth: withFakeIdentity, anonymous: true, method: http.MethodGet, path: OCIRegistryPath, status: http.StatusUnauthorized, challenge: true},
+		{name: "invalid token", auth: rejectToken, method: http.MethodGet, path: OCIRegistryPath, status: http.StatusUnauthorized, challenge: true},
+		{name: "version check", auth: withFakeIdentity, method: http.MethodGet, path: OCIRegistryPath, status: http.StatusOK},
+		{name: "invalid name", auth: withFakeIdentity, method: http.MethodGet, path: OCIRegistryPath + "cache$/manifests/latest", status: http.StatusBadRequest},
+		{name: "unknown path", auth: withFakeIdentity, method: http.MethodGet, path: OCIRegistryPath + "cache/tags/list", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			mux := http.NewServeMux()
+			h.Mount(mux, tt.auth)
+
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if !tt.anonymous {
+				r.SetBasicAuth("oidc", "token")
+			}
+			w := httptest.NewRecorder()
+
+			mux.ServeHTTP(w, r)
+
+			assert.Equal(tt.status, w.Code, w.Body.String())
+			assert.Equal("registry/2.0", w.Header().Get("Docker-Distribution-API-Version"))
+			if tt.challenge {
+				assert.Equal(`Basic realm="zipstash"`, w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestOCIRegistryPush(t *testing.T) {
+	assert := require.New(t)
+
+	cache, bucket, store := newTestCacheService(t, CacheConfig{BucketRetention: 24 * time.Hour})
+
+	mux := http.NewServeMux()
+	NewOCIRegistryHandler(cache).Mount(mux, withFakeIdentity)
+
+	send := func(method, target, contentType string, body []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, bytes.NewReader(body))
+		r.SetBasicAuth("oidc", "token")
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	layer := []byte("layer contents")
+	layerDigest := digestOf(layer)
+
+	// a chunked upload of the layer
+	w := send(http.MethodPost, OCIRegistryPath+"app/cache/blobs/uploads/", "", nil)
+	assert.Equal(http.StatusAccepted, w.Code, w.Body.String())
+	location := w.Header().Get("Location")
+
+	w = send(http.MethodPatch, location, "", layer[:5])
+	assert.Equal(http.StatusAccepted, w.Code, w.Body.String())
+	assert.Equal("0-4", w.Header().Get("Range"))
+
+	w = send(http.MethodPut, location+"?digest="+layerDigest, "", layer[5:])
+	assert.Equal(http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(layerDigest, w.Header().Get("Docker-Content-Digest"))
+
+	tenant := ociTenant{scope: requestScope{owner: "wolfeidau", provider: ciauth.GitHubActions}}
+
+	got, ok := bucket.get(tenant.blobKey(strings.TrimPrefix(layerDigest, "sha256:")))
+	assert.True(ok)
+	assert.Equal(layer, got)
+
+	// the chunks are removed once the blob is assembled
+	for _, key := range bucket.keys() {
+		assert.False(strings.HasPrefix(key, uploadsPrefix), key)
+	}
+
+	w = send(http.MethodHead, OCIRegistryPath+"app/cache/blobs/"+layerDigest, "", nil)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(strconv.Itoa(len(layer)), w.Header().Get("Content-Length"))
+
+	w = send(http.MethodGet, OCIRegistryPath+"app/cache/blobs/"+layerDigest, "", nil)
+	assert.Equal(http.StatusTemporaryRedirect, w.Code)
+	redirect, err := url.Parse(w.Header().Get("Location"))
+	assert.NoError(err)
+	assert.Equal("/"+fakeBucket+"/"+tenant.blobKey(strings.TrimPrefix(layerDigest, "sha256:")), redirect.Path)
+
+	// a blob pushed in a single request must match its digest
+	w = send(http.MethodPost, OCIRegistryPath+"app/cache/blobs/uploads/?digest="+layerDigest, "", []byte("other contents"))
+	assert.Equal(http.StatusBadRequest, w.Code)
+	assert.Contains(w.Body.String(), "DIGEST_INVALID")
+
+	w = send(http.MethodGet, OCIRegistryPath+"app/cache/blobs/"+digestOf([]byte("other contents")), "", nil)
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	manifest := []byte(`{"schemaVersion":2,"layers":[{"digest":"` + layerDigest + `"}]}`)
+	manifestDigest := digestOf(manifest)
+	mediaType := "application/vnd.oci.image.manifest.v1+json"
+
+	w = send(http.MethodPut, OCIRegistryPath+"app/cache/manifests/latest", mediaType, manifest)
+	assert.Equal(http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(manifestDigest, w.Header().Get("Docker-Content-Digest"))
+
+	// the tag is recorded in the index with the digest of the manifest and expires within the bucket retention
+	rec, err := store.GetCache(context.Background(), tenant.tagID("app/cache", "latest"))
+	assert.NoError(err)
+	assert.Equal(strings.TrimPrefix(manifestDigest, "sha256:"), rec.Sha256)
+	assert.Equal(mediaType, rec.Compression)
+	assert.Equal(24*time.Hour, rec.TTL)
+
+	for _, reference := range []string{"latest", manifestDigest} {
+		w = send(http.MethodGet, OCIRegistryPath+"app/cache/manifests/"+reference, "", nil)
+		assert.Equal(http.StatusOK, w.Code, reference)
+		assert.Equal(manifestDigest, w.Header().Get("Docker-Content-Digest"))
+		assert.Equal(mediaType, w.Header().Get("Content-Type"))
+		assert.Equal(manifest, w.Body.Bytes())
+	}
+
+	// tags are looked up in the repository they were pushed to
+	w = send(http.MethodGet, OCIRegistryPath+"other/manifests/latest", "", nil)
+	assert.Equal(http.StatusNotFound, w.Code)
+	assert.Contains(w.Body.String(), "MANIFEST_UNKNOWN")
+
+	// a manifest pushed by digest must match it
+	w = send(http.MethodPut, OCIRegistryPath+"app/cache/manifests/"+digestOf([]byte("other")), mediaType, manifest)
+	assert.Equal(http.StatusBadRequest, w.Code)
+	assert.Contains(w.Body.String(), "DIGEST_INVALID")
+
+	// only manifests are stored
+	w = send(http.MethodPut, OCIRegistryPath+"app/cache/manifests/other", "text/html", manifest)
+	assert.Equal(http.StatusBadRequest, w.Code)
+	assert.Contains(w.Body.String(), "MANIFEST_INVALID")
+
+	w = send(http.MethodGet, OCIRegistryPath+"app/cache/manifests/other", "", nil)
+	assert.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestManifestMediaType(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		manifest    string
+		want        string
+		wantErr     bool
+	}{
+		{name: "oci manifest", contentType: "application/vnd.oci.image.manifest.v1+json", manifest: `{"schemaVersion":2}`, want: "application/vnd.oci.image.manifest.v1+json"},
+		{name: "oci index", contentType: "application/vnd.oci.image.index.v1+json", manifest: `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json"}`, want: "application/vnd.oci.image.index.v1+json"},
+		{name: "docker manifest", contentType: "application/vnd.docker.distribution.manifest.v2+json", manifest: `{"schemaVersion":2}`, want: "application/vnd.docker.distribution.manifest.v2+json"},
+		{name: "docker manifest list", contentType: "application/vnd.docker.distribution.manifest.list.v2+json", manifest: `{"schemaVersion":2}`, want: "application/vnd.docker.distribution.manifest.list.v2+json"},
+		{name: "parameters", contentType: "application/vnd.oci.image.manifest.v1+json; charset=utf-8", manifest: `{"schemaVersion":2}`, want: "application/vnd.oci.image.manifest.v1+json"},
+		{name: "missing", contentType: "", manifest: `{"schemaVersion":2}`, wantErr: true},
+		{name: "unsupported", contentType: "text/html", manifest: `{"schemaVersion":2}`, wantErr: true},
+		{name: "mismatched media type", contentType: "application/vnd.oci.image.manifest.v1+json", manifest: `{"mediaType":"application/vnd.oci.image.index.v1+json"}`, wantErr: true},
+		{name: "not json", contentType: "application/vnd.oci.image.manifest.v1+json", manifest: `<html>`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			got, err := manifestMediaType(tt.contentType, []byte(tt.manifest))
+			if tt.wantErr {
+				assert.ErrorIs(err, errManifestInvalid)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tt.want, got)
+		})
+	}
+}
+
+func TestOCIAssembleBlob(t *testing.T) {
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		sizes   []int
+		corrupt bool
+	}{
+		{name: "small chunks", sizes: []int{10, 10}},
+		{name: "small chunks digest mismatch", sizes: []int{10, 10}, corrupt: true},
+		{name: "chunks large enough to copy", sizes: []int{minCopyPartSize, 10}},
+		{name: "chunks large enough to copy digest mismatch", sizes: []int{minCopyPartSize, 10}, corrupt: true},
+		{name: "empty blob", sizes: nil},
+		{name: "empty blob digest mismatch", sizes: nil, corrupt: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			fake, client := newFakeS3(t)
+			h := &OCIRegistryHandler{blobs: newBlobStore(client, fakeBucket)}
+
+			uploadID := "upload"
+
+			var want []byte
+			var keys []string
+			for i, size := range tt.sizes {
+				data := bytes.Repeat([]byte{byte('a' + i)}, size)
+				key := chunkKey(uploadID, int64(len(want)))
+				fake.put(key, data)
+				keys = append(keys, key)
+				want = append(want, data...)
+			}
+
+			sum := strings.TrimPrefix(digestOf(want), "sha256:")
+			if tt.corrupt {
+				sum = strings.TrimPrefix(digestOf(append(want, 'x')), "sha256:")
+			}
+
+			err := h.assembleBlob(context.Background(), uploadID, keys, "dst", sum)
+			if tt.corrupt {
+				assert.ErrorIs(err, errDigestInvalid)
+
+				_, ok := fake.get("dst")
+				assert.False(ok, "blob written with a mismatched digest")
+				return
+			}
+			assert.NoError(err)
+
+			got, ok := fake.get("dst")
+			assert.True(ok)
+			assert.True(bytes.Equal(want, got), "assembled blob doesn't match the chunks")
+		})
+	}
+}
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -17,12 +17,20 @@ import (
 
 const (
 	MinPartSize       int64         = 10 * 1024 * 1024 // 5MB minimum
+	MaxPartSize       int64         = 5 * 1024 * 1024 * 1024
+	MaxParts          int64         = 10000
 	DefaultExpiration time.Duration = 60 * time.Minute
+
+	// maxPreferredPartSize caps the part size chosen for a given cpu count, larger parts are only used
+	// when needed to stay under the MaxParts limit as the client holds each part in memory while uploading.
+	maxPreferredPartSize int64 = 128 * 1024 * 1024
+	partsPerCPU          int64 = 4
 )
 
 type Presigner struct {
 	s3client        *s3.Client
 	presignS3Client *s3.PresignClient
+	metrics         *cacheMetrics
 	cacheBucket     string
 }
 
@@ -32,14 +40,15 @@ func NewPresigner(s3client *s3.Client, cacheBucket string) *Presigner {
 	return &Presigner{
 		s3client:        s3client,
 		presignS3Client: presignS3Client,
+		metrics:         newCacheMetrics(),
 		cacheBucket:     cacheBucket,
 	}
 }
 
 // GenerateFileUploadInstructions generates the necessary instructions for uploading a file to S3, including presigned URLs and multipart upload details.
 // If the file size is less than the minimum multipart upload part size, a single presigned PUT URL is returned.
-// Otherwise, the function calculates the necessary offsets for a multipart upload and returns the presigned URLs for each part.
-func (p *Presigner) GenerateFileUploadInstructions(ctx context.Context, s3key, sha256sum, compression string, totalSize int64) (*UploadInstructionsResp, error) {
+// Otherwise, the function calculates the necessary offsets for a multipart upload using the supplied part size and returns the presigned URLs for each part.
+func (p *Presigner) GenerateFileUploadInstructions(ctx context.Context, s3key, sha256sum, compression string, totalSize, partSize int64) (*UploadInstructionsResp, error) {
 	ctx, span := trace.Start(ctx, "Presigner.GenerateFileUploadInstructions")
 	defer span.End()
 
@@ -59,6 +68,8 @@ func (p *Presigner) GenerateFileUploadInstructions(ctx context.Context, s3key, s
 			return nil, fmt.Errorf("failed to presign upload: %w", err)
 		}
 
+		p.metrics.recordPresigned(ctx, directionUpload, totalSize)
+
 		return &UploadInstructionsResp{
 			Multipart: false,
 			UploadInstructions: []CacheURLInstruction{
@@ -79,11 +90,13 @@ func (p *Presigner) GenerateFileUploadInstructions(ctx context.Context, s3key, s
 		return nil, fmt.Errorf("failed to create multipart upload: %w", err)
 	}
 
-	log.Info().Int64("totalSize", totalSize).Int64("MinPartSize", MinPartSize).Msg("multipart upload")
+	log.Info().Int64("totalSize", totalSize).Int64("partSize", partSize).Msg("multipart upload")
+
+	p.metrics.multipartStarted(ctx)
 
 	// Maximum multipart upload part size is 5 GB
 	// Maximum number of parts per upload is 10,000
-	offsets := calculateOffsets(totalSize, MinPartSize)
+	offsets := calculateOffsets(totalSize, partSize)
 	reqs := make([]CacheURLInstruction, 0, len(offsets))
 
 	for _, offset := range offsets {
@@ -106,6 +119,8 @@ func (p *Presigner) GenerateFileUploadInstructions(ctx context.Context, s3key, s
 		})
 	}
 
+	p.metrics.recordPresigned(ctx, directionUpload, totalSize)
+
 	return &UploadInstructionsResp{
 		UploadInstructions: reqs,
 		Multipart:          true,
@@ -117,7 +132,7 @@ func (p *Presigner) GenerateFileUploadInstructions(ctx context.Context, s3key, s
 // If the file size is less than the minimum multipart upload part size, it generates a single download instruction.
 // Otherwise, it generates multiple download instructions for downloading the file in parts.
 // The returned instructions include the presigned URLs and HTTP methods to use for the downloads.
-func (p *Presigner) GenerateFileDownloadInstructions(ctx context.Context, s3key string, totalSize int64) (*DownloadInstructionsResp, error) {
+func (p *Presigner) GenerateFileDownloadInstructions(ctx context.Context, s3key string, totalSize, partSize int64) (*DownloadInstructionsResp, error) {
 	ctx, span := trace.Start(ctx, "Presigner.GenerateFileDownloadInstructions")
 	defer span.End()
 
@@ -134,6 +149,9 @@ func (p *Presigner) GenerateFileDownloadInstructions(ctx context.Context, s3key
 		if err != nil {
 			return nil, fmt.Errorf("failed to presign upload: %w", err)
 		}
+
+		p.metrics.recordPresigned(ctx, directionDownload, totalSize)
+
 		return &DownloadInstructionsResp{
 			DownloadInstructions: []CacheURLInstruction{
 				{
@@ -144,9 +162,7 @@ func (p *Presigner) GenerateFileDownloadInstructions(ctx context.Context, s3key
 		}, nil
 	}
 
-	// Maximum multipart upload part size is 5 GB
-	// Maximum number of parts per upload is 10,000
-	offsets := calculateOffsets(totalSize, MinPartSize)
+	offsets := calculateOffsets(totalSize, partSize)
 	reqs := make([]CacheURLInstruction, 0, len(offsets))
 	for _, offset := range offsets {
 		req, err := p.presignS3Client.PresignGetObject(ctx, &s3.GetObjectInput{
@@ -166,12 +182,58 @@ func (p *Presigner) GenerateFileDownloadInstructions(ctx context.Context, s3key
 		})
 	}
 
+	p.metrics.recordPresigned(ctx, directionDownload, totalSize)
+
 	return &DownloadInstructionsResp{
 		DownloadInstructions: reqs,
 		Multipart:            true,
 	}, nil
 }
 
+// PresignDownload returns a presigned url to download the whole object, unlike the urls for parts this isn't
+// signed with a range so clients may request any range of the object. The size is only used for metrics.
+func (p *Presigner) PresignDownload(ctx context.Context, s3key string, size int64) (string, error) {
+	ctx, span := trace.Start(ctx, "Presigner.PresignDownload")
+	defer span.End()
+
+	req, err := p.presignS3Client.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(p.cacheBucket),
+		Key:    aws.String(s3key),
+	}, func(opts *s3.PresignOptions) {
+		opts.Expires = DefaultExpiration
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to presign download: %w", err)
+	}
+
+	p.metrics.recordPresigned(ctx, directionDownload, size)
+
+	return req.URL, nil
+}
+
+// PresignUpload returns a presigned url to upload a whole object with a single PUT, s3 verifies the body matches
+// the sha256 sum.
+func (p *Presigner) PresignUpload(ctx context.Context, s3key, sha256sum string, size int64) (string, error) {
+	ctx, span := trace.Start(ctx, "Presigner.PresignUpload")
+	defer span.End()
+
+	req, err := p.presignS3Client.PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket:         aws.String(p.cacheBucket),
+		Key:            aws.String(s3key),
+		ContentLength:  aws.Int64(size),
+		ChecksumSHA256: aws.String(convertSha256ToBase64(sha256sum)),
+	}, func(opts *s3.PresignOptions) {
+		opts.Expires = DefaultExpiration
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to presign upload: %w", err)
+	}
+
+	p.metrics.recordPresigned(ctx, directionUpload, size)
+
+	return req.URL, nil
+}
+
 type Offset struct {
 	Part  int32
 	Start int64
@@ -195,35 +257,49 @@ type DownloadInstructionsResp struct {
 	Multipart            bool
 }
 
+// CalculatePartSize picks the part size used to split a file of totalSize bytes into parts.
+// The requested part size is a hint from the client, when it is zero the part size is derived from the cpu count
+// so there are enough parts to keep the client busy without creating thousands of tiny parts for large files.
+// The result is always between MinPartSize and MaxPartSize and never results in more than MaxParts parts.
+func CalculatePartSize(totalSize, requestedPartSize int64, cpuCount int32) int64 {
+	partSize := requestedPartSize
+	if partSize <= 0 {
+		cpus := max(int64(cpuCount), 1)
+		partSize = min(totalSize/(cpus*partsPerCPU), maxPreferredPartSize)
+	}
+
+	// ensure the number of parts doesn't exceed the maximum permitted by S3
+	partSize = max(partSize, MinPartSize, (totalSize+MaxParts-1)/MaxParts)
+
+	return min(partSize, MaxPartSize)
+}
+
 // calculateOffsets calculates the offsets for range queries for a given total size and part size.
 // It returns a slice of offset structs, where each offset represents a part of the total size
-// that can be downloaded or uploaded separately.
+// that can be downloaded or uploaded separately. The ranges are inclusive so each part holds exactly partSize
+// bytes, which keeps parts clamped to MaxPartSize within the S3 limit.
 // The part number starts at 1 in S3 multipart uploads.
 // The last part may have a different size than the other parts.
 func calculateOffsets(totalSize int64, partSize int64) []*Offset {
 	offsets := make([]*Offset, 0, (totalSize/partSize)+1)
 	i := int32(1) // part number starts at 1 in s3 multipart uploads
 	start := int64(0)
-	end := partSize
-	for end < totalSize {
+	for start+partSize < totalSize {
 		offsets = append(offsets, &Offset{
 			Part:  i,
 			Start: start,
-			End:   end,
+			End:   start + partSize - 1,
 		})
 
-		start = end + 1
-		end += partSize
+		start += partSize
 		i++
 	}
 
-	end = totalSize - 1
-
 	// add the last part
 	offsets = append(offsets, &Offset{
 		Part:  i,
 		Start: start,
-		End:   end,
+		End:   totalSize - 1,
 	})
 
 	return offsets
@@ -21,10 +21,10 @@ func TestOffsetsForDownload(t *testing.T) {
 				{
 					Part:  1,
 					Start: 0,
-					End:   5 * 1024 * 1024,
+					End:   5*1024*1024 - 1,
 				}, {
 					Part:  2,
-					Start: 5*1024*1024 + 1,
+					Start: 5 * 1024 * 1024,
 					End:   10*1024*1024 - 1,
 				},
 			},
@@ -37,19 +37,27 @@ func TestOffsetsForDownload(t *testing.T) {
 				{
 					Part:  1,
 					Start: 0,
-					End:   5 * 1024 * 1024,
+					End:   5*1024*1024 - 1,
 				}, {
 					Part:  2,
-					Start: 5*1024*1024 + 1,
-					End:   10 * 1024 * 1024,
+					Start: 5 * 1024 * 1024,
+					End:   10*1024*1024 - 1,
 				},
 				{
 					Part:  3,
-					Start: 10*1024*1024 + 1,
+					Start: 10 * 1024 * 1024,
 					End:   14*1024*1024 - 1,
 				},
 			},
 		},
+		{
+			name:     "smaller than a part",
+			total:    1024,
+			partSize: 5 * 1024 * 1024,
+			expected: []Offset{
+				{Part: 1, Start: 0, End: 1023},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -69,3 +77,102 @@ func TestOffsetsForDownload(t *testing.T) {
 		})
 	}
 }
+
+func TestOffsetsPartSizeBoundary(t *testing.T) {
+	assert := require.New(t)
+
+	// a file larger than MaxParts parts of MaxPartSize is clamped to MaxPartSize, no part may exceed it
+	total := 3*MaxPartSize + 1
+	partSize := CalculatePartSize(total, 2*MaxPartSize, 1)
+	assert.Equal(MaxPartSize, partSize)
+
+	offsets := calculateOffsets(total, partSize)
+	assert.Len(offsets, 4)
+
+	var next int64
+	for _, offset := range offsets {
+		assert.Equal(next, offset.Start, "parts must be contiguous")
+		assert.LessOrEqual(offset.End-offset.Start+1, MaxPartSize)
+		next = offset.End + 1
+	}
+	assert.Equal(total, next)
+	assert.Equal(int64(1), offsets[3].End-offsets[3].Start+1)
+
+	// an exact multiple of the part size has no empty trailing part
+	offsets = calculateOffsets(2*MinPartSize, MinPartSize)
+	assert.Len(offsets, 2)
+	assert.Equal(2*MinPartSize-1, offsets[1].End)
+}
+
+func TestCalculatePartSize(t *testing.T) {
+	const mb = 1024 * 1024
+
+	tests := []struct {
+		name      string
+		total     int64
+		requested int64
+		cpuCount  int32
+		expected  int64
+	}{
+		{
+			name:     "small file uses min part size",
+			total:    50 * mb,
+			cpuCount: 4,
+			expected: MinPartSize,
+		},
+		{
+			name:     "derived from cpu count",
+			total:    1024 * mb,
+			cpuCount: 4,
+			expected: 64 * mb,
+		},
+		{
+			name:     "zero cpu count treated as one",
+			total:    1024 * mb,
+			cpuCount: 0,
+			expected: maxPreferredPartSize,
+		},
+		{
+			name:     "large file capped at preferred part size",
+			total:    20 * 1024 * mb,
+			cpuCount: 2,
+			expected: maxPreferredPartSize,
+		},
+		{
+			name:     "very large file stays under max parts",
+			total:    2 * 1024 * 1024 * mb,
+			cpuCount: 2,
+			expected: (2*1024*1024*mb + MaxParts - 1) / MaxParts,
+		},
+		{
+			name:      "requested part size honoured",
+			total:     1024 * mb,
+			requested: 32 * mb,
+			cpuCount:  4,
+			expected:  32 * mb,
+		},
+		{
+			name:      "requested part size below minimum",
+			total:     1024 * mb,
+			requested: 1 * mb,
+			cpuCount:  4,
+			expected:  MinPartSize,
+		},
+		{
+			name:      "requested part size above maximum",
+			total:     1024 * mb,
+			requested: 10 * 1024 * mb,
+			cpuCount:  4,
+			expected:  MaxPartSize,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+			partSize := CalculatePartSize(tt.total, tt.requested, tt.cpuCount)
+			assert.Equal(tt.expected, partSize)
+			assert.LessOrEqual(int64(len(calculateOffsets(tt.total, partSize))), MaxParts)
+		})
+	}
+}
@@ -0,0 +1,399 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/time/rate"
+
+	"github.com/wolfeidau/zipstash/internal/ciauth"
+	"github.com/wolfeidau/zipstash/internal/events"
+	"github.com/wolfeidau/zipstash/internal/index"
+	"github.com/wolfeidau/zipstash/pkg/metrics"
+	"github.com/wolfeidau/zipstash/pkg/ratelimit"
+)
+
+// scopes of the rate limits.
+const (
+	scopeTenant  = "tenant"
+	scopeSubject = "subject"
+)
+
+// kinds of limits requests are rejected by.
+const (
+	limitRate        = "rate"
+	limitConcurrency = "concurrency"
+)
+
+// quotaEventInterval is how often tenant.quota_exceeded is published for a tenant, scope and kind of limit, so a
+// pipeline looping on a procedure doesn't flood the subscribers with an event per rejected request.
+const quotaEventInterval = time.Minute
+
+// concurrencyRetryAfter is the hint given to requests rejected by the concurrency limits, as there is no way to
+// know when the requests in flight complete.
+const concurrencyRetryAfter = time.Second
+
+// rateLimitSweepInterval is how often buckets which have refilled are removed, a removed bucket is recreated
+// full so this doesn't change the limits.
+const rateLimitSweepInterval = time.Minute
+
+// RateLimit is a token bucket permitting a number of requests per second with a burst, a zero rate disables it.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// UnmarshalText parses a limit formatted as rate/burst such as 10/20, the burst defaults to the rate.
+func (l *RateLimit) UnmarshalText(text []byte) error {
+	rateText, burstText, hasBurst := strings.Cut(string(text), "/")
+
+	limit, err := strconv.ParseFloat(rateText, 64)
+	if err != nil || limit < 0 {
+		return fmt.Errorf("invalid rate limit %q, expected rate/burst", text)
+	}
+
+	burst := int(limit)
+	if hasBurst {
+		burst, err = strconv.Atoi(burstText)
+		if err != nil || burst < 0 {
+			return fmt.Errorf("invalid rate limit %q, expected rate/burst", text)
+		}
+	}
+
+	*l = RateLimit{Rate: limit, Burst: burst}
+
+	return nil
+}
+
+func (l RateLimit) enabled() bool {
+	return l.Rate > 0
+}
+
+// RateLimits are the limits of the requests of each tenant or subject. Procedures with their own limit, named by
+// either the procedure or method, are counted separately to the other procedures.
+type RateLimits struct {
+	Default    RateLimit
+	Procedures map[string]RateLimit
+}
+
+// forProcedure returns the limit of a procedure and the bucket it is counted in, this is empty for the default.
+func (l RateLimits) forProcedure(procedure string) (RateLimit, string) {
+	if limit, ok := l.Procedures[procedure]; ok {
+		return limit, procedure
+	}
+
+	if limit, ok := l.Procedures[path.Base(procedure)]; ok {
+		return limit, procedure
+	}
+
+	return l.Default, ""
+}
+
+// RateLimitConfig are the limits of each tenant, identified by the owner and provider of the OIDC token, and of
+// each subject of the tokens, such as a repository branch or pipeline. A request must be permitted by both.
+type RateLimitConfig struct {
+	Tenant  RateLimits
+	Subject RateLimits
+
+	// TenantConcurrency and SubjectConcurrency are the most requests of each tenant and subject in flight at once,
+	// zero disables the limit.
+	TenantConcurrency  int
+	SubjectConcurrency int
+
+	// Events is notified with tenant.quota_exceeded when requests are rejected, nothing is published when nil.
+	Events events.Publisher
+}
+
+type bucketKey struct {
+	scope     string
+	key       string
+	procedure string
+}
+
+// RateLimitInterceptor rejects requests of a tenant or subject exceeding its rate or concurrency limits with
+// ResourceExhausted, the error carries a Retry-After hint with how long until the request would be permitted.
+// Buckets and requests in flight are held in memory so the limits apply to each server.
+type RateLimitInterceptor struct {
+	cfg RateLimitConfig
+
+	mu        sync.Mutex
+	buckets   map[bucketKey]*rate.Limiter
+	inFlight  map[bucketKey]int
+	notified  map[bucketKey]time.Time
+	lastSweep time.Time
+
+	rejected metric.Int64Counter
+}
+
+var _ connect.Interceptor = (*RateLimitInterceptor)(nil)
+
+// NewRateLimitInterceptor creates an interceptor enforcing the limits.
+func NewRateLimitInterceptor(cfg RateLimitConfig) *RateLimitInterceptor {
+	rejected, err := metrics.Meter().Int64Counter("zipstash.ratelimit.rejected",
+		metric.WithDescription("requests rejected by the rate limits by scope and procedure"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to create rate limit counter")
+	}
+
+	return &RateLimitInterceptor{
+		cfg:       cfg,
+		buckets:   make(map[bucketKey]*rate.Limiter),
+		inFlight:  make(map[bucketKey]int),
+		notified:  make(map[bucketKey]time.Time),
+		lastSweep: time.Now(),
+		rejected:  rejected,
+	}
+}
+
+func (i *RateLimitInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if req.Spec().IsClient {
+			return next(ctx, req)
+		}
+
+		release, err := i.allow(ctx, req.Spec().Procedure)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+
+		return next(ctx, req)
+	}
+}
+
+func (i *RateLimitInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (i *RateLimitInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		release, err := i.allow(ctx, conn.Spec().Procedure)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		return next(ctx, conn)
+	}
+}
+
+// allow checks the request is permitted by the limits of its tenant and subject, returning a function to call once
+// the request completes. Requests without an identity are left to be rejected by the handlers.
+func (i *RateLimitInterceptor) allow(ctx context.Context, procedure string) (func(), error) {
+	identity := ciauth.GetOIDCIdentity(ctx)
+	if identity == nil {
+		return func() {}, nil
+	}
+
+	delay, scope := i.reserve(identity, procedure, time.Now())
+	if delay > 0 {
+		return nil, i.reject(ctx, identity, procedure, scope, limitRate, delay)
+	}
+
+	release, scope := i.acquire(identity)
+	if release == nil {
+		return nil, i.reject(ctx, identity, procedure, scope, limitConcurrency, concurrencyRetryAfter)
+	}
+
+	return release, nil
+}
+
+// reject records a request rejected by a limit of a scope and returns the error of the request.
+func (i *RateLimitInterceptor) reject(ctx context.Context, identity ciauth.OIDCIdentity, procedure, scope, limit string, delay time.Duration) error {
+	msg := scope + " " + limit + " limit exceeded"
+
+	if i.rejected != nil {
+		i.rejected.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("scope", scope),
+			attribute.String("procedure", procedure),
+		))
+	}
+
+	log.Warn().Str("scope", scope).Str("procedure", procedure).Str("owner", identity.Owner()).
+		Str("subject", identity.Subject()).Dur("retry_after", delay).Msg(msg)
+
+	i.publishQuotaExceeded(ctx, identity, procedure, scope, limit, time.Now())
+
+	err := connect.NewError(connect.CodeResourceExhausted, errors.New(msg))
+	err.Meta().Set(ratelimit.RetryAfterHeader, ratelimit.FormatRetryAfter(delay))
+
+	return err
+}
+
+// publishQuotaExceeded notifies the subscribers of the tenant that a request was rejected, at most once each
+// interval for the tenant, scope and kind of limit.
+func (i *RateLimitInterceptor) publishQuotaExceeded(ctx context.Context, identity ciauth.OIDCIdentity, procedure, scope, limit string, now time.Time) {
+	if i.cfg.Events == nil {
+		return
+	}
+
+	key := bucketKey{scope: scope, key: tenantKey(identity), procedure: limit}
+
+	i.mu.Lock()
+	last, ok := i.notified[key]
+	if ok && now.Sub(last) < quotaEventInterval {
+		i.mu.Unlock()
+		return
+	}
+	i.notified[key] = now
+	i.mu.Unlock()
+
+	ev := events.Event{
+		Type:      events.TenantQuotaExceeded,
+		Tenant:    index.TenantKey(identity.Provider(), identity.Owner()),
+		Subject:   identity.Subject(),
+		Issuer:    identity.Issuer(),
+		Procedure: procedure,
+		Scope:     scope,
+		Limit:     limit,
+		Claims:    claimsMap(identity.Claims()),
+	}
+
+	if err := i.cfg.Events.Publish(ctx, ev); err != nil {
+		log.Warn().Err(err).Str("type", ev.Type).Str("tenant", ev.Tenant).Msg("failed to publish event")
+	}
+}
+
+// reserve takes a token from the buckets of the tenant and subject, returning the longest delay and its scope
+// when either bucket is empty. Tokens aren't taken from either bucket when the request is rejected.
+func (i *RateLimitInterceptor) reserve(identity ciauth.OIDCIdentity, procedure string, now time.Time) (time.Duration, string) {
+	scopes := []struct {
+		scope  string
+		key    string
+		limits RateLimits
+	}{
+		{scope: scopeTenant, key: tenantKey(identity), limits: i.cfg.Tenant},
+		{scope: scopeSubject, key: subjectKey(identity), limits: i.cfg.Subject},
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.sweep(now)
+
+	var (
+		delay        time.Duration
+		limitedScope string
+		reservations []*rate.Reservation
+	)
+
+	for _, s := range scopes {
+		limit, group := s.limits.forProcedure(procedure)
+		if !limit.enabled() {
+			continue
+		}
+
+		r := i.bucket(bucketKey{scope: s.scope, key: s.key, procedure: group}, limit).ReserveN(now, 1)
+		reservations = append(reservations, r)
+
+		if d := r.DelayFrom(now); d > delay {
+			delay, limitedScope = d, s.scope
+		}
+	}
+
+	if delay > 0 {
+		for _, r := range reservations {
+			r.CancelAt(now)
+		}
+	}
+
+	return delay, limitedScope
+}
+
+// acquire counts a request in flight for the tenant and subject, returning a function releasing it or the scope
+// whose concurrency limit is reached. Nothing is counted when the request is rejected.
+func (i *RateLimitInterceptor) acquire(identity ciauth.OIDCIdentity) (func(), string) {
+	scopes := []struct {
+		key   bucketKey
+		limit int
+	}{
+		{key: bucketKey{scope: scopeTenant, key: tenantKey(identity)}, limit: i.cfg.TenantConcurrency},
+		{key: bucketKey{scope: scopeSubject, key: subjectKey(identity)}, limit: i.cfg.SubjectConcurrency},
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var keys []bucketKey
+
+	for _, s := range scopes {
+		if s.limit <= 0 {
+			continue
+		}
+
+		if i.inFlight[s.key] >= s.limit {
+			return nil, s.key.scope
+		}
+
+		keys = append(keys, s.key)
+	}
+
+	for _, key := range keys {
+		i.inFlight[key]++
+	}
+
+	return func() {
+		i.mu.Lock()
+		defer i.mu.Unlock()
+
+		for _, key := range keys {
+			// counts are removed once they reach zero so they don't accumulate
+			if i.inFlight[key]--; i.inFlight[key] <= 0 {
+				delete(i.inFlight, key)
+			}
+		}
+	}, ""
+}
+
+func (i *RateLimitInterceptor) bucket(key bucketKey, limit RateLimit) *rate.Limiter {
+	limiter, ok := i.buckets[key]
+	if !ok {
+		// a bucket must hold at least one token or every request would be rejected
+		limiter = rate.NewLimiter(rate.Limit(limit.Rate), max(limit.Burst, 1))
+		i.buckets[key] = limiter
+	}
+
+	return limiter
+}
+
+// sweep removes buckets which have refilled so the buckets of tenants and subjects which stop sending requests
+// don't accumulate.
+func (i *RateLimitInterceptor) sweep(now time.Time) {
+	if now.Sub(i.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+
+	i.lastSweep = now
+
+	for key, limiter := range i.buckets {
+		if limiter.TokensAt(now) >= float64(limiter.Burst()) {
+			delete(i.buckets, key)
+		}
+	}
+
+	for key, last := range i.notified {
+		if now.Sub(last) >= quotaEventInterval {
+			delete(i.notified, key)
+		}
+	}
+}
+
+func tenantKey(identity ciauth.OIDCIdentity) string {
+	return identity.Owner() + "/" + identity.Provider()
+}
+
+func subjectKey(identity ciauth.OIDCIdentity) string {
+	return identity.Issuer() + "#" + identity.Subject()
+}
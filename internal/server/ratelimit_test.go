@@ -0,0 +1,290 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wolfeidau/zipstash/internal/ciauth"
+	"github.com/wolfeidau/zipstash/internal/events"
+	"github.com/wolfeidau/zipstash/pkg/ratelimit"
+)
+
+const createEntryProcedure = "/cache.v1.CacheService/CreateEntry"
+
+// subjectIdentity is an identity of the same tenant as fakeIdentity with a different subject.
+type subjectIdentity struct {
+	fakeIdentity
+	subject string
+}
+
+func (s subjectIdentity) Subject() string { return s.subject }
+
+func TestRateLimitUnmarshalText(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    RateLimit
+		wantErr bool
+	}{
+		{name: "rate and burst", text: "10/20", want: RateLimit{Rate: 10, Burst: 20}},
+		{name: "rate", text: "5", want: RateLimit{Rate: 5, Burst: 5}},
+		{name: "fractional rate", text: "0.5/2", want: RateLimit{Rate: 0.5, Burst: 2}},
+		{name: "disabled", text: "0", want: RateLimit{}},
+		{name: "invalid rate", text: "fast/10", wantErr: true},
+		{name: "invalid burst", text: "10/lots", wantErr: true},
+		{name: "negative", text: "-1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			var got RateLimit
+			err := got.UnmarshalText([]byte(tt.text))
+			if tt.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tt.want, got)
+		})
+	}
+}
+
+func TestRateLimitsForProcedure(t *testing.T) {
+	assert := require.New(t)
+
+	limits := RateLimits{
+		Default: RateLimit{Rate: 10, Burst: 10},
+		Procedures: map[string]RateLimit{
+			"CreateEntry":                        {Rate: 1, Burst: 1},
+			"/provision.v1.ProvisionService/Foo": {Rate: 2, Burst: 2},
+		},
+	}
+
+	limit, group := limits.forProcedure(createEntryProcedure)
+	assert.Equal(RateLimit{Rate: 1, Burst: 1}, limit)
+	assert.Equal(createEntryProcedure, group)
+
+	limit, group = limits.forProcedure("/provision.v1.ProvisionService/Foo")
+	assert.Equal(RateLimit{Rate: 2, Burst: 2}, limit)
+	assert.Equal("/provision.v1.ProvisionService/Foo", group)
+
+	limit, group = limits.forProcedure("/cache.v1.CacheService/GetEntry")
+	assert.Equal(RateLimit{Rate: 10, Burst: 10}, limit)
+	assert.Empty(group)
+}
+
+func TestRateLimitInterceptorReserve(t *testing.T) {
+	assert := require.New(t)
+
+	i := NewRateLimitInterceptor(RateLimitConfig{
+		Tenant:  RateLimits{Default: RateLimit{Rate: 1, Burst: 3}},
+		Subject: RateLimits{Default: RateLimit{Rate: 1, Burst: 2}},
+	})
+
+	now := time.Now()
+	main := subjectIdentity{subject: "main"}
+	branch := subjectIdentity{subject: "branch"}
+
+	for range 2 {
+		delay, _ := i.reserve(main, createEntryProcedure, now)
+		assert.Zero(delay)
+	}
+
+	// the subject has used its burst
+	delay, scope := i.reserve(main, createEntryProcedure, now)
+	assert.Equal(scopeSubject, scope)
+	assert.Equal(time.Second, delay)
+
+	// another subject of the tenant uses the last token of the tenant
+	delay, _ = i.reserve(branch, createEntryProcedure, now)
+	assert.Zero(delay)
+
+	delay, scope = i.reserve(branch, createEntryProcedure, now)
+	assert.Equal(scopeTenant, scope)
+	assert.Equal(time.Second, delay)
+
+	// rejected requests don't take tokens so the buckets refill at the rate
+	delay, _ = i.reserve(branch, createEntryProcedure, now.Add(time.Second))
+	assert.Zero(delay)
+}
+
+func TestRateLimitInterceptorProcedureLimits(t *testing.T) {
+	assert := require.New(t)
+
+	i := NewRateLimitInterceptor(RateLimitConfig{
+		Subject: RateLimits{
+			Default:    RateLimit{Rate: 1, Burst: 1},
+			Procedures: map[string]RateLimit{"CreateEntry": {Rate: 1, Burst: 1}},
+		},
+	})
+
+	now := time.Now()
+	identity := fakeIdentity{}
+
+	// procedures with their own limit are counted separately to the default
+	delay, _ := i.reserve(identity, createEntryProcedure, now)
+	assert.Zero(delay)
+
+	delay, _ = i.reserve(identity, "/cache.v1.CacheService/GetEntry", now)
+	assert.Zero(delay)
+
+	delay, _ = i.reserve(identity, createEntryProcedure, now)
+	assert.Positive(delay)
+
+	delay, _ = i.reserve(identity, "/cache.v1.CacheService/GetEntry", now)
+	assert.Positive(delay)
+}
+
+func TestRateLimitInterceptorSweep(t *testing.T) {
+	assert := require.New(t)
+
+	i := NewRateLimitInterceptor(RateLimitConfig{
+		Tenant: RateLimits{Default: RateLimit{Rate: 1, Burst: 1}},
+	})
+
+	now := time.Now()
+
+	delay, _ := i.reserve(fakeIdentity{}, createEntryProcedure, now)
+	assert.Zero(delay)
+	assert.Len(i.buckets, 1)
+
+	i.sweep(now.Add(rateLimitSweepInterval))
+	assert.Empty(i.buckets)
+}
+
+func TestRateLimitInterceptorUnary(t *testing.T) {
+	assert := require.New(t)
+
+	i := NewRateLimitInterceptor(RateLimitConfig{
+		Tenant: RateLimits{Default: RateLimit{Rate: 0.5, Burst: 1}},
+	})
+
+	next := connect.UnaryFunc(func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		return connect.NewResponse(&struct{}{}), nil
+	})
+	unary := i.WrapUnary(next)
+
+	// requests without an identity are left to the handlers
+	_, err := unary(context.Background(), connect.NewRequest(&struct{}{}))
+	assert.NoError(err)
+
+	ctx := ciauth.WithOIDCIdentity(context.Background(), fakeIdentity{})
+
+	_, err = unary(ctx, connect.NewRequest(&struct{}{}))
+	assert.NoError(err)
+
+	_, err = unary(ctx, connect.NewRequest(&struct{}{}))
+	assert.Equal(connect.CodeResourceExhausted, connect.CodeOf(err))
+
+	var connectErr *connect.Error
+	assert.ErrorAs(err, &connectErr)
+	assert.Equal("2", connectErr.Meta().Get(ratelimit.RetryAfterHeader))
+}
+
+func TestRateLimitInterceptorConcurrency(t *testing.T) {
+	assert := require.New(t)
+
+	i := NewRateLimitInterceptor(RateLimitConfig{
+		TenantConcurrency:  3,
+		SubjectConcurrency: 2,
+	})
+
+	main := subjectIdentity{subject: "main"}
+	branch := subjectIdentity{subject: "branch"}
+
+	releaseMain, _ := i.acquire(main)
+	assert.NotNil(releaseMain)
+
+	_, _ = i.acquire(main)
+
+	// the subject has the most requests in flight
+	release, scope := i.acquire(main)
+	assert.Nil(release)
+	assert.Equal(scopeSubject, scope)
+
+	// another subject of the tenant takes the last request of the tenant
+	releaseBranch, _ := i.acquire(branch)
+	assert.NotNil(releaseBranch)
+
+	release, scope = i.acquire(branch)
+	assert.Nil(release)
+	assert.Equal(scopeTenant, scope)
+
+	// rejected requests aren't counted so completing a request permits another
+	releaseMain()
+
+	release, _ = i.acquire(branch)
+	assert.NotNil(release)
+}
+
+func TestRateLimitInterceptorConcurrencyUnary(t *testing.T) {
+	assert := require.New(t)
+
+	i := NewRateLimitInterceptor(RateLimitConfig{
+		SubjectConcurrency: 1,
+	})
+
+	ctx := ciauth.WithOIDCIdentity(context.Background(), fakeIdentity{})
+
+	var nested error
+	unary := i.WrapUnary(func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		// a request made while the first is in flight is rejected
+		_, nested = i.WrapUnary(func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			return connect.NewResponse(&struct{}{}), nil
+		})(ctx, req)
+
+		return connect.NewResponse(&struct{}{}), nil
+	})
+
+	_, err := unary(ctx, connect.NewRequest(&struct{}{}))
+	assert.NoError(err)
+	assert.Equal(connect.CodeResourceExhausted, connect.CodeOf(nested))
+
+	var connectErr *connect.Error
+	assert.ErrorAs(nested, &connectErr)
+	assert.Equal("1", connectErr.Meta().Get(ratelimit.RetryAfterHeader))
+
+	// the request is released once it completes
+	assert.Empty(i.inFlight)
+}
+
+func TestRateLimitInterceptorQuotaExceeded(t *testing.T) {
+	assert := require.New(t)
+
+	publisher := &recordingPublisher{}
+
+	i := NewRateLimitInterceptor(RateLimitConfig{
+		Subject: RateLimits{Default: RateLimit{Rate: 1, Burst: 1}},
+		Events:  publisher,
+	})
+
+	ctx := ciauth.WithOIDCIdentity(context.Background(), fakeIdentity{})
+
+	release, err := i.allow(ctx, createEntryProcedure)
+	assert.NoError(err)
+	release()
+
+	// a pipeline looping on a procedure is only reported once each interval
+	for range 3 {
+		_, err = i.allow(ctx, createEntryProcedure)
+		assert.Equal(connect.CodeResourceExhausted, connect.CodeOf(err))
+	}
+
+	assert.Len(publisher.events, 1)
+
+	ev := publisher.events[0]
+	assert.Equal(events.TenantQuotaExceeded, ev.Type)
+	assert.Equal("github_actions#wolfeidau", ev.Tenant)
+	assert.Equal(createEntryProcedure, ev.Procedure)
+	assert.Equal(scopeSubject, ev.Scope)
+	assert.Equal(limitRate, ev.Limit)
+
+	i.publishQuotaExceeded(ctx, fakeIdentity{}, createEntryProcedure, scopeSubject, limitRate, time.Now().Add(quotaEventInterval))
+	assert.Len(publisher.events, 2)
+}
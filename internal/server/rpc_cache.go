@@ -15,9 +15,13 @@ import (
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/protobuf/types/known/durationpb"
 
 	v1 "github.com/wolfeidau/zipstash/api/gen/proto/go/cache/v1"
+	"github.com/wolfeidau/zipstash/internal/audit"
 	"github.com/wolfeidau/zipstash/internal/ciauth"
+	"github.com/wolfeidau/zipstash/internal/events"
 	"github.com/wolfeidau/zipstash/internal/index"
 	"github.com/wolfeidau/zipstash/pkg/trace"
 )
@@ -25,11 +29,25 @@ import (
 const (
 	cacheRecordInflightTTL = 30 * time.Minute
 	cacheRecordTTL         = 7 * 24 * time.Hour
+	maxCacheRecordTTL      = 90 * 24 * time.Hour
+
+	// batchConcurrency is the number of entries in a batch request which are looked up concurrently
+	batchConcurrency = 5
 )
 
 type CacheConfig struct {
 	GetS3Client S3ClientFunc
 	CacheBucket string
+	// Audit records the entries created and restored, nothing is recorded when nil.
+	Audit *audit.Recorder
+	// Events notifies subscribers of entries being created and restored, nothing is published when nil.
+	Events events.Publisher
+	// DefaultBranch is the branch whose entries every branch can restore through the actions cache api, as the
+	// token doesn't include the default branch of the repository.
+	DefaultBranch string
+	// BucketRetention is how long the lifecycle of the bucket keeps objects, registry tags are capped at this so they
+	// don't refer to expired blobs. Nothing is capped when zero.
+	BucketRetention time.Duration
 }
 
 type S3ClientFunc func() *s3.Client
@@ -37,15 +55,22 @@ type S3ClientFunc func() *s3.Client
 type CacheServiceHandler struct {
 	s3Client  *s3.Client
 	presigner *Presigner
+	metrics   *cacheMetrics
+	audit     *audit.Recorder
+	events    events.Publisher
 	store     *index.Store
 	cfg       CacheConfig
 }
 
 func NewCacheServiceHandler(ctx context.Context, cfg CacheConfig, store *index.Store) *CacheServiceHandler {
 	s3Client := cfg.GetS3Client()
+	presigner := NewPresigner(s3Client, cfg.CacheBucket)
 	return &CacheServiceHandler{
 		s3Client:  s3Client,
-		presigner: NewPresigner(s3Client, cfg.CacheBucket),
+		presigner: presigner,
+		metrics:   presigner.metrics, // shared so the instruments are only created once
+		audit:     cfg.Audit,
+		events:    cfg.Events,
 		store:     store,
 		cfg:       cfg,
 	}
@@ -56,20 +81,66 @@ func (zs *CacheServiceHandler) CheckEntry(ctx context.Context, checkReq *connect
 	ctx, span := trace.Start(ctx, "Cache.CheckEntry")
 	defer span.End()
 
-	owner := checkReq.Msg.Owner
+	res, err := zs.checkEntry(ctx, checkReq.Msg)
+	if err != nil {
+		return nil, err // already a connect error
+	}
+
+	return connect.NewResponse(res), nil
+}
+
+// CheckEntries checks if each of a batch of cache entries exist, the results are returned in the same order as the request.
+func (zs *CacheServiceHandler) CheckEntries(ctx context.Context, checkReq *connect.Request[v1.CheckEntriesRequest]) (*connect.Response[v1.CheckEntriesResponse], error) {
+	ctx, span := trace.Start(ctx, "Cache.CheckEntries")
+	defer span.End()
+
+	span.SetAttributes(attribute.Int("entries", len(checkReq.Msg.Entries)))
+
+	results := make([]*v1.CheckEntryResponse, len(checkReq.Msg.Entries))
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(batchConcurrency)
+
+	for i, entry := range checkReq.Msg.Entries {
+		g.Go(func() error {
+			res, err := zs.checkEntry(ctx, entry)
+			if err != nil {
+				return err
+			}
+
+			results[i] = res
+
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err // already a connect error
+	}
+
+	return connect.NewResponse(&v1.CheckEntriesResponse{
+		Results: results,
+	}), nil
+}
+
+func (zs *CacheServiceHandler) checkEntry(ctx context.Context, msg *v1.CheckEntryRequest) (*v1.CheckEntryResponse, error) {
+	ctx, span := trace.Start(ctx, "Cache.checkEntry")
+	defer span.End()
+
+	owner := msg.Owner
 
 	log.Info().
 		Str("Owner", owner).
-		Str("ProviderType", fromProviderV1(checkReq.Msg.ProviderType)).
+		Str("ProviderType", fromProviderV1(msg.ProviderType)).
 		Msg("check the tenant exists")
 
 	// validate the owner
-	_, err := zs.validateOwner(ctx, owner, fromProviderV1(checkReq.Msg.ProviderType))
+	_, err := zs.validateOwner(ctx, owner, fromProviderV1(msg.ProviderType))
 	if err != nil {
 		return nil, err // already a connect error
 	}
 
-	cacheID := buildCacheKey(owner, fromProviderV1(checkReq.Msg.ProviderType), checkReq.Msg.Platform.OperatingSystem, checkReq.Msg.Platform.Architecture, checkReq.Msg.Key)
+	cacheID := buildCacheKey(owner, fromProviderV1(msg.ProviderType), msg.Platform.OperatingSystem, msg.Platform.Architecture, msg.Key)
 
 	// does the cache entry already exist?
 	exists, cacheRec, err := zs.store.ExistsCache(ctx, cacheID)
@@ -80,14 +151,14 @@ func (zs *CacheServiceHandler) CheckEntry(ctx context.Context, checkReq *connect
 
 	span.SetAttributes(attribute.String("cache_id", cacheID), attribute.Bool("exists", exists))
 
-	return connect.NewResponse(&v1.CheckEntryResponse{
+	return &v1.CheckEntryResponse{
 		Exists:    exists,
 		Sha256Sum: cacheRec.Sha256,
-	}), nil
+	}, nil
 }
 
 // CreateEntry creates a new cache entry, this is the first step in the cache entry creation process.
-func (zs *CacheServiceHandler) CreateEntry(ctx context.Context, createReq *connect.Request[v1.CreateEntryRequest]) (*connect.Response[v1.CreateEntryResponse], error) {
+func (zs *CacheServiceHandler) CreateEntry(ctx context.Context, createReq *connect.Request[v1.CreateEntryRequest]) (_ *connect.Response[v1.CreateEntryResponse], err error) {
 	ctx, span := trace.Start(ctx, "Cache.CreateEntry")
 	defer span.End()
 
@@ -99,13 +170,24 @@ func (zs *CacheServiceHandler) CreateEntry(ctx context.Context, createReq *conne
 
 	owner := createReq.Msg.CacheEntry.Owner
 
+	ev := newAuditEvent(ctx, audit.ActionCacheCreate)
+	ev.Tenant = index.TenantKey(fromProviderV1(createReq.Msg.ProviderType), owner)
+	ev.Key = createReq.Msg.CacheEntry.Key
+	ev.Branch = createReq.Msg.CacheEntry.Branch
+	ev.Sha256 = createReq.Msg.CacheEntry.Sha256Sum
+
+	defer func() {
+		ev.Result = auditResult(err)
+		zs.audit.Record(ctx, ev)
+	}()
+
 	log.Info().
 		Str("Owner", createReq.Msg.CacheEntry.Owner).
 		Str("ProviderType", fromProviderV1(createReq.Msg.ProviderType)).
 		Msg("check the tenant exists")
 
 	// validate the owner
-	_, err := zs.validateOwner(ctx, owner, fromProviderV1(createReq.Msg.ProviderType))
+	tenant, err := zs.validateOwner(ctx, owner, fromProviderV1(createReq.Msg.ProviderType))
 	if err != nil {
 		return nil, err // already a connect error
 	}
@@ -115,6 +197,7 @@ func (zs *CacheServiceHandler) CreateEntry(ctx context.Context, createReq *conne
 	branch := createReq.Msg.CacheEntry.Branch
 
 	cacheID := buildCacheKey(owner, fromProviderV1(createReq.Msg.ProviderType), createReq.Msg.Platform.OperatingSystem, createReq.Msg.Platform.Architecture, createReq.Msg.CacheEntry.Key)
+	ev.CacheID = cacheID
 
 	// does the cache entry already exist?
 	exists, _, err := zs.store.ExistsCache(ctx, cacheID)
@@ -144,6 +227,7 @@ func (zs *CacheServiceHandler) CreateEntry(ctx context.Context, createReq *conne
 		createReq.Msg.CacheEntry.Sha256Sum,
 		createReq.Msg.CacheEntry.Compression,
 		createReq.Msg.CacheEntry.FileSize,
+		CalculatePartSize(createReq.Msg.CacheEntry.FileSize, createReq.Msg.PartSize, createReq.Msg.Platform.CpuCount),
 	)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to presign upload")
@@ -164,6 +248,7 @@ func (zs *CacheServiceHandler) CreateEntry(ctx context.Context, createReq *conne
 		Compression:       createReq.Msg.CacheEntry.Compression,
 		MultipartUploadId: uploadInstructs.MultipartUploadId,
 		FileSize:          createReq.Msg.CacheEntry.FileSize,
+		TTL:               entryTTL(createReq.Msg.Ttl, tenantTTL(tenant)),
 		UpdatedAt:         time.Now(),
 	}
 
@@ -200,10 +285,17 @@ func (zs *CacheServiceHandler) CreateEntry(ctx context.Context, createReq *conne
 }
 
 // UpdateEntry updates an existing cache entry, this is the second step in the cache entry creation process and is called after the upload is complete.
-func (zs *CacheServiceHandler) UpdateEntry(ctx context.Context, updateReq *connect.Request[v1.UpdateEntryRequest]) (*connect.Response[v1.UpdateEntryResponse], error) {
+func (zs *CacheServiceHandler) UpdateEntry(ctx context.Context, updateReq *connect.Request[v1.UpdateEntryRequest]) (_ *connect.Response[v1.UpdateEntryResponse], err error) {
 	ctx, span := trace.Start(ctx, "Cache.UpdateEntry")
 	defer span.End()
 
+	ev := newAuditEvent(ctx, audit.ActionCacheCommit)
+
+	defer func() {
+		ev.Result = auditResult(err)
+		zs.audit.Record(ctx, ev)
+	}()
+
 	// does the in flight cache entry exist?
 	exists, cacheRec, err := zs.store.ExistsCache(ctx, updateReq.Msg.Id)
 	if err != nil {
@@ -218,6 +310,12 @@ func (zs *CacheServiceHandler) UpdateEntry(ctx context.Context, updateReq *conne
 
 	cacheID := buildCacheKey(cacheRec.Owner, cacheRec.Provider, cacheRec.OperatingSystem, cacheRec.Architecture, cacheRec.Key)
 
+	ev.Tenant = index.TenantKey(cacheRec.Provider, cacheRec.Owner)
+	ev.Key = cacheRec.Key
+	ev.CacheID = cacheID
+	ev.Branch = cacheRec.Branch
+	ev.Sha256 = cacheRec.Sha256
+
 	log.Info().
 		Str("Id", updateReq.Msg.Id).
 		Str("Key", cacheRec.Key).
@@ -238,6 +336,8 @@ func (zs *CacheServiceHandler) UpdateEntry(ctx context.Context, updateReq *conne
 			log.Error().Err(err).Msg("failed to complete multipart upload")
 			return nil, connect.NewError(connect.CodeInternal, errors.New("cache.v1.CacheService.UpdateEntry internal error"))
 		}
+
+		zs.metrics.multipartFinished(ctx)
 	}
 
 	// update the cache entry in the cache index
@@ -255,12 +355,20 @@ func (zs *CacheServiceHandler) UpdateEntry(ctx context.Context, updateReq *conne
 	}, "#")
 
 	// move the inflight cache entry to the cache index
-	err = zs.store.PutCache(ctx, cacheID, created, cacheRec, cacheRecordTTL)
+	// records created before the ttl was stored use the default
+	ttl := cacheRec.TTL
+	if ttl <= 0 {
+		ttl = cacheRecordTTL
+	}
+
+	err = zs.store.PutCache(ctx, cacheID, created, cacheRec, ttl)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to update cache entry")
 		return nil, connect.NewError(connect.CodeInternal, errors.New("cache.v1.CacheService.UpdateEntry internal error"))
 	}
 
+	zs.publishEntry(ctx, events.EntryCreated, cacheID, cacheRec, false)
+
 	return connect.NewResponse(&v1.UpdateEntryResponse{
 		Id: updateReq.Msg.Id,
 	}), nil
@@ -271,20 +379,87 @@ func (zs *CacheServiceHandler) GetEntry(ctx context.Context, getReq *connect.Req
 	ctx, span := trace.Start(ctx, "Cache.GetEntry")
 	defer span.End()
 
+	res, err := zs.getEntry(ctx, getReq.Msg)
+	if err != nil {
+		return nil, err // already a connect error
+	}
+
+	return connect.NewResponse(res), nil
+}
+
+// GetEntries returns a batch of cache entries, entries which don't exist are returned as not found rather than
+// failing the whole batch. The results are returned in the same order as the request.
+func (zs *CacheServiceHandler) GetEntries(ctx context.Context, getReq *connect.Request[v1.GetEntriesRequest]) (*connect.Response[v1.GetEntriesResponse], error) {
+	ctx, span := trace.Start(ctx, "Cache.GetEntries")
+	defer span.End()
+
+	span.SetAttributes(attribute.Int("entries", len(getReq.Msg.Entries)))
+
+	results := make([]*v1.GetEntriesResult, len(getReq.Msg.Entries))
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(batchConcurrency)
+
+	for i, entry := range getReq.Msg.Entries {
+		g.Go(func() error {
+			res, err := zs.getEntry(ctx, entry)
+			if err != nil {
+				if connect.CodeOf(err) == connect.CodeNotFound {
+					results[i] = &v1.GetEntriesResult{Found: false}
+					return nil
+				}
+				return err
+			}
+
+			results[i] = &v1.GetEntriesResult{Found: true, Entry: res}
+
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err // already a connect error
+	}
+
+	return connect.NewResponse(&v1.GetEntriesResponse{
+		Results: results,
+	}), nil
+}
+
+func (zs *CacheServiceHandler) getEntry(ctx context.Context, msg *v1.GetEntryRequest) (_ *v1.GetEntryResponse, err error) {
+	ctx, span := trace.Start(ctx, "Cache.getEntry")
+	defer span.End()
+
 	span.SetAttributes(
-		attribute.String("key", getReq.Msg.Key),
-		attribute.String("owner", getReq.Msg.Owner),
-		attribute.String("provider", fromProviderV1(getReq.Msg.ProviderType)),
+		attribute.String("key", msg.Key),
+		attribute.String("owner", msg.Owner),
+		attribute.String("provider", fromProviderV1(msg.ProviderType)),
 	)
 
+	// each entry of a batch is recorded so it is known who restored what, inspections only read the manifest
+	action := audit.ActionCacheRestore
+	if msg.Inspect {
+		action = audit.ActionCacheInspect
+	}
+
+	ev := newAuditEvent(ctx, action)
+	ev.Tenant = index.TenantKey(fromProviderV1(msg.ProviderType), msg.Owner)
+	ev.Key = msg.Key
+	ev.Branch = msg.Branch
+
+	defer func() {
+		ev.Result = auditResult(err)
+		zs.audit.Record(ctx, ev)
+	}()
+
 	// validate the owner
-	_, err := zs.validateOwner(ctx, getReq.Msg.Owner, fromProviderV1(getReq.Msg.ProviderType))
+	_, err = zs.validateOwner(ctx, msg.Owner, fromProviderV1(msg.ProviderType))
 	if err != nil {
 		return nil, err // already a connect error
 	}
 
 	// does the cache entry exist?
-	existsWithFallbackRes, err := zs.existsWithFallback(ctx, getReq)
+	existsWithFallbackRes, err := zs.existsWithFallback(ctx, msg)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to check if cache entry exists")
 		return nil, connect.NewError(connect.CodeInternal, errors.New("cache.v1.CacheService.GetEntry internal error"))
@@ -292,6 +467,7 @@ func (zs *CacheServiceHandler) GetEntry(ctx context.Context, getReq *connect.Req
 
 	if !existsWithFallbackRes.exists {
 		log.Info().Msg("cache entry does not exist")
+		zs.metrics.recordLookup(ctx, msg.Owner, fromProviderV1(msg.ProviderType), lookupMiss)
 		return nil, connect.NewError(connect.CodeNotFound, errors.New("cache.v1.CacheService.GetEntry cache entry does not exist"))
 	}
 
@@ -302,9 +478,12 @@ func (zs *CacheServiceHandler) GetEntry(ctx context.Context, getReq *connect.Req
 	}
 
 	if !exists {
+		zs.metrics.recordLookup(ctx, msg.Owner, fromProviderV1(msg.ProviderType), lookupMiss)
 		return nil, connect.NewError(connect.CodeNotFound, errors.New("cache.v1.CacheService.GetEntry not found"))
 	}
 
+	zs.metrics.recordLookup(ctx, msg.Owner, fromProviderV1(msg.ProviderType), lookupResult(existsWithFallbackRes.fallback))
+
 	log.Info().
 		Str("cacheID", existsWithFallbackRes.cacheID).
 		Bool("exists", existsWithFallbackRes.exists).
@@ -315,6 +494,7 @@ func (zs *CacheServiceHandler) GetEntry(ctx context.Context, getReq *connect.Req
 		ctx,
 		existsWithFallbackRes.cacheID,
 		aws.ToInt64(res.ContentLength),
+		CalculatePartSize(aws.ToInt64(res.ContentLength), msg.PartSize, msg.Platform.CpuCount),
 	)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to presign download")
@@ -325,9 +505,20 @@ func (zs *CacheServiceHandler) GetEntry(ctx context.Context, getReq *connect.Req
 
 	record := existsWithFallbackRes.record
 
-	return connect.NewResponse(&v1.GetEntryResponse{
+	ev.CacheID = existsWithFallbackRes.cacheID
+	ev.Branch = record.Branch
+	ev.Sha256 = record.Sha256
+	ev.Fallback = existsWithFallbackRes.fallback
+
+	if !msg.Inspect {
+		zs.publishEntry(ctx, events.EntryRestored, existsWithFallbackRes.cacheID, record, existsWithFallbackRes.fallback)
+	}
+
+	return &v1.GetEntryResponse{
+		Id: existsWithFallbackRes.cacheID,
 		CacheEntry: &v1.CacheEntry{
-			Key:         existsWithFallbackRes.cacheID,
+			Key:         record.Key,
+			Owner:       record.Owner,
 			Name:        record.Name,
 			Branch:      record.Branch,
 			Compression: record.Compression,
@@ -337,7 +528,22 @@ func (zs *CacheServiceHandler) GetEntry(ctx context.Context, getReq *connect.Req
 		Multipart:            downloadInstructs.Multipart,
 		Fallback:             existsWithFallbackRes.fallback,
 		DownloadInstructions: fromInstructToDownloadV1(downloadInstructs.DownloadInstructions),
-	}), nil
+	}, nil
+}
+
+// Ping checks the bucket can be accessed, this is used by readiness probes.
+func (zs *CacheServiceHandler) Ping(ctx context.Context) error {
+	ctx, span := trace.Start(ctx, "Cache.Ping")
+	defer span.End()
+
+	_, err := zs.s3Client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(zs.cfg.CacheBucket),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to access bucket: %w", err)
+	}
+
+	return nil
 }
 
 // validateOwner validates the owner of the cache entry using the oidc identity. The owner needs to exist in the tenant index.
@@ -395,15 +601,16 @@ type existsWithFallbackResult struct {
 	fallback bool
 }
 
-// existsWithFallback checks if the cache entry exists in the cache index. If it does not exist, it uses the fallback branch to check if the cache entry using a prefix search of the cache created
-func (zs *CacheServiceHandler) existsWithFallback(ctx context.Context, getReq *connect.Request[v1.GetEntryRequest]) (existsWithFallbackResult, error) {
+// existsWithFallback checks if the cache entry exists in the cache index. If it does not exist, the restore keys are tried in order
+// as key prefixes, then it uses the fallback branch to check if the cache entry using a prefix search of the cache created
+func (zs *CacheServiceHandler) existsWithFallback(ctx context.Context, msg *v1.GetEntryRequest) (existsWithFallbackResult, error) {
 	ctx, span := trace.Start(ctx, "Cache.existsWithFallback")
 	defer span.End()
 
-	cacheID := buildCacheKey(getReq.Msg.Owner, fromProviderV1(getReq.Msg.ProviderType), getReq.Msg.Platform.OperatingSystem, getReq.Msg.Platform.Architecture, getReq.Msg.Key)
+	cacheID := buildCacheKey(msg.Owner, fromProviderV1(msg.ProviderType), msg.Platform.OperatingSystem, msg.Platform.Architecture, msg.Key)
 
 	log.Info().
-		Str("key", getReq.Msg.Key).
+		Str("key", msg.Key).
 		Str("cacheID", cacheID).
 		Msg("cache entry get request")
 
@@ -418,14 +625,42 @@ func (zs *CacheServiceHandler) existsWithFallback(ctx context.Context, getReq *c
 		return existsWithFallbackResult{exists: true, record: record, cacheID: cacheID}, nil
 	}
 
+	for _, restoreKey := range msg.RestoreKeys {
+		// an empty prefix would match every entry for the platform
+		if restoreKey == "" {
+			continue
+		}
+
+		prefix := buildCacheKey(msg.Owner, fromProviderV1(msg.ProviderType), msg.Platform.OperatingSystem, msg.Platform.Architecture, restoreKey)
+
+		prefixExists, record, err := zs.store.FindCacheByKeyPrefix(ctx, prefix)
+		if err != nil {
+			return existsWithFallbackResult{}, fmt.Errorf("failed to check restore key cache entry exists: %w", err)
+		}
+
+		if prefixExists {
+			cacheID = buildCacheKey(record.Owner, record.Provider, record.OperatingSystem, record.Architecture, record.Key)
+
+			log.Info().
+				Str("key", msg.Key).
+				Str("restoreKey", restoreKey).
+				Str("cacheID", cacheID).
+				Msg("cache entry get request with restore key")
+
+			span.SetAttributes(attribute.String("restoreKey", restoreKey), attribute.String("cacheKey", cacheID))
+
+			return existsWithFallbackResult{exists: true, record: record, cacheID: cacheID, fallback: true}, nil
+		}
+	}
+
 	// TODO: we should enable customization of this field to allow for removal of fields to change behavior
 	createdPrefix := strings.Join([]string{
-		getReq.Msg.Owner,
-		fromProviderV1(getReq.Msg.ProviderType),
-		getReq.Msg.Platform.OperatingSystem,
-		getReq.Msg.Platform.Architecture,
-		getReq.Msg.Name,
-		escapeValue(getReq.Msg.FallbackBranch),
+		msg.Owner,
+		fromProviderV1(msg.ProviderType),
+		msg.Platform.OperatingSystem,
+		msg.Platform.Architecture,
+		msg.Name,
+		escapeValue(msg.FallbackBranch),
 		"", // include empty string to ensure the string ends in a #
 	}, "#")
 
@@ -437,7 +672,7 @@ func (zs *CacheServiceHandler) existsWithFallback(ctx context.Context, getReq *c
 	cacheID = buildCacheKey(record.Owner, record.Provider, record.OperatingSystem, record.Architecture, record.Key)
 
 	log.Info().
-		Str("key", getReq.Msg.Key).
+		Str("key", msg.Key).
 		Str("cacheID", cacheID).
 		Msg("cache entry get request with fallback")
 
@@ -450,6 +685,25 @@ func (zs *CacheServiceHandler) existsWithFallback(ctx context.Context, getReq *c
 	return existsWithFallbackResult{}, nil
 }
 
+// entryTTL returns the requested ttl for a cache entry clamped to the maximum, or the default when none is requested.
+func entryTTL(ttl *durationpb.Duration, defaultTTL time.Duration) time.Duration {
+	if ttl == nil || ttl.AsDuration() <= 0 {
+		return defaultTTL
+	}
+
+	return min(ttl.AsDuration(), maxCacheRecordTTL)
+}
+
+// tenantTTL returns the ttl of cache entries of the tenant clamped to the maximum, or the server default when the
+// tenant doesn't set one.
+func tenantTTL(tenant index.TenantRecord) time.Duration {
+	if tenant.TTL <= 0 {
+		return cacheRecordTTL
+	}
+
+	return min(tenant.TTL, maxCacheRecordTTL)
+}
+
 func fromUploadInstructions(uploadInstructs []CacheURLInstruction) []*v1.CacheUploadInstruction {
 	uploadInstsV1 := make([]*v1.CacheUploadInstruction, len(uploadInstructs))
 
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	v1 "github.com/wolfeidau/zipstash/api/gen/proto/go/cache/v1"
+	providerv1 "github.com/wolfeidau/zipstash/api/gen/proto/go/provider/v1"
+	"github.com/wolfeidau/zipstash/internal/audit"
+	"github.com/wolfeidau/zipstash/internal/ciauth"
+	"github.com/wolfeidau/zipstash/internal/events"
+	"github.com/wolfeidau/zipstash/internal/index"
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+// newTestCacheService returns a cache service backed by an in memory bucket and index with the tenant of the fake
+// identity.
+func newTestCacheService(t *testing.T, cfg CacheConfig) (*CacheServiceHandler, *fakeS3, *index.Store) {
+	t.Helper()
+
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	require.NoError(t, err)
+
+	bucket, s3Client := newFakeS3(t)
+	_, store := newFakeIndex(t)
+
+	cfg.GetS3Client = func() *s3.Client { return s3Client }
+	cfg.CacheBucket = fakeBucket
+
+	return NewCacheServiceHandler(context.Background(), cfg, store), bucket, store
+}
+
+func TestGetEntryInspect(t *testing.T) {
+	tests := []struct {
+		name    string
+		inspect bool
+		action  string
+		events  []string
+	}{
+		{name: "restore", action: audit.ActionCacheRestore, events: []string{events.EntryRestored}},
+		{name: "inspect", inspect: true, action: audit.ActionCacheInspect},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			sink := &memorySink{}
+			publisher := &recordingPublisher{}
+
+			zs, bucket, store := newTestCacheService(t, CacheConfig{Audit: audit.NewRecorder(sink), Events: publisher})
+
+			cacheID := buildCacheKey("wolfeidau", ciauth.GitHubActions, "linux", "amd64", "go-mod")
+			bucket.put(cacheID, []byte("archive"))
+
+			err := store.PutCache(context.Background(), cacheID, "", index.CacheRecord{
+				Key:             "go-mod",
+				Owner:           "wolfeidau",
+				Provider:        ciauth.GitHubActions,
+				Branch:          "main",
+				OperatingSystem: "linux",
+				Architecture:    "amd64",
+			}, time.Hour)
+			assert.NoError(err)
+
+			ctx := ciauth.WithOIDCIdentity(context.Background(), fakeIdentity{claims: &ciauth.GitHubActionsClaims{}})
+
+			res, err := zs.GetEntry(ctx, connect.NewRequest(&v1.GetEntryRequest{
+				ProviderType: providerv1.Provider_PROVIDER_GITHUB_ACTIONS,
+				Key:          "go-mod",
+				Name:         "wolfeidau/zipstash",
+				Branch:       "main",
+				Owner:        "wolfeidau",
+				Platform:     &v1.Platform{OperatingSystem: "linux", Architecture: "amd64", CpuCount: 2},
+				Inspect:      tt.inspect,
+			}))
+			assert.NoError(err)
+			assert.Equal(cacheID, res.Msg.Id)
+			assert.Equal(int64(len("archive")), res.Msg.CacheEntry.FileSize)
+
+			assert.Len(sink.events, 1)
+			assert.Equal(tt.action, sink.events[0].Action)
+			assert.Equal(audit.ResultSuccess, sink.events[0].Result)
+			assert.Equal(cacheID, sink.events[0].CacheID)
+
+			var published []string
+			for _, ev := range publisher.events {
+				published = append(published, ev.Type)
+			}
+			assert.Equal(tt.events, published)
+		})
+	}
+}
+
+func TestEntryTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		ttl      *durationpb.Duration
+		expected time.Duration
+	}{
+		{name: "not set", ttl: nil, expected: cacheRecordTTL},
+		{name: "zero", ttl: durationpb.New(0), expected: cacheRecordTTL},
+		{name: "negative", ttl: durationpb.New(-time.Hour), expected: cacheRecordTTL},
+		{name: "requested", ttl: durationpb.New(24 * time.Hour), expected: 24 * time.Hour},
+		{name: "clamped", ttl: durationpb.New(365 * 24 * time.Hour), expected: maxCacheRecordTTL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(tt.expected, entryTTL(tt.ttl, cacheRecordTTL))
+		})
+	}
+}
+
+func TestTenantTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		ttl      time.Duration
+		expected time.Duration
+	}{
+		{name: "not set", ttl: 0, expected: cacheRecordTTL},
+		{name: "tenant", ttl: 30 * 24 * time.Hour, expected: 30 * 24 * time.Hour},
+		{name: "clamped", ttl: 365 * 24 * time.Hour, expected: maxCacheRecordTTL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			ttl := tenantTTL(index.TenantRecord{TTL: tt.ttl})
+			assert.Equal(tt.expected, ttl)
+
+			// a requested ttl takes precedence over the tenant ttl
+			assert.Equal(time.Hour, entryTTL(durationpb.New(time.Hour), ttl))
+			assert.Equal(ttl, entryTTL(nil, ttl))
+		})
+	}
+}
@@ -0,0 +1,312 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	"connectrpc.com/connect"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/errgroup"
+
+	v1 "github.com/wolfeidau/zipstash/api/gen/proto/go/cache/v1"
+	"github.com/wolfeidau/zipstash/internal/audit"
+	"github.com/wolfeidau/zipstash/internal/ciauth"
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+const (
+	// objectsPrefix is the prefix of the objects stored with PutObjects, these aren't recorded in the cache index
+	// as compiler caches store millions of them.
+	objectsPrefix = "_objects/"
+
+	// MaxInlineObjectSize is the largest object sent inline in requests and responses, larger objects are
+	// transferred using presigned urls.
+	MaxInlineObjectSize = 256 * 1024
+
+	// maxInlineResponseSize limits the inline data in a response to stay well under the lambda response limit,
+	// objects past the limit are returned with presigned urls.
+	maxInlineResponseSize = 4 * 1024 * 1024
+
+	// objectsConcurrency is the number of objects in a batch request which are read or written concurrently.
+	objectsConcurrency = 16
+)
+
+// objectKey returns the key in the bucket of an object in a namespace of the owner.
+func objectKey(owner, provider, namespace, key string) string {
+	return objectsPrefix + path.Join(owner, provider, namespace, key)
+}
+
+// validateObjectKey checks a key can be used as part of the key of an object, keys may be split into segments by
+// slashes as compiler caches commonly shard their keys by prefix.
+func validateObjectKey(key string) error {
+	if len(key) > maxHTTPCacheKeyLength {
+		return fmt.Errorf("%w: invalid key %q", errInvalidRequest, key)
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		err := validateHTTPCacheKey(segment)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// objectsTenant returns the owner and provider of the OIDC token, which namespace the objects of the request. The
+// owner and provider of the request must match the token so a caller can't read or write the objects of another
+// tenant.
+func (zs *CacheServiceHandler) objectsTenant(ctx context.Context, procedure, owner, provider string) (string, string, error) {
+	identity := ciauth.GetOIDCIdentity(ctx)
+	if identity == nil {
+		return "", "", connect.NewError(connect.CodeUnauthenticated, fmt.Errorf("cache.v1.CacheService.%s unauthenticated", procedure))
+	}
+
+	if owner != identity.Owner() || provider != identity.Provider() {
+		log.Warn().
+			Str("owner", owner).
+			Str("provider", provider).
+			Str("identity.Owner", identity.Owner()).
+			Str("identity.Provider", identity.Provider()).
+			Msg("objects request owner doesn't match the token")
+		return "", "", connect.NewError(connect.CodePermissionDenied, errors.New("cache.v1.CacheService permission denied"))
+	}
+
+	_, err := zs.validateOwner(ctx, identity.Owner(), identity.Provider())
+	if err != nil {
+		return "", "", err
+	}
+
+	return identity.Owner(), identity.Provider(), nil
+}
+
+// GetObjects returns a batch of objects, objects which don't exist are returned as not found rather than failing
+// the whole batch. The results are returned in the same order as the request.
+func (zs *CacheServiceHandler) GetObjects(ctx context.Context, getReq *connect.Request[v1.GetObjectsRequest]) (*connect.Response[v1.GetObjectsResponse], error) {
+	ctx, span := trace.Start(ctx, "Cache.GetObjects")
+	defer span.End()
+
+	msg := getReq.Msg
+
+	span.SetAttributes(
+		attribute.String("owner", msg.Owner),
+		attribute.String("namespace", msg.Namespace),
+		attribute.Int("objects", len(msg.Keys)),
+	)
+
+	owner, provider, err := zs.objectsTenant(ctx, "GetObjects", msg.Owner, fromProviderV1(msg.ProviderType))
+	if err != nil {
+		return nil, err // already a connect error
+	}
+
+	err = validateHTTPCacheKey(msg.Namespace)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	for _, key := range msg.Keys {
+		err = validateObjectKey(key)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
+	}
+
+	results := make([]*v1.GetObjectResult, len(msg.Keys))
+
+	// the inline data is shared by the whole response
+	var inline atomic.Int64
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(objectsConcurrency)
+
+	for i, key := range msg.Keys {
+		g.Go(func() error {
+			res, err := zs.getObject(ctx, objectKey(owner, provider, msg.Namespace, key), &inline)
+			if err != nil {
+				log.Error().Err(err).Str("key", key).Msg("failed to get object")
+				return connect.NewError(connect.CodeInternal, errors.New("cache.v1.CacheService.GetObjects internal error"))
+			}
+
+			res.Key = key
+			results[i] = res
+
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err // already a connect error
+	}
+
+	return connect.NewResponse(&v1.GetObjectsResponse{
+		Results: results,
+	}), nil
+}
+
+// getObject reads an object, small objects are returned inline until the inline data of the response reaches
+// its limit and the rest are returned as presigned urls.
+func (zs *CacheServiceHandler) getObject(ctx context.Context, s3key string, inline *atomic.Int64) (*v1.GetObjectResult, error) {
+	ctx, span := trace.Start(ctx, "Cache.getObject")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("key", s3key))
+
+	res, err := zs.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(zs.cfg.CacheBucket),
+		Key:    aws.String(s3key),
+	})
+	if err != nil {
+		var nsk *types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return &v1.GetObjectResult{Found: false}, nil
+		}
+		return nil, fmt.Errorf("failed to get object: %w", err)
+	}
+	defer res.Body.Close()
+
+	size := aws.ToInt64(res.ContentLength)
+
+	if size <= MaxInlineObjectSize && inline.Add(size) <= maxInlineResponseSize {
+		data, err := io.ReadAll(io.LimitReader(res.Body, size+1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read object: %w", err)
+		}
+
+		if int64(len(data)) != size {
+			return nil, errSizeMismatch
+		}
+
+		return &v1.GetObjectResult{Found: true, Size: size, Data: data}, nil
+	}
+
+	url, err := zs.presigner.PresignDownload(ctx, s3key, size)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.GetObjectResult{Found: true, Size: size, DownloadUrl: url}, nil
+}
+
+// PutObjects stores a batch of objects, objects sent inline are stored before returning while larger objects are
+// returned with a presigned url to upload them. The results are returned in the same order as the request.
+func (zs *CacheServiceHandler) PutObjects(ctx context.Context, putReq *connect.Request[v1.PutObjectsRequest]) (_ *connect.Response[v1.PutObjectsResponse], err error) {
+	ctx, span := trace.Start(ctx, "Cache.PutObjects")
+	defer span.End()
+
+	msg := putReq.Msg
+
+	// a batch is recorded as a single event of the namespace as compiler caches store millions of objects
+	ev := newAuditEvent(ctx, audit.ActionCacheCreate)
+	ev.Key = msg.Namespace
+
+	defer func() {
+		ev.Result = auditResult(err)
+		zs.audit.Record(ctx, ev)
+	}()
+
+	span.SetAttributes(
+		attribute.String("owner", msg.Owner),
+		attribute.String("namespace", msg.Namespace),
+		attribute.Int("objects", len(msg.Objects)),
+	)
+
+	owner, provider, err := zs.objectsTenant(ctx, "PutObjects", msg.Owner, fromProviderV1(msg.ProviderType))
+	if err != nil {
+		return nil, err // already a connect error
+	}
+
+	err = validateHTTPCacheKey(msg.Namespace)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	for _, obj := range msg.Objects {
+		err = validatePutObject(obj)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
+	}
+
+	blobs := newBlobStore(zs.s3Client, zs.cfg.CacheBucket)
+	results := make([]*v1.PutObjectResult, len(msg.Objects))
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(objectsConcurrency)
+
+	for i, obj := range msg.Objects {
+		g.Go(func() error {
+			s3key := objectKey(owner, provider, msg.Namespace, obj.Key)
+
+			if !isInlineObject(obj) {
+				url, err := zs.presigner.PresignUpload(ctx, s3key, obj.Sha256Sum, obj.Size)
+				if err != nil {
+					log.Error().Err(err).Str("key", obj.Key).Msg("failed to presign object upload")
+					return connect.NewError(connect.CodeInternal, errors.New("cache.v1.CacheService.PutObjects internal error"))
+				}
+
+				results[i] = &v1.PutObjectResult{Key: obj.Key, UploadUrl: url}
+
+				return nil
+			}
+
+			err := blobs.put(ctx, s3key, bytes.NewReader(obj.Data), obj.Size, obj.Sha256Sum)
+			if err != nil {
+				if errors.Is(err, errChecksumMismatch) || errors.Is(err, errSizeMismatch) {
+					return connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("object %q: %w", obj.Key, err))
+				}
+
+				log.Error().Err(err).Str("key", obj.Key).Msg("failed to put object")
+				return connect.NewError(connect.CodeInternal, errors.New("cache.v1.CacheService.PutObjects internal error"))
+			}
+
+			results[i] = &v1.PutObjectResult{Key: obj.Key, Stored: true}
+
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err // already a connect error
+	}
+
+	return connect.NewResponse(&v1.PutObjectsResponse{
+		Results: results,
+	}), nil
+}
+
+// isInlineObject reports whether the object was sent inline, empty objects are always inline.
+func isInlineObject(obj *v1.PutObject) bool {
+	return len(obj.Data) > 0 || obj.Size == 0
+}
+
+func validatePutObject(obj *v1.PutObject) error {
+	err := validateObjectKey(obj.Key)
+	if err != nil {
+		return err
+	}
+
+	_, err = hex.DecodeString(obj.Sha256Sum)
+	if err != nil || len(obj.Sha256Sum) != 64 {
+		return fmt.Errorf("%w: invalid sha256sum for %q", errInvalidRequest, obj.Key)
+	}
+
+	switch {
+	case obj.Size < 0, obj.Size > maxHTTPUploadSize:
+		return fmt.Errorf("%w: invalid size for %q", errInvalidRequest, obj.Key)
+	case isInlineObject(obj) && (obj.Size > MaxInlineObjectSize || int64(len(obj.Data)) != obj.Size):
+		return fmt.Errorf("%w: inline data for %q doesn't match its size or exceeds %d bytes", errInvalidRequest, obj.Key, MaxInlineObjectSize)
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/wolfeidau/zipstash/api/gen/proto/go/cache/v1"
+	providerv1 "github.com/wolfeidau/zipstash/api/gen/proto/go/provider/v1"
+	"github.com/wolfeidau/zipstash/internal/ciauth"
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+func TestObjectKey(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("_objects/wolfeidau/github_actions/sccache/a/b/abc", objectKey("wolfeidau", ciauth.GitHubActions, "sccache", "a/b/abc"))
+}
+
+func TestValidatePutObject(t *testing.T) {
+	sum := strings.Repeat("ab", 32)
+
+	tests := []struct {
+		name    string
+		obj     *v1.PutObject
+		wantErr bool
+	}{
+		{name: "inline", obj: &v1.PutObject{Key: "a/b/abc", Size: 3, Sha256Sum: sum, Data: []byte("abc")}},
+		{name: "empty", obj: &v1.PutObject{Key: "abc", Sha256Sum: sum}},
+		{name: "upload", obj: &v1.PutObject{Key: "abc", Size: MaxInlineObjectSize + 1, Sha256Sum: sum}},
+		{name: "traversal", obj: &v1.PutObject{Key: "a/../b", Size: 3, Sha256Sum: sum, Data: []byte("abc")}, wantErr: true},
+		{name: "empty segment", obj: &v1.PutObject{Key: "a//b", Size: 3, Sha256Sum: sum, Data: []byte("abc")}, wantErr: true},
+		{name: "invalid sum", obj: &v1.PutObject{Key: "abc", Size: 3, Sha256Sum: strings.Repeat("z", 64), Data: []byte("abc")}, wantErr: true},
+		{name: "size mismatch", obj: &v1.PutObject{Key: "abc", Size: 4, Sha256Sum: sum, Data: []byte("abc")}, wantErr: true},
+		{name: "inline too large", obj: &v1.PutObject{Key: "abc", Size: MaxInlineObjectSize + 1, Sha256Sum: sum, Data: make([]byte, MaxInlineObjectSize+1)}, wantErr: true},
+		{name: "negative size", obj: &v1.PutObject{Key: "abc", Size: -1, Sha256Sum: sum}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			err := validatePutObject(tt.obj)
+			if tt.wantErr {
+				assert.ErrorIs(err, errInvalidRequest)
+				return
+			}
+			assert.NoError(err)
+		})
+	}
+}
+
+func TestObjectsTenant(t *testing.T) {
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	require.NoError(t, err)
+
+	zs := &CacheServiceHandler{}
+	identity := ciauth.WithOIDCIdentity(context.Background(), fakeIdentity{claims: &ciauth.GitHubActionsClaims{}})
+
+	// only requests which are rejected before the index is used are covered
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		owner    string
+		provider providerv1.Provider
+		code     connect.Code
+	}{
+		{name: "no identity", ctx: context.Background(), owner: "wolfeidau", provider: providerv1.Provider_PROVIDER_GITHUB_ACTIONS, code: connect.CodeUnauthenticated},
+		{name: "other owner", ctx: identity, owner: "other", provider: providerv1.Provider_PROVIDER_GITHUB_ACTIONS, code: connect.CodePermissionDenied},
+		{name: "other provider", ctx: identity, owner: "wolfeidau", provider: providerv1.Provider_PROVIDER_BUILDKITE, code: connect.CodePermissionDenied},
+		{name: "no owner", ctx: identity, provider: providerv1.Provider_PROVIDER_GITHUB_ACTIONS, code: connect.CodePermissionDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			_, err := zs.GetObjects(tt.ctx, connect.NewRequest(&v1.GetObjectsRequest{
+				ProviderType: tt.provider,
+				Owner:        tt.owner,
+				Namespace:    "sccache",
+				Keys:         []string{"abc"},
+			}))
+			assert.Equal(tt.code, connect.CodeOf(err))
+
+			_, err = zs.PutObjects(tt.ctx, connect.NewRequest(&v1.PutObjectsRequest{
+				ProviderType: tt.provider,
+				Owner:        tt.owner,
+				Namespace:    "sccache",
+				Objects:      []*v1.PutObject{{Key: "abc", Sha256Sum: strings.Repeat("ab", 32)}},
+			}))
+			assert.Equal(tt.code, connect.CodeOf(err))
+		})
+	}
+}
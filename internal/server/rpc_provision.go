@@ -6,37 +6,53 @@ import (
 
 	"connectrpc.com/connect"
 	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	v1 "github.com/wolfeidau/zipstash/api/gen/proto/go/provision/v1"
+	"github.com/wolfeidau/zipstash/internal/audit"
 	"github.com/wolfeidau/zipstash/internal/index"
 	"github.com/wolfeidau/zipstash/pkg/trace"
 )
 
 type ProvisionServiceHandler struct {
 	store *index.Store
+	audit *audit.Recorder
 }
 
-func NewProvisionServiceHandler(store *index.Store) *ProvisionServiceHandler {
+// NewProvisionServiceHandler creates a provision handler, admin actions are recorded by the auditor which may be nil.
+func NewProvisionServiceHandler(store *index.Store, auditor *audit.Recorder) *ProvisionServiceHandler {
 	return &ProvisionServiceHandler{
 		store: store,
+		audit: auditor,
 	}
 }
 
-func (ps *ProvisionServiceHandler) CreateTenant(ctx context.Context, req *connect.Request[v1.CreateTenantRequest]) (*connect.Response[v1.CreateTenantResponse], error) {
+func (ps *ProvisionServiceHandler) CreateTenant(ctx context.Context, req *connect.Request[v1.CreateTenantRequest]) (_ *connect.Response[v1.CreateTenantResponse], err error) {
 	ctx, span := trace.Start(ctx, "Provision.CreateTenant")
 	defer span.End()
 
+	ev := newAuditEvent(ctx, audit.ActionTenantCreate)
+	ev.Tenant = index.TenantKey(fromProviderV1(req.Msg.ProviderType), req.Msg.Slug)
+
+	defer func() {
+		ev.Result = auditResult(err)
+		ps.audit.Record(ctx, ev)
+	}()
+
 	value := index.TenantRecord{
 		ID:           req.Msg.Id,
 		ProviderType: fromProviderV1(req.Msg.ProviderType),
 		Owner:        req.Msg.Slug,
+		TTL:          req.Msg.Ttl.AsDuration(),
 	}
 	if err := value.Validate(); err != nil {
 		log.Error().Err(err).Msg("failed to validate tenant record")
 		return nil, connect.NewError(connect.CodeInternal, errors.New("cache.v1.ProvisionService.CreateTenant internal error"))
 	}
 
-	err := ps.store.PutTenant(ctx, req.Msg.Id, value)
+	err = ps.store.PutTenant(ctx, req.Msg.Id, value)
 	if err != nil {
 		span.RecordError(err)
 		if errors.Is(err, index.ErrAlreadyExists) {
@@ -52,10 +68,18 @@ func (ps *ProvisionServiceHandler) CreateTenant(ctx context.Context, req *connec
 	}), nil
 }
 
-func (ps *ProvisionServiceHandler) GetTenant(ctx context.Context, getTenantReq *connect.Request[v1.GetTenantRequest]) (*connect.Response[v1.GetTenantResponse], error) {
+func (ps *ProvisionServiceHandler) GetTenant(ctx context.Context, getTenantReq *connect.Request[v1.GetTenantRequest]) (_ *connect.Response[v1.GetTenantResponse], err error) {
 	ctx, span := trace.Start(ctx, "Provision.GetTenant")
 	defer span.End()
 
+	// the tenant is only known once it is found, lookups of missing tenants are recorded without one
+	ev := newAuditEvent(ctx, audit.ActionTenantGet)
+
+	defer func() {
+		ev.Result = auditResult(err)
+		ps.audit.Record(ctx, ev)
+	}()
+
 	tenant, err := ps.store.GetTenant(ctx, getTenantReq.Msg.Id)
 	if err != nil {
 		span.RecordError(err)
@@ -67,8 +91,86 @@ func (ps *ProvisionServiceHandler) GetTenant(ctx context.Context, getTenantReq *
 		return nil, connect.NewError(connect.CodeInternal, errors.New("cache.v1.ProvisionService.GetTenant internal error"))
 	}
 
+	ev.Tenant = index.TenantKey(tenant.ProviderType, tenant.Owner)
+
 	return connect.NewResponse(&v1.GetTenantResponse{
-		Id:   tenant.ID,
-		Slug: tenant.Owner,
+		Id:           tenant.ID,
+		ProviderType: toProviderV1(tenant.ProviderType),
+		Slug:         tenant.Owner,
+		Ttl:          durationpb.New(tenant.TTL),
 	}), nil
 }
+
+func (ps *ProvisionServiceHandler) QueryAuditEvents(ctx context.Context, queryReq *connect.Request[v1.QueryAuditEventsRequest]) (_ *connect.Response[v1.QueryAuditEventsResponse], err error) {
+	ctx, span := trace.Start(ctx, "Provision.QueryAuditEvents")
+	defer span.End()
+
+	tenant := index.TenantKey(fromProviderV1(queryReq.Msg.ProviderType), queryReq.Msg.Slug)
+
+	// queries are audited in the tenant being queried so reads of the audit log are visible alongside it
+	ev := newAuditEvent(ctx, audit.ActionAuditQuery)
+	ev.Tenant = tenant
+
+	defer func() {
+		ev.Result = auditResult(err)
+		ps.audit.Record(ctx, ev)
+	}()
+
+	events, nextPageToken, err := ps.audit.Query(ctx, audit.Query{
+		Tenant:     tenant,
+		TimePrefix: queryReq.Msg.TimePrefix,
+		Action:     queryReq.Msg.Action,
+		Key:        queryReq.Msg.Key,
+		Subject:    queryReq.Msg.Subject,
+		Limit:      queryReq.Msg.PageSize,
+		PageToken:  queryReq.Msg.PageToken,
+	})
+	if err != nil {
+		span.RecordError(err)
+		if errors.Is(err, audit.ErrQueryUnsupported) {
+			return nil, connect.NewError(connect.CodeFailedPrecondition, errors.New("cache.v1.ProvisionService.QueryAuditEvents audit log can't be queried"))
+		}
+
+		log.Error().Err(err).Msg("failed to query audit events")
+		return nil, connect.NewError(connect.CodeInternal, errors.New("cache.v1.ProvisionService.QueryAuditEvents internal error"))
+	}
+
+	res := &v1.QueryAuditEventsResponse{
+		Events:        make([]*v1.AuditEvent, 0, len(events)),
+		NextPageToken: nextPageToken,
+	}
+
+	for _, event := range events {
+		res.Events = append(res.Events, toAuditEventV1(event))
+	}
+
+	return connect.NewResponse(res), nil
+}
+
+func toAuditEventV1(ev audit.Event) *v1.AuditEvent {
+	event := &v1.AuditEvent{
+		Id:       ev.ID,
+		Time:     timestamppb.New(ev.Time),
+		Action:   ev.Action,
+		Result:   ev.Result,
+		Tenant:   ev.Tenant,
+		Key:      ev.Key,
+		CacheId:  ev.CacheID,
+		Branch:   ev.Branch,
+		Sha256:   ev.Sha256,
+		Fallback: ev.Fallback,
+		Subject:  ev.Subject,
+		Issuer:   ev.Issuer,
+		Actor:    ev.Actor,
+	}
+
+	if ev.Claims != nil {
+		claims, err := structpb.NewStruct(ev.Claims)
+		if err != nil {
+			log.Warn().Err(err).Str("id", ev.ID).Msg("failed to convert audit event claims")
+		}
+		event.Claims = claims
+	}
+
+	return event
+}
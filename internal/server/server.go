@@ -3,9 +3,12 @@ package server
 import (
 	"net/url"
 	"path"
+	"strings"
+	"time"
 
 	providerv1 "github.com/wolfeidau/zipstash/api/gen/proto/go/provider/v1"
 	"github.com/wolfeidau/zipstash/internal/ciauth"
+	"github.com/wolfeidau/zipstash/internal/index"
 )
 
 func fromProviderV1(prov providerv1.Provider) string {
@@ -21,6 +24,19 @@ func fromProviderV1(prov providerv1.Provider) string {
 	}
 }
 
+func toProviderV1(provider string) providerv1.Provider {
+	switch provider {
+	case ciauth.GitHubActions:
+		return providerv1.Provider_PROVIDER_GITHUB_ACTIONS
+	case ciauth.GitLab:
+		return providerv1.Provider_PROVIDER_GITLAB
+	case ciauth.Buildkite:
+		return providerv1.Provider_PROVIDER_BUILDKITE
+	default:
+		return providerv1.Provider_PROVIDER_UNSPECIFIED
+	}
+}
+
 // escapeValue escapes a string for use in a delimited index value.
 // Using escape as it means the value is still readable but the value is safe
 // to use in a delimited index.
@@ -31,3 +47,17 @@ func escapeValue(v string) string {
 func buildCacheKey(owner, provider, os, arch, key string) string {
 	return path.Join(owner, provider, os, arch, key)
 }
+
+// createdValue returns the value of the created index for a record, this is used to find the latest entry for
+// a branch when falling back.
+func createdValue(rec index.CacheRecord) string {
+	return strings.Join([]string{
+		rec.Owner,
+		rec.Provider,
+		rec.OperatingSystem,
+		rec.Architecture,
+		rec.Name,
+		escapeValue(rec.Branch),
+		time.Now().UTC().Format(time.RFC3339),
+	}, "#")
+}
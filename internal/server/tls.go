@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// CertReloader serves a certificate which is reloaded when its files change, this allows certificates issued by
+// tools such as cert-manager to be rotated without restarting the server.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewCertReloader loads the certificate and key, failing if they can't be loaded.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+
+	_, err := r.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// GetCertificate returns the current certificate, this is used as tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, nil
+}
+
+// Watch checks the files at the interval and reloads the certificate when they are modified, a certificate which
+// fails to load is logged and the previous certificate is kept.
+func (r *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := r.reload()
+			if err != nil {
+				log.Error().Err(err).Str("cert", r.certFile).Msg("failed to reload certificate")
+				continue
+			}
+
+			if reloaded {
+				log.Info().Str("cert", r.certFile).Msg("reloaded certificate")
+			}
+		}
+	}
+}
+
+// reload loads the certificate if either file was modified since it was last loaded.
+func (r *CertReloader) reload() (bool, error) {
+	modTime, err := latestModTime(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.RLock()
+	current := r.cert != nil && modTime.Equal(r.modTime)
+	r.mu.RUnlock()
+
+	if current {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	r.mu.Lock()
+	r.cert, r.modTime = &cert, modTime
+	r.mu.Unlock()
+
+	return true, nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+
+	for _, name := range files {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to stat certificate: %w", err)
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// NewTLSConfig returns the tls config of a listener using the reloader for its certificate, when a client CA file
+// is given clients must present a certificate issued by it.
+func NewTLSConfig(reloader *CertReloader, clientCAFile string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if clientCAFile == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("failed to parse client CA: no certificates found")
+	}
+
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+
+	return cfg, nil
+}
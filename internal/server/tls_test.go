@@ -0,0 +1,145 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertReloader(t *testing.T) {
+	assert := require.New(t)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	writeTestCert(t, certFile, keyFile, "first")
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	assert.NoError(err)
+	assert.Equal("first", certCommonName(t, reloader))
+
+	reloaded, err := reloader.reload()
+	assert.NoError(err)
+	assert.False(reloaded, "unmodified files shouldn't be reloaded")
+
+	writeTestCert(t, certFile, keyFile, "second")
+	touch(t, time.Now().Add(time.Minute), certFile, keyFile)
+
+	reloaded, err = reloader.reload()
+	assert.NoError(err)
+	assert.True(reloaded)
+	assert.Equal("second", certCommonName(t, reloader))
+
+	// a certificate which doesn't load keeps the previous certificate
+	assert.NoError(os.WriteFile(keyFile, []byte("invalid"), 0o600))
+	touch(t, time.Now().Add(2*time.Minute), keyFile)
+
+	_, err = reloader.reload()
+	assert.Error(err)
+	assert.Equal("second", certCommonName(t, reloader))
+}
+
+func TestNewCertReloaderMissingFiles(t *testing.T) {
+	assert := require.New(t)
+
+	dir := t.TempDir()
+
+	_, err := NewCertReloader(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"))
+	assert.Error(err)
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	writeTestCert(t, certFile, keyFile, "server")
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+
+	invalidCA := filepath.Join(dir, "invalid.crt")
+	require.NoError(t, os.WriteFile(invalidCA, []byte("invalid"), 0o600))
+
+	tests := []struct {
+		name       string
+		clientCA   string
+		clientAuth tls.ClientAuthType
+		wantErr    bool
+	}{
+		{name: "server only", clientAuth: tls.NoClientCert},
+		{name: "client CA", clientCA: certFile, clientAuth: tls.RequireAndVerifyClientCert},
+		{name: "missing client CA", clientCA: filepath.Join(dir, "missing.crt"), wantErr: true},
+		{name: "invalid client CA", clientCA: invalidCA, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			cfg, err := NewTLSConfig(reloader, tt.clientCA)
+			if tt.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tt.clientAuth, cfg.ClientAuth)
+			assert.Equal(uint16(tls.VersionTLS12), cfg.MinVersion)
+		})
+	}
+}
+
+func writeTestCert(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+}
+
+func touch(t *testing.T, modTime time.Time, files ...string) {
+	t.Helper()
+
+	for _, name := range files {
+		require.NoError(t, os.Chtimes(name, modTime, modTime))
+	}
+}
+
+func certCommonName(t *testing.T, reloader *CertReloader) string {
+	t.Helper()
+
+	cert, err := reloader.GetCertificate(nil)
+	require.NoError(t, err)
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+
+	return leaf.Subject.CommonName
+}
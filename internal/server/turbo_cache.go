@@ -0,0 +1,92 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+const (
+	// TurboArtifactsPath is the prefix of the turborepo remote cache api, turbo is pointed at the server with
+	// --api or TURBO_API and the OIDC token is passed with --token or TURBO_TOKEN.
+	TurboArtifactsPath = "/v8/artifacts/"
+
+	turboProtocol = "turbo"
+)
+
+// TurboCacheHandler implements the subset of the turborepo remote cache api used to save and restore artifacts,
+// this is also used by nx through remote cache plugins which speak the same protocol.
+//
+// Artifacts are namespaced by the team sent by the client, either teamId or slug, within the tenant of the OIDC
+// token. Artifact signatures aren't supported as the x-artifact-tag header isn't returned with downloads.
+type TurboCacheHandler struct {
+	cache *httpCache
+}
+
+// NewTurboCacheHandler creates a turborepo cache handler using the index and bucket of the cache service.
+func NewTurboCacheHandler(cache *CacheServiceHandler) *TurboCacheHandler {
+	return &TurboCacheHandler{cache: newHTTPCache(cache, turboProtocol)}
+}
+
+// Mount registers the turborepo cache api on the mux, this requires an OIDC token which is validated by auth.
+func (h *TurboCacheHandler) Mount(mux *http.ServeMux, auth func(http.Handler) http.Handler) {
+	api := http.NewServeMux()
+
+	// GET patterns also match HEAD requests
+	api.HandleFunc("GET "+TurboArtifactsPath+"status", h.status)
+	api.HandleFunc("POST "+TurboArtifactsPath+"events", h.events)
+	api.HandleFunc("GET "+TurboArtifactsPath+"{hash}", h.get)
+	api.HandleFunc("PUT "+TurboArtifactsPath+"{hash}", h.put)
+
+	mux.Handle(TurboArtifactsPath, auth(api))
+}
+
+type turboStatusResponse struct {
+	Status string `json:"status"`
+}
+
+type turboPutResponse struct {
+	URLs []string `json:"urls"`
+}
+
+// turboNamespace returns the team of the request, turbo sends either the id or the slug of the team.
+func turboNamespace(r *http.Request) string {
+	query := r.URL.Query()
+
+	if team := query.Get("teamId"); team != "" {
+		return team
+	}
+
+	if slug := query.Get("slug"); slug != "" {
+		return slug
+	}
+
+	return defaultNamespace
+}
+
+// status reports caching is enabled, turbo checks this before using the cache.
+func (h *TurboCacheHandler) status(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, turboStatusResponse{Status: "enabled"})
+}
+
+// events accepts the cache usage events sent by turbo, these aren't recorded.
+func (h *TurboCacheHandler) events(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *TurboCacheHandler) get(w http.ResponseWriter, r *http.Request) {
+	h.cache.serve(w, r, turboNamespace(r), r.PathValue("hash"))
+}
+
+func (h *TurboCacheHandler) put(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.Start(r.Context(), "TurboCache.put")
+	defer span.End()
+
+	_, err := h.cache.store(ctx, r, turboNamespace(r), r.PathValue("hash"))
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, turboPutResponse{URLs: []string{r.URL.Path}})
+}
@@ -13,8 +13,6 @@ import (
 
 const (
 	modifiedEpoch = "2024-01-01T00:00:00Z"
-	bufferSize    = 1024 * 1024 * 20
-	skipOwnership = true
 )
 
 // isUnderHome checks if the given path is under the user's home directory.
@@ -0,0 +1,111 @@
+package archive
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec selects how files are compressed in an archive.
+type Codec string
+
+const (
+	// CodecStore stores files without compression.
+	CodecStore Codec = "store"
+	// CodecDeflate compresses files with deflate, this is supported by every zip tool.
+	CodecDeflate Codec = "deflate"
+	// CodecZstd compresses files with zstd.
+	CodecZstd Codec = "zstd"
+	// CodecAuto compresses files with zstd except those which are already compressed, these are identified by
+	// their extension or by sampling the first bytes of the file, and are stored.
+	CodecAuto Codec = "auto"
+)
+
+const (
+	// DefaultZstdLevel is the zstd level used when none is configured, this matches the zstd command line default.
+	DefaultZstdLevel = 3
+	// MaxZstdLevel is the highest zstd level.
+	MaxZstdLevel = 22
+
+	// sniffLen is the number of bytes sampled from the start of a file to detect compressed content.
+	sniffLen = 8
+)
+
+// Codecs lists the supported codecs.
+var Codecs = []Codec{CodecStore, CodecDeflate, CodecZstd, CodecAuto}
+
+// incompressibleExtensions are file extensions for content which is already compressed.
+var incompressibleExtensions = map[string]bool{
+	".7z": true, ".aar": true, ".apk": true, ".avif": true, ".br": true, ".bz2": true, ".ear": true,
+	".gif": true, ".gz": true, ".jar": true, ".jpeg": true, ".jpg": true, ".lz": true, ".lz4": true,
+	".lzma": true, ".mkv": true, ".mov": true, ".mp3": true, ".mp4": true, ".nupkg": true, ".ogg": true,
+	".png": true, ".rar": true, ".tbz2": true, ".tgz": true, ".txz": true, ".war": true, ".webm": true,
+	".webp": true, ".whl": true, ".woff": true, ".woff2": true, ".xz": true, ".zip": true, ".zst": true,
+}
+
+// compressedMagic are the leading bytes of compressed file formats.
+var compressedMagic = [][]byte{
+	{0x1f, 0x8b},                       // gzip
+	{0x28, 0xb5, 0x2f, 0xfd},           // zstd
+	{0xfd, '7', 'z', 'X', 'Z', 0x00},   // xz
+	{'B', 'Z', 'h'},                    // bzip2
+	{0x04, 0x22, 0x4d, 0x18},           // lz4
+	{'P', 'K', 0x03, 0x04},             // zip, jar, whl
+	{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}, // 7z
+	{0x89, 'P', 'N', 'G'},              // png
+	{0xff, 0xd8, 0xff},                 // jpeg
+	{'w', 'O', 'F', '2'},               // woff2
+	{'R', 'a', 'r', '!', 0x1a, 0x07},   // rar
+	{'G', 'I', 'F', '8'},               // gif
+}
+
+// ParseCodec returns the codec with the supplied name.
+func ParseCodec(name string) (Codec, error) {
+	for _, codec := range Codecs {
+		if string(codec) == name {
+			return codec, nil
+		}
+	}
+
+	return "", fmt.Errorf("unsupported codec: %q", name)
+}
+
+// Compression returns the value recorded as the compression of a cache entry holding an archive built with the codec.
+func (c Codec) Compression() string {
+	return "zip+" + string(c)
+}
+
+// method returns the zip method used to compress a file with the codec, head is the start of the file.
+func (c Codec) method(name string, head []byte) uint16 {
+	switch c {
+	case CodecStore:
+		return zip.Store
+	case CodecDeflate:
+		return zip.Deflate
+	case CodecAuto:
+		if incompressible(name, head) {
+			return zip.Store
+		}
+	}
+
+	return zstd.ZipMethodWinZip
+}
+
+// incompressible reports whether the file is already compressed based on its extension or leading bytes.
+func incompressible(name string, head []byte) bool {
+	if incompressibleExtensions[strings.ToLower(path.Ext(name))] {
+		return true
+	}
+
+	for _, magic := range compressedMagic {
+		if bytes.HasPrefix(head, magic) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,186 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+	"github.com/wolfeidau/quickzip"
+
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+func TestIncompressible(t *testing.T) {
+	gz := new(bytes.Buffer)
+	zw := gzip.NewWriter(gz)
+	_, err := zw.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	tests := []struct {
+		name     string
+		file     string
+		head     []byte
+		expected bool
+	}{
+		{name: "tarball extension", file: "dist/app.TGZ", expected: true},
+		{name: "jar extension", file: "lib/app.jar", expected: true},
+		{name: "gzip without extension", file: "blobs/sha256/abc", head: gz.Bytes()[:sniffLen], expected: true},
+		{name: "zstd magic", file: "layer", head: []byte{0x28, 0xb5, 0x2f, 0xfd, 0, 0, 0, 0}, expected: true},
+		{name: "text", file: "main.go", head: []byte("package "), expected: false},
+		{name: "short file", file: "a", head: []byte{0x1f}, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, incompressible(tt.file, tt.head))
+		})
+	}
+}
+
+func TestParseCodec(t *testing.T) {
+	assert := require.New(t)
+
+	codec, err := ParseCodec("auto")
+	assert.NoError(err)
+	assert.Equal(CodecAuto, codec)
+	assert.Equal("zip+auto", codec.Compression())
+
+	_, err = ParseCodec("brotli")
+	assert.ErrorContains(err, "unsupported codec")
+}
+
+// codecTree creates files covering compressible, incompressible, empty and large content.
+func codecTree(t *testing.T, dir string) {
+	t.Helper()
+
+	assert := require.New(t)
+
+	random := make([]byte, maxMemorySpool+1024)
+	_, err := rand.Read(random)
+	assert.NoError(err)
+
+	files := map[string][]byte{
+		"tree/main.go":          []byte(strings.Repeat("package main\n", 1000)),
+		"tree/empty.txt":        nil,
+		"tree/dist/app.tgz":     random[:1024],
+		"tree/blobs/random.bin": random,
+		"tree/naïve.txt":        []byte("utf-8 names are flagged"),
+	}
+
+	for i := range 20 {
+		files[filepath.Join("tree", "src", strings.Repeat("x", i+1)+".txt")] = bytes.Repeat([]byte{byte('a' + i)}, 10000*i)
+	}
+
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		assert.NoError(os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(os.WriteFile(path, data, 0644))
+	}
+}
+
+func TestBuildArchiveCodecs(t *testing.T) {
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	require.NoError(t, err)
+
+	src := t.TempDir()
+	codecTree(t, src)
+
+	modTime := time.Date(2023, 6, 1, 12, 30, 45, 0, time.Local)
+	require.NoError(t, os.Chtimes(filepath.Join(src, "tree", "main.go"), modTime, modTime))
+
+	t.Chdir(src)
+
+	methods := func(codec Codec) map[string]uint16 {
+		switch codec {
+		case CodecStore:
+			return map[string]uint16{"tree/main.go": zip.Store, "tree/dist/app.tgz": zip.Store}
+		case CodecDeflate:
+			return map[string]uint16{"tree/main.go": zip.Deflate, "tree/dist/app.tgz": zip.Deflate}
+		case CodecAuto:
+			return map[string]uint16{"tree/main.go": zstd.ZipMethodWinZip, "tree/dist/app.tgz": zip.Store}
+		default:
+			return map[string]uint16{"tree/main.go": zstd.ZipMethodWinZip, "tree/dist/app.tgz": zstd.ZipMethodWinZip}
+		}
+	}
+
+	for _, codec := range Codecs {
+		t.Run(string(codec), func(t *testing.T) {
+			assert := require.New(t)
+
+			var sums []string
+
+			// the archive is the same regardless of the number of workers
+			for _, workers := range []int{1, 4} {
+				archiveInfo, err := BuildArchive(context.Background(), []string{"tree"}, "codec",
+					WithCodec(codec),
+					WithZstdLevel(1),
+					WithWorkers(workers),
+					WithPreserveModTimes(true),
+				)
+				assert.NoError(err)
+				defer os.Remove(archiveInfo.ArchivePath)
+
+				assert.Equal(codec.Compression(), archiveInfo.Compression)
+				sums = append(sums, archiveInfo.Sha256sum)
+
+				zr, err := zip.OpenReader(archiveInfo.ArchivePath)
+				assert.NoError(err)
+				defer zr.Close()
+
+				zr.RegisterDecompressor(zstd.ZipMethodWinZip, quickzip.ZstdDecompressor())
+
+				for _, f := range zr.File {
+					if expected, ok := methods(codec)[f.Name]; ok {
+						assert.Equal(expected, f.Method, f.Name)
+					}
+
+					if f.Mode().IsRegular() && f.Name != ManifestName {
+						expected, err := os.ReadFile(filepath.Join(src, filepath.FromSlash(f.Name)))
+						assert.NoError(err)
+
+						r, err := f.Open()
+						assert.NoError(err)
+						actual := new(bytes.Buffer)
+						_, err = actual.ReadFrom(r)
+						assert.NoError(err)
+						assert.NoError(r.Close())
+
+						assert.Equal(expected, actual.Bytes(), f.Name)
+					}
+
+					if f.Name == "tree/main.go" {
+						assert.True(modTime.Equal(f.Modified), f.Modified)
+					}
+				}
+			}
+
+			assert.Equal(sums[0], sums[1])
+		})
+	}
+}
+
+func TestBuildArchiveInvalidOptions(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	assert.NoError(err)
+
+	_, err = BuildArchive(context.Background(), []string{"testdata"}, "test", WithZstdLevel(23))
+	assert.ErrorContains(err, "zstd level must be between 1 and 22")
+
+	_, err = BuildArchive(context.Background(), []string{"testdata"}, "test", WithCodec("brotli"))
+	assert.ErrorContains(err, "unsupported codec")
+
+	_, err = BuildArchive(context.Background(), []string{"testdata"}, "test", WithWorkers(0))
+	assert.ErrorContains(err, "workers must be at least 1")
+}
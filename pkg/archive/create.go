@@ -5,24 +5,43 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"time"
 
-	"github.com/klauspost/compress/zstd"
 	"github.com/rs/zerolog/log"
-	"github.com/wolfeidau/quickzip"
 	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/wolfeidau/zipstash/pkg/trace"
 )
 
+const (
+	// StatUncompressedBytes is the total size of the files added to the archive.
+	StatUncompressedBytes = "uncompressed_bytes"
+	// StatFiles is the number of files added to the archive.
+	StatFiles = "files"
+	// StatSkippedFiles is the number of files excluded by a pattern.
+	StatSkippedFiles = "skipped_files"
+	// StatSkippedDirs is the number of directories excluded by a pattern, the files under these aren't counted
+	// as they are never walked.
+	StatSkippedDirs = "skipped_dirs"
+)
+
 type ArchiveInfo struct {
 	Stats       map[string]int64
 	ArchivePath string
 	Sha256sum   string
+	// Compression describes the archive format and codec, see Codec.Compression.
+	Compression string
 	Size        int64
 }
 
-func BuildArchive(ctx context.Context, paths []string, key string) (*ArchiveInfo, error) {
+// BuildArchive creates a zip archive of the files matching the paths, which are patterns as described by Patterns.
+//
+// Directories, including empty ones, symlinks and modes are always recorded, ownership and modification times
+// are recorded when enabled using the options. A manifest describing the files is written as the last entry, see
+// ManifestName. Files are compressed with zstd at the default level using a worker per CPU unless configured
+// otherwise.
+func BuildArchive(ctx context.Context, paths []string, key string, opts ...Option) (*ArchiveInfo, error) {
 	_, span := trace.Start(ctx, "BuildArchive")
 	defer span.End()
 
@@ -31,6 +50,21 @@ func BuildArchive(ctx context.Context, paths []string, key string) (*ArchiveInfo
 		return nil, fmt.Errorf("failed to parse modified epoch: %w", err)
 	}
 
+	archiveOpts := options{
+		modifiedEpoch: modified,
+		codec:         CodecZstd,
+		zstdLevel:     DefaultZstdLevel,
+		workers:       runtime.GOMAXPROCS(0),
+	}
+	for _, opt := range opts {
+		opt(&archiveOpts)
+	}
+
+	err = archiveOpts.validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive options: %w", err)
+	}
+
 	archiveFile, err := os.CreateTemp("", fmt.Sprintf("%s-*.zip", key))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create archive file: %w", err)
@@ -40,22 +74,20 @@ func BuildArchive(ctx context.Context, paths []string, key string) (*ArchiveInfo
 	checksummer := NewChecksumSHA256(archiveFile)
 
 	// wrap the file in an io.Writer which records the sha256sum of the file
-	arc, err := quickzip.NewArchiver(
-		checksummer,
-		quickzip.WithArchiverMethod(zstd.ZipMethodWinZip),
-		quickzip.WithArchiverBufferSize(bufferSize),
-		quickzip.WithModifiedEpoch(modified),
-		quickzip.WithSkipOwnership(skipOwnership),
-	)
+	arc := newWriter(checksummer, archiveOpts)
+
+	patterns, err := ParsePatterns(paths)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create archiver: %w", err)
+		return nil, fmt.Errorf("failed to parse path patterns: %w", err)
 	}
 
-	mappings, err := PathsToMappings(paths)
+	mappings, err := PathsToMappings(patterns.Roots())
 	if err != nil {
 		return nil, fmt.Errorf("failed to get mappings: %w", err)
 	}
 
+	var skipped walkStats
+
 	for _, mapping := range mappings {
 		_, err := os.Stat(mapping.ResolvedPath)
 		if err != nil {
@@ -71,15 +103,14 @@ func BuildArchive(ctx context.Context, paths []string, key string) (*ArchiveInfo
 			return nil, fmt.Errorf("failed directory (%s) outside home directory: %w", mapping.ResolvedPath, err)
 		}
 
-		files := make(map[string]os.FileInfo)
-		err = filepath.Walk(mapping.ResolvedPath, func(filename string, fi os.FileInfo, err error) error {
-			files[filename] = fi
-			return nil
-		})
+		files, stats, err := walkPatterns(patterns, mapping.ResolvedPath)
 		if err != nil {
 			return nil, fmt.Errorf("failed to walk path: %s with error: %w", mapping.ResolvedPath, err)
 		}
 
+		skipped.files += stats.files
+		skipped.dirs += stats.dirs
+
 		log.Info().Str("chroot", mapping.Chroot).Str("path", mapping.ResolvedPath).Msg("chroot")
 
 		err = arc.Archive(context.Background(), mapping.Chroot, files)
@@ -88,6 +119,11 @@ func BuildArchive(ctx context.Context, paths []string, key string) (*ArchiveInfo
 		}
 	}
 
+	err = arc.writeManifest(paths, mappings, modified)
+	if err != nil {
+		return nil, err
+	}
+
 	err = arc.Close()
 	if err != nil {
 		return nil, fmt.Errorf("failed to close archive: %w", err)
@@ -98,15 +134,88 @@ func BuildArchive(ctx context.Context, paths []string, key string) (*ArchiveInfo
 		return nil, fmt.Errorf("failed to stat archive file: %w", err)
 	}
 
+	uncompressedBytes, files := arc.Written()
+
+	log.Info().
+		Int64("files", files).
+		Int64("skippedFiles", skipped.files).
+		Int64("skippedDirs", skipped.dirs).
+		Msg("archived files")
+
 	span.SetAttributes(
 		attribute.String("Sha256sum", checksummer.Sum()),
+		attribute.String("Compression", archiveOpts.codec.Compression()),
 		attribute.Int64("Size", stat.Size()),
+		attribute.Int64("UncompressedBytes", uncompressedBytes),
+		attribute.Int64("Files", files),
+		attribute.Int64("SkippedFiles", skipped.files),
+		attribute.Int64("SkippedDirs", skipped.dirs),
 	)
 
 	return &ArchiveInfo{
 		ArchivePath: archiveFile.Name(),
 		Size:        stat.Size(),
 		Sha256sum:   checksummer.Sum(),
-		Stats:       map[string]int64{},
+		Compression: archiveOpts.codec.Compression(),
+		Stats: map[string]int64{
+			StatUncompressedBytes: uncompressedBytes,
+			StatFiles:             files,
+			StatSkippedFiles:      skipped.files,
+			StatSkippedDirs:       skipped.dirs,
+		},
 	}, nil
 }
+
+// walkStats counts the files and directories excluded by a pattern.
+type walkStats struct {
+	files int64
+	dirs  int64
+}
+
+// walkPatterns walks the root returning the files and directories included by the patterns, directories which
+// are excluded are skipped entirely unless a later pattern could include something beneath them.
+func walkPatterns(patterns *Patterns, root string) (map[string]os.FileInfo, walkStats, error) {
+	var stats walkStats
+
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, stats, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	files := make(map[string]os.FileInfo)
+	dirs := map[string]matchState{
+		filepath.Dir(absRoot): patterns.rootState(absRoot),
+	}
+
+	err = filepath.Walk(root, func(filename string, fi os.FileInfo, err error) error {
+		if err != nil {
+			log.Warn().Err(err).Str("path", filename).Msg("failed to walk path")
+			return nil
+		}
+
+		absPath, err := filepath.Abs(filename)
+		if err != nil {
+			return err
+		}
+
+		state, included, excluded := patterns.match(dirs[filepath.Dir(absPath)], absPath)
+
+		if fi.IsDir() {
+			if excluded && patterns.prunable(state) {
+				stats.dirs++
+				return filepath.SkipDir
+			}
+			dirs[absPath] = state
+		}
+
+		if included {
+			files[filename] = fi
+		} else if excluded && !fi.IsDir() {
+			stats.files++
+		}
+
+		return nil
+	})
+
+	return files, stats, err
+}
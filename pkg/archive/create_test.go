@@ -23,8 +23,8 @@ func TestBuildArchive(t *testing.T) {
 
 	archiveInfo, err := BuildArchive(context.Background(), []string{"testdata"}, "test")
 	assert.NoError(err)
-	assert.Equal("3f194172652432099ffc528f81ea6ce1687780287cba1d1a9587f5c26c72aeac", archiveInfo.Sha256sum)
-	assert.Equal(int64(1228), archiveInfo.Size)
+	assert.Equal("141bcf07b93749845d363b0dedf73fbd180019f4324ddcd06d5c1afbc11d9aa3", archiveInfo.Sha256sum)
+	assert.Equal(int64(1594), archiveInfo.Size)
 
 	homeDir, err := os.UserHomeDir()
 	assert.NoError(err)
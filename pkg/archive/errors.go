@@ -0,0 +1,53 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidEntry is returned for entries with an empty, duplicate or otherwise malformed name or symlink target.
+	ErrInvalidEntry = errors.New("invalid entry")
+	// ErrAbsolutePath is returned for entries with an absolute name.
+	ErrAbsolutePath = errors.New("absolute path")
+	// ErrPathTraversal is returned for entries with a name containing .. which would be extracted outside the target root.
+	ErrPathTraversal = errors.New("path traversal")
+	// ErrSymlinkEscape is returned when extracting an entry would follow a symlink to a location outside the target root.
+	ErrSymlinkEscape = errors.New("symlink escapes target root")
+	// ErrNoMapping is returned for entries which don't match any of the paths being restored.
+	ErrNoMapping = errors.New("no path mapping")
+	// ErrSizeLimit is returned when the total uncompressed size of the archive exceeds the limit.
+	ErrSizeLimit = errors.New("uncompressed size limit exceeded")
+	// ErrFileLimit is returned when the number of entries in the archive exceeds the limit.
+	ErrFileLimit = errors.New("file count limit exceeded")
+	// ErrRatioLimit is returned when the compression ratio of the archive exceeds the limit.
+	ErrRatioLimit = errors.New("compression ratio limit exceeded")
+)
+
+// ExtractError is returned when an archive, or an entry in it, is rejected during extraction. Err wraps one of
+// the sentinel errors in this package so the reason can be checked with errors.Is.
+type ExtractError struct {
+	// Name is the name of the rejected entry, this is empty when the archive as a whole was rejected.
+	Name string
+	Err  error
+}
+
+func (e *ExtractError) Error() string {
+	if e.Name == "" {
+		return fmt.Sprintf("archive rejected: %v", e.Err)
+	}
+
+	return fmt.Sprintf("entry %q rejected: %v", e.Name, e.Err)
+}
+
+func (e *ExtractError) Unwrap() error {
+	return e.Err
+}
+
+func rejectEntry(name string, err error, format string, args ...any) error {
+	if format != "" {
+		err = fmt.Errorf("%w: "+format, append([]any{err}, args...)...)
+	}
+
+	return &ExtractError{Name: name, Err: err}
+}
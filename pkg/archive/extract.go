@@ -1,46 +1,103 @@
 package archive
 
 import (
+	"bufio"
 	"context"
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/klauspost/compress/zip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/saracen/zipextra"
 	"github.com/wolfeidau/quickzip"
 	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/wolfeidau/zipstash/pkg/trace"
 )
 
-func ExtractFiles(ctx context.Context, zipFile *os.File, zipFileLen int64, paths []string) error {
+// maxSymlinkTarget is the longest symlink target accepted, this matches PATH_MAX on linux.
+const maxSymlinkTarget = 4096
+
+// Limits bounds the resources an archive can consume when extracted, a zero value disables the limit.
+type Limits struct {
+	// MaxSize is the maximum total uncompressed size of the entries in bytes.
+	MaxSize int64
+	// MaxFiles is the maximum number of entries.
+	MaxFiles int64
+	// MaxRatio is the maximum ratio of the total uncompressed size to the size of the archive.
+	MaxRatio float64
+}
+
+// DefaultLimits are generous enough for large dependency and build caches while still stopping decompression bombs.
+var DefaultLimits = Limits{
+	MaxSize:  50 << 30,
+	MaxFiles: 2_000_000,
+	MaxRatio: 1000,
+}
+
+// ExtractOption configures how an archive is extracted.
+type ExtractOption func(*extractOptions)
+
+type extractOptions struct {
+	limits      Limits
+	concurrency int
+}
+
+// WithLimits replaces the default limits applied to the archive.
+func WithLimits(limits Limits) ExtractOption {
+	return func(o *extractOptions) {
+		o.limits = limits
+	}
+}
+
+// ExtractInfo contains statistics about the files extracted from an archive.
+type ExtractInfo struct {
+	BytesExtracted int64
+	FilesExtracted int64
+}
+
+// ExtractFiles extracts the entries in the archive matching the paths. Every entry is validated before anything is
+// written, entries with absolute names or .. components are rejected, as are entries which would be written through
+// a symlink leading outside the working or home directory they are restored to. Symlinks in the archive are created
+// after all other entries, and entries below a symlink in the same archive are rejected, so they can't be used to
+// redirect files extracted from the same archive. The limits are
+// checked against the sizes recorded in the archive up front, and again against the bytes actually written.
+//
+// Rejections are returned as an *ExtractError wrapping one of the sentinel errors in this package.
+func ExtractFiles(ctx context.Context, zipFile io.ReaderAt, zipFileLen int64, paths []string, opts ...ExtractOption) (*ExtractInfo, error) {
 	_, span := trace.Start(ctx, "ExtractFiles")
 	defer span.End()
-	extract, err := quickzip.NewExtractorFromReader(zipFile, zipFileLen)
+
+	patterns, err := ParsePatterns(paths)
 	if err != nil {
-		return fmt.Errorf("failed to create extractor: %w", err)
+		return nil, fmt.Errorf("failed to parse path patterns: %w", err)
 	}
 
-	mappings, err := PathsToMappings(paths)
+	mappings, err := PathsToMappings(patterns.Roots())
 	if err != nil {
-		return fmt.Errorf("failed to create mappings: %w", err)
+		return nil, fmt.Errorf("failed to create mappings: %w", err)
 	}
 
-	err = extract.ExtractWithPathMapper(ctx, func(file *zip.File) (string, error) {
-		for _, mapping := range mappings {
-			if strings.HasPrefix(file.Name, mapping.RelativePath) {
-				return filepath.Join(mapping.Chroot, file.Name), nil
-			}
-		}
+	ext, err := newExtractor(zipFile, zipFileLen, mappings, opts...)
+	if err != nil {
+		return nil, err
+	}
 
-		return "", fmt.Errorf("failed to find path mapping for: %s", file.Name)
-	})
+	err = ext.Extract(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to extract zip file: %w", err)
+		return nil, fmt.Errorf("failed to extract zip file: %w", err)
 	}
 
-	bytesExtracted, countExtracted := extract.Written()
+	bytesExtracted, countExtracted := ext.Written()
 
 	span.SetAttributes(
 		attribute.Int64("zipFileLen", zipFileLen),
@@ -48,5 +105,490 @@ func ExtractFiles(ctx context.Context, zipFile *os.File, zipFileLen int64, paths
 		attribute.Int64("bytesExtracted", bytesExtracted),
 	)
 
+	return &ExtractInfo{
+		BytesExtracted: bytesExtracted,
+		FilesExtracted: countExtracted,
+	}, nil
+}
+
+// extractor restores the entries of an archive under the chroot of the mapping matching each entry.
+type extractor struct {
+	zr       *zip.Reader
+	mappings []Mapping
+	opts     extractOptions
+	size     int64
+	written  int64
+	entries  int64
+}
+
+// extractEntry is an entry which passed validation along with the path it is extracted to.
+type extractEntry struct {
+	file *zip.File
+	path string
+	root string
+}
+
+func newExtractor(r io.ReaderAt, size int64, mappings []Mapping, opts ...ExtractOption) (*extractor, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zip file: %w", err)
+	}
+
+	zr.RegisterDecompressor(zip.Deflate, quickzip.FlateDecompressor())
+	zr.RegisterDecompressor(zstd.ZipMethodWinZip, quickzip.ZstdDecompressor())
+
+	e := &extractor{
+		zr:       zr,
+		mappings: mappings,
+		size:     size,
+		opts: extractOptions{
+			limits:      DefaultLimits,
+			concurrency: runtime.GOMAXPROCS(0),
+		},
+	}
+
+	for _, opt := range opts {
+		opt(&e.opts)
+	}
+
+	return e, nil
+}
+
+// Written returns the number of bytes and entries written to disk.
+func (e *extractor) Written() (bytes, entries int64) {
+	return atomic.LoadInt64(&e.written), atomic.LoadInt64(&e.entries)
+}
+
+// Extract validates every entry then creates directories, files and lastly symlinks.
+func (e *extractor) Extract(ctx context.Context) error {
+	entries, err := e.plan()
+	if err != nil {
+		return err
+	}
+
+	limiter := make(chan struct{}, e.opts.concurrency)
+
+	wg, wctx := errgroup.WithContext(ctx)
+
+	for _, entry := range entries {
+		if wctx.Err() != nil {
+			break
+		}
+
+		if err := os.MkdirAll(filepath.Dir(entry.path), 0o777); err != nil {
+			return waitFirst(wg, err)
+		}
+
+		switch {
+		case entry.file.Mode()&os.ModeSymlink != 0:
+			// symlinks are created once everything else is extracted so files can't be written through them
+			continue
+		case entry.file.Mode().IsDir():
+			if err := e.createDirectory(entry.path); err != nil {
+				return waitFirst(wg, err)
+			}
+		default:
+			limiter <- struct{}{}
+
+			wg.Go(func() error {
+				defer func() { <-limiter }()
+
+				if err := e.createFile(wctx, entry); err != nil {
+					return err
+				}
+
+				return e.updateMetadata(entry)
+			})
+		}
+	}
+
+	if err := wg.Wait(); err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// directory metadata is updated last as extracting files changes the modification time
+	for _, entry := range entries {
+		switch {
+		case entry.file.Mode()&os.ModeSymlink != 0:
+			err = e.createSymlink(entry)
+		case entry.file.Mode().IsDir():
+			err = e.updateMetadata(entry)
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
+
+// plan validates the names, sizes and destinations of the entries before anything is written.
+func (e *extractor) plan() ([]extractEntry, error) {
+	limits := e.opts.limits
+
+	var (
+		entries []extractEntry
+		total   uint64
+	)
+
+	seen := make(map[string]bool, len(e.zr.File))
+	checked := make(map[string]error)
+
+	for _, file := range e.zr.File {
+		if file.Mode()&irregularModes != 0 || file.Name == ManifestName {
+			continue
+		}
+
+		if limits.MaxFiles > 0 && int64(len(entries)) >= limits.MaxFiles {
+			return nil, rejectEntry("", ErrFileLimit, "more than %d entries", limits.MaxFiles)
+		}
+
+		total += file.UncompressedSize64
+		if total < file.UncompressedSize64 {
+			total = math.MaxUint64
+		}
+
+		if err := e.checkSize(total); err != nil {
+			return nil, err
+		}
+
+		name, err := validateName(file.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if seen[name] {
+			return nil, rejectEntry(file.Name, ErrInvalidEntry, "duplicate name")
+		}
+		seen[name] = true
+
+		mapping, ok := e.mapping(name)
+		if !ok {
+			return nil, rejectEntry(file.Name, ErrNoMapping, "")
+		}
+
+		path := filepath.Join(mapping.Chroot, filepath.FromSlash(name))
+		if !isUnder(path, mapping.Chroot) {
+			return nil, rejectEntry(file.Name, ErrPathTraversal, "")
+		}
+
+		// a directory which is already a symlink would have its metadata updated through the link
+		dir := filepath.Dir(path)
+		if file.Mode().IsDir() {
+			dir = path
+		}
+
+		err = checkSymlinks(mapping.Chroot, dir, checked)
+		if err != nil {
+			return nil, rejectEntry(file.Name, err, "")
+		}
+
+		entries = append(entries, extractEntry{file: file, path: path, root: mapping.Chroot})
+	}
+
+	return entries, checkSymlinkParents(entries)
+}
+
+// checkSymlinkParents returns ErrSymlinkEscape when an entry is below a symlink from the same archive. Symlinks are
+// created last but replacing an entry below one, or creating a symlink below another, follows the earlier link.
+// Paths are compared case folded as tree/A and tree/a are the same directory on macOS and Windows.
+func checkSymlinkParents(entries []extractEntry) error {
+	symlinks := make(map[string]bool)
+
+	for _, entry := range entries {
+		if entry.file.Mode()&os.ModeSymlink != 0 {
+			symlinks[foldPath(entry.path)] = true
+		}
+	}
+
+	if len(symlinks) == 0 {
+		return nil
+	}
+
+	for _, entry := range entries {
+		for dir := filepath.Dir(entry.path); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+			if symlinks[foldPath(dir)] {
+				return rejectEntry(entry.file.Name, ErrSymlinkEscape, "parent %s is a symlink in the archive", dir)
+			}
+		}
+	}
+
+	return nil
+}
+
+// foldPath returns the cleaned path in lower case so paths which differ only by case compare equal.
+func foldPath(path string) string {
+	return strings.ToLower(filepath.Clean(path))
+}
+
+// checkSize returns an error when the total uncompressed bytes exceed the size or ratio limits.
+func (e *extractor) checkSize(total uint64) error {
+	limits := e.opts.limits
+
+	if limits.MaxSize > 0 && total > uint64(limits.MaxSize) {
+		return rejectEntry("", ErrSizeLimit, "more than %d bytes", limits.MaxSize)
+	}
+
+	if limits.MaxRatio > 0 && float64(total) > limits.MaxRatio*float64(e.size) {
+		return rejectEntry("", ErrRatioLimit, "more than %.0f times the archive size of %d bytes", limits.MaxRatio, e.size)
+	}
+
+	return nil
+}
+
+// mapping returns the mapping with the longest relative path containing the name, a relative path of . is used for
+// patterns starting with a glob and contains everything in the chroot.
+func (e *extractor) mapping(name string) (Mapping, bool) {
+	var (
+		match Mapping
+		found bool
+		best  = -1
+	)
+
+	for _, mapping := range e.mappings {
+		rel := filepath.ToSlash(filepath.Clean(mapping.RelativePath))
+
+		length := len(rel)
+		switch {
+		case rel == ".":
+			length = 0
+		case name == rel || strings.HasPrefix(name, rel+"/"):
+		default:
+			continue
+		}
+
+		if length > best {
+			match, found, best = mapping, true, length
+		}
+	}
+
+	return match, found
+}
+
+// validateName checks the name of an entry is a relative path without .. components, it returns the cleaned name.
+func validateName(name string) (string, error) {
+	if name == "" || strings.ContainsRune(name, 0) {
+		return "", rejectEntry(name, ErrInvalidEntry, "empty name or name containing NUL")
+	}
+
+	// backslashes are path separators on windows and could be used to sneak in .. or a volume name
+	if filepath.Separator == '\\' && strings.ContainsRune(name, '\\') {
+		return "", rejectEntry(name, ErrInvalidEntry, "name contains a backslash")
+	}
+
+	if path.IsAbs(name) || filepath.IsAbs(filepath.FromSlash(name)) || filepath.VolumeName(filepath.FromSlash(name)) != "" {
+		return "", rejectEntry(name, ErrAbsolutePath, "")
+	}
+
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", rejectEntry(name, ErrPathTraversal, "")
+		}
+	}
+
+	cleaned := path.Clean(name)
+	if cleaned == "." {
+		return "", rejectEntry(name, ErrInvalidEntry, "name refers to the target root")
+	}
+
+	return cleaned, nil
+}
+
+// checkSymlinks returns ErrSymlinkEscape when dir, or a directory between it and the root, is an existing symlink
+// which resolves outside the root. Directories which don't exist yet are created by the extractor so can't be
+// symlinks, the results are cached in checked as entries share parent directories.
+func checkSymlinks(root, dir string, checked map[string]error) error {
+	if dir == root || !isUnder(dir, root) {
+		return nil
+	}
+
+	if err, ok := checked[dir]; ok {
+		return err
+	}
+
+	err := checkSymlinks(root, filepath.Dir(dir), checked)
+	if err == nil {
+		err = checkSymlink(root, dir)
+	}
+
+	checked[dir] = err
+
+	return err
+}
+
+func checkSymlink(root, dir string) error {
+	fi, err := os.Lstat(dir)
+	if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		// missing directories are created, anything else fails when it is used
+		return nil
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return fmt.Errorf("failed to resolve root: %w", err)
+	}
+
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		// a dangling symlink can't be followed so the extraction fails when it is used
+		return nil
+	}
+
+	if resolved != realRoot && !isUnder(resolved, realRoot) {
+		return fmt.Errorf("%w: %s resolves to %s", ErrSymlinkEscape, dir, resolved)
+	}
+
+	return nil
+}
+
+func (e *extractor) createDirectory(path string) error {
+	err := os.Mkdir(path, 0o777)
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	atomic.AddInt64(&e.entries, 1)
+
+	return nil
+}
+
+func (e *extractor) createSymlink(entry extractEntry) error {
+	r, err := entry.file.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	target, err := io.ReadAll(io.LimitReader(r, maxSymlinkTarget+1))
+	if err != nil {
+		return err
+	}
+
+	if len(target) == 0 || len(target) > maxSymlinkTarget || strings.ContainsRune(string(target), 0) {
+		return rejectEntry(entry.file.Name, ErrInvalidEntry, "invalid symlink target")
+	}
+
+	// the parents are checked again as the symlinks created so far may alias them in ways the names don't show,
+	// such as unicode normalisation on macOS
+	if err := checkSymlinks(entry.root, filepath.Dir(entry.path), make(map[string]error)); err != nil {
+		return rejectEntry(entry.file.Name, err, "")
+	}
+
+	if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.Symlink(filepath.FromSlash(string(target)), entry.path); err != nil {
+		return err
+	}
+
+	if err := e.updateMetadata(entry); err != nil {
+		return err
+	}
+
+	atomic.AddInt64(&e.entries, 1)
+
+	return nil
+}
+
+func (e *extractor) createFile(ctx context.Context, entry extractEntry) (err error) {
+	// remove any existing file or symlink so the file is never written through a link
+	if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	r, err := entry.file.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f, err := os.OpenFile(entry.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriterSize(limitWriter{w: f, e: e, ctx: ctx}, 32*1024)
+
+	_, err = bw.ReadFrom(r)
+	if err == nil {
+		err = bw.Flush()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	atomic.AddInt64(&e.entries, 1)
+
+	return nil
+}
+
+func (e *extractor) updateMetadata(entry extractEntry) error {
+	file := entry.file
+
+	fields, err := zipextra.Parse(file.Extra)
+	if err != nil {
+		return rejectEntry(file.Name, ErrInvalidEntry, "malformed extra fields: %v", err)
+	}
+
+	if err := lchtimes(entry.path, file.Mode(), time.Now(), file.Modified); err != nil {
+		return err
+	}
+
+	if err := lchmod(entry.path, file.Mode()); err != nil {
+		return err
+	}
+
+	unixField, ok := fields[zipextra.ExtraFieldUnixN]
+	if !ok {
+		return nil
+	}
+
+	unix, err := unixField.InfoZIPNewUnix()
+	if err != nil {
+		return rejectEntry(file.Name, ErrInvalidEntry, "malformed ownership: %v", err)
+	}
+
+	// ownership is only restored when permitted, typically when running as root
+	_ = lchown(entry.path, int(unix.Uid.Int64()), int(unix.Gid.Int64()))
+
+	return nil
+}
+
+// limitWriter counts the bytes written by all files and enforces the size and ratio limits on them, this catches
+// archives which record smaller sizes than the data they contain.
+type limitWriter struct {
+	w   io.Writer
+	e   *extractor
+	ctx context.Context
+}
+
+func (w limitWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	total := atomic.AddInt64(&w.e.written, int64(len(p)))
+	if err := w.e.checkSize(uint64(total)); err != nil {
+		return 0, err
+	}
+
+	return w.w.Write(p)
+}
+
+// waitFirst waits for the running goroutines and returns err.
+func waitFirst(wg *errgroup.Group, err error) error {
+	_ = wg.Wait()
+	return err
+}
@@ -0,0 +1,297 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/stretchr/testify/require"
+)
+
+type zipEntry struct {
+	name string
+	data string
+	mode os.FileMode
+}
+
+// buildZip creates an archive containing the entries exactly as named, bypassing the checks made when archiving.
+func buildZip(t testing.TB, entries ...zipEntry) []byte {
+	t.Helper()
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	for _, entry := range entries {
+		hdr := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+
+		mode := entry.mode
+		if mode == 0 {
+			mode = 0644
+		}
+		hdr.SetMode(mode)
+
+		w, err := zw.CreateHeader(hdr)
+		require.NoError(t, err)
+
+		_, err = w.Write([]byte(entry.data))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, zw.Close())
+
+	return buf.Bytes()
+}
+
+func extractZip(data []byte, root string, opts ...ExtractOption) error {
+	mappings := []Mapping{{Path: "tree", RelativePath: "tree", Chroot: root, Relative: true}}
+
+	ext, err := newExtractor(bytes.NewReader(data), int64(len(data)), mappings, opts...)
+	if err != nil {
+		return err
+	}
+
+	return ext.Extract(context.Background())
+}
+
+func TestExtractRejectsHostileArchives(t *testing.T) {
+	zeros := strings.Repeat("\x00", 1<<20)
+
+	tests := []struct {
+		name    string
+		entries []zipEntry
+		limits  Limits
+		setup   func(t *testing.T, sandbox, root string)
+		wantErr error
+	}{
+		{
+			name:    "parent traversal",
+			entries: []zipEntry{{name: "tree/../../evil.txt", data: "evil"}},
+			wantErr: ErrPathTraversal,
+		},
+		{
+			name:    "leading traversal",
+			entries: []zipEntry{{name: "../evil.txt", data: "evil"}},
+			wantErr: ErrPathTraversal,
+		},
+		{
+			name:    "absolute name",
+			entries: []zipEntry{{name: "/tmp/evil.txt", data: "evil"}},
+			wantErr: ErrAbsolutePath,
+		},
+		{
+			name:    "name sharing a prefix with the path",
+			entries: []zipEntry{{name: "treehouse/evil.txt", data: "evil"}},
+			wantErr: ErrNoMapping,
+		},
+		{
+			name:    "duplicate names",
+			entries: []zipEntry{{name: "tree/a.txt", data: "a"}, {name: "tree/./a.txt", data: "b"}},
+			wantErr: ErrInvalidEntry,
+		},
+		{
+			name:    "root entry",
+			entries: []zipEntry{{name: "tree/..", data: "evil"}, {name: "./", mode: os.ModeDir | 0755}},
+			wantErr: ErrPathTraversal,
+		},
+		{
+			name:    "existing symlink outside the root",
+			entries: []zipEntry{{name: "tree/out/evil.txt", data: "evil"}},
+			setup: func(t *testing.T, sandbox, root string) {
+				require.NoError(t, os.MkdirAll(filepath.Join(root, "tree"), 0755))
+				require.NoError(t, os.Symlink(sandbox, filepath.Join(root, "tree", "out")))
+			},
+			wantErr: ErrSymlinkEscape,
+		},
+		{
+			name:    "existing symlinked directory entry outside the root",
+			entries: []zipEntry{{name: "tree/out/", mode: os.ModeDir | 0777}},
+			setup: func(t *testing.T, sandbox, root string) {
+				require.NoError(t, os.MkdirAll(filepath.Join(root, "tree"), 0755))
+				require.NoError(t, os.Symlink(sandbox, filepath.Join(root, "tree", "out")))
+			},
+			wantErr: ErrSymlinkEscape,
+		},
+		{
+			name: "symlink below a symlink in the archive",
+			entries: []zipEntry{
+				{name: "tree/a", data: "../..", mode: os.ModeSymlink | 0777},
+				{name: "tree/a/pwned", data: "/etc/shadow", mode: os.ModeSymlink | 0777},
+			},
+			wantErr: ErrSymlinkEscape,
+		},
+		{
+			name: "symlink below a symlink differing by case",
+			entries: []zipEntry{
+				{name: "tree/A", data: "../..", mode: os.ModeSymlink | 0777},
+				{name: "tree/a/pwned", data: "/etc/shadow", mode: os.ModeSymlink | 0777},
+			},
+			wantErr: ErrSymlinkEscape,
+		},
+		{
+			name: "file below a symlink in the archive",
+			entries: []zipEntry{
+				{name: "tree/a/b/pwned", data: "evil"},
+				{name: "tree/a", data: "../..", mode: os.ModeSymlink | 0777},
+			},
+			wantErr: ErrSymlinkEscape,
+		},
+		{
+			name:    "size limit",
+			entries: []zipEntry{{name: "tree/a.txt", data: "0123456789"}, {name: "tree/b.txt", data: "0123456789"}},
+			limits:  Limits{MaxSize: 15},
+			wantErr: ErrSizeLimit,
+		},
+		{
+			name:    "file limit",
+			entries: []zipEntry{{name: "tree/", mode: os.ModeDir | 0755}, {name: "tree/a.txt"}, {name: "tree/b.txt"}},
+			limits:  Limits{MaxFiles: 2},
+			wantErr: ErrFileLimit,
+		},
+		{
+			name:    "compression ratio limit",
+			entries: []zipEntry{{name: "tree/zeros.bin", data: zeros}},
+			limits:  Limits{MaxRatio: 100},
+			wantErr: ErrRatioLimit,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			sandbox := t.TempDir()
+			root := filepath.Join(sandbox, "root")
+			assert.NoError(os.Mkdir(root, 0755))
+
+			if tt.setup != nil {
+				tt.setup(t, sandbox, root)
+			}
+
+			limits := DefaultLimits
+			if tt.limits != (Limits{}) {
+				limits = tt.limits
+			}
+
+			err := extractZip(buildZip(t, tt.entries...), root, WithLimits(limits))
+			assert.ErrorIs(err, tt.wantErr)
+
+			var extractErr *ExtractError
+			assert.ErrorAs(err, &extractErr)
+
+			assertConfined(t, sandbox)
+
+			// nothing is written when an archive is rejected
+			assert.NoFileExists(filepath.Join(root, "tree", "a.txt"))
+			assert.NoFileExists(filepath.Join(root, "tree", "zeros.bin"))
+		})
+	}
+}
+
+func TestExtractSymlinksCreatedLast(t *testing.T) {
+	assert := require.New(t)
+
+	sandbox := t.TempDir()
+	root := filepath.Join(sandbox, "root")
+	assert.NoError(os.Mkdir(root, 0755))
+
+	// the symlink would redirect the file outside the root if it was created first
+	data := buildZip(t,
+		zipEntry{name: "tree/link", data: sandbox, mode: os.ModeSymlink | 0777},
+		zipEntry{name: "tree/link/evil.txt", data: "evil"},
+	)
+
+	err := extractZip(data, root)
+	assert.Error(err)
+
+	assertConfined(t, sandbox)
+}
+
+func TestExtractMapping(t *testing.T) {
+	assert := require.New(t)
+
+	cwd, home := t.TempDir(), t.TempDir()
+
+	ext := &extractor{mappings: []Mapping{
+		{RelativePath: ".", Chroot: cwd},
+		{RelativePath: ".npm", Chroot: home},
+		{RelativePath: "tree", Chroot: cwd},
+	}}
+
+	for name, expected := range map[string]string{
+		"tree":             cwd,
+		"tree/a.txt":       cwd,
+		"treehouse/a.txt":  cwd,
+		".npm/_cacache/x":  home,
+		".npmrc":           cwd,
+		"other/nested/dir": cwd,
+	} {
+		mapping, ok := ext.mapping(name)
+		assert.True(ok, name)
+		assert.Equal(expected, mapping.Chroot, name)
+	}
+
+	ext.mappings = ext.mappings[1:]
+
+	_, ok := ext.mapping("treehouse/a.txt")
+	assert.False(ok)
+}
+
+// assertConfined checks the only entry in the sandbox is the root the archive was extracted to.
+func assertConfined(t *testing.T, sandbox string) {
+	t.Helper()
+
+	entries, err := os.ReadDir(sandbox)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "root", entries[0].Name())
+}
+
+func FuzzExtract(f *testing.F) {
+	for _, entries := range [][]zipEntry{
+		{{name: "tree/a.txt", data: "a"}, {name: "tree/dir/", mode: os.ModeDir | 0755}},
+		{{name: "tree/../../evil.txt", data: "evil"}},
+		{{name: "/tmp/evil.txt", data: "evil"}},
+		{{name: `tree\..\..\evil.txt`, data: "evil"}},
+		{{name: "tree/link", data: "../..", mode: os.ModeSymlink | 0777}, {name: "tree/link/evil.txt", data: "evil"}},
+		{{name: "tree/link", data: "/tmp", mode: os.ModeSymlink | 0777}},
+		{{name: "tree/a", data: "../..", mode: os.ModeSymlink | 0777}, {name: "tree/a/pwned", data: "/etc/shadow", mode: os.ModeSymlink | 0777}},
+		{{name: "tree/zeros.bin", data: strings.Repeat("\x00", 1<<16)}},
+	} {
+		f.Add(buildZip(f, entries...))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		sandbox := t.TempDir()
+		root := filepath.Join(sandbox, "root")
+		require.NoError(t, os.Mkdir(root, 0755))
+
+		_ = extractZip(data, root, WithLimits(Limits{MaxSize: 1 << 20, MaxFiles: 100, MaxRatio: 1000}))
+
+		assertConfined(t, sandbox)
+	})
+}
+
+func FuzzValidateName(f *testing.F) {
+	for _, name := range []string{"tree/a.txt", "../a", "tree/../../a", "/etc/passwd", "tree/./a", "./", "C:/a", `..\a`, "a//b/"} {
+		f.Add(name)
+	}
+
+	f.Fuzz(func(t *testing.T, name string) {
+		cleaned, err := validateName(name)
+		if err != nil {
+			require.ErrorAs(t, err, new(*ExtractError))
+			return
+		}
+
+		require.False(t, path.IsAbs(cleaned), cleaned)
+		require.NotEqual(t, "..", strings.Split(cleaned, "/")[0], cleaned)
+
+		root := filepath.Join(string(filepath.Separator), "root")
+		require.True(t, isUnder(filepath.Join(root, filepath.FromSlash(cleaned)), root), cleaned)
+	})
+}
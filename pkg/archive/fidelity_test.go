@@ -0,0 +1,234 @@
+package archive
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/saracen/zipextra"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// fidelityTree creates a tree covering the entries which need to round trip through an archive.
+func fidelityTree(t *testing.T, dir string) {
+	t.Helper()
+
+	assert := require.New(t)
+
+	root := filepath.Join(dir, "tree")
+
+	for _, d := range []struct {
+		name string
+		mode os.FileMode
+	}{
+		{"", 0755},
+		{"bin", 0755},
+		{"empty", 0750},
+		{"private", 0700},
+	} {
+		path := filepath.Join(root, d.name)
+		assert.NoError(os.MkdirAll(path, 0755))
+		assert.NoError(os.Chmod(path, d.mode))
+	}
+
+	for _, f := range []struct {
+		name string
+		mode os.FileMode
+	}{
+		{"bin/tool", 0755},
+		{"readonly.txt", 0444},
+		{"private/secret.txt", 0600},
+	} {
+		path := filepath.Join(root, f.name)
+		assert.NoError(os.WriteFile(path, []byte(f.name), 0600))
+		assert.NoError(os.Chmod(path, f.mode))
+	}
+
+	for _, l := range []struct {
+		name   string
+		target string
+	}{
+		{"link-relative", "bin/tool"},
+		{"link-absolute", filepath.Join(root, "readonly.txt")},
+		{"link-dir", "private"},
+		{"link-outside", "/nonexistent/target"},
+	} {
+		assert.NoError(os.Symlink(l.target, filepath.Join(root, l.name)))
+	}
+}
+
+// listArchive describes each entry in the archive, one per line.
+func listArchive(t *testing.T, path string) string {
+	t.Helper()
+
+	zr, err := zip.OpenReader(path)
+	require.NoError(t, err)
+	defer zr.Close()
+
+	var sb strings.Builder
+	for _, f := range zr.File {
+		fmt.Fprintf(&sb, "%s %s", f.Mode(), f.Name)
+
+		if f.Mode()&os.ModeSymlink != 0 {
+			r, err := f.Open()
+			require.NoError(t, err)
+
+			link, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.NoError(t, r.Close())
+
+			fmt.Fprintf(&sb, " -> %s", link)
+		}
+
+		sb.WriteString("\n")
+	}
+
+	return sb.String()
+}
+
+func assertGolden(t *testing.T, name, actual string) {
+	t.Helper()
+
+	// golden files are kept out of testdata as it is archived by TestBuildArchive
+	path := filepath.Join("golden", name)
+
+	if *update {
+		require.NoError(t, os.WriteFile(path, []byte(actual), 0600))
+	}
+
+	expected, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, string(expected), actual)
+}
+
+func TestArchiveFidelity(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	assert.NoError(err)
+
+	wd, err := os.Getwd()
+	assert.NoError(err)
+
+	src := t.TempDir()
+	fidelityTree(t, src)
+
+	t.Chdir(src)
+
+	archiveInfo, err := BuildArchive(context.Background(), []string{"tree"}, "fidelity")
+	assert.NoError(err)
+	defer os.Remove(archiveInfo.ArchivePath)
+
+	listing := listArchive(t, archiveInfo.ArchivePath)
+
+	t.Chdir(wd)
+	assertGolden(t, "fidelity.golden", listing)
+
+	dst := t.TempDir()
+	t.Chdir(dst)
+
+	zipFile, err := os.Open(archiveInfo.ArchivePath)
+	assert.NoError(err)
+	defer zipFile.Close()
+
+	_, err = ExtractFiles(context.Background(), zipFile, archiveInfo.Size, []string{"tree"})
+	assert.NoError(err)
+
+	for _, name := range []string{"", "bin", "empty", "private", "bin/tool", "readonly.txt", "private/secret.txt", "link-relative", "link-absolute", "link-dir", "link-outside"} {
+		expected, err := os.Lstat(filepath.Join(src, "tree", name))
+		assert.NoError(err)
+
+		actual, err := os.Lstat(filepath.Join(dst, "tree", name))
+		assert.NoError(err, name)
+
+		assert.Equal(expected.Mode(), actual.Mode(), name)
+	}
+
+	for name, target := range map[string]string{
+		"link-relative": "bin/tool",
+		"link-absolute": "readonly.txt",
+		"link-dir":      "private",
+		"link-outside":  "/nonexistent/target",
+	} {
+		link, err := os.Readlink(filepath.Join(dst, "tree", name))
+		assert.NoError(err)
+		assert.Equal(target, link, name)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dst, "tree", "link-absolute"))
+	assert.NoError(err)
+	assert.Equal("readonly.txt", string(data))
+
+	entries, err := os.ReadDir(filepath.Join(dst, "tree", "empty"))
+	assert.NoError(err)
+	assert.Empty(entries)
+}
+
+func TestArchivePreserveModTimesAndOwnership(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	assert.NoError(err)
+
+	src := t.TempDir()
+	fidelityTree(t, src)
+
+	modTime := time.Date(2023, 6, 1, 12, 30, 45, 0, time.UTC)
+	assert.NoError(os.Chtimes(filepath.Join(src, "tree", "bin", "tool"), modTime, modTime))
+
+	t.Chdir(src)
+
+	archiveInfo, err := BuildArchive(context.Background(), []string{"tree"}, "fidelity",
+		WithPreserveModTimes(true),
+		WithPreserveOwnership(true),
+	)
+	assert.NoError(err)
+	defer os.Remove(archiveInfo.ArchivePath)
+
+	zr, err := zip.OpenReader(archiveInfo.ArchivePath)
+	assert.NoError(err)
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		// the manifest is generated rather than archived from disk so has no owner
+		if f.Name == ManifestName {
+			continue
+		}
+
+		fields, err := zipextra.Parse(f.Extra)
+		assert.NoError(err)
+
+		unixField, ok := fields[zipextra.ExtraFieldUnixN]
+		assert.True(ok, f.Name)
+
+		owner, err := unixField.InfoZIPNewUnix()
+		assert.NoError(err)
+		assert.Equal(int64(os.Getuid()), owner.Uid.Int64())
+		assert.Equal(int64(os.Getgid()), owner.Gid.Int64())
+	}
+
+	dst := t.TempDir()
+	t.Chdir(dst)
+
+	zipFile, err := os.Open(archiveInfo.ArchivePath)
+	assert.NoError(err)
+	defer zipFile.Close()
+
+	_, err = ExtractFiles(context.Background(), zipFile, archiveInfo.Size, []string{"tree"})
+	assert.NoError(err)
+
+	fi, err := os.Stat(filepath.Join(dst, "tree", "bin", "tool"))
+	assert.NoError(err)
+	assert.True(modTime.Equal(fi.ModTime()), fi.ModTime())
+}
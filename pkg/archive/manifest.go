@@ -0,0 +1,234 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zip"
+)
+
+const (
+	// ManifestName is the name of the entry holding the manifest, this is written after all the files so it sits
+	// next to the central directory at the end of the archive where both can be read with a couple of ranged reads.
+	ManifestName = ".zipstash/manifest.json"
+
+	manifestVersion = 1
+)
+
+// Manifest describes the contents of an archive along with the paths used to build it.
+type Manifest struct {
+	Paths    []string          `json:"paths"`
+	Mappings []ManifestMapping `json:"mappings"`
+	Files    []ManifestFile    `json:"files"`
+	Version  int               `json:"version"`
+	// Complete is false when the manifest was built from the central directory of an archive without one, in
+	// this case files have a crc32 rather than a sha256.
+	Complete bool `json:"complete"`
+}
+
+// ManifestMapping records how the names in the archive map to a path when restored.
+type ManifestMapping struct {
+	// Path is the root of a path pattern passed when saving.
+	Path string `json:"path"`
+	// RelativePath is the prefix of the names in the archive for this path.
+	RelativePath string `json:"relative_path"`
+	// Home is true when names are relative to the home directory rather than the working directory.
+	Home bool `json:"home"`
+}
+
+// ManifestFile describes a file, directory or symlink in the archive.
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Sha256 string `json:"sha256,omitempty"`
+	Link   string `json:"link,omitempty"`
+	// Mode is formatted as by os.FileMode, for example -rw-r--r--.
+	Mode  string `json:"mode"`
+	Size  int64  `json:"size"`
+	CRC32 uint32 `json:"crc32,omitempty"`
+}
+
+// TotalSize returns the combined size of the files.
+func (m *Manifest) TotalSize() int64 {
+	var total int64
+	for _, f := range m.Files {
+		total += f.Size
+	}
+	return total
+}
+
+func newManifestMappings(mappings []Mapping) []ManifestMapping {
+	res := make([]ManifestMapping, len(mappings))
+	for i, mapping := range mappings {
+		res[i] = ManifestMapping{
+			Path:         mapping.Path,
+			RelativePath: filepath.ToSlash(mapping.RelativePath),
+			Home:         !mapping.Relative,
+		}
+	}
+	return res
+}
+
+// ReadManifest returns the manifest stored in the archive, for archives created before manifests were added one is
+// built from the central directory. Only the central directory and the manifest entry are read, so this is cheap
+// when zr is backed by ranged reads of a remote archive.
+func ReadManifest(zr *zip.Reader) (*Manifest, error) {
+	for _, file := range zr.File {
+		if file.Name != ManifestName {
+			continue
+		}
+
+		r, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open manifest: %w", err)
+		}
+		defer r.Close()
+
+		manifest := new(Manifest)
+
+		err = json.NewDecoder(io.LimitReader(r, maxManifestSize)).Decode(manifest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode manifest: %w", err)
+		}
+
+		manifest.Complete = true
+
+		return manifest, nil
+	}
+
+	manifest := &Manifest{Version: manifestVersion}
+
+	for _, file := range zr.File {
+		manifest.Files = append(manifest.Files, ManifestFile{
+			Name:  file.Name,
+			Size:  int64(file.UncompressedSize64),
+			Mode:  file.Mode().String(),
+			CRC32: file.CRC32,
+		})
+	}
+
+	return manifest, nil
+}
+
+// maxManifestSize bounds the manifest read from an archive, this is far larger than the manifest for millions of files.
+const maxManifestSize = 1 << 30
+
+// writeManifest adds the manifest describing the entries written so far, this must be the last entry.
+func (w *writer) writeManifest(paths []string, mappings []Mapping, modified time.Time) error {
+	manifest := Manifest{
+		Version:  manifestVersion,
+		Paths:    paths,
+		Mappings: newManifestMappings(mappings),
+		Files:    w.files,
+	}
+
+	if manifest.Files == nil {
+		manifest.Files = []ManifestFile{}
+	}
+
+	hdr := &zip.FileHeader{
+		Name:     ManifestName,
+		Method:   zip.Deflate,
+		Modified: modified,
+	}
+	hdr.SetMode(0o644)
+
+	mw, err := w.zw.CreateHeader(hdr)
+	if err != nil {
+		return fmt.Errorf("failed to create manifest entry: %w", err)
+	}
+
+	err = json.NewEncoder(mw).Encode(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	return nil
+}
+
+// Change describes a difference between two manifests.
+type Change struct {
+	Name    string `json:"name"`
+	Kind    string `json:"kind"`
+	OldMode string `json:"old_mode,omitempty"`
+	NewMode string `json:"new_mode,omitempty"`
+	OldSize int64  `json:"old_size"`
+	NewSize int64  `json:"new_size"`
+}
+
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeModified = "modified"
+	ChangeMode     = "mode"
+)
+
+// Diff returns the changes between two manifests sorted by name. Content is compared using the sha256 of files
+// when both manifests have one, otherwise the crc32 and size are compared.
+func Diff(a, b *Manifest) []Change {
+	before := make(map[string]ManifestFile, len(a.Files))
+	for _, f := range a.Files {
+		before[f.Name] = f
+	}
+
+	after := make(map[string]ManifestFile, len(b.Files))
+	for _, f := range b.Files {
+		after[f.Name] = f
+	}
+
+	var changes []Change
+
+	for name, old := range before {
+		cur, ok := after[name]
+		if !ok {
+			changes = append(changes, Change{Name: name, Kind: ChangeRemoved, OldSize: old.Size, OldMode: old.Mode})
+			continue
+		}
+
+		change := Change{Name: name, OldSize: old.Size, NewSize: cur.Size, OldMode: old.Mode, NewMode: cur.Mode}
+
+		switch {
+		case !sameContent(old, cur):
+			change.Kind = ChangeModified
+		case old.Mode != cur.Mode:
+			change.Kind = ChangeMode
+		default:
+			continue
+		}
+
+		changes = append(changes, change)
+	}
+
+	for name, cur := range after {
+		if _, ok := before[name]; !ok {
+			changes = append(changes, Change{Name: name, Kind: ChangeAdded, NewSize: cur.Size, NewMode: cur.Mode})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+
+	return changes
+}
+
+func sameContent(a, b ManifestFile) bool {
+	if a.Size != b.Size {
+		return false
+	}
+
+	switch {
+	case a.Sha256 != "" && b.Sha256 != "":
+		return strings.EqualFold(a.Sha256, b.Sha256)
+	case a.Link != "" && b.Link != "":
+		return a.Link == b.Link
+	case a.CRC32 != 0 && b.CRC32 != 0:
+		return a.CRC32 == b.CRC32
+	default:
+		return true
+	}
+}
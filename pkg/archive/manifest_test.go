@@ -0,0 +1,101 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+func TestReadManifest(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	assert.NoError(err)
+
+	wd, err := os.Getwd()
+	assert.NoError(err)
+
+	src := t.TempDir()
+	fidelityTree(t, src)
+
+	t.Chdir(src)
+
+	archiveInfo, err := BuildArchive(context.Background(), []string{"tree", "!tree/private"}, "manifest")
+	assert.NoError(err)
+	defer os.Remove(archiveInfo.ArchivePath)
+
+	zr, err := zip.OpenReader(archiveInfo.ArchivePath)
+	assert.NoError(err)
+	defer zr.Close()
+
+	manifest, err := ReadManifest(&zr.Reader)
+	assert.NoError(err)
+	assert.True(manifest.Complete)
+	assert.Equal([]string{"tree", "!tree/private"}, manifest.Paths)
+	assert.Equal([]ManifestMapping{{Path: "tree", RelativePath: "tree"}}, manifest.Mappings)
+
+	data, err := json.MarshalIndent(manifest.Files, "", "  ")
+	assert.NoError(err)
+
+	t.Chdir(wd)
+	assertGolden(t, "fidelity-manifest.golden", string(data)+"\n")
+}
+
+func TestReadManifestFromCentralDirectory(t *testing.T) {
+	assert := require.New(t)
+
+	data := buildZip(t,
+		zipEntry{name: "tree/", mode: os.ModeDir | 0755},
+		zipEntry{name: "tree/a.txt", data: "a"},
+	)
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	assert.NoError(err)
+
+	manifest, err := ReadManifest(zr)
+	assert.NoError(err)
+	assert.False(manifest.Complete)
+	assert.Equal([]ManifestFile{
+		{Name: "tree/", Mode: "drwxr-xr-x"},
+		{Name: "tree/a.txt", Size: 1, Mode: "-rw-r--r--", CRC32: 0xe8b7be43},
+	}, manifest.Files)
+}
+
+func TestDiff(t *testing.T) {
+	assert := require.New(t)
+
+	before := &Manifest{Files: []ManifestFile{
+		{Name: "tree/", Mode: "drwxr-xr-x"},
+		{Name: "tree/same.txt", Size: 1, Sha256: "aa", Mode: "-rw-r--r--"},
+		{Name: "tree/changed.txt", Size: 1, Sha256: "aa", Mode: "-rw-r--r--"},
+		{Name: "tree/exec.sh", Size: 1, Sha256: "aa", Mode: "-rw-r--r--"},
+		{Name: "tree/link", Size: 1, Link: "a", Mode: "Lrwxrwxrwx"},
+		{Name: "tree/removed.txt", Size: 2, Sha256: "bb", Mode: "-rw-r--r--"},
+	}}
+
+	after := &Manifest{Files: []ManifestFile{
+		{Name: "tree/", Mode: "drwxr-xr-x"},
+		{Name: "tree/same.txt", Size: 1, Sha256: "aa", Mode: "-rw-r--r--"},
+		{Name: "tree/changed.txt", Size: 1, Sha256: "cc", Mode: "-rw-r--r--"},
+		{Name: "tree/exec.sh", Size: 1, Sha256: "aa", Mode: "-rwxr-xr-x"},
+		{Name: "tree/link", Size: 1, Link: "b", Mode: "Lrwxrwxrwx"},
+		{Name: "tree/added.txt", Size: 3, Sha256: "dd", Mode: "-rw-r--r--"},
+	}}
+
+	assert.Equal([]Change{
+		{Name: "tree/added.txt", Kind: ChangeAdded, NewSize: 3, NewMode: "-rw-r--r--"},
+		{Name: "tree/changed.txt", Kind: ChangeModified, OldSize: 1, NewSize: 1, OldMode: "-rw-r--r--", NewMode: "-rw-r--r--"},
+		{Name: "tree/exec.sh", Kind: ChangeMode, OldSize: 1, NewSize: 1, OldMode: "-rw-r--r--", NewMode: "-rwxr-xr-x"},
+		{Name: "tree/link", Kind: ChangeModified, OldSize: 1, NewSize: 1, OldMode: "Lrwxrwxrwx", NewMode: "Lrwxrwxrwx"},
+		{Name: "tree/removed.txt", Kind: ChangeRemoved, OldSize: 2, OldMode: "-rw-r--r--"},
+	}, Diff(before, after))
+
+	assert.Empty(Diff(after, after))
+}
@@ -0,0 +1,60 @@
+//go:build !windows
+
+package archive
+
+import (
+	"math/big"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/saracen/zipextra"
+	"golang.org/x/sys/unix"
+)
+
+// ownershipExtra returns the Info-ZIP unix extra field recording the uid and gid of the file.
+func ownershipExtra(fi os.FileInfo) []byte {
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+
+	return zipextra.NewInfoZIPNewUnix(big.NewInt(int64(stat.Uid)), big.NewInt(int64(stat.Gid))).Encode()
+}
+
+// lchmod changes the mode of the file without following symlinks, linux doesn't support modes on symlinks so
+// they are skipped.
+func lchmod(name string, mode os.FileMode) error {
+	var flags int
+	if runtime.GOOS == "linux" {
+		if mode&os.ModeSymlink != 0 {
+			return nil
+		}
+	} else {
+		flags = unix.AT_SYMLINK_NOFOLLOW
+	}
+
+	err := unix.Fchmodat(unix.AT_FDCWD, name, uint32(mode.Perm()), flags)
+	if err != nil {
+		return &os.PathError{Op: "lchmod", Path: name, Err: err}
+	}
+
+	return nil
+}
+
+// lchtimes changes the access and modification times of the file without following symlinks.
+func lchtimes(name string, _ os.FileMode, atime, mtime time.Time) error {
+	tv := []unix.Timeval{unix.NsecToTimeval(atime.UnixNano()), unix.NsecToTimeval(mtime.UnixNano())}
+
+	err := unix.Lutimes(name, tv)
+	if err != nil {
+		return &os.PathError{Op: "lchtimes", Path: name, Err: err}
+	}
+
+	return nil
+}
+
+func lchown(name string, uid, gid int) error {
+	return os.Lchown(name, uid, gid)
+}
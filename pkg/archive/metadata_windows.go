@@ -0,0 +1,33 @@
+//go:build windows
+
+package archive
+
+import (
+	"os"
+	"time"
+)
+
+// ownershipExtra returns nil as windows doesn't have a uid and gid to record.
+func ownershipExtra(os.FileInfo) []byte {
+	return nil
+}
+
+func lchmod(name string, mode os.FileMode) error {
+	if mode&os.ModeSymlink != 0 {
+		return nil
+	}
+
+	return os.Chmod(name, mode.Perm())
+}
+
+func lchtimes(name string, mode os.FileMode, atime, mtime time.Time) error {
+	if mode&os.ModeSymlink != 0 {
+		return nil
+	}
+
+	return os.Chtimes(name, atime, mtime)
+}
+
+func lchown(string, int, int) error {
+	return nil
+}
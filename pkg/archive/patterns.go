@@ -0,0 +1,265 @@
+package archive
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+const globMeta = `*?[]{}\`
+
+// Patterns is a list of include and exclude patterns following the semantics of actions/cache. Each pattern
+// is a path or doublestar glob, relative to the working directory or the home directory when it starts
+// with ~/, and patterns starting with ! exclude matching paths. Matching a directory also matches everything
+// under it, and when several patterns match a path the last one wins, so a later include can re-include
+// paths under an excluded directory.
+type Patterns struct {
+	rules []patternRule
+}
+
+type patternRule struct {
+	// original is the pattern as supplied without the ! prefix
+	original string
+	// pattern is the absolute slash separated pattern used for matching
+	pattern string
+	// root is the longest leading path of the original pattern without glob characters
+	root string
+	// absRoot is the absolute path of the root
+	absRoot string
+	negate  bool
+	glob    bool
+}
+
+// ParsePatterns parses a list of patterns, blank lines and lines starting with # are ignored. At least one
+// include pattern is required.
+func ParsePatterns(lines []string) (*Patterns, error) {
+	p := new(Patterns)
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := patternRule{original: line}
+
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			rule.original = strings.TrimSpace(line[1:])
+		}
+
+		if rule.original == "" {
+			return nil, fmt.Errorf("empty pattern: %q", line)
+		}
+
+		if !doublestar.ValidatePattern(filepath.ToSlash(rule.original)) {
+			return nil, fmt.Errorf("invalid pattern: %q", line)
+		}
+
+		root, rest, glob := staticRoot(rule.original)
+		rule.root = root
+		rule.glob = glob
+
+		resolvedRoot, err := ResolveHomeDir(root)
+		if err != nil {
+			return nil, err
+		}
+
+		resolvedRoot, err = filepath.Abs(resolvedRoot)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path: %w", err)
+		}
+
+		rule.absRoot = resolvedRoot
+		rule.pattern = escapeGlob(filepath.ToSlash(resolvedRoot))
+		if glob {
+			rule.pattern = strings.TrimSuffix(rule.pattern, "/") + "/" + rest
+		}
+
+		p.rules = append(p.rules, rule)
+	}
+
+	if len(p.Roots()) == 0 {
+		return nil, fmt.Errorf("no include patterns provided")
+	}
+
+	return p, nil
+}
+
+// Roots returns the paths which are walked to find files matching the include patterns, this is the leading
+// part of each include pattern before any glob characters. Roots nested in another root of the same kind,
+// either relative to the working directory or the home directory, are omitted as they are walked with their parent.
+func (p *Patterns) Roots() []string {
+	var roots []string
+
+	for i, rule := range p.rules {
+		if rule.negate || p.nested(i) {
+			continue
+		}
+		roots = append(roots, rule.root)
+	}
+
+	return roots
+}
+
+// nested reports whether the root of rule i is the same as or inside the root of another include rule, duplicates
+// are resolved in favour of the first rule.
+func (p *Patterns) nested(i int) bool {
+	rule := p.rules[i]
+
+	for j, other := range p.rules {
+		if j == i || other.negate || isHomeRoot(other.root) != isHomeRoot(rule.root) {
+			continue
+		}
+
+		if rule.absRoot == other.absRoot {
+			if j < i {
+				return true
+			}
+			continue
+		}
+
+		if strings.HasPrefix(rule.absRoot, strings.TrimSuffix(other.absRoot, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isHomeRoot(root string) bool {
+	return strings.HasPrefix(root, "~/")
+}
+
+// Literals returns the include patterns which don't contain glob characters.
+func (p *Patterns) Literals() []string {
+	var literals []string
+
+	for _, rule := range p.rules {
+		if rule.negate || rule.glob {
+			continue
+		}
+		literals = append(literals, rule.original)
+	}
+
+	return literals
+}
+
+// matchState records which rules match a path, or one of its parent directories.
+type matchState []bool
+
+// match updates the state for the absolute path using the state of its parent directory, it returns whether
+// the path is included and whether it was excluded by a negated pattern.
+func (p *Patterns) match(parent matchState, path string) (matchState, bool, bool) {
+	state := make(matchState, len(p.rules))
+	slashPath := filepath.ToSlash(path)
+
+	last := -1
+	for i, rule := range p.rules {
+		state[i] = (parent != nil && parent[i]) || rule.matches(slashPath)
+		if state[i] {
+			last = i
+		}
+	}
+
+	if last == -1 {
+		return state, false, false
+	}
+
+	return state, !p.rules[last].negate, p.rules[last].negate
+}
+
+// rootState returns the match state of the parent directory of a root, this ensures patterns matching
+// a directory above the root apply to everything in the root.
+func (p *Patterns) rootState(root string) matchState {
+	state := make(matchState, len(p.rules))
+
+	for dir := filepath.Dir(root); ; dir = filepath.Dir(dir) {
+		for i, rule := range p.rules {
+			state[i] = state[i] || rule.matches(filepath.ToSlash(dir))
+		}
+
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	return state
+}
+
+// prunable reports whether a directory which was excluded can be skipped entirely, this is the case when
+// no include pattern follows the exclude pattern which matched it.
+func (p *Patterns) prunable(state matchState) bool {
+	last := -1
+	for i := range state {
+		if state[i] {
+			last = i
+		}
+	}
+
+	if last == -1 || !p.rules[last].negate {
+		return false
+	}
+
+	for _, rule := range p.rules[last+1:] {
+		if !rule.negate {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r patternRule) matches(slashPath string) bool {
+	matched, err := doublestar.Match(r.pattern, slashPath)
+	return err == nil && matched
+}
+
+// staticRoot returns the leading path components of the pattern which don't contain glob characters, along
+// with the remainder of the pattern.
+func staticRoot(pattern string) (string, string, bool) {
+	parts := strings.Split(filepath.ToSlash(pattern), "/")
+
+	for i, part := range parts {
+		if !strings.ContainsAny(part, globMeta) {
+			continue
+		}
+
+		rest := strings.Join(parts[i:], "/")
+
+		switch root := strings.Join(parts[:i], "/"); {
+		case i == 0:
+			return ".", rest, true
+		case root == "":
+			return "/", rest, true
+		case root == "~":
+			return "~/.", rest, true
+		default:
+			return cleanRoot(filepath.FromSlash(root)), rest, true
+		}
+	}
+
+	return cleanRoot(pattern), "", false
+}
+
+// cleanRoot cleans the path retaining the ~/ prefix used to refer to the home directory.
+func cleanRoot(root string) string {
+	if rest, ok := strings.CutPrefix(root, "~/"); ok {
+		return "~/" + filepath.Clean(rest)
+	}
+
+	return filepath.Clean(root)
+}
+
+func escapeGlob(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(globMeta, r) {
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
@@ -0,0 +1,177 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaticRoot(t *testing.T) {
+	tests := []struct {
+		pattern string
+		root    string
+		rest    string
+		glob    bool
+	}{
+		{pattern: "node_modules", root: "node_modules"},
+		{pattern: "./vendor/", root: "vendor"},
+		{pattern: "~/.npm", root: "~/.npm"},
+		{pattern: "**/target/release/deps", root: ".", rest: "**/target/release/deps", glob: true},
+		{pattern: "~/.cargo/**/bin", root: "~/.cargo", rest: "**/bin", glob: true},
+		{pattern: "~/*.log", root: "~/.", rest: "*.log", glob: true},
+		{pattern: "/var/cache/*/data", root: "/var/cache", rest: "*/data", glob: true},
+		{pattern: "/*", root: "/", rest: "*", glob: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			assert := require.New(t)
+
+			root, rest, glob := staticRoot(tt.pattern)
+			assert.Equal(tt.root, root)
+			assert.Equal(tt.rest, rest)
+			assert.Equal(tt.glob, glob)
+		})
+	}
+}
+
+func TestParsePatterns(t *testing.T) {
+	tests := []struct {
+		name     string
+		lines    []string
+		roots    []string
+		literals []string
+		wantErr  string
+	}{
+		{
+			name:     "paths and globs",
+			lines:    []string{"node_modules", "", "# comment", "**/target/release/deps", "!**/*.log", "~/.npm"},
+			roots:    []string{".", "~/.npm"},
+			literals: []string{"node_modules", "~/.npm"},
+		},
+		{
+			name:     "path with spaces",
+			lines:    []string{"my cache dir"},
+			roots:    []string{"my cache dir"},
+			literals: []string{"my cache dir"},
+		},
+		{
+			name:    "only excludes",
+			lines:   []string{"!**/*.log"},
+			wantErr: "no include patterns provided",
+		},
+		{
+			name:    "empty negation",
+			lines:   []string{"dist", "!"},
+			wantErr: "empty pattern",
+		},
+		{
+			name:    "invalid glob",
+			lines:   []string{"dist/[abc"},
+			wantErr: "invalid pattern",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			patterns, err := ParsePatterns(tt.lines)
+			if tt.wantErr != "" {
+				assert.ErrorContains(err, tt.wantErr)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(tt.roots, patterns.Roots())
+			assert.Equal(tt.literals, patterns.Literals())
+		})
+	}
+}
+
+func TestWalkPatterns(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	for _, name := range []string{
+		"node_modules/a/index.js",
+		"node_modules/a/debug.log",
+		"node_modules/.cache/big.bin",
+		"node_modules/.cache/keep/important.txt",
+		"target/release/deps/libfoo.rlib",
+		"target/release/build/out.o",
+		"crates/bar/target/release/deps/libbar.rlib",
+		"src/main.rs",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+		require.NoError(t, os.WriteFile(name, []byte(name), 0600))
+	}
+
+	tests := []struct {
+		name     string
+		lines    []string
+		expected []string
+		stats    walkStats
+	}{
+		{
+			name:  "directory",
+			lines: []string{"node_modules"},
+			expected: []string{
+				"node_modules", "node_modules/.cache", "node_modules/.cache/big.bin", "node_modules/.cache/keep",
+				"node_modules/.cache/keep/important.txt", "node_modules/a", "node_modules/a/debug.log", "node_modules/a/index.js",
+			},
+		},
+		{
+			name:     "exclude directory and glob",
+			lines:    []string{"node_modules", "!node_modules/.cache", "!**/*.log"},
+			expected: []string{"node_modules", "node_modules/a", "node_modules/a/index.js"},
+			stats:    walkStats{files: 1, dirs: 1},
+		},
+		{
+			name:  "later include overrides exclude",
+			lines: []string{"node_modules", "!node_modules/.cache", "node_modules/.cache/keep"},
+			expected: []string{
+				"node_modules", "node_modules/.cache/keep", "node_modules/.cache/keep/important.txt",
+				"node_modules/a", "node_modules/a/debug.log", "node_modules/a/index.js",
+			},
+			stats: walkStats{files: 1},
+		},
+		{
+			name:  "doublestar include",
+			lines: []string{"**/target/release/deps"},
+			expected: []string{
+				"crates/bar/target/release/deps", "crates/bar/target/release/deps/libbar.rlib",
+				"target/release/deps", "target/release/deps/libfoo.rlib",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			patterns, err := ParsePatterns(tt.lines)
+			assert.NoError(err)
+
+			var names []string
+			var stats walkStats
+			for _, root := range patterns.Roots() {
+				files, rootStats, err := walkPatterns(patterns, root)
+				assert.NoError(err)
+
+				for name := range files {
+					names = append(names, filepath.ToSlash(name))
+				}
+				stats.files += rootStats.files
+				stats.dirs += rootStats.dirs
+			}
+
+			slices.Sort(names)
+			assert.Equal(tt.expected, names)
+			assert.Equal(tt.stats, stats)
+		})
+	}
+}
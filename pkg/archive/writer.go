@@ -0,0 +1,599 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/rs/zerolog/log"
+	"github.com/wolfeidau/quickzip"
+)
+
+const (
+	irregularModes = os.ModeSocket | os.ModeDevice | os.ModeCharDevice | os.ModeNamedPipe
+
+	// maxMemorySpool is the amount of compressed data a worker holds in memory before spilling to a temporary file.
+	maxMemorySpool = 4 << 20
+
+	// extTimeExtraID is the Info-ZIP extended timestamp extra field written for each entry by the zip package.
+	extTimeExtraID = 0x5455
+)
+
+// Option configures how entries are recorded in an archive.
+type Option func(*options)
+
+type options struct {
+	modifiedEpoch     time.Time
+	codec             Codec
+	zstdLevel         int
+	workers           int
+	preserveOwnership bool
+	preserveModTimes  bool
+}
+
+// WithPreserveOwnership records the uid and gid of each entry, these are restored when extracting as a user
+// permitted to change ownership.
+func WithPreserveOwnership(preserve bool) Option {
+	return func(o *options) {
+		o.preserveOwnership = preserve
+	}
+}
+
+// WithPreserveModTimes records the modification time of each entry to the second, by default a fixed epoch is
+// used so the archive checksum only changes when the content, names or modes of the files change.
+func WithPreserveModTimes(preserve bool) Option {
+	return func(o *options) {
+		o.preserveModTimes = preserve
+	}
+}
+
+// WithCodec sets the codec used to compress files, the default is zstd.
+func WithCodec(codec Codec) Option {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
+
+// WithZstdLevel sets the zstd level from 1 to 22, lower levels are faster and higher levels compress better.
+func WithZstdLevel(level int) Option {
+	return func(o *options) {
+		o.zstdLevel = level
+	}
+}
+
+// WithWorkers sets the number of files compressed concurrently, the archive is identical regardless of the
+// number of workers.
+func WithWorkers(workers int) Option {
+	return func(o *options) {
+		o.workers = workers
+	}
+}
+
+func (o options) validate() error {
+	if _, err := ParseCodec(string(o.codec)); err != nil {
+		return err
+	}
+
+	if o.zstdLevel < 1 || o.zstdLevel > MaxZstdLevel {
+		return fmt.Errorf("zstd level must be between 1 and %d: %d", MaxZstdLevel, o.zstdLevel)
+	}
+
+	if o.workers < 1 {
+		return fmt.Errorf("workers must be at least 1: %d", o.workers)
+	}
+
+	return nil
+}
+
+// writer writes files, directories and symlinks to a zip archive preserving their modes. Symlinks with an absolute
+// target inside the chroot are rewritten to a relative target so they remain valid when restored to another
+// location, such as a different home directory.
+//
+// With more than one worker files are compressed ahead of the writer, the compressed data is then copied into the
+// archive in order with the same headers the zip package would write, so the archive doesn't depend on the number
+// of workers.
+type writer struct {
+	zw          *zip.Writer
+	compressors map[uint16]zip.Compressor
+	// files describes each entry written for the manifest
+	files   []ManifestFile
+	opts    options
+	written int64
+	entries int64
+}
+
+// archiveEntry is an entry which is written to the archive.
+type archiveEntry struct {
+	fi   os.FileInfo
+	hdr  *zip.FileHeader
+	path string
+	// done receives the result of compressing the file when a worker compresses it ahead of the writer.
+	done chan error
+	// compressed holds the compressed data produced by a worker, this is nil for stored files.
+	compressed *spool
+	sha256     string
+	crc32      uint32
+	size       int64
+}
+
+func newWriter(w io.Writer, opts options) *writer {
+	zw := zip.NewWriter(w)
+
+	compressors := map[uint16]zip.Compressor{
+		zip.Deflate:          quickzip.FlateCompressor(flate.DefaultCompression),
+		zstd.ZipMethodWinZip: quickzip.ZstdCompressor(int(zstd.EncoderLevelFromZstd(opts.zstdLevel))),
+	}
+
+	for method, comp := range compressors {
+		zw.RegisterCompressor(method, comp)
+	}
+
+	return &writer{zw: zw, compressors: compressors, opts: opts}
+}
+
+// Written returns the number of uncompressed bytes and entries written to the archive.
+func (w *writer) Written() (bytes, entries int64) {
+	return atomic.LoadInt64(&w.written), atomic.LoadInt64(&w.entries)
+}
+
+func (w *writer) Close() error {
+	return w.zw.Close()
+}
+
+// Archive adds the files to the archive with names relative to the chroot, files are added in sorted order so the
+// archive is reproducible.
+func (w *writer) Archive(ctx context.Context, chroot string, files map[string]os.FileInfo) (err error) {
+	entries, err := w.prepare(chroot, files)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	slots := w.precompress(ctx, entries)
+
+	written := 0
+	defer func() {
+		// wait for the workers and discard anything they compressed which wasn't written
+		cancel()
+		for _, entry := range entries[written:] {
+			if entry.done != nil {
+				<-entry.done
+				entry.compressed.Close()
+			}
+		}
+	}()
+
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		file := ManifestFile{Mode: entry.fi.Mode().String()}
+
+		switch {
+		case entry.fi.Mode()&os.ModeSymlink != 0:
+			file.Link, err = w.writeSymlink(chroot, entry.path, entry.hdr)
+		case entry.fi.IsDir():
+			entry.hdr.Name += "/"
+			_, err = w.zw.CreateHeader(entry.hdr)
+		case entry.done != nil:
+			err = <-entry.done
+			if err == nil {
+				file.Sha256, err = w.writeCompressed(ctx, entry)
+			}
+			entry.compressed.Close()
+			<-slots
+		default:
+			file.Sha256, err = w.writeFile(ctx, entry.path, entry.hdr)
+		}
+
+		written++
+
+		if err != nil {
+			return err
+		}
+
+		file.Name = entry.hdr.Name
+		file.Size = int64(entry.hdr.UncompressedSize64)
+		w.files = append(w.files, file)
+
+		atomic.AddInt64(&w.entries, 1)
+	}
+
+	return nil
+}
+
+// prepare builds the sorted entries and their headers.
+func (w *writer) prepare(chroot string, files map[string]os.FileInfo) ([]*archiveEntry, error) {
+	chroot, err := filepath.Abs(chroot)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := make([]*archiveEntry, 0, len(names))
+
+	for _, name := range names {
+		fi := files[name]
+		if fi.Mode()&irregularModes != 0 {
+			continue
+		}
+
+		path, err := filepath.Abs(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if !isUnder(path, chroot) && path != chroot {
+			return nil, fmt.Errorf("%s cannot be archived from outside of chroot (%s)", name, chroot)
+		}
+
+		rel, err := filepath.Rel(chroot, path)
+		if err != nil {
+			return nil, err
+		}
+
+		hdr := &zip.FileHeader{
+			Name:               filepath.ToSlash(rel),
+			UncompressedSize64: uint64(fi.Size()),
+			Modified:           w.opts.modifiedEpoch,
+		}
+		hdr.SetMode(fi.Mode())
+
+		if w.opts.preserveModTimes {
+			hdr.Modified = fi.ModTime()
+		}
+
+		if w.opts.preserveOwnership {
+			hdr.Extra = append(hdr.Extra, ownershipExtra(fi)...)
+		}
+
+		entries = append(entries, &archiveEntry{fi: fi, hdr: hdr, path: path})
+	}
+
+	return entries, nil
+}
+
+// precompress starts compressing regular files with the workers, the writer receives from the returned channel
+// after writing each compressed file which bounds the amount of compressed data held ahead of it.
+func (w *writer) precompress(ctx context.Context, entries []*archiveEntry) chan struct{} {
+	if w.opts.workers <= 1 {
+		return nil
+	}
+
+	slots := make(chan struct{}, w.opts.workers*2)
+
+	for _, entry := range entries {
+		if entry.fi.Mode().IsRegular() && entry.hdr.UncompressedSize64 > 0 {
+			entry.done = make(chan error, 1)
+		}
+	}
+
+	go func() {
+		workers := make(chan struct{}, w.opts.workers)
+
+		for _, entry := range entries {
+			if entry.done == nil {
+				continue
+			}
+
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				entry.done <- ctx.Err()
+				continue
+			}
+
+			workers <- struct{}{}
+
+			go func() {
+				defer func() { <-workers }()
+				entry.done <- w.compress(ctx, entry)
+			}()
+		}
+	}()
+
+	return slots
+}
+
+// compress selects the method for the file and compresses it into a spool, stored files are read when written.
+func (w *writer) compress(ctx context.Context, entry *archiveEntry) error {
+	f, err := os.Open(entry.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReaderSize(f, 32*1024)
+	head, _ := br.Peek(sniffLen)
+
+	entry.hdr.Method = w.opts.codec.method(entry.hdr.Name, head)
+	if entry.hdr.Method == zip.Store {
+		return nil
+	}
+
+	entry.compressed = new(spool)
+
+	cw, err := w.compressors[entry.hdr.Method](entry.compressed)
+	if err != nil {
+		return err
+	}
+
+	crc, sum := crc32.NewIEEE(), sha256.New()
+
+	entry.size, err = br.WriteTo(ctxWriter{w: io.MultiWriter(cw, crc, sum), ctx: ctx})
+	if err != nil {
+		cw.Close()
+		return err
+	}
+
+	entry.crc32 = crc.Sum32()
+	entry.sha256 = hex.EncodeToString(sum.Sum(nil))
+
+	return cw.Close()
+}
+
+// writeCompressed copies a file compressed by a worker into the archive, returning the sha256 of the file.
+func (w *writer) writeCompressed(ctx context.Context, entry *archiveEntry) (string, error) {
+	if entry.compressed == nil {
+		return w.writeFile(ctx, entry.path, entry.hdr)
+	}
+
+	hdr := entry.hdr
+	prepareRawHeader(hdr, entry.crc32, uint64(entry.compressed.Size()), uint64(entry.size))
+
+	fw, err := w.zw.CreateRaw(hdr)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := entry.compressed.WriteTo(fw); err != nil {
+		return "", err
+	}
+
+	// the zip package only marks an entry as zip64 once it is written, the local header is written with version 2.0
+	if hdr.CompressedSize64 >= 1<<32-1 || hdr.UncompressedSize64 >= 1<<32-1 {
+		hdr.ReaderVersion = 45
+	}
+
+	atomic.AddInt64(&w.written, entry.size)
+
+	return entry.sha256, nil
+}
+
+// writeSymlink adds the symlink to the archive, returning the target which was recorded.
+func (w *writer) writeSymlink(chroot, path string, hdr *zip.FileHeader) (string, error) {
+	link, err := os.Readlink(path)
+	if err != nil {
+		return "", err
+	}
+
+	if filepath.IsAbs(link) && isUnder(filepath.Clean(link), chroot) {
+		rel, err := filepath.Rel(filepath.Dir(path), link)
+		if err != nil {
+			return "", err
+		}
+
+		log.Debug().Str("path", path).Str("link", link).Str("rel", rel).Msg("rewriting absolute symlink")
+
+		link = rel
+	}
+
+	link = filepath.ToSlash(link)
+	hdr.UncompressedSize64 = uint64(len(link))
+
+	lw, err := w.zw.CreateHeader(hdr)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.WriteString(lw, link)
+
+	return link, err
+}
+
+// writeFile compresses the file into the archive, returning the sha256 of the file.
+func (w *writer) writeFile(ctx context.Context, path string, hdr *zip.FileHeader) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	br := bufio.NewReaderSize(f, 32*1024)
+
+	if hdr.UncompressedSize64 > 0 && hdr.Method == zip.Store {
+		head, _ := br.Peek(sniffLen)
+		hdr.Method = w.opts.codec.method(hdr.Name, head)
+	}
+
+	fw, err := w.zw.CreateHeader(hdr)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.New()
+
+	n, err := br.WriteTo(countWriter{w: io.MultiWriter(fw, sum), written: &w.written, ctx: ctx})
+	if err != nil {
+		return "", err
+	}
+
+	// the size recorded by the zip package is the number of bytes read which may differ from the stat
+	hdr.UncompressedSize64 = uint64(n)
+
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// prepareRawHeader sets the fields of a header for compressed data written with CreateRaw to match those CreateHeader
+// sets when the zip package compresses the data itself.
+func prepareRawHeader(hdr *zip.FileHeader, crc uint32, compressedSize, uncompressedSize uint64) {
+	if valid, require := detectUTF8(hdr.Name); valid && require {
+		hdr.Flags |= 0x800
+	}
+
+	hdr.CreatorVersion = hdr.CreatorVersion&0xff00 | 20
+	hdr.ReaderVersion = 20
+
+	if !hdr.Modified.IsZero() {
+		hdr.ModifiedDate, hdr.ModifiedTime = timeToMsDosTime(hdr.Modified)
+
+		var extra [9]byte
+		binary.LittleEndian.PutUint16(extra[0:], extTimeExtraID)
+		binary.LittleEndian.PutUint16(extra[2:], 5)
+		extra[4] = 1 // modification time only
+		binary.LittleEndian.PutUint32(extra[5:], uint32(hdr.Modified.Unix()))
+
+		hdr.Extra = append(hdr.Extra, extra[:]...)
+	}
+
+	// sizes are written in a data descriptor following the data as they are when the zip package compresses
+	hdr.Flags |= 0x8
+	hdr.CRC32 = crc
+	hdr.CompressedSize64 = compressedSize
+	hdr.UncompressedSize64 = uncompressedSize
+}
+
+// detectUTF8 mirrors the zip package, names are only flagged as UTF-8 when they aren't compatible with CP-437.
+func detectUTF8(s string) (valid, require bool) {
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+
+		if r < 0x20 || r > 0x7d || r == 0x5c {
+			if !utf8.ValidRune(r) || (r == utf8.RuneError && size == 1) {
+				return false, false
+			}
+			require = true
+		}
+	}
+
+	return true, require
+}
+
+func timeToMsDosTime(t time.Time) (fDate uint16, fTime uint16) {
+	fDate = uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	fTime = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return fDate, fTime
+}
+
+// isUnder reports whether path is inside the directory dir.
+func isUnder(path, dir string) bool {
+	return strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
+
+type countWriter struct {
+	w       io.Writer
+	written *int64
+	ctx     context.Context
+}
+
+func (w countWriter) Write(p []byte) (n int, err error) {
+	if err = w.ctx.Err(); err == nil {
+		n, err = w.w.Write(p)
+
+		atomic.AddInt64(w.written, int64(n))
+	}
+	return n, err
+}
+
+// ctxWriter stops writing once the context is cancelled.
+type ctxWriter struct {
+	w   io.Writer
+	ctx context.Context
+}
+
+func (w ctxWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
+
+// spool holds compressed data in memory, spilling to a temporary file once it exceeds maxMemorySpool.
+type spool struct {
+	buf  bytes.Buffer
+	file *os.File
+	size int64
+}
+
+func (s *spool) Write(p []byte) (int, error) {
+	if s.file == nil && s.buf.Len()+len(p) > maxMemorySpool {
+		f, err := os.CreateTemp("", "zipstash-spool-*")
+		if err != nil {
+			return 0, fmt.Errorf("failed to create spool file: %w", err)
+		}
+		s.file = f
+
+		if _, err := s.buf.WriteTo(f); err != nil {
+			return 0, fmt.Errorf("failed to write spool file: %w", err)
+		}
+	}
+
+	var (
+		n   int
+		err error
+	)
+
+	if s.file != nil {
+		n, err = s.file.Write(p)
+	} else {
+		n, err = s.buf.Write(p)
+	}
+
+	s.size += int64(n)
+
+	return n, err
+}
+
+func (s *spool) Size() int64 {
+	return s.size
+}
+
+func (s *spool) WriteTo(w io.Writer) (int64, error) {
+	if s.file == nil {
+		return s.buf.WriteTo(w)
+	}
+
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	return io.Copy(w, s.file)
+}
+
+// Close releases the spool, this is safe to call on a nil spool.
+func (s *spool) Close() {
+	if s == nil || s.file == nil {
+		return
+	}
+
+	err := errors.Join(s.file.Close(), os.Remove(s.file.Name()))
+	if err != nil {
+		log.Warn().Err(err).Str("path", s.file.Name()).Msg("failed to remove spool file")
+	}
+}
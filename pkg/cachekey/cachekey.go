@@ -0,0 +1,118 @@
+package cachekey
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// Resolve expands a cache key template, paths passed to hashFiles are relative to dir. Keys which don't contain
+// a template action are returned unchanged.
+//
+// The following functions are available in templates:
+//
+//	os                  the operating system, for example linux
+//	arch                the architecture, for example arm64
+//	env "NAME"          the value of an environment variable, or an empty string if it isn't set
+//	hashFiles "PATTERN" the sha256 of the files matching one or more doublestar patterns, for example "**/go.sum"
+func Resolve(key, dir string) (string, error) {
+	if !strings.Contains(key, "{{") {
+		return key, nil
+	}
+
+	fsys := os.DirFS(dir)
+
+	tmpl, err := template.New("key").Option("missingkey=error").Funcs(template.FuncMap{
+		"os":   func() string { return runtime.GOOS },
+		"arch": func() string { return runtime.GOARCH },
+		"env":  os.Getenv,
+		"hashFiles": func(patterns ...string) (string, error) {
+			return HashFiles(fsys, patterns...)
+		},
+	}).Parse(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse key template: %w", err)
+	}
+
+	buf := new(strings.Builder)
+
+	err = tmpl.Execute(buf, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute key template: %w", err)
+	}
+
+	return buf.String(), nil
+}
+
+// HashFiles returns a hex encoded sha256 of the contents of the regular files matching the doublestar patterns. The
+// files are hashed individually in sorted path order and the hashes combined, so the result only changes when the
+// content of a matched file changes, or a file is added or removed. An empty string is returned if nothing matches.
+func HashFiles(fsys fs.FS, patterns ...string) (string, error) {
+	if len(patterns) == 0 {
+		return "", fmt.Errorf("hashFiles requires at least one pattern")
+	}
+
+	var files []string
+
+	for _, pattern := range patterns {
+		if !doublestar.ValidatePattern(pattern) {
+			return "", fmt.Errorf("invalid pattern: %q", pattern)
+		}
+
+		matches, err := doublestar.Glob(fsys, pattern, doublestar.WithFilesOnly(), doublestar.WithFailOnIOErrors())
+		if err != nil {
+			return "", fmt.Errorf("failed to match pattern %q: %w", pattern, err)
+		}
+
+		files = append(files, matches...)
+	}
+
+	if len(files) == 0 {
+		log.Warn().Strs("patterns", patterns).Msg("hashFiles matched no files")
+		return "", nil
+	}
+
+	// patterns may overlap so sort and remove duplicates to ensure a stable order
+	slices.Sort(files)
+	files = slices.Compact(files)
+
+	combined := sha256.New()
+
+	for _, file := range files {
+		sum, err := hashFile(fsys, file)
+		if err != nil {
+			return "", err
+		}
+
+		_, _ = combined.Write(sum)
+	}
+
+	return hex.EncodeToString(combined.Sum(nil)), nil
+}
+
+func hashFile(fsys fs.FS, name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash file %s: %w", name, err)
+	}
+
+	return h.Sum(nil), nil
+}
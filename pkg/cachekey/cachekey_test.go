@@ -0,0 +1,143 @@
+package cachekey
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"go.sum":             {Data: []byte("root")},
+		"tools/go.sum":       {Data: []byte("tools")},
+		"web/package.json":   {Data: []byte("{}")},
+		"web/node/go.sum":    {Data: []byte("nested")},
+		"vendor/go.sum/file": {Data: []byte("not a go.sum")},
+	}
+
+	combined := func(contents ...string) string {
+		h := sha256.New()
+		for _, c := range contents {
+			sum := sha256.Sum256([]byte(c))
+			h.Write(sum[:])
+		}
+		return hex.EncodeToString(h.Sum(nil))
+	}
+
+	tests := []struct {
+		name     string
+		patterns []string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "single file",
+			patterns: []string{"go.sum"},
+			expected: combined("root"),
+		},
+		{
+			name:     "doublestar sorted by path",
+			patterns: []string{"**/go.sum"},
+			expected: combined("root", "tools", "nested"),
+		},
+		{
+			name:     "overlapping patterns are only hashed once",
+			patterns: []string{"tools/go.sum", "**/go.sum"},
+			expected: combined("root", "tools", "nested"),
+		},
+		{
+			name:     "no matches",
+			patterns: []string{"**/yarn.lock"},
+			expected: "",
+		},
+		{
+			name:     "invalid pattern",
+			patterns: []string{"[go.sum"},
+			wantErr:  true,
+		},
+		{
+			name:     "no patterns",
+			patterns: nil,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			sum, err := HashFiles(fsys, tt.patterns...)
+			if tt.wantErr {
+				assert.Error(err)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(tt.expected, sum)
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.sum"), []byte("root"), 0600))
+
+	t.Setenv("GO_VERSION", "1.24")
+
+	sum, err := HashFiles(os.DirFS(dir), "go.sum")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		key      string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "plain key",
+			key:      "go-mod-abc123",
+			expected: "go-mod-abc123",
+		},
+		{
+			name:     "template",
+			key:      `go-{{ os }}-{{ arch }}-{{ hashFiles "**/go.sum" }}-{{ env "GO_VERSION" }}`,
+			expected: "go-" + runtime.GOOS + "-" + runtime.GOARCH + "-" + sum + "-1.24",
+		},
+		{
+			name:     "missing env",
+			key:      `go-{{ env "ZIPSTASH_MISSING_VAR" }}`,
+			expected: "go-",
+		},
+		{
+			name:    "unknown function",
+			key:     `go-{{ hashfiles "go.sum" }}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid pattern",
+			key:     `go-{{ hashFiles "[go.sum" }}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			key, err := Resolve(tt.key, dir)
+			if tt.wantErr {
+				assert.Error(err)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(tt.expected, key)
+		})
+	}
+}
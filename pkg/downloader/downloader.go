@@ -3,6 +3,7 @@ package downloader
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"sync"
@@ -11,16 +12,20 @@ import (
 	"github.com/cenkalti/backoff/v5"
 	"github.com/rs/zerolog/log"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
 
+	"github.com/wolfeidau/zipstash/pkg/progress"
+	"github.com/wolfeidau/zipstash/pkg/ratelimit"
 	"github.com/wolfeidau/zipstash/pkg/trace"
 )
 
-type DownloadedFile struct {
-	URL      string
-	FilePath string
-	ETag     string
-	Part     int
-	Size     int64
+// DownloadedPart describes a part which has been written to the target file.
+type DownloadedPart struct {
+	URL  string
+	ETag string
+	Part int
+	Size int64
 }
 
 type CacheDownloadInstruction struct {
@@ -44,76 +49,113 @@ type Offset struct {
 	Start int64 `json:"start"`
 }
 
-// Downloader uses go routines to download parts of a file in parallel with a limit of 20 concurrent uploads.
-// It is provided a list of URLs to upload and a channel to receive the results.
+// Downloader uses go routines to download parts of a file in parallel with a configurable limit of concurrent downloads.
+// It is provided a list of URLs to download and each part is written directly to its offset in the target file.
 type Downloader struct {
 	client            *http.Client
-	errors            chan error
-	done              chan struct{}
+	limiter           *rate.Limiter
+	reporter          progress.Reporter
 	downloadInstructs []CacheDownloadInstruction
 	limit             int
 }
 
-func NewDownloader(downloadInstructs []CacheDownloadInstruction, limit int) *Downloader {
-	return &Downloader{
+// Option configures a Downloader.
+type Option func(*Downloader)
+
+// WithRateLimiter throttles the combined download bandwidth of all parts using the supplied limiter.
+func WithRateLimiter(limiter *rate.Limiter) Option {
+	return func(d *Downloader) {
+		d.limiter = limiter
+	}
+}
+
+// WithReporter sends progress events for each part to the supplied reporter.
+func WithReporter(reporter progress.Reporter) Option {
+	return func(d *Downloader) {
+		d.reporter = reporter
+	}
+}
+
+// NewDownloader creates a new downloader which downloads at most limit parts concurrently, a limit less than one is treated as one.
+func NewDownloader(downloadInstructs []CacheDownloadInstruction, limit int, opts ...Option) *Downloader {
+	d := &Downloader{
 		downloadInstructs: downloadInstructs,
 		client:            &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)},
-		limit:             limit,
-		errors:            make(chan error),
-		done:              make(chan struct{}),
+		limit:             max(limit, 1),
+		reporter:          progress.Discard,
+	}
+
+	for _, opt := range opts {
+		opt(d)
 	}
+
+	return d
 }
-func (d *Downloader) Download(ctx context.Context) ([]DownloadedFile, error) {
+
+// Download fetches all the parts and writes them into the target file at their offsets, the file is
+// preallocated to totalSize before any parts are written.
+func (d *Downloader) Download(ctx context.Context, target *os.File, totalSize int64) ([]DownloadedPart, error) {
 	ctx, span := trace.Start(ctx, "Downloader.Download")
 	defer span.End()
 
+	err := target.Truncate(totalSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to preallocate file: %w", err)
+	}
+
+	d.reporter.Start("download", totalSize, len(d.downloadInstructs))
+
 	var mu sync.Mutex
-	downloads := make([]DownloadedFile, 0, len(d.downloadInstructs))
+	downloads := make([]DownloadedPart, 0, len(d.downloadInstructs))
 
-	wg := sync.WaitGroup{}
-	wg.Add(len(d.downloadInstructs))
-	sem := make(chan struct{}, d.limit)
 	start := time.Now()
 
+	// the first failed part cancels the others so nothing is written to the target once this returns
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(d.limit)
+
 	for _, downloadInstruct := range d.downloadInstructs {
-		sem <- struct{}{}
-		go func(downloadInstruct CacheDownloadInstruction) {
-			defer func() {
-				<-sem
-				wg.Done()
-			}()
-			download, err := d.download(ctx, downloadInstruct)
+		g.Go(func() error {
+			download, err := d.download(ctx, target, downloadInstruct)
 			if err != nil {
-				d.errors <- err
+				return err
 			}
 			// Safely collect the etag
 			mu.Lock()
 			downloads = append(downloads, download)
 			mu.Unlock()
-		}(downloadInstruct)
-	}
 
-	go func() {
-		wg.Wait()
-		close(d.done)
-	}()
+			return nil
+		})
+	}
 
-	select {
-	case <-d.done:
-		emitSummary(downloads, start)
-		return downloads, nil
-	case err := <-d.errors:
+	if err := g.Wait(); err != nil {
+		d.reporter.Done(err)
 		return nil, err
 	}
+
+	d.reporter.Done(nil)
+	emitSummary(downloads, start)
+
+	return downloads, nil
 }
 
-func (d *Downloader) download(ctx context.Context, downloadInstruct CacheDownloadInstruction) (DownloadedFile, error) {
+func (d *Downloader) download(ctx context.Context, target io.WriterAt, downloadInstruct CacheDownloadInstruction) (DownloadedPart, error) {
 	ctx, span := trace.Start(ctx, "Downloader.download")
 	defer span.End()
 
-	operation := func() (DownloadedFile, error) {
+	var (
+		part   = 1
+		offset int64
+	)
+	if downloadInstruct.Offset != nil {
+		part = int(downloadInstruct.Offset.Part)
+		offset = downloadInstruct.Offset.Start
+	}
+
+	operation := func() (DownloadedPart, error) {
 
-		var download DownloadedFile
+		var download DownloadedPart
 
 		downloadReq, err := http.NewRequestWithContext(ctx, downloadInstruct.Method, downloadInstruct.Url, nil)
 		if err != nil {
@@ -130,45 +172,60 @@ func (d *Downloader) download(ctx context.Context, downloadInstruct CacheDownloa
 		}
 		defer resp.Body.Close()
 
-		if resp.StatusCode == http.StatusBadRequest ||
-			resp.StatusCode == http.StatusNotFound ||
-			resp.StatusCode == http.StatusInternalServerError {
-			return download, fmt.Errorf("failed to download file: %s", resp.Status)
+		// wait for throttled requests as long as the response asks
+		if delay, ok := ratelimit.ResponseRetryAfter(resp); ok {
+			return download, &backoff.RetryAfterError{Duration: delay}
 		}
 
-		var part = 1
-		if downloadInstruct.Offset != nil {
-			part = int(downloadInstruct.Offset.Part)
+		// only write successful responses to the target file
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+			return download, fmt.Errorf("failed to download file: %s", resp.Status)
 		}
 
-		f, err := os.CreateTemp("", fmt.Sprintf("zipstash-download-%06d-*", part))
-		if err != nil {
-			return download, fmt.Errorf("failed to create temp file: %w", err)
+		// a server which ignores the range returns the whole file, which can't be written at the offset of the part
+		if downloadInstruct.Offset != nil && resp.StatusCode == http.StatusOK && !coversPart(resp, downloadInstruct.Offset) {
+			return download, backoff.Permanent(fmt.Errorf("failed to download part %d: range not satisfied by %s response", part, resp.Status))
 		}
 
-		defer f.Close()
+		// each attempt writes from the start of the part so a retry overwrites any partial write
+		body := progress.NewReader(ratelimit.NewReader(ctx, resp.Body, d.limiter), d.reporter, int32(part))
 
-		_, err = f.ReadFrom(resp.Body)
+		n, err := io.Copy(io.NewOffsetWriter(target, offset), body)
 		if err != nil {
-			return download, fmt.Errorf("failed to read response body: %w", err)
+			return download, fmt.Errorf("failed to write response body: %w", err)
+		}
+
+		if downloadInstruct.Offset != nil {
+			expected := downloadInstruct.Offset.End - downloadInstruct.Offset.Start + 1
+			if n != expected {
+				return download, fmt.Errorf("download size mismatch for part %d: got %d, expected %d", part, n, expected)
+			}
 		}
 
 		download.Part = part
 		download.URL = downloadInstruct.Url
 		download.ETag = resp.Header.Get("ETag")
-		download.Size = resp.ContentLength
-		download.FilePath = f.Name()
+		download.Size = n
 
-		log.Debug().Int("part", download.Part).Str("path", download.FilePath).Int64("size", download.Size).Msg("downloaded file")
+		log.Debug().Int("part", download.Part).Int64("offset", offset).Int64("size", download.Size).Msg("downloaded part")
 
 		return download, nil
 	}
 
 	return backoff.Retry(ctx, operation,
-		backoff.WithBackOff(backoff.NewExponentialBackOff()), backoff.WithMaxTries(3))
+		backoff.WithBackOff(backoff.NewExponentialBackOff()), backoff.WithMaxTries(3),
+		backoff.WithNotify(func(err error, _ time.Duration) {
+			d.reporter.Retried(int32(part), err)
+		}))
+}
+
+// coversPart reports whether a full response has exactly the content of the part, which is the case when the part
+// is the whole file.
+func coversPart(resp *http.Response, offset *Offset) bool {
+	return offset.Start == 0 && resp.ContentLength == offset.End+1
 }
 
-func emitSummary(downloads []DownloadedFile, start time.Time) {
+func emitSummary(downloads []DownloadedPart, start time.Time) {
 	since := time.Since(start)
 
 	var totalSize int64
@@ -0,0 +1,176 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+func TestDownload(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	assert.NoError(err)
+
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "archive.zip", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		instruct []CacheDownloadInstruction
+	}{
+		{
+			name:     "single",
+			instruct: []CacheDownloadInstruction{{Method: http.MethodGet, Url: srv.URL}},
+		},
+		{
+			name: "multipart",
+			instruct: []CacheDownloadInstruction{
+				{Method: http.MethodGet, Url: srv.URL, Offset: &Offset{Part: 1, Start: 0, End: 300}},
+				{Method: http.MethodGet, Url: srv.URL, Offset: &Offset{Part: 2, Start: 301, End: 601}},
+				{Method: http.MethodGet, Url: srv.URL, Offset: &Offset{Part: 3, Start: 602, End: 999}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			f, err := os.Create(filepath.Join(t.TempDir(), "archive.zip"))
+			assert.NoError(err)
+			defer f.Close()
+
+			parts, err := NewDownloader(tt.instruct, 2).Download(context.Background(), f, int64(len(content)))
+			assert.NoError(err)
+			assert.Len(parts, len(tt.instruct))
+
+			data, err := os.ReadFile(f.Name())
+			assert.NoError(err)
+			assert.Equal(content, data)
+		})
+	}
+}
+
+func TestDownloadError(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	assert.NoError(err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "denied", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "archive.zip"))
+	assert.NoError(err)
+	defer f.Close()
+
+	_, err = NewDownloader([]CacheDownloadInstruction{{Method: http.MethodGet, Url: srv.URL}}, 1).Download(context.Background(), f, 10)
+	assert.ErrorContains(err, "403 Forbidden")
+}
+
+func TestDownloadRetryAfter(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	assert.NoError(err)
+
+	content := bytes.Repeat([]byte("0123456789"), 10)
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "slow down", http.StatusServiceUnavailable)
+			return
+		}
+
+		http.ServeContent(w, r, "archive.zip", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "archive.zip"))
+	assert.NoError(err)
+	defer f.Close()
+
+	_, err = NewDownloader([]CacheDownloadInstruction{{Method: http.MethodGet, Url: srv.URL}}, 1).Download(context.Background(), f, int64(len(content)))
+	assert.NoError(err)
+	assert.Equal(int32(2), calls.Load())
+
+	data, err := os.ReadFile(f.Name())
+	assert.NoError(err)
+	assert.Equal(content, data)
+}
+
+func TestDownloadRangeIgnored(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	assert.NoError(err)
+
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(content)
+	}))
+	defer srv.Close()
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "archive.zip"))
+	assert.NoError(err)
+	defer f.Close()
+
+	_, err = NewDownloader([]CacheDownloadInstruction{
+		{Method: http.MethodGet, Url: srv.URL, Offset: &Offset{Part: 1, Start: 0, End: 499}},
+		{Method: http.MethodGet, Url: srv.URL, Offset: &Offset{Part: 2, Start: 500, End: 999}},
+	}, 1).Download(context.Background(), f, int64(len(content)))
+	assert.ErrorContains(err, "range not satisfied")
+}
+
+func TestDownloadPartError(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	assert.NoError(err)
+
+	var cancelled atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/denied" {
+			http.Error(w, "denied", http.StatusForbidden)
+			return
+		}
+
+		// the other parts stall until the failed part cancels them
+		<-r.Context().Done()
+		cancelled.Add(1)
+	}))
+	defer srv.Close()
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "archive.zip"))
+	assert.NoError(err)
+	defer f.Close()
+
+	_, err = NewDownloader([]CacheDownloadInstruction{
+		{Method: http.MethodGet, Url: srv.URL + "/stalled", Offset: &Offset{Part: 1, Start: 0, End: 4}},
+		{Method: http.MethodGet, Url: srv.URL + "/stalled", Offset: &Offset{Part: 2, Start: 5, End: 9}},
+		{Method: http.MethodGet, Url: srv.URL + "/denied", Offset: &Offset{Part: 3, Start: 10, End: 14}},
+	}, 3).Download(context.Background(), f, 15)
+	assert.ErrorContains(err, "403 Forbidden")
+
+	assert.Eventually(func() bool { return cancelled.Load() == 2 }, 5*time.Second, 10*time.Millisecond)
+}
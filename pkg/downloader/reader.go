@@ -0,0 +1,172 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+// DefaultBlockSize is the size of the ranged reads made by a ReaderAt when the archive is available from a single URL.
+const DefaultBlockSize = 256 * 1024
+
+// ReaderAt reads a remote file using ranged requests, this is used to read the central directory of an archive
+// without downloading all of it. Fetched ranges are cached so repeated reads of the same region are free.
+//
+// When the file is split into parts each URL is signed for the range of its part, so a whole part is fetched
+// on the first read within it. Otherwise reads are rounded out to blocks of the configured size.
+type ReaderAt struct {
+	ctx       context.Context
+	client    *http.Client
+	instructs []CacheDownloadInstruction
+	spans     []span
+	mu        sync.Mutex
+	size      int64
+	blockSize int64
+	fetched   int64
+}
+
+type span struct {
+	data  []byte
+	start int64
+}
+
+// NewReaderAt creates a reader for the file of the supplied size described by the download instructions.
+func NewReaderAt(ctx context.Context, downloadInstructs []CacheDownloadInstruction, size int64) (*ReaderAt, error) {
+	if len(downloadInstructs) == 0 {
+		return nil, errors.New("no download instructions")
+	}
+
+	return &ReaderAt{
+		ctx:       ctx,
+		client:    &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)},
+		instructs: downloadInstructs,
+		size:      size,
+		blockSize: DefaultBlockSize,
+	}, nil
+}
+
+// Size returns the size of the remote file.
+func (r *ReaderAt) Size() int64 {
+	return r.size
+}
+
+// Fetched returns the number of bytes downloaded so far.
+func (r *ReaderAt) Fetched() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.fetched
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset: %d", off)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var n int
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= r.size {
+			return n, io.EOF
+		}
+
+		s, ok := r.lookup(pos)
+		if !ok {
+			var err error
+			s, err = r.fetch(pos)
+			if err != nil {
+				return n, err
+			}
+		}
+
+		n += copy(p[n:], s.data[pos-s.start:])
+	}
+
+	return n, nil
+}
+
+func (r *ReaderAt) lookup(pos int64) (span, bool) {
+	for _, s := range r.spans {
+		if pos >= s.start && pos < s.start+int64(len(s.data)) {
+			return s, true
+		}
+	}
+
+	return span{}, false
+}
+
+// fetch downloads the part or block containing pos and caches it.
+func (r *ReaderAt) fetch(pos int64) (span, error) {
+	ctx, sp := trace.Start(r.ctx, "ReaderAt.fetch")
+	defer sp.End()
+
+	instruct, start, end, err := r.locate(pos)
+	if err != nil {
+		return span{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, instruct.Method, instruct.Url, nil)
+	if err != nil {
+		return span{}, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return span{}, fmt.Errorf("failed to read range: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// the range was ignored so the whole file is returned, this is only expected for single URLs
+		if instruct.Offset != nil {
+			return span{}, fmt.Errorf("range not supported for part %d", instruct.Offset.Part)
+		}
+		start, end = 0, r.size-1
+	default:
+		return span{}, fmt.Errorf("failed to read range: %s", resp.Status)
+	}
+
+	data := make([]byte, end-start+1)
+
+	_, err = io.ReadFull(resp.Body, data)
+	if err != nil {
+		return span{}, fmt.Errorf("failed to read range %d-%d: %w", start, end, err)
+	}
+
+	s := span{start: start, data: data}
+	r.spans = append(r.spans, s)
+	r.fetched += int64(len(data))
+
+	return s, nil
+}
+
+// locate returns the instruction and inclusive range to fetch for the byte at pos.
+func (r *ReaderAt) locate(pos int64) (CacheDownloadInstruction, int64, int64, error) {
+	for _, instruct := range r.instructs {
+		if instruct.Offset == nil {
+			start := pos - pos%r.blockSize
+			return instruct, start, min(start+r.blockSize, r.size) - 1, nil
+		}
+
+		if pos >= instruct.Offset.Start && pos <= instruct.Offset.End {
+			return instruct, instruct.Offset.Start, instruct.Offset.End, nil
+		}
+	}
+
+	return CacheDownloadInstruction{}, 0, 0, fmt.Errorf("no part contains offset %d", pos)
+}
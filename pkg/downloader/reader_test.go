@@ -0,0 +1,103 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+func TestReaderAt(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	assert.NoError(err)
+
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("norange") {
+			r.Header.Del("Range")
+		}
+		http.ServeContent(w, r, "archive.zip", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		instruct []CacheDownloadInstruction
+		fetched  int64
+	}{
+		{
+			name:     "single",
+			instruct: []CacheDownloadInstruction{{Method: http.MethodGet, Url: srv.URL}},
+			fetched:  232,
+		},
+		{
+			name:     "single without range support",
+			instruct: []CacheDownloadInstruction{{Method: http.MethodGet, Url: srv.URL + "?norange"}},
+			fetched:  1000,
+		},
+		{
+			name: "multipart",
+			instruct: []CacheDownloadInstruction{
+				{Method: http.MethodGet, Url: srv.URL, Offset: &Offset{Part: 1, Start: 0, End: 300}},
+				{Method: http.MethodGet, Url: srv.URL, Offset: &Offset{Part: 2, Start: 301, End: 601}},
+				{Method: http.MethodGet, Url: srv.URL, Offset: &Offset{Part: 3, Start: 602, End: 999}},
+			},
+			fetched: 398,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			r, err := NewReaderAt(context.Background(), tt.instruct, int64(len(content)))
+			assert.NoError(err)
+			r.blockSize = 128
+
+			// reads near the end of the file, as done for the central directory of an archive
+			buf := make([]byte, 200)
+			n, err := r.ReadAt(buf, 790)
+			assert.NoError(err)
+			assert.Equal(200, n)
+			assert.Equal(content[790:990], buf)
+
+			n, err = r.ReadAt(buf, 900)
+			assert.ErrorIs(err, io.EOF)
+			assert.Equal(100, n)
+			assert.Equal(content[900:], buf[:n])
+
+			assert.Equal(tt.fetched, r.Fetched())
+		})
+	}
+}
+
+func TestReaderAtError(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	assert.NoError(err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "denied", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	r, err := NewReaderAt(context.Background(), []CacheDownloadInstruction{{Method: http.MethodGet, Url: srv.URL}}, 1000)
+	assert.NoError(err)
+
+	_, err = r.ReadAt(make([]byte, 10), 0)
+	assert.ErrorContains(err, "403")
+
+	_, err = NewReaderAt(context.Background(), nil, 1000)
+	assert.Error(err)
+}
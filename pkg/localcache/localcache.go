@@ -0,0 +1,260 @@
+// Package localcache provides a content addressed cache of archives in a local directory, this is used on
+// persistent runners to avoid downloading the same archive for every job.
+//
+// Archives are stored by their sha256 and evicted least recently used first once the total size exceeds the
+// configured limit. A lock file in the directory coordinates concurrent jobs sharing the cache.
+package localcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+const (
+	objectsDir = "objects"
+	tmpDir     = "tmp"
+	lockName   = "lock"
+
+	// staleTempAge is the age after which temp files are assumed to be left behind by a job which was killed.
+	staleTempAge = 24 * time.Hour
+)
+
+var (
+	// ErrNotFound is returned when the cache doesn't contain an archive with the sha256.
+	ErrNotFound = errors.New("archive not found in local cache")
+	// ErrChecksumMismatch is returned when the content added to the cache doesn't match its sha256.
+	ErrChecksumMismatch = errors.New("archive checksum mismatch")
+)
+
+// Cache is a content addressed cache of archives in a local directory.
+type Cache struct {
+	dir     string
+	maxSize int64
+}
+
+// New creates a cache in dir which holds at most maxSize bytes, the directory is created if it doesn't exist.
+func New(dir string, maxSize int64) (*Cache, error) {
+	for _, name := range []string{objectsDir, tmpDir} {
+		err := os.MkdirAll(filepath.Join(dir, name), 0o755)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create local cache directory: %w", err)
+		}
+	}
+
+	return &Cache{dir: dir, maxSize: maxSize}, nil
+}
+
+// Get opens the archive with the supplied sha256 and marks it as recently used, ErrNotFound is returned
+// on a miss. The file remains readable if it is evicted while open, except on Windows where eviction of
+// open files fails and is retried by a later eviction.
+func (c *Cache) Get(ctx context.Context, sum string) (*os.File, error) {
+	_, span := trace.Start(ctx, "Cache.Get")
+	defer span.End()
+
+	path, err := c.objectPath(sum)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := c.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to open cached archive: %w", err)
+	}
+
+	now := time.Now()
+
+	err = os.Chtimes(path, now, now)
+	if err != nil {
+		log.Warn().Err(err).Str("sha256sum", sum).Msg("failed to update cached archive time")
+	}
+
+	span.SetAttributes(attribute.Bool("hit", true))
+
+	return f, nil
+}
+
+// Put adds the content read from r to the cache under the sha256, the content is verified against the sum
+// before it is added and ErrChecksumMismatch is returned if it doesn't match. Archives larger than the
+// cache are skipped.
+func (c *Cache) Put(ctx context.Context, sum string, r io.Reader) error {
+	_, span := trace.Start(ctx, "Cache.Put")
+	defer span.End()
+
+	path, err := c.objectPath(sum)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(c.dir, tmpDir), sum+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+
+	size, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if err != nil {
+		return fmt.Errorf("failed to copy archive to local cache: %w", err)
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != sum {
+		return ErrChecksumMismatch
+	}
+
+	span.SetAttributes(attribute.Int64("size", size))
+
+	if c.maxSize > 0 && size > c.maxSize {
+		log.Debug().Str("sha256sum", sum).Int64("size", size).Msg("archive larger than local cache")
+		return nil
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	unlock, err := c.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create local cache directory: %w", err)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("failed to add archive to local cache: %w", err)
+	}
+
+	return c.evict()
+}
+
+type object struct {
+	modified time.Time
+	path     string
+	size     int64
+}
+
+// evict removes the least recently used archives until the cache is within its size limit, along with stale
+// temp files. This must be called holding the exclusive lock.
+func (c *Cache) evict() error {
+	var (
+		objects []object
+		total   int64
+	)
+
+	err := filepath.WalkDir(filepath.Join(c.dir, objectsDir), func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		objects = append(objects, object{path: path, size: info.Size(), modified: info.ModTime()})
+		total += info.Size()
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list local cache: %w", err)
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].modified.Before(objects[j].modified)
+	})
+
+	for _, obj := range objects {
+		if c.maxSize <= 0 || total <= c.maxSize {
+			break
+		}
+
+		err = os.Remove(obj.path)
+		if err != nil {
+			log.Warn().Err(err).Str("path", obj.path).Msg("failed to evict cached archive")
+			continue
+		}
+
+		log.Debug().Str("path", obj.path).Int64("size", obj.size).Msg("evicted cached archive")
+
+		total -= obj.size
+	}
+
+	temps, err := os.ReadDir(filepath.Join(c.dir, tmpDir))
+	if err != nil {
+		return fmt.Errorf("failed to list local cache temp files: %w", err)
+	}
+
+	for _, temp := range temps {
+		info, err := temp.Info()
+		if err == nil && time.Since(info.ModTime()) > staleTempAge {
+			_ = os.Remove(filepath.Join(c.dir, tmpDir, temp.Name()))
+		}
+	}
+
+	return nil
+}
+
+// objectPath returns the path of the archive with the sha256, the first two characters of the sum are used
+// as a directory to keep directories small.
+func (c *Cache) objectPath(sum string) (string, error) {
+	if len(sum) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid sha256: %q", sum)
+	}
+
+	for _, ch := range sum {
+		if (ch < '0' || ch > '9') && (ch < 'a' || ch > 'f') {
+			return "", fmt.Errorf("invalid sha256: %q", sum)
+		}
+	}
+
+	return filepath.Join(c.dir, objectsDir, sum[:2], sum), nil
+}
+
+// lock takes a shared or exclusive lock of the cache, the returned function releases it.
+func (c *Cache) lock(exclusive bool) (func(), error) {
+	f, err := os.OpenFile(filepath.Join(c.dir, lockName), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open local cache lock: %w", err)
+	}
+
+	err = lockFile(f, exclusive)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock local cache: %w", err)
+	}
+
+	return func() {
+		_ = unlockFile(f)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,144 @@
+package localcache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+func sum(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func TestCache(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	assert.NoError(err)
+
+	ctx := context.Background()
+
+	cache, err := New(t.TempDir(), 1000)
+	assert.NoError(err)
+
+	data := bytes.Repeat([]byte("a"), 100)
+
+	_, err = cache.Get(ctx, sum(data))
+	assert.ErrorIs(err, ErrNotFound)
+
+	assert.NoError(cache.Put(ctx, sum(data), bytes.NewReader(data)))
+
+	f, err := cache.Get(ctx, sum(data))
+	assert.NoError(err)
+	defer f.Close()
+
+	got, err := io.ReadAll(f)
+	assert.NoError(err)
+	assert.Equal(data, got)
+
+	err = cache.Put(ctx, sum(data), strings.NewReader("corrupt"))
+	assert.ErrorIs(err, ErrChecksumMismatch)
+
+	_, err = cache.Get(ctx, "../../etc/passwd")
+	assert.ErrorContains(err, "invalid sha256")
+
+	// archives larger than the cache are skipped
+	large := bytes.Repeat([]byte("b"), 1001)
+	assert.NoError(cache.Put(ctx, sum(large), bytes.NewReader(large)))
+
+	_, err = cache.Get(ctx, sum(large))
+	assert.ErrorIs(err, ErrNotFound)
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	assert.NoError(err)
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	cache, err := New(dir, 250)
+	assert.NoError(err)
+
+	var sums []string
+	for i, ch := range []string{"a", "b"} {
+		data := bytes.Repeat([]byte(ch), 100)
+		sums = append(sums, sum(data))
+		assert.NoError(cache.Put(ctx, sum(data), bytes.NewReader(data)))
+
+		// backdate the archives so the order doesn't depend on the timestamp resolution of the file system
+		old := time.Now().Add(-time.Duration(10-i) * time.Hour)
+		path, err := cache.objectPath(sum(data))
+		assert.NoError(err)
+		assert.NoError(os.Chtimes(path, old, old))
+	}
+
+	// using the first archive makes the second the least recently used
+	f, err := cache.Get(ctx, sums[0])
+	assert.NoError(err)
+	assert.NoError(f.Close())
+
+	data := bytes.Repeat([]byte("c"), 100)
+	assert.NoError(cache.Put(ctx, sum(data), bytes.NewReader(data)))
+
+	for name, expected := range map[string]bool{sums[0]: true, sums[1]: false, sum(data): true} {
+		f, err := cache.Get(ctx, name)
+		if expected {
+			assert.NoError(err)
+			assert.NoError(f.Close())
+		} else {
+			assert.ErrorIs(err, ErrNotFound)
+		}
+	}
+
+	temps, err := os.ReadDir(filepath.Join(dir, tmpDir))
+	assert.NoError(err)
+	assert.Empty(temps)
+}
+
+func TestCacheConcurrentPut(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "test", "0.0.1")
+	assert.NoError(err)
+
+	ctx := context.Background()
+
+	cache, err := New(t.TempDir(), 0)
+	assert.NoError(err)
+
+	data := bytes.Repeat([]byte("a"), 1<<16)
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(cache.Put(ctx, sum(data), bytes.NewReader(data)))
+		}()
+	}
+	wg.Wait()
+
+	f, err := cache.Get(ctx, sum(data))
+	assert.NoError(err)
+	defer f.Close()
+
+	got, err := io.ReadAll(f)
+	assert.NoError(err)
+	assert.Equal(data, got)
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	otelprometheus "go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+
+	"github.com/wolfeidau/zipstash/pkg/trace"
+)
+
+const meterName = "github.com/wolfeidau/zipstash"
+
+// NewProvider creates a meter provider which is read by a prometheus registry, the returned handler serves the
+// registry on /metrics.
+func NewProvider(ctx context.Context, name, version string) (*sdkmetric.MeterProvider, http.Handler, error) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	exporter, err := otelprometheus.New(otelprometheus.WithRegisterer(registry))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create exporter: %w", err)
+	}
+
+	res, err := trace.NewResource(ctx, name, version)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create resource: %w", err)
+	}
+
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(exporter),
+		sdkmetric.WithResource(res),
+	)
+	otel.SetMeterProvider(mp)
+
+	return mp, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}), nil
+}
+
+// NewLambdaProvider creates a meter provider which exports to the collector extension of the function, lambda
+// freezes the function between invocations so metrics should be flushed at the end of each invocation.
+func NewLambdaProvider(ctx context.Context, name, version string) (*sdkmetric.MeterProvider, error) {
+	exporter, err := otlpmetricgrpc.New(ctx,
+		otlpmetricgrpc.WithInsecure(),
+		otlpmetricgrpc.WithEndpoint("0.0.0.0:4317"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create exporter: %w", err)
+	}
+
+	res, err := trace.NewResource(ctx, name, version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource: %w", err)
+	}
+
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter)),
+		sdkmetric.WithResource(res),
+	)
+	otel.SetMeterProvider(mp)
+
+	return mp, nil
+}
+
+// FlushHandler flushes the metrics of the provider after each request, this is used in lambda where the function
+// may be frozen before the periodic reader exports.
+func FlushHandler(mp *sdkmetric.MeterProvider, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+
+		_ = mp.ForceFlush(r.Context())
+	})
+}
+
+// Meter returns the meter used to create instruments, instruments created before a provider is configured are
+// forwarded to it once it is.
+func Meter() metric.Meter {
+	return otel.Meter(meterName)
+}
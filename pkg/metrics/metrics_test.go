@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewProvider(t *testing.T) {
+	assert := require.New(t)
+
+	ctx := context.Background()
+
+	mp, handler, err := NewProvider(ctx, "test", "0.0.1")
+	assert.NoError(err)
+	defer func() {
+		_ = mp.Shutdown(ctx)
+	}()
+
+	counter, err := Meter().Int64Counter("zipstash.test.requests")
+	assert.NoError(err)
+
+	counter.Add(ctx, 2)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), "zipstash_test_requests_total")
+	assert.Contains(w.Body.String(), "go_goroutines")
+}
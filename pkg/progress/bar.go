@@ -0,0 +1,101 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	barWidth        = 30
+	barRefreshDelay = 100 * time.Millisecond
+)
+
+// Bar renders a single line progress bar, this is intended for interactive terminals.
+type Bar struct {
+	w        io.Writer
+	counter  *counter
+	start    time.Time
+	rendered time.Time
+	phase    string
+	mu       sync.Mutex
+}
+
+// NewBar returns a progress bar which renders to the supplied writer, typically os.Stderr.
+func NewBar(w io.Writer) *Bar {
+	return &Bar{w: w, counter: newCounter()}
+}
+
+func (b *Bar) Start(phase string, totalBytes int64, _ int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.phase = phase
+	b.start = time.Now()
+	b.rendered = time.Time{}
+	b.counter.reset(totalBytes)
+	b.render()
+}
+
+func (b *Bar) Transferred(part int32, n int64) {
+	b.counter.add(part, n)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if time.Since(b.rendered) < barRefreshDelay {
+		return
+	}
+	b.render()
+}
+
+func (b *Bar) Retried(part int32, _ error) {
+	b.counter.retry(part)
+}
+
+func (b *Bar) Done(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.render()
+	if err != nil {
+		fmt.Fprintf(b.w, " failed: %v", err)
+	}
+	fmt.Fprintln(b.w)
+}
+
+// render must be called with the lock held.
+func (b *Bar) render() {
+	b.rendered = time.Now()
+
+	percent := b.counter.percent()
+	filled := int(percent / 100 * barWidth)
+	filled = min(max(filled, 0), barWidth)
+
+	fmt.Fprintf(b.w, "\r%-8s [%s%s] %6s %s/%s %s",
+		b.phase,
+		strings.Repeat("=", filled),
+		strings.Repeat(" ", barWidth-filled),
+		formatPercent(percent),
+		formatBytes(b.counter.total.Load()),
+		formatBytes(b.counter.totalBytes.Load()),
+		formatSpeed(b.counter.total.Load(), time.Since(b.start)),
+	)
+}
+
+func formatBytes(n int64) string {
+	return fmt.Sprintf("%.1fMB", float64(n)/1024/1024)
+}
+
+func formatSpeed(n int64, since time.Duration) string {
+	if since <= 0 {
+		return "0.00MB/s"
+	}
+	return fmt.Sprintf("%.2fMB/s", float64(n)/since.Seconds()/1024/1024)
+}
+
+func formatPercent(percent float64) string {
+	return fmt.Sprintf("%.1f%%", percent)
+}
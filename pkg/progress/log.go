@@ -0,0 +1,86 @@
+package progress
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Logger periodically emits a log line with the current progress, this is intended for CI logs where a
+// progress bar would produce unreadable output.
+type Logger struct {
+	counter  *counter
+	stop     chan struct{}
+	start    time.Time
+	name     string
+	phase    string
+	interval time.Duration
+	mu       sync.Mutex
+}
+
+// NewLogger returns a reporter which logs progress every interval.
+func NewLogger(interval time.Duration) *Logger {
+	return &Logger{interval: interval, counter: newCounter()}
+}
+
+// Named labels the log lines with a name, this distinguishes transfers which are running concurrently.
+func (l *Logger) Named(name string) *Logger {
+	l.name = name
+	return l
+}
+
+func (l *Logger) Start(phase string, totalBytes int64, parts int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.phase = phase
+	l.start = time.Now()
+	l.counter.reset(totalBytes)
+	l.stop = make(chan struct{})
+
+	log.Info().Str("name", l.name).Str("phase", phase).Int64("totalSize", totalBytes).Int("parts", parts).Msg("transfer started")
+
+	go l.run(l.stop)
+}
+
+func (l *Logger) Transferred(part int32, n int64) {
+	l.counter.add(part, n)
+}
+
+func (l *Logger) Retried(part int32, err error) {
+	l.counter.retry(part)
+
+	log.Warn().Err(err).Str("name", l.name).Str("phase", l.phase).Int32("part", part).Msg("retrying part")
+}
+
+func (l *Logger) Done(error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.stop != nil {
+		close(l.stop)
+		l.stop = nil
+	}
+}
+
+func (l *Logger) run(stop chan struct{}) {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			log.Info().
+				Str("name", l.name).
+				Str("phase", l.phase).
+				Int64("transferred", l.counter.total.Load()).
+				Int64("totalSize", l.counter.totalBytes.Load()).
+				Str("progress", formatPercent(l.counter.percent())).
+				Str("transfer_speed", formatSpeed(l.counter.total.Load(), time.Since(l.start))).
+				Msg("transfer progress")
+		}
+	}
+}
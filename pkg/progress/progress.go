@@ -0,0 +1,132 @@
+package progress
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Reporter receives progress events from the uploader and downloader as each part is transferred.
+//
+// Implementations must be safe for concurrent use as events are emitted by all the transfer workers.
+type Reporter interface {
+	// Start is called once before any parts are transferred.
+	Start(phase string, totalBytes int64, parts int)
+	// Transferred is called as bytes for a part are sent or received.
+	Transferred(part int32, n int64)
+	// Retried is called when a part is retried, any bytes already reported for the part are discarded.
+	Retried(part int32, err error)
+	// Done is called once all parts are transferred, or the transfer has failed.
+	Done(err error)
+}
+
+// Discard is a Reporter which ignores all events.
+var Discard Reporter = discard{}
+
+type discard struct{}
+
+func (discard) Start(string, int64, int) {}
+func (discard) Transferred(int32, int64) {}
+func (discard) Retried(int32, error)     {}
+func (discard) Done(error)               {}
+
+// Multi returns a Reporter which sends events to all the supplied reporters.
+func Multi(reporters ...Reporter) Reporter {
+	return multi(reporters)
+}
+
+type multi []Reporter
+
+func (m multi) Start(phase string, totalBytes int64, parts int) {
+	for _, r := range m {
+		r.Start(phase, totalBytes, parts)
+	}
+}
+
+func (m multi) Transferred(part int32, n int64) {
+	for _, r := range m {
+		r.Transferred(part, n)
+	}
+}
+
+func (m multi) Retried(part int32, err error) {
+	for _, r := range m {
+		r.Retried(part, err)
+	}
+}
+
+func (m multi) Done(err error) {
+	for _, r := range m {
+		r.Done(err)
+	}
+}
+
+// counter tracks the bytes transferred per part so retries don't inflate the total.
+type counter struct {
+	parts      map[int32]int64
+	total      atomic.Int64
+	retries    atomic.Int64
+	totalBytes atomic.Int64
+	mu         sync.Mutex
+}
+
+func newCounter() *counter {
+	return &counter{parts: make(map[int32]int64)}
+}
+
+func (c *counter) reset(totalBytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.parts = make(map[int32]int64)
+	c.total.Store(0)
+	c.retries.Store(0)
+	c.totalBytes.Store(totalBytes)
+}
+
+func (c *counter) add(part int32, n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.parts[part] += n
+	c.total.Add(n)
+}
+
+func (c *counter) retry(part int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.total.Add(-c.parts[part])
+	c.parts[part] = 0
+	c.retries.Add(1)
+}
+
+// percent returns the percentage of bytes transferred.
+func (c *counter) percent() float64 {
+	totalBytes := c.totalBytes.Load()
+	if totalBytes <= 0 {
+		return 0
+	}
+
+	return float64(c.total.Load()) / float64(totalBytes) * 100
+}
+
+// Reader wraps an io.Reader and reports the bytes read for a part.
+type Reader struct {
+	r        io.Reader
+	reporter Reporter
+	part     int32
+}
+
+// NewReader returns a reader which reports bytes read from r as transferred for the part.
+func NewReader(r io.Reader, reporter Reporter, part int32) *Reader {
+	return &Reader{r: r, reporter: reporter, part: part}
+}
+
+func (pr *Reader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	if n > 0 {
+		pr.reporter.Transferred(pr.part, int64(n))
+	}
+	return n, err
+}
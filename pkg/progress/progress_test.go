@@ -0,0 +1,98 @@
+package progress
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummary(t *testing.T) {
+	assert := require.New(t)
+
+	summary := NewSummary("save", "test-key")
+	summary.SetArchive(100, 400, 3)
+	summary.Phase("archive", 1500*time.Millisecond)
+
+	summary.Start("upload", 100, 2)
+	summary.Transferred(1, 40)
+	summary.Retried(1, errors.New("connection reset"))
+	summary.Transferred(1, 50)
+	summary.Transferred(2, 50)
+	summary.Done(nil)
+
+	assert.Equal(int64(100), summary.BytesTransferred)
+	assert.Equal(int64(1), summary.Retries)
+	assert.Equal(2, summary.Parts)
+	assert.Equal(4.0, summary.CompressionRatio)
+	assert.Contains(summary.Durations, "upload")
+
+	path := filepath.Join(t.TempDir(), "summary.json")
+	assert.NoError(summary.WriteFile(path))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(err)
+
+	var result map[string]any
+	assert.NoError(json.Unmarshal(data, &result))
+	assert.Equal("save", result["operation"])
+	assert.Equal("test-key", result["key"])
+	assert.Equal(float64(100), result["archive_size"])
+	assert.Equal(float64(1500), result["durations_ms"].(map[string]any)["archive"])
+}
+
+func TestWriteSummaries(t *testing.T) {
+	assert := require.New(t)
+
+	first := NewSummary("restore", "go-mod")
+	first.SetArchive(10, 20, 1)
+	second := NewSummary("restore", "node-modules")
+
+	path := filepath.Join(t.TempDir(), "summary.json")
+	assert.NoError(WriteSummaries(path, []*Summary{first, second}))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(err)
+
+	var result []map[string]any
+	assert.NoError(json.Unmarshal(data, &result))
+	assert.Len(result, 2)
+	assert.Equal("go-mod", result[0]["key"])
+	assert.Equal(float64(10), result[0]["archive_size"])
+	assert.Equal("node-modules", result[1]["key"])
+}
+
+func TestReader(t *testing.T) {
+	assert := require.New(t)
+
+	summary := NewSummary("restore", "test-key")
+	summary.Start("download", 11, 1)
+
+	n, err := io.Copy(io.Discard, NewReader(strings.NewReader("hello world"), Multi(summary, Discard), 1))
+	assert.NoError(err)
+	assert.Equal(int64(11), n)
+
+	summary.Done(nil)
+	assert.Equal(int64(11), summary.BytesTransferred)
+}
+
+func TestBar(t *testing.T) {
+	assert := require.New(t)
+
+	buf := new(bytes.Buffer)
+
+	bar := NewBar(buf)
+	bar.Start("upload", 100, 1)
+	bar.Transferred(1, 50)
+	bar.Done(nil)
+
+	assert.Contains(buf.String(), "50.0%")
+	assert.True(strings.HasSuffix(buf.String(), "\n"))
+}
@@ -0,0 +1,148 @@
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Summary collects statistics about a save or restore which can be written to a JSON file, this enables
+// teams to chart cache performance across builds.
+//
+// Summary is also a Reporter so it can record the parts, retries and throughput of a transfer.
+type Summary struct {
+	counter   *counter
+	start     time.Time
+	phase     string
+	Durations map[string]int64 `json:"durations_ms"`
+	Operation string           `json:"operation"`
+	Key       string           `json:"key"`
+	// ArchiveSize is the size of the compressed archive in bytes.
+	ArchiveSize int64 `json:"archive_size"`
+	// UncompressedSize is the total size of the files in the archive in bytes.
+	UncompressedSize int64   `json:"uncompressed_size"`
+	CompressionRatio float64 `json:"compression_ratio"`
+	Files            int64   `json:"files"`
+	// SkippedFiles and SkippedDirs are the files and directories excluded by a path pattern.
+	SkippedFiles     int64 `json:"skipped_files"`
+	SkippedDirs      int64 `json:"skipped_dirs"`
+	Parts            int   `json:"parts"`
+	Retries          int64 `json:"retries"`
+	BytesTransferred int64 `json:"bytes_transferred"`
+	// Throughput is the average transfer speed in bytes per second.
+	Throughput float64 `json:"throughput_bytes_per_second"`
+	mu         sync.Mutex
+}
+
+// NewSummary returns an empty summary for the operation and key.
+func NewSummary(operation, key string) *Summary {
+	return &Summary{
+		counter:   newCounter(),
+		Operation: operation,
+		Key:       key,
+		Durations: make(map[string]int64),
+	}
+}
+
+// SetArchive records the compressed and uncompressed size of the archive along with the number of files.
+func (s *Summary) SetArchive(archiveSize, uncompressedSize, files int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ArchiveSize = archiveSize
+	s.UncompressedSize = uncompressedSize
+	s.Files = files
+	if archiveSize > 0 {
+		s.CompressionRatio = float64(uncompressedSize) / float64(archiveSize)
+	}
+}
+
+// SetSkipped records the number of files and directories excluded from the archive by a path pattern.
+func (s *Summary) SetSkipped(files, dirs int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.SkippedFiles = files
+	s.SkippedDirs = dirs
+}
+
+// Phase records the duration of a named phase such as archive or extract.
+func (s *Summary) Phase(name string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Durations[name] = d.Milliseconds()
+}
+
+func (s *Summary) Start(phase string, totalBytes int64, parts int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.phase = phase
+	s.start = time.Now()
+	s.Parts = parts
+	s.counter.reset(totalBytes)
+}
+
+func (s *Summary) Transferred(part int32, n int64) {
+	s.counter.add(part, n)
+}
+
+func (s *Summary) Retried(part int32, _ error) {
+	s.counter.retry(part)
+}
+
+// Done records the transfer statistics, the transfer duration is stored using the phase name passed to Start.
+func (s *Summary) Done(error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	since := time.Since(s.start)
+
+	s.BytesTransferred = s.counter.total.Load()
+	s.Retries = s.counter.retries.Load()
+	s.Durations[s.phase] = since.Milliseconds()
+	if since > 0 {
+		s.Throughput = float64(s.BytesTransferred) / since.Seconds()
+	}
+}
+
+// WriteFile writes the summary as JSON to the supplied path.
+func (s *Summary) WriteFile(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return writeJSON(path, s)
+}
+
+// WriteSummaries writes a JSON array of summaries to the supplied path, this is used when several caches
+// are saved or restored together.
+func WriteSummaries(path string, summaries []*Summary) error {
+	for _, s := range summaries {
+		s.mu.Lock()
+	}
+
+	err := writeJSON(path, summaries)
+
+	for _, s := range summaries {
+		s.mu.Unlock()
+	}
+
+	return err
+}
+
+func writeJSON(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal summary: %w", err)
+	}
+
+	err = os.WriteFile(path, data, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write summary: %w", err)
+	}
+
+	return nil
+}
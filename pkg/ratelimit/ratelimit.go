@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+const megabyte = 1024 * 1024
+
+// NewLimiter returns a token bucket limiter which permits the given number of megabytes per second, with a burst
+// of one second worth of data. The limiter is intended to be shared by all the workers transferring a file.
+// A nil limiter is returned when megabytesPerSecond is zero or less which disables limiting.
+func NewLimiter(megabytesPerSecond int64) *rate.Limiter {
+	if megabytesPerSecond <= 0 {
+		return nil
+	}
+
+	bytesPerSecond := int(megabytesPerSecond * megabyte)
+
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), bytesPerSecond)
+}
+
+// Reader wraps an io.Reader and waits for tokens from the limiter for every byte read.
+type Reader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+// NewReader returns a reader which is throttled by the supplied limiter, if the limiter is nil the
+// original reader is returned.
+func NewReader(ctx context.Context, r io.Reader, limiter *rate.Limiter) io.Reader {
+	if limiter == nil {
+		return r
+	}
+
+	return &Reader{ctx: ctx, r: r, limiter: limiter}
+}
+
+func (lr *Reader) Read(p []byte) (int, error) {
+	// never ask for more tokens than the bucket can hold
+	if burst := lr.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		if werr := lr.limiter.WaitN(lr.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestNewLimiter(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Nil(NewLimiter(0))
+	assert.Nil(NewLimiter(-1))
+
+	limiter := NewLimiter(2)
+	assert.Equal(rate.Limit(2*megabyte), limiter.Limit())
+	assert.Equal(2*megabyte, limiter.Burst())
+}
+
+func TestReader(t *testing.T) {
+	assert := require.New(t)
+
+	data := bytes.Repeat([]byte("a"), 15*1024)
+
+	// 10KB per second with an initially full bucket so the remaining 5KB should take about half a second
+	limiter := rate.NewLimiter(rate.Limit(10*1024), 10*1024)
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, NewReader(context.Background(), bytes.NewReader(data), limiter))
+	assert.NoError(err)
+	assert.Equal(int64(len(data)), n)
+	assert.GreaterOrEqual(time.Since(start), 400*time.Millisecond)
+}
+
+func TestReaderNilLimiter(t *testing.T) {
+	assert := require.New(t)
+
+	r := bytes.NewReader([]byte("hello"))
+	assert.Equal(r, NewReader(context.Background(), r, nil))
+}
+
+func TestReaderCancelled(t *testing.T) {
+	assert := require.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	limiter := rate.NewLimiter(rate.Limit(1), 1)
+	_, err := io.Copy(io.Discard, NewReader(ctx, bytes.NewReader([]byte("hello")), limiter))
+	assert.ErrorIs(err, context.Canceled)
+}
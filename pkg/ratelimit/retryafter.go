@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryAfterHeader is the header, or connect error metadata, telling clients how long to wait before retrying a
+// request which was rejected by a rate limit.
+const RetryAfterHeader = "Retry-After"
+
+// FormatRetryAfter formats a delay as whole seconds, rounding up so clients don't retry before the limit allows
+// the request.
+func FormatRetryAfter(delay time.Duration) string {
+	seconds := int64(math.Ceil(delay.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	return strconv.FormatInt(seconds, 10)
+}
+
+// ParseRetryAfter parses a retry after value in either seconds or as an http date, false is returned when the
+// value is missing or invalid.
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+
+	delay := date.Sub(now)
+	if delay < 0 {
+		delay = 0
+	}
+
+	return delay, true
+}
+
+// ResponseRetryAfter returns the retry after hint of a response which was throttled, such as a rate limited
+// request to the server or a SlowDown response from s3.
+func ResponseRetryAfter(res *http.Response) (time.Duration, bool) {
+	if res.StatusCode != http.StatusTooManyRequests && res.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+
+	return ParseRetryAfter(res.Header.Get(RetryAfterHeader), time.Now())
+}
@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		delay time.Duration
+		want  string
+	}{
+		{name: "zero", delay: 0, want: "1"},
+		{name: "under a second", delay: 100 * time.Millisecond, want: "1"},
+		{name: "rounds up", delay: 2100 * time.Millisecond, want: "3"},
+		{name: "whole seconds", delay: 5 * time.Second, want: "5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.Equal(tt.want, FormatRetryAfter(tt.delay))
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+		found bool
+	}{
+		{name: "missing", value: ""},
+		{name: "seconds", value: "3", want: 3 * time.Second, found: true},
+		{name: "negative", value: "-1"},
+		{name: "date", value: now.Add(10 * time.Second).Format(http.TimeFormat), want: 10 * time.Second, found: true},
+		{name: "past date", value: now.Add(-time.Minute).Format(http.TimeFormat), want: 0, found: true},
+		{name: "invalid", value: "soon"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			got, found := ParseRetryAfter(tt.value, now)
+			assert.Equal(tt.found, found)
+			assert.Equal(tt.want, got)
+		})
+	}
+}
+
+func TestResponseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		value  string
+		want   time.Duration
+		found  bool
+	}{
+		{name: "too many requests", status: http.StatusTooManyRequests, value: "2", want: 2 * time.Second, found: true},
+		{name: "unavailable", status: http.StatusServiceUnavailable, value: "1", want: time.Second, found: true},
+		{name: "unavailable without hint", status: http.StatusServiceUnavailable},
+		{name: "not throttled", status: http.StatusInternalServerError, value: "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			res := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			if tt.value != "" {
+				res.Header.Set(RetryAfterHeader, tt.value)
+			}
+
+			got, found := ResponseRetryAfter(res)
+			assert.Equal(tt.found, found)
+			assert.Equal(tt.want, got)
+		})
+	}
+}
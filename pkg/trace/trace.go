@@ -31,7 +31,7 @@ func NewLambdaProvider(ctx context.Context, name, version string) (*sdktrace.Tra
 		return nil, fmt.Errorf("failed to create exporter: %w", err)
 	}
 
-	res, err := newResource(ctx, name, version)
+	res, err := NewResource(ctx, name, version)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create resource: %w", err)
 	}
@@ -63,7 +63,7 @@ func NewProvider(ctx context.Context, name, version string) (*sdktrace.TracerPro
 		return nil, fmt.Errorf("failed to create exporter: %w", err)
 	}
 
-	res, err := newResource(ctx, name, version)
+	res, err := NewResource(ctx, name, version)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create resource: %w", err)
 	}
@@ -107,7 +107,8 @@ func newExporter(ctx context.Context) (sdktrace.SpanExporter, error) {
 	}
 }
 
-func newResource(cxt context.Context, name, version string) (*resource.Resource, error) {
+// NewResource describes the process, this is shared by the trace and metric providers.
+func NewResource(cxt context.Context, name, version string) (*resource.Resource, error) {
 	options := []resource.Option{
 		resource.WithSchemaURL(semconv.SchemaURL),
 	}
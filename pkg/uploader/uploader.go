@@ -12,7 +12,10 @@ import (
 	"github.com/cenkalti/backoff/v5"
 	"github.com/rs/zerolog/log"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"golang.org/x/time/rate"
 
+	"github.com/wolfeidau/zipstash/pkg/progress"
+	"github.com/wolfeidau/zipstash/pkg/ratelimit"
 	"github.com/wolfeidau/zipstash/pkg/trace"
 )
 
@@ -48,7 +51,7 @@ type Offset struct {
 	Start int64 `json:"start"`
 }
 
-// Uploader uses go routines to upload files in parallel with a limit of 20 concurrent uploads.
+// Uploader uses go routines to upload files in parallel with a configurable limit of concurrent uploads.
 // It is provided a list of URLs to upload and a channel to receive the results.
 // The results are sent to the channel as a map of URL to error.
 // If an error occurs, the error is sent to the channel and the upload is stopped.
@@ -56,6 +59,8 @@ type Offset struct {
 // The uploader will return when all uploads are complete or when an error occurs.
 type Uploader struct {
 	client          *http.Client
+	limiter         *rate.Limiter
+	reporter        progress.Reporter
 	errors          chan error
 	done            chan struct{}
 	filePath        string
@@ -63,21 +68,53 @@ type Uploader struct {
 	limit           int
 }
 
-func NewUploader(ctx context.Context, filePath string, uploadInstructs []CacheUploadInstruction, limit int) *Uploader {
-	return &Uploader{
+// Option configures an Uploader.
+type Option func(*Uploader)
+
+// WithRateLimiter throttles the combined upload bandwidth of all parts using the supplied limiter.
+func WithRateLimiter(limiter *rate.Limiter) Option {
+	return func(u *Uploader) {
+		u.limiter = limiter
+	}
+}
+
+// WithReporter sends progress events for each part to the supplied reporter.
+func WithReporter(reporter progress.Reporter) Option {
+	return func(u *Uploader) {
+		u.reporter = reporter
+	}
+}
+
+// NewUploader creates a new uploader which uploads at most limit parts concurrently, a limit less than one is treated as one.
+func NewUploader(ctx context.Context, filePath string, uploadInstructs []CacheUploadInstruction, limit int, opts ...Option) *Uploader {
+	u := &Uploader{
 		filePath:        filePath,
 		uploadInstructs: uploadInstructs,
 		client:          &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)},
-		limit:           limit,
+		limit:           max(limit, 1),
+		reporter:        progress.Discard,
 		errors:          make(chan error),
 		done:            make(chan struct{}),
 	}
+
+	for _, opt := range opts {
+		opt(u)
+	}
+
+	return u
 }
 
 func (u *Uploader) Upload(ctx context.Context) ([]CachePartETag, error) {
 	ctx, span := trace.Start(ctx, "Uploader.Upload")
 	defer span.End()
 
+	stat, err := os.Stat(u.filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	u.reporter.Start("upload", stat.Size(), len(u.uploadInstructs))
+
 	var mu sync.Mutex
 	etags := make([]CachePartETag, 0, len(u.uploadInstructs))
 
@@ -112,9 +149,11 @@ func (u *Uploader) Upload(ctx context.Context) ([]CachePartETag, error) {
 
 	select {
 	case <-u.done:
+		u.reporter.Done(nil)
 		emitSummary(etags, start)
 		return etags, nil
 	case err := <-u.errors:
+		u.reporter.Done(err)
 		return nil, err
 	}
 }
@@ -135,17 +174,19 @@ func (u *Uploader) upload(ctx context.Context, uploadInstruct CacheUploadInstruc
 		return cachePartEtag, fmt.Errorf("failed to read chunk: %w", err)
 	}
 
-	etag, err := u.uploadChunk(ctx, uploadInstruct, chunk)
+	part := int32(1)
+	if multipart {
+		part = uploadInstruct.Offset.Part
+	}
+
+	etag, err := u.uploadChunk(ctx, uploadInstruct, part, chunk)
 	if err != nil {
 		return cachePartEtag, fmt.Errorf("failed to upload chunk: %w", err)
 	}
 
 	cachePartEtag.Etag = etag
-	cachePartEtag.Part = 1
+	cachePartEtag.Part = part
 	cachePartEtag.PartSize = int64(len(chunk))
-	if multipart {
-		cachePartEtag.Part = uploadInstruct.Offset.Part
-	}
 
 	log.Debug().Str("etag", etag).Int64("size", int64(len(chunk))).Int32("part", cachePartEtag.Part).Msg("uploaded")
 
@@ -184,22 +225,32 @@ func (u *Uploader) readChunk(ctx context.Context, size int64, uploadInstruct Cac
 	return buf, nil
 }
 
-func (u *Uploader) uploadChunk(ctx context.Context, uploadInstruct CacheUploadInstruction, chunk []byte) (string, error) {
+func (u *Uploader) uploadChunk(ctx context.Context, uploadInstruct CacheUploadInstruction, part int32, chunk []byte) (string, error) {
 	ctx, span := trace.Start(ctx, "Uploader.uploadChunk")
 	defer span.End()
 
 	operation := func() (string, error) {
-		uploadReq, err := http.NewRequestWithContext(ctx, uploadInstruct.Method, uploadInstruct.Url, bytes.NewBuffer(chunk))
+		body := progress.NewReader(ratelimit.NewReader(ctx, bytes.NewReader(chunk), u.limiter), u.reporter, part)
+
+		uploadReq, err := http.NewRequestWithContext(ctx, uploadInstruct.Method, uploadInstruct.Url, body)
 		if err != nil {
 			return "", fmt.Errorf("failed to create request: %w", err)
 		}
 
+		// the throttled reader hides the length of the chunk so set it explicitly
+		uploadReq.ContentLength = int64(len(chunk))
+
 		resp, err := u.client.Do(uploadReq)
 		if err != nil {
 			return "", fmt.Errorf("failed to do upload file: %w", err)
 		}
 		defer resp.Body.Close()
 
+		// wait for throttled requests as long as the response asks
+		if delay, ok := ratelimit.ResponseRetryAfter(resp); ok {
+			return "", &backoff.RetryAfterError{Duration: delay}
+		}
+
 		if resp.StatusCode == http.StatusBadRequest ||
 			resp.StatusCode == http.StatusForbidden ||
 			resp.StatusCode == http.StatusLengthRequired {
@@ -220,7 +271,10 @@ func (u *Uploader) uploadChunk(ctx context.Context, uploadInstruct CacheUploadIn
 	}
 
 	return backoff.Retry(ctx, operation,
-		backoff.WithBackOff(backoff.NewExponentialBackOff()), backoff.WithMaxTries(3))
+		backoff.WithBackOff(backoff.NewExponentialBackOff()), backoff.WithMaxTries(3),
+		backoff.WithNotify(func(err error, _ time.Duration) {
+			u.reporter.Retried(part, err)
+		}))
 }
 
 func emitSummary(etags []CachePartETag, start time.Time) {